@@ -0,0 +1,726 @@
+package updater
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ArchiveFormatTar    = "tar"
+	ArchiveFormatTarGz  = "tar.gz"
+	ArchiveFormatTarZst = "tar.zst"
+	ArchiveFormatZip    = "zip"
+)
+
+// 解压时目标文件已存在的处理策略
+const (
+	OverwriteError  = "error"  // 返回错误
+	OverwriteSkip   = "skip"   // 跳过该文件
+	OverwriteAlways = "always" // 覆盖
+)
+
+var (
+	ErrUnknownArchiveFormat = errors.New("unknown archive format")
+	ErrIllegalArchivePath   = errors.New("illegal path in archive")
+)
+
+// ExtractRequest 是解压请求参数
+type ExtractRequest struct {
+	TaskID              string `json:"taskId"`              // 任务ID
+	Src                 string `json:"src"`                 // 压缩包路径
+	DestPath            string `json:"destPath"`            // 解压目录
+	Format              string `json:"format"`              // 压缩格式，为空时根据扩展名判断
+	StripComponents     int    `json:"stripComponents"`     // 去掉路径的前几级目录
+	PreservePermissions bool   `json:"preservePermissions"` // 是否保留文件权限
+	Overwrite           string `json:"overwrite"`           // 文件已存在时的处理策略：error、skip、always
+	AutoCreateDir       bool   `json:"autoCreateDir"`       // 是否自动创建解压目录
+	RemoveSrc           bool   `json:"removeSrc"`           // 解压成功后删除压缩包
+}
+
+// ArchiveRequest 是打包请求参数
+type ArchiveRequest struct {
+	TaskID           string   `json:"taskId"`           // 任务ID
+	Src              string   `json:"src"`              // 要打包的目录或文件
+	DestPath         string   `json:"destPath"`         // 压缩包路径
+	Format           string   `json:"format"`           // 压缩格式，为空时根据扩展名判断
+	Include          []string `json:"include"`          // 包含的文件 glob
+	Exclude          []string `json:"exclude"`          // 排除的文件 glob
+	AutoCreateDir    bool     `json:"autoCreateDir"`    // 是否自动创建文件夹
+	OverwriteExisted bool     `json:"overwriteExisted"` // 文件存在是否覆盖文件
+}
+
+// ManifestEntry 是压缩包中的一个条目
+type ManifestEntry struct {
+	Path    string `json:"path"`              // 相对路径
+	Size    int64  `json:"size"`              // 文件大小
+	Mode    string `json:"mode"`              // 文件权限
+	IsDir   bool   `json:"isDir"`             // 是否是目录
+	Link    string `json:"link,omitempty"`    // 符号链接目标
+	Skipped bool   `json:"skipped,omitempty"` // 因为文件已存在而跳过
+}
+
+// ArchiveResult 是解压或打包的结果
+type ArchiveResult struct {
+	Path   string          `json:"path"`             // 解压目录或压缩包路径
+	Format string          `json:"format"`           // 压缩格式
+	Size   int64           `json:"size"`             // 文件总大小
+	Sha256 string          `json:"sha256,omitempty"` // 压缩包校验和，仅打包时有效
+	Files  []ManifestEntry `json:"files"`            // 文件清单
+}
+
+// DetectArchiveFormat 根据文件扩展名判断压缩格式
+func DetectArchiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return ArchiveFormatTarGz
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return ArchiveFormatTarZst
+	case strings.HasSuffix(name, ".tar"):
+		return ArchiveFormatTar
+	case strings.HasSuffix(name, ".zip"):
+		return ArchiveFormatZip
+	}
+	return ""
+}
+
+// Extract 解压压缩包到目标目录，返回解压出的文件清单
+func (fm *FileManager) Extract(ctx context.Context, req *ExtractRequest) (*ArchiveResult, error) {
+	format := req.Format
+	if format == "" {
+		format = DetectArchiveFormat(req.Src)
+	}
+	if req.Overwrite == "" {
+		req.Overwrite = OverwriteError
+	}
+
+	if err := fm.CheckPath(PathOpRead, req.Src); err != nil {
+		return nil, err
+	}
+	if err := fm.CheckPath(PathOpWrite, req.DestPath); err != nil {
+		return nil, err
+	}
+	if req.RemoveSrc {
+		if err := fm.CheckPath(PathOpDelete, req.Src); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.AutoCreateDir {
+		if err := os.MkdirAll(req.DestPath, 0755); err != nil {
+			return nil, err
+		}
+	}
+	dest, err := filepath.Abs(req.DestPath)
+	if err != nil {
+		return nil, err
+	}
+	if dest, err = filepath.EvalSymlinks(dest); err != nil {
+		return nil, err
+	}
+
+	x := &extractor{fm: fm, ctx: ctx, req: req, dest: dest}
+	switch format {
+	case ArchiveFormatTar, ArchiveFormatTarGz, ArchiveFormatTarZst:
+		err = x.extractTar(format)
+	case ArchiveFormatZip:
+		err = x.extractZip()
+	default:
+		err = ErrUnknownArchiveFormat
+	}
+	if err == nil {
+		err = x.checkLinks()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if req.RemoveSrc {
+		if err := os.Remove(req.Src); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ArchiveResult{
+		Path:   dest,
+		Format: format,
+		Size:   x.size,
+		Files:  x.files,
+	}, nil
+}
+
+// Archive 将目录或文件打包为压缩包
+func (fm *FileManager) Archive(ctx context.Context, req *ArchiveRequest) (*ArchiveResult, error) {
+	format := req.Format
+	if format == "" {
+		format = DetectArchiveFormat(req.DestPath)
+	}
+	if format == "" {
+		return nil, ErrUnknownArchiveFormat
+	}
+
+	if err := fm.CheckPath(PathOpWrite, req.DestPath); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(req.DestPath); err == nil && !req.OverwriteExisted {
+		return nil, fmt.Errorf("file already exists and overwriteExisted is set to false")
+	}
+	if req.AutoCreateDir {
+		if err := os.MkdirAll(filepath.Dir(req.DestPath), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	// 先写入临时文件，成功后再改名，避免留下不完整的压缩包
+	out, err := ioutil.TempFile(filepath.Dir(req.DestPath), "."+filepath.Base(req.DestPath)+".")
+	if err != nil {
+		return nil, err
+	}
+	tmp := out.Name()
+
+	// 压缩包在源目录中时不能把自己打包进去
+	var skip []os.FileInfo
+	for _, path := range []string{tmp, req.DestPath} {
+		if info, err := os.Stat(path); err == nil {
+			skip = append(skip, info)
+		}
+	}
+	files, err := fm.writeArchive(ctx, out, format, req.Src, req.Include, req.Exclude, skip...)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, req.DestPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	info, err := os.Stat(req.DestPath)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := fileSha256(req.DestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArchiveResult{
+		Path:   req.DestPath,
+		Format: format,
+		Size:   info.Size(),
+		Sha256: sum,
+		Files:  files,
+	}, nil
+}
+
+// matchGlobs 判断相对路径是否匹配任意一个 glob，同时匹配完整相对路径和文件名
+func matchGlobs(rel string, patterns []string) bool {
+	rel = filepath.ToSlash(rel)
+	base := filepath.Base(rel)
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+		// "logs/**" 形式匹配目录下所有文件
+		if strings.HasSuffix(pattern, "/**") && strings.HasPrefix(rel+"/", strings.TrimSuffix(pattern, "**")) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTarGz 将 root 目录打包为 tar.gz 写入 w。include 为空时包含所有文件，exclude 优先于 include
+func (fm *FileManager) writeTarGz(w io.Writer, root string, include, exclude []string) error {
+	_, err := fm.writeArchive(context.Background(), w, ArchiveFormatTarGz, root, include, exclude)
+	return err
+}
+
+// archiveWriter 屏蔽 tar 和 zip 写入的差异
+type archiveWriter interface {
+	add(path, name string, info os.FileInfo) error
+	Close() error
+}
+
+// writeArchive 将 root 打包写入 w，root 也可以是单个文件，跳过 skip 中的文件。返回打包的文件清单
+func (fm *FileManager) writeArchive(ctx context.Context, w io.Writer, format, root string, include, exclude []string, skip ...os.FileInfo) ([]ManifestEntry, error) {
+	var (
+		aw  archiveWriter
+		err error
+	)
+	switch format {
+	case ArchiveFormatTar, ArchiveFormatTarGz, ArchiveFormatTarZst:
+		aw, err = newTarArchiveWriter(w, format)
+	case ArchiveFormatZip:
+		aw = &zipArchiveWriter{zw: zip.NewWriter(w)}
+	default:
+		err = ErrUnknownArchiveFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	base := root
+	if !rootInfo.IsDir() {
+		base = filepath.Dir(root)
+	}
+
+	var files []ManifestEntry
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		for _, s := range skip {
+			if os.SameFile(info, s) {
+				return nil
+			}
+		}
+
+		if matchGlobs(rel, exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && len(include) > 0 && !matchGlobs(rel, include) {
+			return nil
+		}
+		if err := fm.CheckPath(PathOpRead, path); err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if err := aw.add(path, name, info); err != nil {
+			return err
+		}
+		files = append(files, ManifestEntry{
+			Path:  name,
+			Size:  info.Size(),
+			Mode:  info.Mode().String(),
+			IsDir: info.IsDir(),
+		})
+		return nil
+	})
+	if err != nil {
+		aw.Close()
+		return nil, err
+	}
+
+	return files, aw.Close()
+}
+
+type tarArchiveWriter struct {
+	tw *tar.Writer
+	cw io.WriteCloser // 压缩层，tar 格式时为 nil
+}
+
+func newTarArchiveWriter(w io.Writer, format string) (*tarArchiveWriter, error) {
+	aw := &tarArchiveWriter{}
+	switch format {
+	case ArchiveFormatTarGz:
+		aw.cw = gzip.NewWriter(w)
+	case ArchiveFormatTarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		aw.cw = zw
+	}
+	if aw.cw != nil {
+		w = aw.cw
+	}
+	aw.tw = tar.NewWriter(w)
+	return aw, nil
+}
+
+// add 写入一个文件、目录或符号链接
+func (aw *tarArchiveWriter) add(path, name string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := aw.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	return copyFileTo(aw.tw, path)
+}
+
+func (aw *tarArchiveWriter) Close() error {
+	if err := aw.tw.Close(); err != nil {
+		return err
+	}
+	if aw.cw != nil {
+		return aw.cw.Close()
+	}
+	return nil
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (aw *zipArchiveWriter) add(path, name string, info os.FileInfo) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	} else {
+		hdr.Method = zip.Deflate
+	}
+
+	w, err := aw.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, link)
+		return err
+	case info.Mode().IsRegular():
+		return copyFileTo(w, path)
+	}
+	return nil
+}
+
+func (aw *zipArchiveWriter) Close() error {
+	return aw.zw.Close()
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// extractor 负责把压缩包条目安全地写到目标目录
+type extractor struct {
+	fm    *FileManager
+	ctx   context.Context
+	req   *ExtractRequest
+	dest  string
+	size  int64
+	files []ManifestEntry
+	links []string // 解压出的符号链接的相对路径
+}
+
+func (x *extractor) extractTar(format string) error {
+	f, err := os.Open(x.req.Src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch format {
+	case ArchiveFormatTarGz:
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	case ArchiveFormatTarZst:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.writeEntry(hdr.Name, hdr.FileInfo().Mode(), hdr.ModTime, "", nil)
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.writeEntry(hdr.Name, hdr.FileInfo().Mode(), hdr.ModTime, "", tr)
+		case tar.TypeSymlink:
+			err = x.writeEntry(hdr.Name, os.ModeSymlink|0777, hdr.ModTime, hdr.Linkname, nil)
+		default:
+			// 硬链接、设备文件等不支持，直接跳过
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) extractZip() error {
+	zr, err := zip.OpenReader(x.req.Src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		if err := x.ctx.Err(); err != nil {
+			return err
+		}
+		if err := x.extractZipFile(zf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) extractZipFile(zf *zip.File) error {
+	mode := zf.Mode()
+	if mode.IsDir() {
+		return x.writeEntry(zf.Name, mode, zf.Modified, "", nil)
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if mode&os.ModeSymlink != 0 {
+		b, err := io.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		return x.writeEntry(zf.Name, mode, zf.Modified, string(b), nil)
+	}
+	return x.writeEntry(zf.Name, mode, zf.Modified, "", rc)
+}
+
+// resolve 计算条目的目标路径，拒绝任何逃逸出目标目录的路径。
+// 目标路径的父目录按磁盘上的实际情况解析，解析失败时同样拒绝
+func (x *extractor) resolve(name string) (rel, target string, err error) {
+	name = filepath.ToSlash(name)
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if len(parts) <= x.req.StripComponents {
+		return "", "", nil
+	}
+	rel = filepath.Clean(filepath.FromSlash(strings.Join(parts[x.req.StripComponents:], "/")))
+
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.VolumeName(rel) != "" {
+		return "", "", fmt.Errorf("%w: %s", ErrIllegalArchivePath, name)
+	}
+
+	// 经过同一个压缩包中先解压出的符号链接写入的条目，可以借助多个链接组合逃逸，一律拒绝
+	for _, link := range x.links {
+		if strings.HasPrefix(rel, link+string(filepath.Separator)) {
+			return "", "", fmt.Errorf("%w: %s is under symlink %s", ErrIllegalArchivePath, name, filepath.ToSlash(link))
+		}
+	}
+
+	// 已存在的父目录可能是指向外部的符号链接
+	parent, err := evalPath(x.dest, filepath.Dir(rel))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s: %v", ErrIllegalArchivePath, name, err)
+	}
+	if !isWithin(x.dest, parent) {
+		return "", "", fmt.Errorf("%w: %s", ErrIllegalArchivePath, name)
+	}
+	return rel, filepath.Join(parent, filepath.Base(rel)), nil
+}
+
+// checkLink 检查 dir 目录中指向 link 的符号链接按磁盘上的实际情况解析后是否仍在目标目录内
+func (x *extractor) checkLink(name, dir, link string) error {
+	resolved, err := evalPath(dir, link)
+	if err != nil {
+		return fmt.Errorf("%w: %s -> %s: %v", ErrIllegalArchivePath, name, link, err)
+	}
+	if !isWithin(x.dest, resolved) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalArchivePath, name, link)
+	}
+	return nil
+}
+
+// checkLinks 在解压结束后重新检查所有解压出的符号链接：后面的条目可能改变了链接经过的目录，
+// 逃逸的链接被删除
+func (x *extractor) checkLinks() error {
+	for _, rel := range x.links {
+		target := filepath.Join(x.dest, rel)
+		// 链接可能已经被后面的条目覆盖
+		if info, err := os.Lstat(target); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		link, err := os.Readlink(target)
+		if err == nil {
+			err = x.checkLink(filepath.ToSlash(rel), filepath.Dir(target), link)
+		}
+		if err != nil {
+			os.Remove(target)
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) writeEntry(name string, mode os.FileMode, modTime time.Time, link string, r io.Reader) error {
+	rel, target, err := x.resolve(name)
+	if err != nil || rel == "" || rel == "." {
+		return err
+	}
+	if err := x.fm.CheckPath(PathOpWrite, target); err != nil {
+		return err
+	}
+
+	entry := ManifestEntry{
+		Path:  filepath.ToSlash(rel),
+		Mode:  mode.String(),
+		IsDir: mode.IsDir(),
+		Link:  link,
+	}
+
+	perm := mode.Perm()
+	if !x.req.PreservePermissions {
+		perm = 0644
+		if mode.IsDir() || mode&0111 != 0 {
+			perm = 0755
+		}
+	}
+
+	if mode.IsDir() {
+		if err := os.MkdirAll(target, perm); err != nil {
+			return err
+		}
+		x.files = append(x.files, entry)
+		return nil
+	}
+
+	if _, err := os.Lstat(target); err == nil {
+		switch x.req.Overwrite {
+		case OverwriteSkip:
+			entry.Skipped = true
+			x.files = append(x.files, entry)
+			return nil
+		case OverwriteAlways:
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("file already exists: %s", target)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if mode&os.ModeSymlink != 0 {
+		if err := x.checkLink(name, filepath.Dir(target), link); err != nil {
+			return err
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return err
+		}
+		x.links = append(x.links, rel)
+		x.files = append(x.files, entry)
+		return nil
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// OpenFile 的权限受 umask 影响，需要单独设置
+	if x.req.PreservePermissions {
+		if err := os.Chmod(target, perm); err != nil {
+			return err
+		}
+	}
+	if !modTime.IsZero() {
+		os.Chtimes(target, modTime, modTime)
+	}
+
+	entry.Size = n
+	x.size += n
+	x.files = append(x.files, entry)
+	return nil
+}
+
+// isWithin 判断 path 是否在 root 目录内
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
@@ -0,0 +1,171 @@
+package updater
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveExtractRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "app", "bin"), 0755)
+	os.WriteFile(filepath.Join(src, "app", "bin", "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	os.WriteFile(filepath.Join(src, "app", "config.json"), []byte("{}"), 0600)
+	os.WriteFile(filepath.Join(src, "app", "debug.log"), []byte("log"), 0644)
+
+	fm := NewFileManager()
+	for _, format := range []string{ArchiveFormatTar, ArchiveFormatTarGz, ArchiveFormatTarZst, ArchiveFormatZip} {
+		archive := filepath.Join(t.TempDir(), "app."+format)
+		_, err := fm.Archive(context.Background(), &ArchiveRequest{
+			Src:      src,
+			DestPath: archive,
+			Exclude:  []string{"*.log"},
+		})
+		if err != nil {
+			t.Fatalf("%s: archive failed: %v", format, err)
+		}
+
+		dest := t.TempDir()
+		result, err := fm.Extract(context.Background(), &ExtractRequest{
+			Src:                 archive,
+			DestPath:            dest,
+			StripComponents:     1,
+			PreservePermissions: true,
+		})
+		if err != nil {
+			t.Fatalf("%s: extract failed: %v", format, err)
+		}
+
+		info, err := os.Stat(filepath.Join(dest, "bin", "run.sh"))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if info.Mode().Perm() != 0755 {
+			t.Errorf("%s: run.sh mode = %v, want 0755", format, info.Mode().Perm())
+		}
+		if _, err := os.Stat(filepath.Join(dest, "debug.log")); !os.IsNotExist(err) {
+			t.Errorf("%s: excluded file was archived", format)
+		}
+		if len(result.Files) != 3 {
+			t.Errorf("%s: manifest has %d entries, want 3: %+v", format, len(result.Files), result.Files)
+		}
+	}
+}
+
+func TestArchiveIntoSource(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(src, "backup.tar.gz"), []byte("old"), 0644)
+
+	// 压缩包写在源目录中，不能包含自己和写入中的临时文件
+	fm := NewFileManager()
+	dest := filepath.Join(src, "backup.tar.gz")
+	result, err := fm.Archive(context.Background(), &ArchiveRequest{Src: src, DestPath: dest, OverwriteExisted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 || result.Files[0].Path != "a.txt" {
+		t.Fatalf("unexpected archived files: %+v", result.Files)
+	}
+	entries, _ := os.ReadDir(src)
+	if len(entries) != 2 {
+		t.Fatalf("temporary file left behind: %v", entries)
+	}
+}
+
+func TestExtractRejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "evil.tar")
+
+	entries := []*tar.Header{
+		{Name: "../evil.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+	}
+	for _, hdr := range entries {
+		f, _ := os.Create(archive)
+		tw := tar.NewWriter(f)
+		tw.WriteHeader(hdr)
+		if hdr.Size > 0 {
+			tw.Write([]byte("x"))
+		}
+		tw.Close()
+		f.Close()
+
+		dest := filepath.Join(dir, "dest")
+		os.RemoveAll(dest)
+		_, err := NewFileManager().Extract(context.Background(), &ExtractRequest{
+			Src:           archive,
+			DestPath:      dest,
+			AutoCreateDir: true,
+		})
+		if !errors.Is(err, ErrIllegalArchivePath) {
+			t.Errorf("%s: err = %v, want ErrIllegalArchivePath", hdr.Name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+		t.Error("file was written outside of destination")
+	}
+}
+
+func TestExtractRejectsChainedSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name    string
+		entries []*tar.Header
+	}{
+		// a -> . 时 a/a/.. 在磁盘上是目标目录的父目录，按文本计算却在目标目录内
+		{"dotdot through symlink", []*tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "esc", Typeflag: tar.TypeSymlink, Linkname: "a/a/.."},
+			{Name: "esc/newdir/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		}},
+		{"entry under earlier symlink", []*tar.Header{
+			{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "sub"},
+			{Name: "a/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		}},
+		{"dotdot after missing component", []*tar.Header{
+			{Name: "esc", Typeflag: tar.TypeSymlink, Linkname: "missing/../.."},
+		}},
+		// 创建链接时 d 是目录，之后被替换为 d -> .，esc 变成指向外部
+		{"directory replaced by symlink", []*tar.Header{
+			{Name: "d/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "esc", Typeflag: tar.TypeSymlink, Linkname: "d/.."},
+			{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "."},
+		}},
+	}
+	for _, c := range cases {
+		archive := filepath.Join(dir, "evil.tar")
+		f, _ := os.Create(archive)
+		tw := tar.NewWriter(f)
+		for _, hdr := range c.entries {
+			tw.WriteHeader(hdr)
+			if hdr.Size > 0 {
+				tw.Write([]byte("x"))
+			}
+		}
+		tw.Close()
+		f.Close()
+
+		dest := filepath.Join(dir, "dest")
+		os.RemoveAll(dest)
+		_, err := NewFileManager().Extract(context.Background(), &ExtractRequest{
+			Src:           archive,
+			DestPath:      dest,
+			AutoCreateDir: true,
+			Overwrite:     OverwriteAlways,
+		})
+		if !errors.Is(err, ErrIllegalArchivePath) {
+			t.Errorf("%s: err = %v, want ErrIllegalArchivePath", c.name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "newdir")); !os.IsNotExist(err) {
+			t.Fatalf("%s: file was written outside of destination", c.name)
+		}
+		if target, err := filepath.EvalSymlinks(filepath.Join(dest, "esc")); err == nil && !isWithin(dest, target) {
+			t.Errorf("%s: escaping symlink left in destination: %s", c.name, target)
+		}
+	}
+}
@@ -0,0 +1,621 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/logger"
+)
+
+const (
+	AuditActionScript     = "script"
+	AuditActionFile       = "file"
+	AuditActionProcess    = "process"
+	AuditActionConfig     = "config"
+	AuditActionService    = "service"
+	AuditActionPackage    = "package"
+	AuditActionSelfUpdate = "selfUpdate"
+	AuditActionWorkflow   = "workflow"
+	AuditActionSchedule   = "schedule"
+
+	defaultAuditDir       = ".data/audit"
+	defaultAuditMaxSize   = 10 * 1024 * 1024
+	defaultAuditRetention = 90 * 24 * time.Hour
+	defaultAuditLimit     = 200
+	maxAuditLimit         = 5000
+	maxAuditPaths         = 100
+
+	auditFileName   = "audit.log"
+	auditTimeLayout = "20060102T150405.000"
+)
+
+var (
+	ErrAuditChainBroken = errors.New("audit log hash chain is broken")
+	ErrAuditUnavailable = errors.New("audit log is not available")
+)
+
+// auditedMessages 是需要记录审计日志的消息类型和对应的操作类别
+var auditedMessages = map[string]string{
+	"v1/ExecuteScript":     AuditActionScript,
+	"v1/DeleteFile":        AuditActionFile,
+	"v1/MoveFile":          AuditActionFile,
+	"v1/DownloadFile":      AuditActionFile,
+	"v1/Extract":           AuditActionFile,
+	"v1/Archive":           AuditActionFile,
+	"v1/WriteFile":         AuditActionFile,
+	"v1/PatchFile":         AuditActionFile,
+	"v1/RestoreFile":       AuditActionFile,
+	"v1/SyncDir":           AuditActionFile,
+	"v1/Process/Signal":    AuditActionProcess,
+	"v1/Config/Update":     AuditActionConfig,
+	"v1/Log/SetLevel":      AuditActionConfig,
+	"v1/Service/Start":     AuditActionService,
+	"v1/Service/Stop":      AuditActionService,
+	"v1/Service/Restart":   AuditActionService,
+	"v1/Service/Enable":    AuditActionService,
+	"v1/Service/Disable":   AuditActionService,
+	"v1/Package/Install":   AuditActionPackage,
+	"v1/Package/Upgrade":   AuditActionPackage,
+	"v1/Package/Rollback":  AuditActionPackage,
+	"v1/Package/Uninstall": AuditActionPackage,
+	"v1/SelfUpdate":        AuditActionSelfUpdate,
+	MsgTypeWorkflow:        AuditActionWorkflow,
+	"v1/Schedule/Create":   AuditActionSchedule,
+	"v1/Schedule/Delete":   AuditActionSchedule,
+	"v1/Schedule/Pause":    AuditActionSchedule,
+}
+
+// auditPathKeys 是请求中表示本机路径的字段
+var auditPathKeys = map[string]bool{
+	"path": true, "filePath": true, "src": true, "dest": true, "destPath": true,
+	"dir": true, "workDir": true, "installDir": true, "backupPath": true,
+}
+
+// AuditRecord 是一条审计日志。Hash 是除 Hash 之外所有字段的 JSON 的 sha256，
+// 其中包括上一条记录的 Hash，修改或删除任何一条记录都会使之后的校验失败
+type AuditRecord struct {
+	Seq          int64     `json:"seq"`
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"`              // script、file、process、config 等
+	Type         string    `json:"type"`                // 消息类型，或者 schedule.run、config.reload 等内部操作
+	MessageID    string    `json:"messageId,omitempty"` // 消息 ID
+	Requester    string    `json:"requester,omitempty"` // 消息发送者，内部操作为触发来源
+	TaskID       string    `json:"taskId,omitempty"`
+	TraceID      string    `json:"traceId,omitempty"`
+	ScriptHashes []string  `json:"scriptHashes,omitempty"` // 执行的脚本内容的 sha256
+	Paths        []string  `json:"paths,omitempty"`        // 涉及的本机路径
+	Code         string    `json:"code"`
+	Error        string    `json:"error,omitempty"`
+	Duration     int64     `json:"duration"` // 毫秒
+	PrevHash     string    `json:"prevHash"`
+	Hash         string    `json:"hash"`
+}
+
+// hash 计算记录的 hash，Hash 字段本身不参与计算
+func (r *AuditRecord) hash() string {
+	c := *r
+	c.Hash = ""
+	b, _ := json.Marshal(&c)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditFetchRequest 是 v1/Audit/Fetch 的请求参数，所有条件同时满足
+type AuditFetchRequest struct {
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"`
+	Action    string    `json:"action"`
+	Type      string    `json:"type"`
+	MessageID string    `json:"messageId"`
+	TaskID    string    `json:"taskId"`
+	Limit     int       `json:"limit"`  // 最多返回的条数，超过时返回最新的，0 使用默认值 200
+	Verify    bool      `json:"verify"` // 同时校验所有保留的审计日志的 hash 链
+}
+
+// AuditFetchResult 是 v1/Audit/Fetch 的结果，按 seq 从小到大排列
+type AuditFetchResult struct {
+	Records   []*AuditRecord     `json:"records"`
+	Truncated bool               `json:"truncated"` // 匹配的记录超过 limit，较早的记录没有返回
+	Verify    *AuditVerifyResult `json:"verify,omitempty"`
+}
+
+// AuditVerifyResult 是 hash 链的校验结果。超过保留时间的文件被删除后，从保留的第一条记录开始校验
+type AuditVerifyResult struct {
+	OK        bool   `json:"ok"`
+	Records   int    `json:"records"`
+	FirstSeq  int64  `json:"firstSeq"`
+	LastSeq   int64  `json:"lastSeq"`
+	BrokenSeq int64  `json:"brokenSeq,omitempty"` // 第一条校验失败的记录
+	Error     string `json:"error,omitempty"`
+}
+
+// AuditLog 是只追加的本地审计日志，超过大小上限时轮转，轮转的文件超过保留时间后删除
+type AuditLog struct {
+	mu        sync.Mutex
+	dir       string
+	maxSize   int64
+	retention time.Duration
+	seq       int64
+	lastHash  string
+	logger    *logger.Logger
+}
+
+func NewAuditLog(app *app.App) *AuditLog {
+	a := &AuditLog{
+		dir:       app.Config.AuditDir,
+		maxSize:   int64(app.Config.AuditMaxSize) * 1024 * 1024,
+		retention: time.Duration(app.Config.AuditRetention) * 24 * time.Hour,
+		logger:    app.Logger,
+	}
+	if a.dir == "" {
+		a.dir = defaultAuditDir
+	}
+	if a.maxSize <= 0 {
+		a.maxSize = defaultAuditMaxSize
+	}
+	if a.retention <= 0 {
+		a.retention = defaultAuditRetention
+	}
+	return a
+}
+
+// Open 创建审计日志目录，从最后一条记录恢复序号和 hash
+func (a *AuditLog) Open() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return err
+	}
+	if err := truncatePartialLine(filepath.Join(a.dir, auditFileName)); err != nil {
+		return err
+	}
+	files, err := a.files(time.Time{})
+	if err != nil {
+		return err
+	}
+	// 从最新的文件开始找最后一条完整的记录
+	for i := len(files) - 1; i >= 0; i-- {
+		var last *AuditRecord
+		err := scanAuditFile(files[i], func(r *AuditRecord) { last = r })
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			a.seq, a.lastHash = last.Seq, last.Hash
+			break
+		}
+	}
+	a.cleanup()
+	return nil
+}
+
+// truncatePartialLine 删除文件末尾写了一半的行。写入过程中崩溃留下的半行会和之后追加的记录连在一起，
+// 导致后面所有的校验都失败
+func truncatePartialLine(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// 从末尾向前按块查找最后一个换行符
+	size := info.Size()
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			if start+int64(i)+1 == size {
+				return nil
+			}
+			return f.Truncate(start + int64(i) + 1)
+		}
+		end = start
+	}
+	if size == 0 {
+		return nil
+	}
+	return f.Truncate(0)
+}
+
+// Record 追加一条记录，填充序号、时间和 hash，写入后同步到磁盘
+func (a *AuditLog) Record(r *AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r.Seq = a.seq + 1
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Time = r.Time.UTC()
+	r.PrevHash = a.lastHash
+	r.Hash = r.hash()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	path := filepath.Join(a.dir, auditFileName)
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(b)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	a.seq, a.lastHash = r.Seq, r.Hash
+	return nil
+}
+
+// RecordMessage 记录一条需要审计的消息的处理结果，其它消息类型忽略。
+// req 是处理前的请求消息的副本，路径和脚本 hash 从请求中解析；msg 是处理后的消息，
+// 结果码来自已经发送的响应，没有响应时根据 err 判断
+func (a *AuditLog) RecordMessage(req, msg *Message, err error, start time.Time) {
+	action, ok := auditedMessages[req.Type]
+	if !ok {
+		return
+	}
+	r := &AuditRecord{
+		Time:      start,
+		Action:    action,
+		Type:      req.Type,
+		MessageID: req.Id,
+		Requester: req.From,
+		TaskID:    req.TaskId,
+		TraceID:   req.TraceId,
+		Duration:  time.Since(start).Milliseconds(),
+	}
+	var data interface{}
+	if json.Unmarshal(req.Data, &data) == nil {
+		r.Paths = auditPaths(data)
+		if action == AuditActionScript || action == AuditActionWorkflow || action == AuditActionSchedule {
+			r.ScriptHashes = auditScriptHashes(data)
+		}
+	}
+	switch {
+	case msg.Method == METHOD_RESPONSE && req.Method != METHOD_RESPONSE:
+		r.Code = msg.Code
+		if msg.Code != CODE_SUCCESS {
+			r.Error = msg.Msg
+			break
+		}
+		// 脚本非零退出时同样以成功响应返回，按退出码记录为失败
+		var sr ScriptResult
+		if action == AuditActionScript && json.Unmarshal(msg.Data, &sr) == nil {
+			if e, failed := scriptFailure(&sr); failed {
+				r.Code, r.Error = CODE_ERROR, e
+			}
+		}
+	case err != nil:
+		r.Code, r.Error = ErrorCode(err), err.Error()
+	default:
+		r.Code = CODE_SUCCESS
+	}
+	if err := a.Record(r); err != nil {
+		a.logger.Errorw("write audit log failed", "type", req.Type, "id", req.Id, "error", err)
+	}
+}
+
+// scriptFailure 判断脚本是否执行失败，返回记录到审计日志中的错误
+func scriptFailure(r *ScriptResult) (string, bool) {
+	if r.Code == CodeSuccess && r.ExitCode == 0 {
+		return "", false
+	}
+	if r.Error != "" {
+		return r.Error, true
+	}
+	return fmt.Sprintf("%s: exit code %d", r.Code, r.ExitCode), true
+}
+
+// Fetch 返回满足条件的记录，verify 为 true 时同时校验 hash 链
+func (a *AuditLog) Fetch(req *AuditFetchRequest) (*AuditFetchResult, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := &AuditFetchResult{Records: []*AuditRecord{}}
+	if req.Verify {
+		v, err := a.verify()
+		if err != nil {
+			return nil, err
+		}
+		result.Verify = v
+	}
+
+	files, err := a.files(req.Since)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		err := scanAuditFile(file, func(r *AuditRecord) {
+			if !matchAuditRecord(r, req) {
+				return
+			}
+			if len(result.Records) == limit {
+				copy(result.Records, result.Records[1:])
+				result.Records = result.Records[:limit-1]
+				result.Truncated = true
+			}
+			result.Records = append(result.Records, r)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", file, err)
+		}
+	}
+	return result, nil
+}
+
+// Verify 校验所有保留的审计日志的 hash 链
+func (a *AuditLog) Verify() (*AuditVerifyResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.verify()
+}
+
+func (a *AuditLog) verify() (*AuditVerifyResult, error) {
+	files, err := a.files(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	v := &AuditVerifyResult{OK: true}
+	var prev *AuditRecord
+	for _, file := range files {
+		err := scanAuditLines(file, func(line []byte) bool {
+			r := new(AuditRecord)
+			if err := json.Unmarshal(line, r); err != nil {
+				v.fail(prev, fmt.Sprintf("invalid record after seq %d: %v", v.LastSeq, err))
+				return false
+			}
+			switch {
+			case r.Hash != r.hash():
+				v.fail(r, fmt.Sprintf("seq %d: hash mismatch", r.Seq))
+			case prev != nil && r.Seq != prev.Seq+1:
+				v.fail(r, fmt.Sprintf("seq %d: expected seq %d", r.Seq, prev.Seq+1))
+			case prev != nil && r.PrevHash != prev.Hash:
+				v.fail(r, fmt.Sprintf("seq %d: previous hash mismatch", r.Seq))
+			}
+			if !v.OK {
+				return false
+			}
+			if v.Records == 0 {
+				v.FirstSeq = r.Seq
+			}
+			v.Records++
+			v.LastSeq = r.Seq
+			prev = r
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", file, err)
+		}
+		if !v.OK {
+			break
+		}
+	}
+	if v.OK && prev != nil && prev.Hash != a.lastHash {
+		v.fail(prev, fmt.Sprintf("last record seq %d does not match the chain head", prev.Seq))
+	}
+	return v, nil
+}
+
+func (v *AuditVerifyResult) fail(r *AuditRecord, msg string) {
+	v.OK = false
+	v.Error = ErrAuditChainBroken.Error() + ": " + msg
+	if r != nil {
+		v.BrokenSeq = r.Seq
+	}
+}
+
+// rotate 把当前文件改名为 audit-<UTC 时间>-<最后一条记录的 seq>.log，然后删除过期的文件
+func (a *AuditLog) rotate() error {
+	path := filepath.Join(a.dir, auditFileName)
+	backup := filepath.Join(a.dir, fmt.Sprintf("audit-%s-%012d.log", time.Now().UTC().Format(auditTimeLayout), a.seq))
+	if err := os.Rename(path, backup); err != nil {
+		return err
+	}
+	a.cleanup()
+	return nil
+}
+
+// cleanup 删除最后修改时间超过保留时间的轮转文件，当前文件不会被删除
+func (a *AuditLog) cleanup() {
+	files, err := a.files(time.Time{})
+	if err != nil {
+		return
+	}
+	deadline := time.Now().Add(-a.retention)
+	for _, file := range files {
+		if filepath.Base(file) == auditFileName {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.ModTime().Before(deadline) {
+			if err := os.Remove(file); err != nil {
+				a.logger.Warnw("remove audit log failed", "path", file, "error", err)
+			}
+		}
+	}
+}
+
+// files 返回按时间排序的轮转文件和当前文件，忽略最后修改时间早于 since 的轮转文件
+func (a *AuditLog) files(since time.Time) ([]string, error) {
+	entries, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []string
+	current := ""
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case e.IsDir():
+		case name == auditFileName:
+			current = filepath.Join(a.dir, name)
+		case strings.HasPrefix(name, "audit-") && strings.HasSuffix(name, ".log"):
+			if since.IsZero() || !e.ModTime().Before(since) {
+				files = append(files, filepath.Join(a.dir, name))
+			}
+		}
+	}
+	sort.Strings(files)
+	if current != "" {
+		files = append(files, current)
+	}
+	return files, nil
+}
+
+// scanAuditFile 依次返回文件中的记录，跳过不完整的行
+func scanAuditFile(file string, fn func(*AuditRecord)) error {
+	return scanAuditLines(file, func(line []byte) bool {
+		r := new(AuditRecord)
+		if json.Unmarshal(line, r) == nil {
+			fn(r)
+		}
+		return true
+	})
+}
+
+// scanAuditLines fn 返回 false 时停止
+func scanAuditLines(file string, fn func([]byte) bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if !fn(scanner.Bytes()) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func matchAuditRecord(r *AuditRecord, req *AuditFetchRequest) bool {
+	switch {
+	case !req.Since.IsZero() && r.Time.Before(req.Since),
+		!req.Until.IsZero() && r.Time.After(req.Until),
+		req.Action != "" && r.Action != req.Action,
+		req.Type != "" && r.Type != req.Type,
+		req.MessageID != "" && r.MessageID != req.MessageID,
+		req.TaskID != "" && r.TaskID != req.TaskID:
+		return false
+	}
+	return true
+}
+
+// auditPaths 递归地收集请求中表示路径的字段，去掉重复的路径
+func auditPaths(data interface{}) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(x))
+			for k := range x {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if s, ok := x[k].(string); ok && auditPathKeys[k] && s != "" && !seen[s] && len(paths) < maxAuditPaths {
+					seen[s] = true
+					paths = append(paths, s)
+					continue
+				}
+				walk(x[k])
+			}
+		case []interface{}:
+			for _, item := range x {
+				walk(item)
+			}
+		}
+	}
+	walk(data)
+	return paths
+}
+
+// auditScriptHashes 递归地计算请求中所有脚本内容的 sha256
+func auditScriptHashes(data interface{}) []string {
+	var hashes []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			if s, ok := x["content"].(string); ok {
+				hashes = append(hashes, scriptHash(s))
+			}
+			keys := make([]string, 0, len(x))
+			for k := range x {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(x[k])
+			}
+		case []interface{}:
+			for _, item := range x {
+				walk(item)
+			}
+		}
+	}
+	walk(data)
+	return hashes
+}
+
+func scriptHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
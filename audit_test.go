@@ -0,0 +1,240 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+)
+
+func newTestAuditLog(t *testing.T, dir string) *AuditLog {
+	c := &config.Config{
+		LogConfig: config.LogConfig{Level: "info", Filename: filepath.Join(dir, "agent.log")},
+		AuditDir:  filepath.Join(dir, "audit"),
+	}
+	a := NewAuditLog(&app.App{Config: c, Logger: logger.NewLogger(c.LogConfig)})
+	if err := a.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuditLogChain(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestAuditLog(t, dir)
+	// 每个文件只能写下几条记录
+	a.maxSize = 1024
+
+	for i := 0; i < 20; i++ {
+		req := &Message{
+			From: "server", Id: "msg-" + string(rune('a'+i)), Type: "v1/DeleteFile", Method: METHOD_REQUEST,
+			Data: json.RawMessage(`{"path":"/tmp/a"}`),
+		}
+		if i%2 == 1 {
+			req.Type = "v1/Process/Signal"
+		}
+		resp := *req
+		resp.Method, resp.Code, resp.Data = METHOD_RESPONSE, CODE_SUCCESS, nil
+		if i%2 == 1 {
+			resp.Code, resp.Msg = CODE_PERMISSION_DENIED, "denied"
+		}
+		a.RecordMessage(req, &resp, nil, time.Now())
+	}
+	// 不需要审计的消息不记录
+	msg := &Message{Type: "v1/GetTaskInfo"}
+	a.RecordMessage(msg, msg, nil, time.Now())
+
+	files, err := a.files(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 3 {
+		t.Fatalf("audit log not rotated: %v", files)
+	}
+
+	result, err := a.Fetch(&AuditFetchRequest{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := result.Verify; !v.OK || v.Records != 20 || v.FirstSeq != 1 || v.LastSeq != 20 {
+		t.Fatalf("unexpected verify result: %+v", v)
+	}
+	if len(result.Records) != 20 || result.Records[0].Paths[0] != "/tmp/a" || result.Records[1].Code != CODE_PERMISSION_DENIED || result.Records[1].Error != "denied" {
+		t.Fatalf("unexpected records: %+v", result.Records)
+	}
+
+	result, err = a.Fetch(&AuditFetchRequest{Action: AuditActionProcess, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Records) != 3 || !result.Truncated || result.Records[2].Seq != 20 {
+		t.Fatalf("unexpected filtered records: %+v", result.Records)
+	}
+
+	// 重新打开后继续原来的 hash 链
+	b := newTestAuditLog(t, dir)
+	if b.seq != 20 || b.lastHash != a.lastHash {
+		t.Fatalf("chain not recovered: seq %d", b.seq)
+	}
+	if err := b.Record(&AuditRecord{Action: AuditActionConfig, Type: "config.reload", Code: CODE_SUCCESS}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.Verify(); err != nil || !v.OK || v.LastSeq != 21 {
+		t.Fatalf("unexpected verify result after reopen: %+v, %v", v, err)
+	}
+
+	// 修改一条记录后校验失败
+	file := files[1]
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(string(raw), "\n", 2)
+	var r AuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(lines[0], `"code":"`+r.Code+`"`, `"code":"tampered"`, 1)
+	if err := ioutil.WriteFile(file, []byte(tampered+"\n"+lines[1]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := b.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.OK || v.BrokenSeq != r.Seq || !strings.Contains(v.Error, ErrAuditChainBroken.Error()) {
+		t.Fatalf("tampering not detected: %+v", v)
+	}
+}
+
+func TestAuditLogDeletedRecord(t *testing.T) {
+	a := newTestAuditLog(t, t.TempDir())
+	for i := 0; i < 3; i++ {
+		msg := &Message{Type: "v1/ExecuteScript"}
+		a.RecordMessage(msg, msg, errors.New("failed"), time.Now())
+	}
+
+	path := filepath.Join(a.dir, auditFileName)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(raw), "\n")
+	if err := ioutil.WriteFile(path, []byte(lines[0]+"\n"+lines[2]+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := a.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.OK || v.BrokenSeq != 3 {
+		t.Fatalf("deleted record not detected: %+v", v)
+	}
+
+	// 删除最后一条记录同样可以发现
+	if err := ioutil.WriteFile(path, []byte(lines[0]+"\n"+lines[1]+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := a.Verify(); v.OK {
+		t.Fatalf("truncation not detected: %+v", v)
+	}
+}
+
+func TestAuditLogPartialLine(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestAuditLog(t, dir)
+	for i := 0; i < 2; i++ {
+		if err := a.Record(&AuditRecord{Action: AuditActionConfig, Type: "config.reload", Code: CODE_SUCCESS}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 模拟写入过程中崩溃留下的半行
+	path := filepath.Join(a.dir, auditFileName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"time":"20`)
+	f.Close()
+
+	b := newTestAuditLog(t, dir)
+	if err := b.Record(&AuditRecord{Action: AuditActionConfig, Type: "config.reload", Code: CODE_SUCCESS}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.Verify(); err != nil || !v.OK || v.Records != 3 || v.LastSeq != 3 {
+		t.Fatalf("unexpected verify result after partial line: %+v, %v", v, err)
+	}
+}
+
+func TestAuditMessageFields(t *testing.T) {
+	a := newTestAuditLog(t, t.TempDir())
+	msg := &Message{
+		From: "ops", Id: "m1", Type: MsgTypeWorkflow, TaskId: "wf-1", TraceId: "trace-1",
+		Data: json.RawMessage(`{"steps":[{"type":"script","content":"echo 1","workDir":"/opt"},
+			{"type":"move","src":"/tmp/a","dest":"/opt/a"},{"type":"script","content":"echo 2","workDir":"/opt"}]}`),
+	}
+	a.RecordMessage(msg, msg, errors.New("step failed"), time.Now())
+
+	result, err := a.Fetch(&AuditFetchRequest{MessageID: "m1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Records) != 1 {
+		t.Fatalf("unexpected records: %+v", result.Records)
+	}
+	r := result.Records[0]
+	if r.Action != AuditActionWorkflow || r.Requester != "ops" || r.TaskID != "wf-1" || r.TraceID != "trace-1" ||
+		r.Code != CODE_ERROR || r.Error != "step failed" {
+		t.Fatalf("unexpected record: %+v", r)
+	}
+	if len(r.ScriptHashes) != 2 || r.ScriptHashes[0] != scriptHash("echo 1") || r.ScriptHashes[1] != scriptHash("echo 2") {
+		t.Fatalf("unexpected script hashes: %v", r.ScriptHashes)
+	}
+	if strings.Join(r.Paths, ",") != "/opt,/opt/a,/tmp/a" {
+		t.Fatalf("unexpected paths: %v", r.Paths)
+	}
+}
+
+func TestAuditScriptResponse(t *testing.T) {
+	a := newTestAuditLog(t, t.TempDir())
+	handle := func(id, content string, result *ScriptResult) {
+		msg := &Message{
+			From: "ops", Id: id, Type: "v1/ExecuteScript", Method: METHOD_REQUEST,
+			Data: json.RawMessage(`{"content":"` + content + `","workDir":"/opt/app"}`),
+		}
+		req := *msg
+		ctx := newTestContext()
+		ctx.Client = &Client{send: make(chan []byte, 1)}
+		ctx.Client.Connected.Store(true)
+		ctx.Message = msg
+		ctx.JSONSuccess(result)
+		a.RecordMessage(&req, msg, nil, time.Now())
+	}
+	handle("ok", "echo ok", &ScriptResult{Code: CodeSuccess})
+	handle("fail", "exit 3", &ScriptResult{Code: CodeSuccess, ExitCode: 3})
+
+	result, err := a.Fetch(&AuditFetchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Records) != 2 {
+		t.Fatalf("unexpected records: %+v", result.Records)
+	}
+	ok, fail := result.Records[0], result.Records[1]
+	if ok.Code != CODE_SUCCESS || len(ok.ScriptHashes) != 1 || ok.ScriptHashes[0] != scriptHash("echo ok") ||
+		strings.Join(ok.Paths, ",") != "/opt/app" {
+		t.Fatalf("unexpected record: %+v", ok)
+	}
+	if fail.Code != CODE_ERROR || fail.Error != string(CodeSuccess)+": exit code 3" ||
+		len(fail.ScriptHashes) != 1 || fail.ScriptHashes[0] != scriptHash("exit 3") {
+		t.Fatalf("unexpected record of failed script: %+v", fail)
+	}
+}
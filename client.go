@@ -1,21 +1,26 @@
 package updater
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/url"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"updater/pkg/app"
 	"updater/pkg/config"
+	"updater/pkg/logger"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
 )
 
 type Client struct {
@@ -23,21 +28,29 @@ type Client struct {
 	send           chan []byte
 	Url            string
 	UUID           string
-	Connected      bool
-	Registered     bool // 是否已经注册
+	Connected      atomic.Bool
+	Registered     atomic.Bool // 是否已经注册
 	HostIP         string
 	LocalIPs       string
 	HostName       string
 	Vmuuid         string
+	Sn             string // DMI 序列号
 	Token          string // 新增 Token 字段
 	Server         *Server
 	OS             string //
 	Arch           string //
 	Version        string
+	SelfUpdater    *SelfUpdater    // 在心跳中上报自更新结果
+	Facts          *FactsCollector // 在注册时上报主机清单信息
 	messageHandler *MessageHandler
 	app            *app.App
+	mu             sync.Mutex // 保护 Server、servers 和 conn 的切换
+	servers        []*Server  // 候选服务器，当前服务器连接失败时依次尝试
+	epoch          uint64     // 连接序号，每次重连加一
 }
 
+var ErrNotConnected = errors.New("client is not connected")
+
 type Server struct {
 	Url     *url.URL
 	Load    int
@@ -54,23 +67,24 @@ func NewClient(server *Server, messageHandler *MessageHandler, app *app.App) *Cl
 	}
 }
 
-func NewServer(urlAddress string) *Server {
+func NewServer(urlAddress string) (*Server, error) {
 	u, err := url.Parse(urlAddress)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	return &Server{
 		Url: u,
-	}
+	}, nil
 }
 
 func (c *Client) connect() error {
 	if c.UUID == "" {
 		c.setUUID()
 	}
+	server := c.currentServer()
 	dialer := websocket.Dialer{}
-	address := c.Server.Url.String() + c.UUID + "/" + uuid.New().String()
-	log.Println("connect to:", address)
+	address := server.Url.String() + c.UUID + "/" + uuid.New().String()
+	c.logger().Infow("connect to server", "address", address)
 	conn, _, err := dialer.Dial(address, nil)
 	if err != nil {
 		return err
@@ -86,22 +100,35 @@ func (c *Client) connect() error {
 	}
 	// 保存连接和负载信息
 
+	c.mu.Lock()
 	c.conn = conn
-	c.Connected = true
-	log.Printf("Connected to %s, load: %d", c.Server.Url.String(), load)
-	c.Server.mu.Lock()
-	c.Server.Load = load
-	c.Server.Checked = true
-	c.Server.mu.Unlock()
+	c.mu.Unlock()
+	c.Connected.Store(true)
+	c.logger().Infow("connected to server", "server", server.Url.String(), "load", load)
+	server.mu.Lock()
+	server.Load = load
+	server.Checked = true
+	server.mu.Unlock()
 	return nil
 }
 
+// PackageURL 返回服务器上软件包的下载地址
+func (c *Client) PackageURL(downloadPath string) string {
+	server := c.currentServer()
+	scheme := "http"
+	if server.Url.Scheme == "wss" {
+		scheme = "https"
+	}
+	return scheme + "://" + server.Url.Host + "/api/v1/pkg/" + strings.TrimPrefix(downloadPath, "/")
+}
+
 // 尝试连接到每个服务器，返回连接成功并且负载最低的客户端
 func ConnectToServers(servers []*Server, messageHandler *MessageHandler, app *app.App) (*Client, error) {
 	var minLoad int
 	var minClient *Client
 	for _, server := range servers {
 		client := NewClient(server, messageHandler, app)
+		client.servers = servers
 		err := client.connect()
 		if err == nil {
 			if minClient == nil || client.Server.Load < minLoad {
@@ -121,8 +148,7 @@ func (c *Client) Start() error {
 	for {
 		err := c.connect()
 		if err != nil {
-			log.Println("connect to:", c.Server.Url.String()+c.UUID, " error:", err)
-			log.Println("retry after 5 seconds")
+			c.logger().Warnw("connect to server failed, retry after 5 seconds", "server", c.ServerURL(), "error", err)
 			time.Sleep(time.Second * 5)
 			continue
 		}
@@ -140,13 +166,13 @@ func (c *Client) Start() error {
 func (c *Client) RegisterUntilSuccess() {
 	for {
 		c.ClientRegister()
-		log.Println("registering...")
+		c.logger().Info("registering...")
 		time.Sleep(time.Second * 5)
-		if c.Registered {
-			log.Println("register success")
+		if c.Registered.Load() {
+			c.logger().Info("register success")
 			break
 		}
-		log.Println("register failed, retry after 5 seconds")
+		c.logger().Warn("register failed, retry after 5 seconds")
 		time.Sleep(time.Second * 5)
 	}
 }
@@ -168,7 +194,7 @@ func (c *Client) setInitClientInfo() {
 	c.LocalIPs, err = config.GetLocalIPs()
 
 	if err != nil {
-		log.Println("get local ips error:", err)
+		c.logger().Warnw("get local ips failed", "error", err)
 	}
 
 	return
@@ -176,12 +202,20 @@ func (c *Client) setInitClientInfo() {
 
 func (c *Client) ClientRegister() {
 	c.setInitClientInfo()
+	var facts *Facts
+	if c.Facts != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), factsCollectTimeout)
+		facts = c.Facts.Get(ctx, factsRegisterMaxAge)
+		cancel()
+		c.Sn = facts.DMI.Serial
+	}
 	clientinfo := c.getClientInfo()
 	clientinfo.LocalIPs = c.LocalIPs
+	clientinfo.Facts = facts
 
 	data, err := json.Marshal(clientinfo)
 	if err != nil {
-		log.Println("marshal client info error:", err)
+		c.logger().Errorw("marshal client info failed", "error", err)
 		return
 	}
 
@@ -213,7 +247,7 @@ func (c *Client) setUUID() {
 			// Read the UUID from the file
 			data, err := ioutil.ReadFile("uuid.txt")
 			if err != nil {
-				log.Println("Failed to read UUID from file:", err)
+				c.logger().Errorw("read uuid file failed", "error", err)
 			} else {
 				c.UUID = string(data)
 				return
@@ -224,38 +258,107 @@ func (c *Client) setUUID() {
 			// Write the UUID to the file
 			err := ioutil.WriteFile("uuid.txt", []byte(c.UUID), 0644)
 			if err != nil {
-				log.Println("Failed to write UUID to file:", err)
+				c.logger().Errorw("write uuid file failed", "error", err)
 			}
 		}
 	}
 	return
 }
 
+func (c *Client) currentServer() *Server {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Server
+}
+
+// ServerURL 返回当前连接的服务器地址
+func (c *Client) ServerURL() string {
+	return c.currentServer().Url.String()
+}
+
+// Online 是否已经连接并注册成功
+func (c *Client) Online() bool {
+	return c.Connected.Load() && c.Registered.Load()
+}
+
+// nextServer 当前服务器连接失败时切换到下一个候选服务器
+func (c *Client) nextServer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, s := range c.servers {
+		if s == c.Server {
+			c.Server = c.servers[(i+1)%len(c.servers)]
+			return
+		}
+	}
+	if len(c.servers) > 0 {
+		c.Server = c.servers[0]
+	}
+}
+
+// SetServers 替换候选服务器列表。当前服务器不在新的列表中时断开连接，由 readPump 重连到新的服务器
+func (c *Client) SetServers(urls []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("no server address")
+	}
+	servers := make([]*Server, 0, len(urls))
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, &Server{Url: parsed})
+	}
+
+	c.mu.Lock()
+	keep := false
+	for i, s := range servers {
+		if c.Server != nil && s.Url.String() == c.Server.Url.String() {
+			servers[i], keep = c.Server, true
+		}
+	}
+	c.servers = servers
+	if !keep {
+		c.Server = servers[0]
+	}
+	conn := c.conn
+	c.mu.Unlock()
+
+	if !keep && conn != nil {
+		c.logger().Infow("server list changed, reconnect", "server", servers[0].Url.String())
+		c.Registered.Store(false)
+		conn.Close()
+	}
+	return nil
+}
+
 // 重连
 func (c *Client) reconnect() {
-	c.Connected = false
+	c.Connected.Store(false)
+	atomic.AddUint64(&c.epoch, 1)
+	telemetry.reconnects.Inc()
 	time.Sleep(5 * time.Second)
-	log.Println("reconnecting to server...", c.Server.Url.String()+c.UUID)
+	c.logger().Infow("reconnecting to server", "server", c.ServerURL())
 
 	err := c.connect()
 	if err != nil {
-		log.Println("connect to:", c.Server.Url.String()+c.UUID, " error:", err)
-		log.Println("retry after 5 seconds")
+		c.logger().Warnw("connect to server failed, retry after 5 seconds", "server", c.ServerURL(), "error", err)
+		c.nextServer()
 		time.Sleep(time.Second * 5)
 		return
 	}
 
-	c.Registered = false
+	c.Registered.Store(false)
 	go func() {
 		for {
 			c.ClientRegister()
-			log.Println("registering...")
+			c.logger().Info("registering...")
 			time.Sleep(time.Second * 5)
-			if c.Registered {
-				log.Println("register success")
+			if c.Registered.Load() {
+				c.logger().Info("register success")
 				break
 			}
-			log.Println("register failed, retry after 5 seconds")
+			c.logger().Warn("register failed, retry after 5 seconds")
 			time.Sleep(time.Second * 5)
 		}
 	}()
@@ -268,20 +371,18 @@ func (c *Client) readPump() {
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			log.Println("read:", err)
+			c.logger().Warnw("read message failed", "error", err)
 
 			c.reconnect()
 			continue
 		}
-		log.Println("recv: ", string(message))
-		// 这里你可以添加处理消息的逻辑
-
 		msg := new(Message)
 		err = json.Unmarshal(message, msg)
 		if err != nil {
-			log.Println("json unmarshal error:", err)
+			c.logger().Errorw("unmarshal message failed", "error", err, "message", string(message))
 			continue
 		}
+		c.logger().WithTrace(msg.TraceId, msg.TaskId).Debugw("recv", "id", msg.Id, "type", msg.Type, "method", msg.Method, "size", len(message))
 		if c.messageHandler != nil {
 			c.messageHandler.SubmitMessage(msg)
 		}
@@ -299,20 +400,20 @@ func (c *Client) writePump() {
 		select {
 		case message, ok := <-c.send:
 			if !ok {
-				log.Println("send channel closed")
+				c.logger().Info("send channel closed")
 				// 如果通道关闭，关闭websocket连接
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 			err := c.conn.WriteMessage(websocket.TextMessage, message)
 			if err != nil {
-				log.Println("write:", err)
+				c.logger().Warnw("write message failed", "error", err)
 				c.reconnect()
 				continue
 			}
 		case <-ticker.C:
 			// 定时ping服务器以保持连接
-			log.Println("send heartbeat to server...")
+			c.logger().Debug("send heartbeat to server...")
 			c.Heartbeat()
 		}
 	}
@@ -324,6 +425,7 @@ func (c *Client) getClientInfo() (r *ClientInfo) {
 		HostIP:    c.HostIP,
 		HostName:  c.HostName,
 		Vmuuid:    c.Vmuuid,
+		Sn:        c.Sn,
 		OS:        c.OS,
 		Arch:      c.Arch,
 		Heartbeat: time.Now().Unix(),
@@ -334,6 +436,9 @@ func (c *Client) getClientInfo() (r *ClientInfo) {
 
 func (c *Client) Heartbeat() {
 	clientInfo := c.getClientInfo()
+	if c.SelfUpdater != nil {
+		clientInfo.SelfUpdate = c.SelfUpdater.Report()
+	}
 	b, _ := json.Marshal(clientInfo)
 	msg := &Message{
 		Id:     uuid.New().String(),
@@ -343,13 +448,15 @@ func (c *Client) Heartbeat() {
 	}
 
 	c.SendMessage(msg)
-
+	if clientInfo.SelfUpdate != nil && c.Connected.Load() {
+		c.SelfUpdater.MarkReported()
+	}
 }
 
 type ClientInfo struct {
 	UUID      string `json:"uuid"`
 	HostIP    string `json:"hostIp"`
-	HostName  string `json:"hostName""`
+	HostName  string `json:"hostName"`
 	Vmuuid    string `json:"vmuuid"`
 	Sn        string `json:"sn"`       // 序列号
 	OS        string `json:"os"`       //
@@ -357,6 +464,9 @@ type ClientInfo struct {
 	Heartbeat int64  `json:"hearbeat"` // 心跳时间
 	LocalIPs  string `json:"localIps"` // 本地IP地址
 	Version   string `json:"version"`  // 客户端版本
+
+	SelfUpdate *SelfUpdateStatus `json:"selfUpdate,omitempty"` // 还没有上报的自更新结果
+	Facts      *Facts            `json:"facts,omitempty"`      // 主机清单信息，只在注册时上报
 }
 
 // 向服务器发送消息
@@ -368,17 +478,68 @@ func (c *Client) SendMessage(msg *Message) error {
 
 	b, err := json.Marshal(msg)
 	if err != nil {
-		log.Println("marshal message error:", err)
+		c.logger().Errorw("marshal message failed", "error", err, "type", msg.Type)
 		return err
 	}
+	c.logger().WithTrace(msg.TraceId, msg.TaskId).Debugw("send", "id", msg.Id, "type", msg.Type, "method", msg.Method, "code", msg.Code)
+	if c.Connected.Load() {
+		telemetry.messagesOut.With(msg.Type).Inc()
+	}
 	c.Send(b)
 	return nil
 }
 
+// Notify 向服务器主动发送一条请求消息，例如文件变化事件
+func (c *Client) Notify(msgType string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(&Message{
+		Type:   msgType,
+		Method: METHOD_REQUEST,
+		Data:   b,
+	})
+}
+
 func (c *Client) Send(msg []byte) {
-	log.Println("send: ", string(msg))
-	if c.Connected {
-		log.Println("send message to server...")
+	if c.Connected.Load() {
 		c.send <- msg
+	} else {
+		telemetry.messagesDropped.Inc()
+		c.logger().Debugw("not connected, drop message", "size", len(msg))
+	}
+}
+
+// Epoch 返回连接序号，两次调用之间的值不同说明连接中断过，期间的消息可能已经丢失
+func (c *Client) Epoch() uint64 {
+	return atomic.LoadUint64(&c.epoch)
+}
+
+// WaitQueue 等待发送队列中的消息少于 limit，用于大量发送数据时的流量控制。
+// 未连接时返回 ErrNotConnected，避免消息被丢弃
+func (c *Client) WaitQueue(ctx context.Context, limit int) error {
+	for {
+		if !c.Connected.Load() {
+			return ErrNotConnected
+		}
+		if len(c.send) < limit {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (c *Client) logger() *logger.Logger {
+	if c.app != nil && c.app.Logger != nil {
+		return c.app.Logger
+	}
+	if l := logger.GetLogger(); l != nil {
+		return l
 	}
+	return &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
 }
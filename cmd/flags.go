@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"updater/pkg/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setFlags 是可以重复的 -set key=value 参数
+type setFlags map[string]string
+
+func (s setFlags) String() string {
+	return ""
+}
+
+func (s setFlags) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", v)
+	}
+	s[strings.TrimSpace(key)] = value
+	return nil
+}
+
+// parseFlags 解析命令行参数，返回合并配置使用的 Loader 和剩余的子命令参数
+func parseFlags(args []string) (*config.Loader, []string, error) {
+	fs := flag.NewFlagSet("updater", flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "Usage: updater [flags] [config print [-format json|yaml]]")
+		fmt.Fprintln(out, "\nConfiguration is merged from defaults, the config file, UPDATER_* environment variables and flags, later ones win.")
+		fmt.Fprintln(out, "\nFlags:")
+		fs.PrintDefaults()
+	}
+	file := fs.String("config", "", "config file (json or yaml), defaults to $CONFIG_FILE or ./config.json")
+	server := fs.String("server", "", "comma separated server addresses, overrides serverAddress")
+	level := fs.String("log-level", "", "log level, overrides logConfig.level")
+	set := setFlags{}
+	fs.Var(set, "set", "set a config key, e.g. -set logConfig.format=console (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *server != "" {
+		set["serverAddress"] = *server
+	}
+	if *level != "" {
+		set["logConfig.level"] = *level
+	}
+	return config.NewLoader(*file, set), fs.Args(), nil
+}
+
+// runCommand 执行子命令，返回进程退出码
+func runCommand(loader *config.Loader, args []string, stdout, stderr io.Writer) int {
+	if len(args) < 2 || args[0] != "config" || args[1] != "print" {
+		fmt.Fprintf(stderr, "unknown command: %s\n", strings.Join(args, " "))
+		return 2
+	}
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	format := fs.String("format", "json", "output format: json or yaml")
+	if err := fs.Parse(args[2:]); err != nil {
+		return 2
+	}
+
+	c, err := loader.Load()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	doc := config.Redacted(c)
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(doc)
+	case "yaml":
+		err = yaml.NewEncoder(stdout).Encode(doc)
+	default:
+		fmt.Fprintf(stderr, "unknown format: %s\n", *format)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"flag"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"updater"
@@ -14,44 +18,178 @@ import (
 
 func main() {
 
-	config.InitConfig()
+	loader, args, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	if len(args) > 0 {
+		os.Exit(runCommand(loader, args, os.Stdout, os.Stderr))
+	}
+
+	cfg, err := loader.Load()
+	exitOnError(err)
+	config.SetConfig(cfg)
 	logger.InitLogger()
 
 	var client *updater.Client
-	var err error
 
 	servers := make([]*updater.Server, 0)
 	msghanlder := updater.NewMessageHandler(10)
 
+	appInfo, err := app.NewApp()
+	exitOnError(err)
+	tracer, err := updater.StartTracing(appInfo)
+	if err != nil {
+		appInfo.Logger.Println("start tracing failed:", err)
+	}
+	watcher := updater.NewFileWatcher(appInfo)
+	scheduler := updater.NewScheduler(appInfo)
+	selfUpdater := updater.NewSelfUpdater(appInfo)
+	facts := updater.NewFactsCollector(appInfo)
+	taskLogs := updater.NewTaskLogs(appInfo)
+	if err := taskLogs.Start(); err != nil {
+		appInfo.Logger.Println("start task logs failed:", err)
+	}
+	msghanlder.TaskLogs = taskLogs
+	// 审计日志无法打开时不记录，v1/Audit/Fetch 返回不可用
+	audit := updater.NewAuditLog(appInfo)
+	if err := audit.Open(); err != nil {
+		appInfo.Logger.Println("open audit log failed:", err)
+		audit = nil
+	} else {
+		msghanlder.Audit = audit
+		scheduler.SetAuditLog(audit)
+	}
+	if err := selfUpdater.Resume(); err != nil {
+		appInfo.Logger.Println("resume self update failed:", err)
+	}
+
 	v1.NewFileController(msghanlder)
 	v1.NewAuthController(msghanlder)
 	v1.NewScriptController(msghanlder)
-
-	appInfo := app.NewApp()
+	v1.NewTaskController(msghanlder, taskLogs)
+	v1.NewWatchController(msghanlder, watcher)
+	v1.NewSelfUpdateController(msghanlder, selfUpdater)
+	v1.NewPackageController(msghanlder, updater.NewPackageManager(appInfo))
+	v1.NewWorkflowController(msghanlder)
+	v1.NewScheduleController(msghanlder, scheduler)
+
+	serviceManager, err := updater.NewServiceManager(appInfo.Config.ServiceManager)
+	if err != nil {
+		appInfo.Logger.Println("service manager is not available:", err)
+	}
+	v1.NewServiceController(msghanlder, serviceManager)
+	v1.NewProcessController(msghanlder)
+	v1.NewFactsController(msghanlder, facts)
+	v1.NewInventoryController(msghanlder, updater.NewInventory(appInfo))
+	v1.NewLogController(msghanlder, updater.NewLogManager(appInfo))
+	v1.NewAuditController(msghanlder, audit)
+
+	configManager := updater.NewConfigManager(appInfo, loader)
+	if audit != nil {
+		configManager.SetAuditLog(audit)
+	}
+	configManager.OnChange(func(c *config.Config) {
+		watcher.SetLimit(c.WatchLimit)
+		scheduler.SetLimits(c.ScheduleLimit, c.ScheduleSpoolLimit)
+	})
+	v1.NewConfigController(msghanlder, configManager)
+	if err := configManager.Start(); err != nil {
+		appInfo.Logger.Println("watch config file failed:", err)
+	}
 
 	msghanlder.PrintRegisteredHandlers()
 
+	// 定时任务不依赖与服务器的连接，执行结果在连接可用时上报
+	var connected atomic.Pointer[updater.Client]
+	err = scheduler.Start(func(r *updater.ScheduleRunResult) bool {
+		client := connected.Load()
+		if client == nil || !client.Online() {
+			return false
+		}
+		return client.Notify("v1/Schedule/Result", r) == nil
+	})
+	if err != nil {
+		appInfo.Logger.Println("start scheduler failed:", err)
+	}
+
+	metrics := updater.NewMetricsCollector(appInfo)
+	err = metrics.Start(func(b *updater.MetricsBatch) bool {
+		client := connected.Load()
+		if client == nil || !client.Online() {
+			return false
+		}
+		return client.Notify("Metrics", b) == nil
+	})
+	if err != nil {
+		appInfo.Logger.Println("start metrics collector failed:", err)
+	}
+
+	// 连接服务器之前开始监听，连接过程中 /healthz 返回不可用
+	monitor := updater.NewMonitorServer(appInfo, msghanlder)
+	if err := monitor.Start(); err != nil {
+		appInfo.Logger.Println("start monitor server failed:", err)
+	}
+
+	// 退出前停止所有组件，等待定时任务结束并上报缓存的 span
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	shutdown := func() {
+		appInfo.Logger.Println("shutting down")
+		monitor.Stop()
+		watcher.Stop()
+		scheduler.Stop()
+		metrics.Stop()
+		configManager.Stop()
+		if client != nil {
+			client.Stop()
+		}
+		taskLogs.Stop()
+		updater.StopTracing(tracer)
+	}
+
 	for _, item := range config.GetConfig().ServerAddress {
-		servers = append(servers, updater.NewServer(item))
+		server, err := updater.NewServer(item)
+		exitOnError(err)
+		servers = append(servers, server)
 	}
 	for {
 		client, err = updater.ConnectToServers(servers, msghanlder, appInfo)
-		if err != nil {
-			time.Sleep(time.Second * 5)
-			continue
+		if err == nil {
+			break
+		}
+		select {
+		case <-sig:
+			shutdown()
+			return
+		case <-time.After(time.Second * 5):
 		}
-		break
 	}
+	client.SelfUpdater = selfUpdater
+	client.Facts = facts
+	configManager.SetClient(client)
+	monitor.SetClient(client)
+	connected.Store(client)
 
 	msghanlder.HandleMessages(client, 10)
 
 	client.Start()
+	selfUpdater.Confirm()
 
-	sig := make(chan os.Signal, 1)
+	if err := updater.ResumeWorkflows(client, appInfo); err != nil {
+		appInfo.Logger.Println("resume workflows failed:", err)
+	}
 
-	select {
-	case <-sig:
-		client.Stop()
+	err = watcher.Start(func(ev *updater.WatchEvent) {
+		client.Notify("v1/WatchPath/Event", ev)
+	})
+	if err != nil {
+		appInfo.Logger.Println("start file watcher failed:", err)
 	}
 
+	<-sig
+	shutdown()
 }
@@ -0,0 +1,466 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	ConfigSourceUpdate = "update" // v1/Config/Update
+	ConfigSourceFile   = "file"   // 配置文件被修改
+	ConfigSourceSignal = "signal" // 收到 SIGHUP
+
+	defaultConfigReconnectTimeout = 60
+
+	configReloadDelay = 500 * time.Millisecond
+	configSwitchDelay = time.Second
+)
+
+var ErrConfigUpdating = errors.New("config update is in progress")
+
+// hotConfigKeys 是不需要重启就能生效的配置项，其它配置项写入文件后在重启时生效
+var hotConfigKeys = []string{
+	"serverAddress", "logConfig.level", "pathPolicy", "processPolicy",
+	"watchLimit", "scheduleLimit", "scheduleSpoolLimit",
+}
+
+// ConfigUpdateRequest 是 v1/Config/Update 的请求参数，config 和 patch 二选一
+type ConfigUpdateRequest struct {
+	Config           json.RawMessage `json:"config"`           // 完整的配置，替换配置文件
+	Patch            json.RawMessage `json:"patch"`            // JSON merge patch（RFC 7386），在当前配置文件的基础上修改
+	ReconnectTimeout int             `json:"reconnectTimeout"` // 修改服务器地址后等待重连成功的时间（秒），超时后回滚
+}
+
+// ConfigUpdateResult 是一次配置更新或重新加载的结果
+type ConfigUpdateResult struct {
+	Source          string   `json:"source"`
+	Changed         []string `json:"changed"`                   // 修改的配置项
+	Applied         []string `json:"applied"`                   // 已经生效的配置项
+	RestartRequired []string `json:"restartRequired,omitempty"` // 重启后才能生效的配置项
+	Reconnecting    bool     `json:"reconnecting,omitempty"`    // 正在连接新的服务器，结果通过 v1/Config/Result 上报
+	RolledBack      bool     `json:"rolledBack,omitempty"`      // 无法连接新的服务器，已经恢复原来的配置
+	Error           string   `json:"error,omitempty"`
+}
+
+// configClient 是 ConfigManager 切换服务器时用到的客户端方法
+type configClient interface {
+	SetServers(urls []string) error
+	ServerURL() string
+	Online() bool
+}
+
+// ConfigManager 在运行时重新加载配置文件，或者应用服务器推送的配置。
+// 推送的配置修改了服务器地址时，在超时时间内无法连接新的服务器则回滚到原来的配置
+type ConfigManager struct {
+	mu       sync.Mutex
+	loader   *config.Loader
+	path     string
+	raw      []byte // 当前生效的配置文件内容
+	app      *app.App
+	client   configClient
+	hooks    []func(*config.Config)
+	updating bool
+	audit    *AuditLog
+	done     chan struct{}
+}
+
+// NewConfigManager loader 与启动时加载配置使用的相同，重新加载时环境变量和命令行参数同样覆盖配置文件
+func NewConfigManager(app *app.App, loader *config.Loader) *ConfigManager {
+	path := loader.Path()
+	raw, _ := ioutil.ReadFile(path)
+	return &ConfigManager{
+		loader: loader,
+		path:   path,
+		raw:    raw,
+		app:    app,
+		done:   make(chan struct{}),
+	}
+}
+
+// SetClient 设置切换服务器使用的客户端，没有设置时修改服务器地址只在重启后生效
+func (cm *ConfigManager) SetClient(c *Client) {
+	cm.mu.Lock()
+	cm.client = c
+	cm.mu.Unlock()
+}
+
+// SetAuditLog 设置审计日志，配置文件重新加载和回滚都会记录
+func (cm *ConfigManager) SetAuditLog(a *AuditLog) {
+	cm.mu.Lock()
+	cm.audit = a
+	cm.mu.Unlock()
+}
+
+// OnChange 注册配置生效时的回调，用于更新各个模块保存的数量上限等配置
+func (cm *ConfigManager) OnChange(fn func(*config.Config)) {
+	cm.mu.Lock()
+	cm.hooks = append(cm.hooks, fn)
+	cm.mu.Unlock()
+}
+
+// Start 监听配置文件的修改和 SIGHUP，重新加载配置。配置不合法时保持原来的配置
+func (cm *ConfigManager) Start() error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// 编辑器通常通过重命名替换文件，所以监听所在的目录
+	if err := fsw.Add(filepath.Dir(cm.path)); err != nil {
+		fsw.Close()
+		return err
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer fsw.Close()
+		defer signal.Stop(hup)
+		var timer <-chan time.Time
+		for {
+			select {
+			case <-cm.done:
+				return
+			case <-hup:
+				cm.reload(ConfigSourceSignal)
+			case ev, ok := <-fsw.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) == filepath.Clean(cm.path) {
+					timer = time.After(configReloadDelay)
+				}
+			case err, ok := <-fsw.Errors:
+				if !ok {
+					return
+				}
+				cm.app.Logger.Println("watch config file error:", err)
+			case <-timer:
+				timer = nil
+				cm.reload(ConfigSourceFile)
+			}
+		}
+	}()
+	return nil
+}
+
+func (cm *ConfigManager) Stop() {
+	close(cm.done)
+}
+
+func (cm *ConfigManager) reload(source string) {
+	start := time.Now()
+	result, err := cm.Reload(source)
+	if err != nil || result != nil {
+		cm.record("config.reload", source, err, start)
+	}
+	if err != nil {
+		cm.app.Logger.Println("reload config failed:", err)
+		return
+	}
+	if result != nil {
+		cm.app.Logger.Println("config reloaded, changed:", result.Changed, "restart required:", result.RestartRequired)
+	}
+}
+
+// Reload 重新读取配置文件，文件内容没有变化时返回 nil
+func (cm *ConfigManager) Reload(source string) (*ConfigUpdateResult, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.updating {
+		return nil, ErrConfigUpdating
+	}
+	b, err := ioutil.ReadFile(cm.path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, cm.raw) {
+		return nil, nil
+	}
+	c, err := cm.loader.Parse(b, false)
+	if err != nil {
+		return nil, err
+	}
+	cm.raw = b
+
+	result, serversChanged := cm.apply(config.GetConfig(), c)
+	result.Source = source
+	if serversChanged && cm.client != nil {
+		if err := cm.client.SetServers(c.ServerAddress); err != nil {
+			result.Error = err.Error()
+		}
+	}
+	return result, nil
+}
+
+// Update 校验并应用服务器推送的配置，原子地写入配置文件。修改了服务器地址时先返回，
+// 稍后切换到新的服务器，重连的结果通过 report 上报，超时后恢复原来的配置
+func (cm *ConfigManager) Update(req *ConfigUpdateRequest, report func(*ConfigUpdateResult)) (*ConfigUpdateResult, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.updating {
+		return nil, ErrConfigUpdating
+	}
+
+	var b []byte
+	var err error
+	switch {
+	case len(req.Config) > 0 && len(req.Patch) > 0:
+		return nil, errors.New("only one of config and patch can be set")
+	case len(req.Config) > 0:
+		b = req.Config
+	case len(req.Patch) > 0:
+		doc, err := cm.loader.ToJSON(cm.raw)
+		if err != nil {
+			return nil, err
+		}
+		if b, err = mergeJSONPatch(doc, req.Patch); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("config or patch is required")
+	}
+	// JSON 同时也是合法的 YAML
+	c, err := cm.loader.Parse(b, true)
+	if err != nil {
+		return nil, err
+	}
+	content, err := cm.loader.FromJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	old, oldRaw := config.GetConfig(), cm.raw
+	if err := config.WriteFile(cm.path, content); err != nil {
+		return nil, err
+	}
+	cm.raw = content
+
+	result, serversChanged := cm.apply(old, c)
+	result.Source = ConfigSourceUpdate
+	if !serversChanged || cm.client == nil {
+		return result, nil
+	}
+
+	timeout := req.ReconnectTimeout
+	if timeout <= 0 {
+		timeout = defaultConfigReconnectTimeout
+	}
+	result.Reconnecting = true
+	cm.updating = true
+	final := *result
+	go cm.switchServers(old, oldRaw, c, time.Duration(timeout)*time.Second, &final, report)
+	return result, nil
+}
+
+// switchServers 连接新的服务器，超时后恢复原来的配置文件和服务器
+func (cm *ConfigManager) switchServers(old *config.Config, oldRaw []byte, c *config.Config, timeout time.Duration, result *ConfigUpdateResult, report func(*ConfigUpdateResult)) {
+	// 先让 v1/Config/Update 的响应从原来的连接发送出去
+	time.Sleep(configSwitchDelay)
+	result.Reconnecting = false
+
+	if err := cm.client.SetServers(c.ServerAddress); err != nil {
+		result.Error = err.Error()
+	} else if cm.waitOnline(c.ServerAddress, timeout) {
+		cm.app.Logger.Println("config updated, connected to:", cm.client.ServerURL())
+		cm.mu.Lock()
+		cm.updating = false
+		cm.mu.Unlock()
+		report(result)
+		return
+	} else {
+		result.Error = fmt.Sprintf("cannot connect to %v in %v", c.ServerAddress, timeout)
+	}
+
+	cm.app.Logger.Println("config update rollback:", result.Error)
+	cm.mu.Lock()
+	if err := config.WriteFile(cm.path, oldRaw); err != nil {
+		cm.app.Logger.Println("restore config file failed:", err)
+	}
+	cm.raw = oldRaw
+	cm.apply(c, old)
+	if err := cm.client.SetServers(old.ServerAddress); err != nil {
+		cm.app.Logger.Println("restore servers failed:", err)
+	}
+	cm.updating = false
+	cm.mu.Unlock()
+
+	cm.record("config.rollback", ConfigSourceUpdate, errors.New(result.Error), time.Now())
+	result.RolledBack = true
+	result.Applied, result.RestartRequired = nil, nil
+	if !cm.waitOnline(old.ServerAddress, timeout) {
+		cm.app.Logger.Println("cannot reconnect after config rollback")
+	}
+	report(result)
+}
+
+// record 记录不是由消息触发的配置修改，消息触发的修改由 MessageHandler 记录
+func (cm *ConfigManager) record(typ, source string, err error, start time.Time) {
+	cm.mu.Lock()
+	a := cm.audit
+	cm.mu.Unlock()
+	if a == nil {
+		return
+	}
+	r := &AuditRecord{
+		Time:      start,
+		Action:    AuditActionConfig,
+		Type:      typ,
+		Requester: source,
+		Paths:     []string{cm.path},
+		Code:      CODE_SUCCESS,
+		Duration:  time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		r.Code, r.Error = ErrorCode(err), err.Error()
+	}
+	if err := a.Record(r); err != nil {
+		cm.app.Logger.Println("write audit log failed:", err)
+	}
+}
+
+func (cm *ConfigManager) waitOnline(servers []string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cm.client.Online() && containsString(servers, cm.client.ServerURL()) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// apply 替换当前配置并更新日志级别和各个模块，返回修改的配置项以及服务器地址是否变化
+func (cm *ConfigManager) apply(old, c *config.Config) (*ConfigUpdateResult, bool) {
+	result := &ConfigUpdateResult{Changed: diffConfig(old, c)}
+	config.SetConfig(c)
+	cm.app.Config = c
+	if err := cm.app.Logger.SetLevel(c.LogConfig.Level); err != nil {
+		cm.app.Logger.Println("set log level failed:", err)
+	}
+	for _, fn := range cm.hooks {
+		fn(c)
+	}
+
+	serversChanged := false
+	for _, key := range result.Changed {
+		if key == "serverAddress" {
+			serversChanged = true
+		}
+		if isHotConfigKey(key) {
+			result.Applied = append(result.Applied, key)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+	return result, serversChanged
+}
+
+func isHotConfigKey(key string) bool {
+	for _, hot := range hotConfigKeys {
+		if key == hot || strings.HasPrefix(key, hot+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffConfig 返回两个配置中不同的配置项，嵌套的对象展开为 a.b 的形式
+func diffConfig(old, c *config.Config) []string {
+	a, b := flattenConfig(old), flattenConfig(c)
+	var keys []string
+	for k, v := range b {
+		if !reflect.DeepEqual(a[k], v) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func flattenConfig(c *config.Config) map[string]interface{} {
+	flat := make(map[string]interface{})
+	if c == nil {
+		return flat
+	}
+	b, _ := json.Marshal(c)
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			if sub, ok := v.(map[string]interface{}); ok {
+				walk(prefix+k+".", sub)
+				continue
+			}
+			flat[prefix+k] = v
+		}
+	}
+	walk("", m)
+	return flat
+}
+
+// mergeJSONPatch 按 RFC 7386 把 patch 合并到 doc 中，patch 中值为 null 的字段被删除
+func mergeJSONPatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if len(bytes.TrimSpace(doc)) > 0 {
+		if err := json.Unmarshal(doc, &target); err != nil {
+			return nil, fmt.Errorf("parse current config: %w", err)
+		}
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("parse patch: %w", err)
+	}
+	return json.Marshal(mergePatchValue(target, p))
+}
+
+func mergePatchValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatchValue(t[k], v)
+	}
+	return t
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package updater
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+)
+
+type testConfigClient struct {
+	mu     sync.Mutex
+	server string
+	good   string // 只能连接这个服务器
+}
+
+func (c *testConfigClient) SetServers(urls []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.server = urls[0]
+	return nil
+}
+
+func (c *testConfigClient) ServerURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.server
+}
+
+func (c *testConfigClient) Online() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.server == c.good
+}
+
+func TestConfigManager(t *testing.T) {
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	original := []byte(`{"serverAddress": ["ws://a.example.com/ws/"], "logConfig": {"level": "info", "filename": "` + filepath.Join(dir, "agent.log") + `"}}`)
+	if err := ioutil.WriteFile(path, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetConfig(c)
+	a := &app.App{Config: c, Logger: logger.NewLogger(c.LogConfig)}
+	cm := NewConfigManager(a, &config.Loader{File: path})
+	client := &testConfigClient{server: "ws://a.example.com/ws/", good: "ws://a.example.com/ws/"}
+	cm.client = client
+	var limits []int
+	cm.OnChange(func(c *config.Config) { limits = append(limits, c.WatchLimit) })
+
+	// 不合法的配置返回所有不合法的配置项，不修改配置文件
+	_, err = cm.Update(&ConfigUpdateRequest{Patch: []byte(`{"logConfig": {"level": "loud"}, "serverAddress": ["http://b"]}`)}, nil)
+	var verr *config.ValidationError
+	if !errors.As(err, &verr) || len(verr.Fields) != 2 || verr.Fields[0].Key != "logConfig.level" || verr.Fields[1].Key != "serverAddress[0]" {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	if _, err := cm.Update(&ConfigUpdateRequest{Patch: []byte(`{"watchLimt": 5}`)}, nil); err == nil {
+		t.Fatal("expected unknown field error")
+	}
+
+	result, err := cm.Update(&ConfigUpdateRequest{Patch: []byte(`{"logConfig": {"level": "debug"}, "watchLimit": 5, "backupDir": "/tmp/backups"}`)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Applied, []string{"logConfig.level", "watchLimit"}) || !reflect.DeepEqual(result.RestartRequired, []string{"backupDir"}) || result.Reconnecting {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if a.Logger.Level() != "debug" || config.GetConfig().WatchLimit != 5 || a.Config.WatchLimit != 5 || !reflect.DeepEqual(limits, []int{5}) {
+		t.Fatalf("config is not applied: %s %+v", a.Logger.Level(), limits)
+	}
+	if saved, err := config.LoadFile(path); err != nil || saved.WatchLimit != 5 || saved.LogConfig.Level != "debug" {
+		t.Fatalf("config is not saved: %+v %v", saved, err)
+	}
+	updated, _ := ioutil.ReadFile(path)
+
+	// 无法连接新的服务器时回滚
+	reports := make(chan *ConfigUpdateResult, 1)
+	result, err = cm.Update(&ConfigUpdateRequest{
+		Patch:            []byte(`{"serverAddress": ["ws://b.example.com/ws/"], "watchLimit": 6}`),
+		ReconnectTimeout: 1,
+	}, func(r *ConfigUpdateResult) { reports <- r })
+	if err != nil || !result.Reconnecting || config.GetConfig().WatchLimit != 6 {
+		t.Fatalf("unexpected result: %+v %v", result, err)
+	}
+	if _, err := cm.Update(&ConfigUpdateRequest{Patch: []byte(`{}`)}, nil); !errors.Is(err, ErrConfigUpdating) {
+		t.Fatalf("expected ErrConfigUpdating, got %v", err)
+	}
+	select {
+	case r := <-reports:
+		if !r.RolledBack || r.Error == "" {
+			t.Fatalf("expected rollback: %+v", r)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for rollback")
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != string(updated) {
+		t.Fatalf("config file is not restored: %s", b)
+	}
+	if c := config.GetConfig(); c.WatchLimit != 5 || c.ServerAddress[0] != "ws://a.example.com/ws/" || client.ServerURL() != c.ServerAddress[0] {
+		t.Fatalf("config is not restored: %+v", c)
+	}
+
+	// 连接新的服务器成功
+	client.mu.Lock()
+	client.good = "ws://b.example.com/ws/"
+	client.mu.Unlock()
+	if _, err := cm.Update(&ConfigUpdateRequest{Patch: []byte(`{"serverAddress": ["ws://b.example.com/ws/"]}`)}, func(r *ConfigUpdateResult) { reports <- r }); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-reports; r.RolledBack || r.Error != "" || client.ServerURL() != "ws://b.example.com/ws/" {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+
+	// 重新加载修改后的配置文件，内容没有变化时不重新加载
+	if err := ioutil.WriteFile(path, []byte(`{"serverAddress": ["ws://b.example.com/ws/"], "logConfig": {"level": "warn"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := cm.Reload(ConfigSourceSignal); err != nil || a.Logger.Level() != "warn" || config.GetConfig().WatchLimit != 32 || result.Source != ConfigSourceSignal {
+		t.Fatalf("unexpected reload: %+v %v", result, err)
+	}
+	if result, err := cm.Reload(ConfigSourceFile); result != nil || err != nil {
+		t.Fatalf("expected no change: %+v %v", result, err)
+	}
+	if err := ioutil.WriteFile(path, []byte(`{`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.Reload(ConfigSourceFile); err == nil || a.Logger.Level() != "warn" {
+		t.Fatal("invalid config file should not be applied")
+	}
+	os.Remove(path)
+}
@@ -0,0 +1,49 @@
+package v1
+
+import (
+	"updater"
+)
+
+type AuditController struct {
+	handler *updater.MessageHandler
+	audit   *updater.AuditLog
+}
+
+// NewAuditController audit 为 nil 时 v1/Audit/Fetch 返回审计日志不可用
+func NewAuditController(handler *updater.MessageHandler, audit *updater.AuditLog) *AuditController {
+	controller := &AuditController{
+		handler: handler,
+		audit:   audit,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (ac *AuditController) registerHandlers() {
+	ac.handler.RegisterHandler("v1/Audit/Fetch", ac.handleFetch)
+}
+
+// handleFetch 按时间、操作类别、消息类型、消息 ID 和 taskId 检索审计日志，verify 为 true 时同时校验 hash 链
+func (ac *AuditController) handleFetch(ctx *updater.Context) error {
+	if ac.audit == nil {
+		ctx.JSONError(updater.CODE_ERROR, updater.ErrAuditUnavailable.Error())
+		return updater.ErrAuditUnavailable
+	}
+
+	var req updater.AuditFetchRequest
+	if len(ctx.Message.Data) > 0 {
+		if err := ctx.Unmarshal(&req); err != nil {
+			ctx.JSONError(updater.CODE_ERROR, err.Error())
+			return err
+		}
+	}
+
+	result, err := ac.audit.Fetch(&req)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(result)
+	return nil
+}
@@ -3,7 +3,6 @@ package v1
 import (
 	"encoding/json"
 	"errors"
-	"log"
 
 	"updater"
 )
@@ -42,7 +41,7 @@ func (ac *AuthController) Register(ctx *updater.Context) error {
 		return err
 	}
 
-	log.Println("注册成功，服务器时间:", heartBeat.Time)
-	ctx.Client.Registered = true
+	ctx.Logger.Println("注册成功，服务器时间:", heartBeat.Time)
+	ctx.Client.Registered.Store(true)
 	return nil
 }
@@ -0,0 +1,54 @@
+package v1
+
+import (
+	"errors"
+
+	"updater"
+	"updater/pkg/config"
+)
+
+type ConfigController struct {
+	handler *updater.MessageHandler
+	manager *updater.ConfigManager
+}
+
+func NewConfigController(handler *updater.MessageHandler, manager *updater.ConfigManager) *ConfigController {
+	controller := &ConfigController{
+		handler: handler,
+		manager: manager,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (cc *ConfigController) registerHandlers() {
+	cc.handler.RegisterHandler("v1/Config/Update", cc.handleUpdate)
+}
+
+// handleUpdate 应用服务器推送的配置，校验失败时返回每个不合法的配置项。
+// 修改了服务器地址时，重连的结果通过 v1/Config/Result 上报
+func (cc *ConfigController) handleUpdate(ctx *updater.Context) error {
+	var req updater.ConfigUpdateRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	client := ctx.Client
+	result, err := cc.manager.Update(&req, func(r *updater.ConfigUpdateResult) {
+		client.Notify("v1/Config/Result", r)
+	})
+	if err != nil {
+		var verr *config.ValidationError
+		if errors.As(err, &verr) {
+			ctx.JSON(updater.ErrorCode(err), err.Error(), verr.Fields)
+			return err
+		}
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.Logger.Println("config updated, changed:", result.Changed, "restart required:", result.RestartRequired)
+	ctx.JSONSuccess(result)
+	return nil
+}
@@ -0,0 +1,36 @@
+package v1
+
+import (
+	"context"
+	"time"
+
+	"updater"
+)
+
+type FactsController struct {
+	handler   *updater.MessageHandler
+	collector *updater.FactsCollector
+}
+
+func NewFactsController(handler *updater.MessageHandler, collector *updater.FactsCollector) *FactsController {
+	controller := &FactsController{
+		handler:   handler,
+		collector: collector,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (fc *FactsController) registerHandlers() {
+	fc.handler.RegisterHandler("v1/GetFacts", fc.handleGetFacts)
+}
+
+// handleGetFacts 总是重新采集，同时刷新注册时使用的缓存
+func (fc *FactsController) handleGetFacts(ctx *updater.Context) error {
+	c, cancel := context.WithTimeout(ctx.Ctx, time.Minute)
+	defer cancel()
+
+	facts := fc.collector.Collect(c)
+	ctx.JSONSuccess(facts)
+	return nil
+}
@@ -4,23 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net"
-	"net/http"
-	"os"
-	"path/filepath"
 	"time"
 
 	"updater"
 )
 
 type FileController struct {
-	handler *updater.MessageHandler
+	handler     *updater.MessageHandler
+	fileManager *updater.FileManager
 }
 
 func NewFileController(handler *updater.MessageHandler) *FileController {
 	controller := &FileController{
-		handler: handler,
+		handler:     handler,
+		fileManager: updater.NewFileManager(),
 	}
 	controller.registerHandlers()
 	return controller
@@ -31,35 +28,80 @@ func (fc *FileController) registerHandlers() {
 	fc.handler.RegisterHandler("v1/DeleteFile", fc.handleDeleteFile)
 	fc.handler.RegisterHandler("v1/MoveFile", fc.handleMoveFile)
 	fc.handler.RegisterHandler("v1/DownloadFile", fc.handleDownloadFile)
+	fc.handler.RegisterHandler("v1/UploadFile", fc.handleUploadFile)
+	fc.handler.RegisterHandler("v1/Extract", fc.handleExtract)
+	fc.handler.RegisterHandler("v1/Archive", fc.handleArchive)
+	fc.handler.RegisterHandler("v1/ReadFile", fc.handleReadFile)
+	fc.handler.RegisterHandler("v1/TailFile", fc.handleTailFile)
+	fc.handler.RegisterHandler("v1/WriteFile", fc.handleWriteFile)
+	fc.handler.RegisterHandler("v1/PatchFile", fc.handlePatchFile)
+	fc.handler.RegisterHandler("v1/RestoreFile", fc.handleRestoreFile)
+	fc.handler.RegisterHandler("v1/SyncDir", fc.handleSyncDir)
 }
 
 func (fc *FileController) handleGetFileInfo(ctx *updater.Context) error {
-	//path := string(ctx.Message.Data)
-	// fileInfo, err := fc.fileManager.GetFileInfo(path)
-	// if err != nil {
-	// 	return err
-	// }
+	var req updater.FileRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	fileInfo, err := fc.fileManager.GetFileInfo(req.Path)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(updater.NewFileInfo(fileInfo))
 	return nil
 }
 
 func (fc *FileController) handleDeleteFile(ctx *updater.Context) error {
-	// var req updater.DeleteRequest
-	// if err := json.Unmarshal(ctx.), &req); err != nil {
-	// 	return err
-	// }
-	// if err := fc.fileManager.DeleteFile(req.FilePath); err != nil {
-	// 	return err
-	// }
+	var req updater.DeleteRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	span := ctx.StartSpan("file.delete", "file.path", req.FilePath, "file.recursive", req.Recursive)
+	var err error
+	if req.Recursive {
+		err = fc.fileManager.DeleteAll(req.FilePath)
+	} else {
+		err = fc.fileManager.DeleteFile(req.FilePath)
+	}
+	span.End(err)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(req)
 	return nil
 }
 
 func (fc *FileController) handleMoveFile(ctx *updater.Context) error {
+	var req updater.MoveRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	span := ctx.StartSpan("file.move", "file.src", req.Src, "file.dest", req.Dest)
+	err := fc.fileManager.MoveFile(&req)
+	span.End(err)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(req)
 	return nil
 }
 
 // 执行信息
 type ExecuteInfo struct {
-	StartTime time.Time `json:"startTime""`
+	StartTime time.Time `json:"startTime"`
 	EndTime   time.Time `json:"endTime"`
 	Message   string    `json:"message"`
 }
@@ -89,84 +131,297 @@ func (fc *FileController) handleDownloadFile(ctx *updater.Context) error {
 	}
 
 	if reqmsg.URL == "" {
-		reqmsg.URL = "http://" + ctx.Client.Server.Url.Host + "/api/v1/pkg/" + reqmsg.DownLoadPath
+		reqmsg.URL = ctx.Client.PackageURL(reqmsg.DownLoadPath)
+	}
+	if reqmsg.TaskID == "" {
+		reqmsg.TaskID = ctx.Message.TaskId
+	}
+	if reqmsg.TaskID == "" {
+		reqmsg.TaskID = ctx.Message.Id
 	}
 
 	ctx.Logger.Println("download url:", reqmsg.URL)
-	c, cancel := context.WithTimeout(ctx.Ctx, time.Second*time.Duration(reqmsg.Timeout))
-	defer cancel()
-
-	// 创建目标文件夹（如果需要）
-	ctx.Logger.Println("autoCreateDir:", reqmsg.AutoCreateDir)
-	if reqmsg.AutoCreateDir {
-		err := os.MkdirAll(filepath.Dir(reqmsg.DestPath), 0755)
-		if err != nil {
-			execinfo.EndTime = time.Now()
-			execinfo.Message = err.Error()
-			ctx.JSON(updater.CODE_ERROR, err.Error(), execinfo)
-			return err
-		}
-	}
 
-	ctx.Logger.Println("overwriteExisted:", reqmsg.OverwriteExisted)
-	ctx.Logger.Println("destPath:", reqmsg.DestPath)
-	// 检查目标文件是否存在
-	if _, err := os.Stat(reqmsg.DestPath); err == nil && !reqmsg.OverwriteExisted {
-		err = fmt.Errorf("file already exists and overwriteExisted is set to false")
+	downloadTask := updater.NewDownloadTask(&reqmsg)
+	if err := ctx.App().TaskManager.AddTask(downloadTask); err != nil {
 		execinfo.EndTime = time.Now()
 		execinfo.Message = err.Error()
 		ctx.JSON(updater.CODE_ERROR, err.Error(), execinfo)
 		return err
 	}
 
-	//ctx.Client.Server.Url
+	err := downloadTask.Run(ctx)
+	if serr := ctx.App().TaskStore.AddTask(downloadTask); serr != nil {
+		ctx.Logger.Println("save download task failed:", serr)
+	}
+
+	result := downloadTask.GetResult().(*updater.DownloadResult)
+	ctx.JSON(result.Code, result.Message, result)
+	return err
+}
 
-	// 发起 HTTP 请求
-	req, err := http.NewRequestWithContext(c, http.MethodGet, reqmsg.URL, nil)
+// handleUploadFile 上传本地文件或目录到服务器
+func (fc *FileController) handleUploadFile(ctx *updater.Context) error {
+	var reqmsg updater.UploadRequest
+	if err := json.Unmarshal(ctx.Message.Data, &reqmsg); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	if reqmsg.Path == "" {
+		ctx.JSONError(updater.CODE_ERROR, "path is empty")
+		return fmt.Errorf("path is empty")
+	}
+	if reqmsg.TaskID == "" {
+		reqmsg.TaskID = ctx.Message.TaskId
+	}
+	if reqmsg.TaskID == "" {
+		reqmsg.TaskID = ctx.Message.Id
+	}
+
+	ctx.Logger.Println("upload path:", reqmsg.Path, "url:", reqmsg.URL)
+
+	uploadTask := updater.NewUploadTask(&reqmsg)
+	if err := ctx.App().TaskManager.AddTask(uploadTask); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	err := uploadTask.Run(ctx)
+	if serr := ctx.App().TaskStore.AddTask(uploadTask); serr != nil {
+		ctx.Logger.Println("save upload task failed:", serr)
+	}
+
+	result := uploadTask.GetResult().(*updater.UploadResult)
+	ctx.JSON(result.Code, result.Message, result)
+	return err
+}
+
+// handleExtract 解压压缩包，返回解压出的文件清单
+func (fc *FileController) handleExtract(ctx *updater.Context) error {
+	var reqmsg updater.ExtractRequest
+	if err := json.Unmarshal(ctx.Message.Data, &reqmsg); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	if reqmsg.Src == "" || reqmsg.DestPath == "" {
+		ctx.JSONError(updater.CODE_ERROR, "src or destPath is empty")
+		return fmt.Errorf("src or destPath is empty")
+	}
+
+	ctx.Logger.Println("extract:", reqmsg.Src, "to:", reqmsg.DestPath)
+	span := ctx.StartSpan("file.extract", "file.src", reqmsg.Src, "file.dest", reqmsg.DestPath)
+	result, err := fc.fileManager.Extract(ctx.Ctx, &reqmsg)
+	span.End(err)
 	if err != nil {
-		execinfo.EndTime = time.Now()
-		execinfo.Message = err.Error()
-		ctx.JSON(updater.CODE_ERROR, err.Error(), execinfo)
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
 		return err
 	}
-	resp, err := http.DefaultClient.Do(req)
+
+	ctx.JSONSuccess(result)
+	return nil
+}
+
+// handleArchive 将目录或文件打包
+func (fc *FileController) handleArchive(ctx *updater.Context) error {
+	var reqmsg updater.ArchiveRequest
+	if err := json.Unmarshal(ctx.Message.Data, &reqmsg); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	if reqmsg.Src == "" || reqmsg.DestPath == "" {
+		ctx.JSONError(updater.CODE_ERROR, "src or destPath is empty")
+		return fmt.Errorf("src or destPath is empty")
+	}
+
+	ctx.Logger.Println("archive:", reqmsg.Src, "to:", reqmsg.DestPath)
+	span := ctx.StartSpan("file.archive", "file.src", reqmsg.Src, "file.dest", reqmsg.DestPath)
+	result, err := fc.fileManager.Archive(ctx.Ctx, &reqmsg)
+	span.End(err)
 	if err != nil {
-		// 检查错误类型是否为超时错误
-		execinfo.EndTime = time.Now()
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			// 处理超时错误
-			execinfo.Message = err.Error()
-			ctx.JSON(updater.CODE_TIMEOUT, err.Error(), execinfo)
-			return fmt.Errorf("request timed out")
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(result)
+	return nil
+}
+
+// handleReadFile 按偏移和长度读取文件内容
+func (fc *FileController) handleReadFile(ctx *updater.Context) error {
+	var req updater.ReadFileRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	result, err := fc.fileManager.ReadFile(&req)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(result)
+	return nil
+}
+
+// handleTailFile 返回文件最后几行。follow 模式下先返回最后几行，
+// 之后新增的行通过 "v1/TailFile/Lines" 消息推送，直到任务被取消或超时
+func (fc *FileController) handleTailFile(ctx *updater.Context) error {
+	var req updater.TailFileRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+	if req.TaskID == "" {
+		req.TaskID = ctx.Message.TaskId
+	}
+	if req.TaskID == "" {
+		req.TaskID = ctx.Message.Id
+	}
+
+	lines, offset, err := fc.fileManager.TailFile(req.Path, req.Lines)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	result := &updater.TailLines{
+		TaskID: req.TaskID,
+		Path:   req.Path,
+		Lines:  lines,
+		Offset: offset,
+	}
+	if !req.Follow {
+		ctx.JSONSuccess(result)
+		return nil
+	}
+
+	tailTask := updater.NewTailTask(&req)
+	if err := ctx.App().TaskManager.AddTask(tailTask); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+	ctx.JSONSuccess(result)
+
+	// 消息处理结束后 ctx.Ctx 会被取消，follow 使用独立的 context，通过 v1/CancelTask 结束
+	go func() {
+		err := tailTask.Follow(context.Background(), offset, func(lines *updater.TailLines) {
+			ctx.Notify("v1/TailFile/Lines", lines)
+		})
+		if err != nil {
+			ctx.Logger.Println("tail file failed:", err)
+		}
+		ctx.Notify("v1/TailFile/Done", tailTask.GetResult())
+		if err := ctx.App().TaskStore.AddTask(tailTask); err != nil {
+			ctx.Logger.Println("save tail task failed:", err)
 		}
+	}()
+	return nil
+}
 
-		execinfo.Message = err.Error()
-		ctx.JSON(updater.CODE_ERROR, err.Error(), execinfo)
+// handleWriteFile 写入文件内容，原文件会先备份
+func (fc *FileController) handleWriteFile(ctx *updater.Context) error {
+	var req updater.WriteFileRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
 		return err
 	}
-	defer resp.Body.Close()
 
-	// 创建目标文件
-	file, err := os.Create(reqmsg.DestPath)
+	span := ctx.StartSpan("file.write", "file.path", req.Path)
+	result, err := fc.fileManager.WriteFile(&req)
+	span.End(err)
 	if err != nil {
-		execinfo.EndTime = time.Now()
-		execinfo.Message = err.Error()
-		ctx.JSON(updater.CODE_ERROR, err.Error(), execinfo)
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+	if err := fc.fileManager.VerifyWrite(ctx, result, req.HealthCheck); err != nil {
+		ctx.JSON(updater.ErrorCode(err), err.Error(), result)
 		return err
 	}
-	defer file.Close()
 
-	// 将响应的内容写入文件
-	_, err = io.Copy(file, resp.Body)
+	ctx.JSONSuccess(result)
+	return nil
+}
+
+// handlePatchFile 将 unified diff 应用到文件上，原文件会先备份
+func (fc *FileController) handlePatchFile(ctx *updater.Context) error {
+	var req updater.PatchFileRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	span := ctx.StartSpan("file.patch", "file.path", req.Path)
+	result, err := fc.fileManager.PatchFile(&req)
+	span.End(err)
 	if err != nil {
-		execinfo.EndTime = time.Now()
-		execinfo.Message = err.Error()
-		ctx.JSON(updater.CODE_ERROR, err.Error(), execinfo)
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+	if err := fc.fileManager.VerifyWrite(ctx, result, req.HealthCheck); err != nil {
+		ctx.JSON(updater.ErrorCode(err), err.Error(), result)
+		return err
+	}
+
+	ctx.JSONSuccess(result)
+	return nil
+}
+
+// handleRestoreFile 从 WriteFile 或 PatchFile 返回的备份恢复文件
+func (fc *FileController) handleRestoreFile(ctx *updater.Context) error {
+	var req updater.RestoreFileRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	span := ctx.StartSpan("file.restore", "file.path", req.Path)
+	result, err := fc.fileManager.RestoreFile(&req)
+	span.End(err)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
 		return err
 	}
 
-	execinfo.EndTime = time.Now()
-	execinfo.Message = "success"
-	ctx.JSONSuccess(execinfo)
+	ctx.JSONSuccess(result)
 	return nil
 }
+
+func (fc *FileController) handleSyncDir(ctx *updater.Context) error {
+	var reqmsg updater.SyncRequest
+	if err := ctx.Unmarshal(&reqmsg); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	if reqmsg.DestPath == "" {
+		ctx.JSONError(updater.CODE_ERROR, "destPath is empty")
+		return fmt.Errorf("destPath is empty")
+	}
+	if reqmsg.TaskID == "" {
+		reqmsg.TaskID = ctx.Message.TaskId
+	}
+	if reqmsg.TaskID == "" {
+		reqmsg.TaskID = ctx.Message.Id
+	}
+
+	ctx.Logger.Println("sync dir:", reqmsg.DestPath, "files:", len(reqmsg.Files), "dryRun:", reqmsg.DryRun)
+
+	syncTask := updater.NewSyncTask(&reqmsg)
+	if err := ctx.App().TaskManager.AddTask(syncTask); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	err := syncTask.Run(ctx)
+	if serr := ctx.App().TaskStore.AddTask(syncTask); serr != nil {
+		ctx.Logger.Println("save sync task failed:", serr)
+	}
+
+	result := syncTask.GetResult().(*updater.SyncResult)
+	ctx.JSON(result.Code, result.Message, result)
+	return err
+}
@@ -0,0 +1,44 @@
+package v1
+
+import (
+	"updater"
+)
+
+type InventoryController struct {
+	handler   *updater.MessageHandler
+	inventory *updater.Inventory
+}
+
+func NewInventoryController(handler *updater.MessageHandler, inventory *updater.Inventory) *InventoryController {
+	controller := &InventoryController{
+		handler:   handler,
+		inventory: inventory,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (ic *InventoryController) registerHandlers() {
+	ic.handler.RegisterHandler("v1/Packages/List", ic.handleList)
+}
+
+// handleList 返回系统包管理器中已安装的软件包，delta 为 true 时只返回变化
+func (ic *InventoryController) handleList(ctx *updater.Context) error {
+	var req updater.InventoryRequest
+	if len(ctx.Message.Data) > 0 {
+		if err := ctx.Unmarshal(&req); err != nil {
+			ctx.JSONError(updater.CODE_ERROR, err.Error())
+			return err
+		}
+	}
+
+	report, err := ic.inventory.List(ctx.Ctx, &req)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.Logger.Println("list system packages:", report.Count, "full:", report.Full, "id:", report.ID)
+	ctx.JSONSuccess(report)
+	return nil
+}
@@ -0,0 +1,63 @@
+package v1
+
+import (
+	"updater"
+)
+
+type LogController struct {
+	handler *updater.MessageHandler
+	manager *updater.LogManager
+}
+
+func NewLogController(handler *updater.MessageHandler, manager *updater.LogManager) *LogController {
+	controller := &LogController{
+		handler: handler,
+		manager: manager,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (lc *LogController) registerHandlers() {
+	lc.handler.RegisterHandler("v1/Log/SetLevel", lc.handleSetLevel)
+	lc.handler.RegisterHandler("v1/Log/Fetch", lc.handleFetch)
+}
+
+// handleSetLevel 临时修改日志级别，duration 到期后恢复为配置的级别
+func (lc *LogController) handleSetLevel(ctx *updater.Context) error {
+	var req updater.LogLevelRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	result, err := lc.manager.SetLevel(&req)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.Logger.Infow("log level changed", "level", result.Level, "previous", result.Previous, "revertAt", result.RevertAt)
+	ctx.JSONSuccess(result)
+	return nil
+}
+
+// handleFetch 按时间、级别、traceId 和 taskId 检索 agent 的日志
+func (lc *LogController) handleFetch(ctx *updater.Context) error {
+	var req updater.LogFetchRequest
+	if len(ctx.Message.Data) > 0 {
+		if err := ctx.Unmarshal(&req); err != nil {
+			ctx.JSONError(updater.CODE_ERROR, err.Error())
+			return err
+		}
+	}
+
+	result, err := lc.manager.Fetch(&req)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(result)
+	return nil
+}
@@ -0,0 +1,108 @@
+package v1
+
+import (
+	"updater"
+)
+
+type PackageController struct {
+	handler        *updater.MessageHandler
+	packageManager *updater.PackageManager
+}
+
+func NewPackageController(handler *updater.MessageHandler, packageManager *updater.PackageManager) *PackageController {
+	controller := &PackageController{
+		handler:        handler,
+		packageManager: packageManager,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (pc *PackageController) registerHandlers() {
+	pc.handler.RegisterHandler("v1/Package/Install", pc.handleInstall)
+	pc.handler.RegisterHandler("v1/Package/Upgrade", pc.handleUpgrade)
+	pc.handler.RegisterHandler("v1/Package/Rollback", pc.handleRollback)
+	pc.handler.RegisterHandler("v1/Package/Uninstall", pc.handleUninstall)
+	pc.handler.RegisterHandler("v1/Package/List", pc.handleList)
+}
+
+func (pc *PackageController) handleInstall(ctx *updater.Context) error {
+	return pc.deploy(ctx, pc.packageManager.Install)
+}
+
+func (pc *PackageController) handleUpgrade(ctx *updater.Context) error {
+	return pc.deploy(ctx, pc.packageManager.Upgrade)
+}
+
+func (pc *PackageController) deploy(ctx *updater.Context, fn func(*updater.Context, *updater.PackageInstallRequest) (*updater.PackageResult, error)) error {
+	var req updater.PackageInstallRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+	req.TaskID = packageTaskID(ctx, req.TaskID)
+
+	ctx.Logger.Println(ctx.Message.Type, "package:", req.Manifest.Name, "version:", req.Manifest.Version)
+
+	result, err := fn(ctx, &req)
+	return pc.reply(ctx, result, err)
+}
+
+func (pc *PackageController) handleRollback(ctx *updater.Context) error {
+	var req updater.PackageRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+	req.TaskID = packageTaskID(ctx, req.TaskID)
+
+	ctx.Logger.Println("rollback package:", req.Name, "version:", req.Version)
+
+	result, err := pc.packageManager.Rollback(ctx, &req)
+	return pc.reply(ctx, result, err)
+}
+
+func (pc *PackageController) handleUninstall(ctx *updater.Context) error {
+	var req updater.PackageRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+	req.TaskID = packageTaskID(ctx, req.TaskID)
+
+	ctx.Logger.Println("uninstall package:", req.Name)
+
+	result, err := pc.packageManager.Uninstall(ctx, &req)
+	return pc.reply(ctx, result, err)
+}
+
+func (pc *PackageController) handleList(ctx *updater.Context) error {
+	list, err := pc.packageManager.List()
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(list)
+	return nil
+}
+
+// reply 失败时也返回结果，其中包含已经执行的钩子输出
+func (pc *PackageController) reply(ctx *updater.Context, result *updater.PackageResult, err error) error {
+	if err != nil {
+		ctx.JSON(updater.ErrorCode(err), err.Error(), result)
+		return err
+	}
+	ctx.JSONSuccess(result)
+	return nil
+}
+
+func packageTaskID(ctx *updater.Context, taskID string) string {
+	if taskID == "" {
+		taskID = ctx.Message.TaskId
+	}
+	if taskID == "" {
+		taskID = ctx.Message.Id
+	}
+	return taskID
+}
@@ -0,0 +1,61 @@
+package v1
+
+import (
+	"updater"
+)
+
+type ProcessController struct {
+	handler *updater.MessageHandler
+}
+
+func NewProcessController(handler *updater.MessageHandler) *ProcessController {
+	controller := &ProcessController{
+		handler: handler,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (pc *ProcessController) registerHandlers() {
+	pc.handler.RegisterHandler("v1/Process/List", pc.handleList)
+	pc.handler.RegisterHandler("v1/Process/Signal", pc.handleSignal)
+}
+
+func (pc *ProcessController) handleList(ctx *updater.Context) error {
+	var req updater.ProcessListRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	procs, err := updater.ListProcesses(&req.ProcessFilter)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+	if req.Limit > 0 && len(procs) > req.Limit {
+		procs = procs[:req.Limit]
+	}
+
+	ctx.JSONSuccess(procs)
+	return nil
+}
+
+// handleSignal 向匹配的进程发送信号，部分进程失败时同样返回每个进程的结果
+func (pc *ProcessController) handleSignal(ctx *updater.Context) error {
+	var req updater.ProcessSignalRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	result, err := updater.SignalProcesses(&req)
+	if err != nil {
+		ctx.JSON(updater.ErrorCode(err), err.Error(), result)
+		return err
+	}
+
+	ctx.Logger.Println("signal processes:", result.Signal, "targets:", len(result.Targets), "dryRun:", result.DryRun)
+	ctx.JSONSuccess(result)
+	return nil
+}
@@ -0,0 +1,97 @@
+package v1
+
+import (
+	"updater"
+)
+
+type ScheduleController struct {
+	handler   *updater.MessageHandler
+	scheduler *updater.Scheduler
+}
+
+func NewScheduleController(handler *updater.MessageHandler, scheduler *updater.Scheduler) *ScheduleController {
+	controller := &ScheduleController{
+		handler:   handler,
+		scheduler: scheduler,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (sc *ScheduleController) registerHandlers() {
+	sc.handler.RegisterHandler("v1/Schedule/Create", sc.handleCreate)
+	sc.handler.RegisterHandler("v1/Schedule/List", sc.handleList)
+	sc.handler.RegisterHandler("v1/Schedule/Delete", sc.handleDelete)
+	sc.handler.RegisterHandler("v1/Schedule/Pause", sc.handlePause)
+	sc.handler.RegisterHandler("v1/Schedule/Result/Response", sc.handleResultResponse)
+}
+
+func (sc *ScheduleController) handleCreate(ctx *updater.Context) error {
+	var req updater.ScheduleJob
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	job, err := sc.scheduler.Create(&req)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.Logger.Println("create schedule:", job.ID, job.Name, "next run:", job.NextRun)
+	ctx.JSONSuccess(job)
+	return nil
+}
+
+func (sc *ScheduleController) handleList(ctx *updater.Context) error {
+	ctx.JSONSuccess(sc.scheduler.List())
+	return nil
+}
+
+func (sc *ScheduleController) handleDelete(ctx *updater.Context) error {
+	var req updater.ScheduleRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	if err := sc.scheduler.Delete(req.ID); err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(req)
+	return nil
+}
+
+// handlePause 暂停定时任务，resume 为 true 时恢复
+func (sc *ScheduleController) handlePause(ctx *updater.Context) error {
+	var req updater.ScheduleRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	job, err := sc.scheduler.Pause(req.ID, !req.Resume)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(job)
+	return nil
+}
+
+// handleResultResponse 服务器确认收到执行结果后删除本地保存的结果
+func (sc *ScheduleController) handleResultResponse(ctx *updater.Context) error {
+	var req updater.ScheduleRunResult
+	if err := ctx.Unmarshal(&req); err != nil {
+		return err
+	}
+	if ctx.Message.Code != "" && ctx.Message.Code != updater.CODE_SUCCESS {
+		ctx.Logger.Println("schedule result rejected:", req.RunID, ctx.Message.Msg)
+		return nil
+	}
+	return sc.scheduler.Ack(req.RunID)
+}
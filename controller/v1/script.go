@@ -37,11 +37,27 @@ func (sc *ScriptController) handleExecuteScript(ctx *updater.Context) error {
 	if err := json.Unmarshal(ctx.Message.Data, &req); err != nil {
 		return err
 	}
+	if req.TaskID == "" {
+		req.TaskID = ctx.Message.TaskId
+	}
 
 	scriptTask := updater.NewScriptTask(&req)
 
+	// 有任务ID的脚本登记到任务管理器中，以便查询和取消
+	if scriptTask.TaskID != "" {
+		if err := ctx.App().TaskManager.AddTask(scriptTask); err != nil {
+			ctx.JSONError(updater.CODE_ERROR, err.Error())
+			return err
+		}
+		defer func() {
+			if err := ctx.App().TaskStore.AddTask(scriptTask); err != nil {
+				ctx.Logger.Println("save script task failed:", err)
+			}
+		}()
+	}
+
 	if err := scriptTask.Run(ctx); err != nil {
-		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
 		return err
 	}
 
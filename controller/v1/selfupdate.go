@@ -0,0 +1,50 @@
+package v1
+
+import (
+	"updater"
+)
+
+type SelfUpdateController struct {
+	handler     *updater.MessageHandler
+	selfUpdater *updater.SelfUpdater
+}
+
+func NewSelfUpdateController(handler *updater.MessageHandler, selfUpdater *updater.SelfUpdater) *SelfUpdateController {
+	controller := &SelfUpdateController{
+		handler:     handler,
+		selfUpdater: selfUpdater,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (sc *SelfUpdateController) registerHandlers() {
+	sc.handler.RegisterHandler("v1/SelfUpdate", sc.handleSelfUpdate)
+}
+
+func (sc *SelfUpdateController) handleSelfUpdate(ctx *updater.Context) error {
+	var req updater.SelfUpdateRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+	if req.TaskID == "" {
+		req.TaskID = ctx.Message.TaskId
+	}
+	if req.TaskID == "" {
+		req.TaskID = ctx.Message.Id
+	}
+
+	ctx.Logger.Println("self update to version:", req.Version)
+
+	status, err := sc.selfUpdater.Prepare(ctx.Ctx, ctx.Client, &req)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	// 先回复服务器，再重启为新版本，结果在新版本注册后的心跳中上报
+	ctx.JSON(updater.CODE_SUCCESS, status.Message, status)
+	go sc.selfUpdater.Restart()
+	return nil
+}
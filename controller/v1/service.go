@@ -0,0 +1,54 @@
+package v1
+
+import (
+	"updater"
+)
+
+type ServiceController struct {
+	handler *updater.MessageHandler
+	manager updater.ServiceManager
+}
+
+// NewServiceController manager 为 nil 时所有服务消息都返回不支持
+func NewServiceController(handler *updater.MessageHandler, manager updater.ServiceManager) *ServiceController {
+	controller := &ServiceController{
+		handler: handler,
+		manager: manager,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (sc *ServiceController) registerHandlers() {
+	for msgType, action := range map[string]string{
+		"v1/Service/Status":  updater.ServiceActionStatus,
+		"v1/Service/Start":   updater.ServiceActionStart,
+		"v1/Service/Stop":    updater.ServiceActionStop,
+		"v1/Service/Restart": updater.ServiceActionRestart,
+		"v1/Service/Enable":  updater.ServiceActionEnable,
+		"v1/Service/Disable": updater.ServiceActionDisable,
+	} {
+		sc.handler.RegisterHandler(msgType, sc.handleAction(action))
+	}
+}
+
+// handleAction 执行服务操作，返回操作之后的服务状态，失败时同样返回状态
+func (sc *ServiceController) handleAction(action string) updater.HandlerFunc {
+	return func(ctx *updater.Context) error {
+		var req updater.ServiceRequest
+		if err := ctx.Unmarshal(&req); err != nil {
+			ctx.JSONError(updater.CODE_ERROR, err.Error())
+			return err
+		}
+
+		ctx.Logger.Println("service", action, req.Name)
+		status, err := updater.RunServiceAction(ctx.Ctx, sc.manager, action, &req)
+		if err != nil {
+			ctx.JSON(updater.ErrorCode(err), err.Error(), status)
+			return err
+		}
+
+		ctx.JSONSuccess(status)
+		return nil
+	}
+}
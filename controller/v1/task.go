@@ -7,12 +7,20 @@ import (
 )
 
 type TaskController struct {
-	handler *updater.MessageHandler
+	handler  *updater.MessageHandler
+	taskLogs *updater.TaskLogs
 }
 
-func NewTaskController(handler *updater.MessageHandler) *TaskController {
+// taskInfoWithLogs 是请求 with_logs 时 v1/GetTaskInfo 返回的数据
+type taskInfoWithLogs struct {
+	Result interface{}            `json:"result"`
+	Logs   *updater.TaskLogResult `json:"logs"`
+}
+
+func NewTaskController(handler *updater.MessageHandler, taskLogs *updater.TaskLogs) *TaskController {
 	controller := &TaskController{
-		handler: handler,
+		handler:  handler,
+		taskLogs: taskLogs,
 	}
 	controller.registerHandlers()
 	return controller
@@ -21,6 +29,8 @@ func NewTaskController(handler *updater.MessageHandler) *TaskController {
 func (tc *TaskController) registerHandlers() {
 	tc.handler.RegisterHandler("v1/GetTaskInfo", tc.handleGetTaskInfo)
 	tc.handler.RegisterHandler("v1/GetTaskInfo/Response", tc.handleGetTaskInfoResponse)
+	tc.handler.RegisterHandler("v1/CancelTask", tc.handleCancelTask)
+	tc.handler.RegisterHandler("v1/GetTaskLogs", tc.handleGetTaskLogs)
 }
 
 func (tc *TaskController) handleGetTaskInfo(ctx *updater.Context) error {
@@ -34,22 +44,80 @@ func (tc *TaskController) handleGetTaskInfo(ctx *updater.Context) error {
 
 		tinfo, err = ctx.App().TaskStore.GetTask(req.TaskID)
 		if err != nil {
+			ctx.JSONError(updater.CODE_ERROR, err.Error())
 			return err
 		}
 	}
 
-	if tinfo == nil {
-		tinfo, err = ctx.App().TaskStore.GetTask(req.TaskID)
-		if err != nil {
-			return err
-		}
+	if !req.WithLogs {
+		ctx.JSON(updater.CODE_SUCCESS, taskStatusText(tinfo.GetStatus()), tinfo.GetResult())
+		return nil
+	}
+
+	logs, err := tc.taskLogs.Read(req.TaskID)
+	if err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+	ctx.JSON(updater.CODE_SUCCESS, taskStatusText(tinfo.GetStatus()), &taskInfoWithLogs{Result: tinfo.GetResult(), Logs: logs})
+	return nil
+}
+
+// handleGetTaskLogs 返回任务的日志，任务结果已经删除后在保留时间内仍然可以获取
+func (tc *TaskController) handleGetTaskLogs(ctx *updater.Context) error {
+	var req models.ReqTaskInfo
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	logs, err := tc.taskLogs.Read(req.TaskID)
+	if err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+	ctx.JSONSuccess(logs)
+	return nil
+}
+
+// handleCancelTask 取消正在运行的任务
+func (tc *TaskController) handleCancelTask(ctx *updater.Context) error {
+	var req models.ReqTaskInfo
+	if err := ctx.Unmarshal(&req); err != nil {
+		return err
 	}
 
-	ctx.JSONSuccess(tinfo.GetResult())
+	tinfo, err := ctx.App().TaskManager.GetTask(req.TaskID)
+	if err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	if err := tinfo.Stop(); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
 
+	ctx.JSONSuccess(req)
 	return nil
 }
 
+func taskStatusText(status task.TaskStatus) string {
+	switch status {
+	case task.TaskStatusCreated:
+		return "created"
+	case task.TaskStatusRunning:
+		return "running"
+	case task.TaskStatusCompleted:
+		return "completed"
+	case task.TaskStatusFailed:
+		return "failed"
+	case task.TaskStatusCanceled:
+		return "canceled"
+	}
+	return "unknown"
+}
+
 func (tc *TaskController) handleGetTaskInfoResponse(ctx *updater.Context) error {
 	var req models.ReqTaskInfo
 	if err := ctx.Unmarshal(&req); err != nil {
@@ -65,7 +133,8 @@ func (tc *TaskController) handleGetTaskInfoResponse(ctx *updater.Context) error
 		return nil
 	}
 
-	if tinfo.GetStatus() == task.TaskStatusCompleted || tinfo.GetStatus() == task.TaskStatusFailed {
+	switch tinfo.GetStatus() {
+	case task.TaskStatusCompleted, task.TaskStatusFailed, task.TaskStatusCanceled:
 		ctx.App().TaskManager.RemoveTask(req.TaskID)
 		ctx.App().TaskStore.RemoveTask(req.TaskID)
 	}
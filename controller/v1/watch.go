@@ -0,0 +1,58 @@
+package v1
+
+import (
+	"updater"
+)
+
+type WatchController struct {
+	handler *updater.MessageHandler
+	watcher *updater.FileWatcher
+}
+
+func NewWatchController(handler *updater.MessageHandler, watcher *updater.FileWatcher) *WatchController {
+	controller := &WatchController{
+		handler: handler,
+		watcher: watcher,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (wc *WatchController) registerHandlers() {
+	wc.handler.RegisterHandler("v1/WatchPath", wc.handleWatchPath)
+	wc.handler.RegisterHandler("v1/UnwatchPath", wc.handleUnwatchPath)
+}
+
+func (wc *WatchController) handleWatchPath(ctx *updater.Context) error {
+	var req updater.WatchRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	info, err := wc.watcher.Watch(&req)
+	if err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.Logger.Println("watch path:", info.Path, "id:", info.ID, "polling:", info.Polling)
+	ctx.JSONSuccess(info)
+	return nil
+}
+
+func (wc *WatchController) handleUnwatchPath(ctx *updater.Context) error {
+	var req updater.UnwatchRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	if err := wc.watcher.Unwatch(req.ID); err != nil {
+		ctx.JSONError(updater.ErrorCode(err), err.Error())
+		return err
+	}
+
+	ctx.JSONSuccess(req)
+	return nil
+}
@@ -0,0 +1,49 @@
+package v1
+
+import (
+	"updater"
+)
+
+type WorkflowController struct {
+	handler *updater.MessageHandler
+}
+
+func NewWorkflowController(handler *updater.MessageHandler) *WorkflowController {
+	controller := &WorkflowController{
+		handler: handler,
+	}
+	controller.registerHandlers()
+	return controller
+}
+
+func (wc *WorkflowController) registerHandlers() {
+	wc.handler.RegisterHandler(updater.MsgTypeWorkflow, wc.handleWorkflow)
+}
+
+// handleWorkflow 在本地按顺序执行工作流的所有步骤，执行完后返回每个步骤的结果
+func (wc *WorkflowController) handleWorkflow(ctx *updater.Context) error {
+	var req updater.WorkflowRequest
+	if err := ctx.Unmarshal(&req); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+	if req.TaskID == "" {
+		req.TaskID = ctx.Message.TaskId
+	}
+	if req.TaskID == "" {
+		req.TaskID = ctx.Message.Id
+	}
+
+	ctx.Logger.Println("workflow:", req.TaskID, req.Name, "steps:", len(req.Steps))
+
+	workflowTask := updater.NewWorkflowTask(&req)
+	if err := ctx.App().TaskManager.AddTask(workflowTask); err != nil {
+		ctx.JSONError(updater.CODE_ERROR, err.Error())
+		return err
+	}
+
+	err := workflowTask.Run(ctx)
+	result := workflowTask.GetResult().(*updater.WorkflowResult)
+	ctx.JSON(result.Code, result.Message, result)
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"updater/pkg/app"
 	"updater/pkg/logger"
+	"updater/pkg/trace"
 )
 
 type Context struct {
@@ -48,6 +49,7 @@ func (ctx *Context) JSON(code string, msg string, req interface{}) {
 
 func (ctx *Context) SendResponse(code string, msg string, resp interface{}) {
 	ctx.Message.Method = METHOD_RESPONSE
+	ctx.setTraceParent(ctx.Message)
 	ctx.Message.Code = code
 	ctx.Message.Msg = msg
 	if resp != nil {
@@ -63,6 +65,36 @@ func (ctx *Context) SendRequest(req interface{}) {
 	ctx.Client.SendMessage(ctx.Message)
 }
 
+// Notify 向服务器主动发送一条与当前消息关联的请求消息，例如任务进度
+func (ctx *Context) Notify(msgType string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	msg := &Message{
+		Type:    msgType,
+		Method:  METHOD_REQUEST,
+		Data:    b,
+		TraceId: ctx.Message.TraceId,
+		TaskId:  ctx.Message.TaskId,
+	}
+	ctx.setTraceParent(msg)
+	return ctx.Client.SendMessage(msg)
+}
+
+// StartSpan 创建当前消息处理 span 的子 span，调用者负责 End
+func (ctx *Context) StartSpan(name string, kv ...interface{}) *trace.Span {
+	_, span := trace.Start(ctx.Ctx, name, kv...)
+	return span
+}
+
+// setTraceParent 发给服务器的消息以当前消息处理的 span 作为父 span
+func (ctx *Context) setTraceParent(msg *Message) {
+	if sc := trace.SpanFromContext(ctx.Ctx).SpanContext(); sc.IsValid() {
+		msg.TraceParent, msg.TraceState = sc.TraceParent(), sc.State
+	}
+}
+
 func (ctx *Context) Unmarshal(req interface{}) (err error) {
 	if err := json.Unmarshal(ctx.Message.Data, req); err != nil {
 		return err
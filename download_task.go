@@ -0,0 +1,280 @@
+package updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/task"
+	"updater/pkg/trace"
+)
+
+const TaskTypeDownload = "download"
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// DownloadResult 下载任务的执行结果
+type DownloadResult struct {
+	TaskID    string    `json:"taskId"`
+	Code      string    `json:"code"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Message   string    `json:"message"`
+	Progress  *Progress `json:"progress,omitempty"`
+}
+
+type DownloadTask struct {
+	TaskID  string
+	Request *DownloadRequest
+	Status  task.TaskStatus
+	Created time.Time
+	Updated time.Time
+	Result  *DownloadResult
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	progress *ProgressCounter
+	fm       *FileManager
+}
+
+func NewDownloadTask(req *DownloadRequest) *DownloadTask {
+	return &DownloadTask{
+		TaskID:  req.TaskID,
+		Request: req,
+		Status:  task.TaskStatusCreated,
+		Created: time.Now(),
+		Updated: time.Now(),
+		Result: &DownloadResult{
+			TaskID: req.TaskID,
+		},
+		fm: NewFileManager(),
+	}
+}
+
+func (dt *DownloadTask) GetTaskID() string {
+	return dt.TaskID
+}
+
+func (dt *DownloadTask) GetType() string {
+	return TaskTypeDownload
+}
+
+func (dt *DownloadTask) GetStatus() task.TaskStatus {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	return dt.Status
+}
+
+func (dt *DownloadTask) GetContent() []byte {
+	return []byte(dt.Request.URL)
+}
+
+func (dt *DownloadTask) SetStatus(status task.TaskStatus) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.Status = status
+	dt.Updated = time.Now()
+}
+
+// GetResult 返回下载结果，任务运行中时附带当前进度
+func (dt *DownloadTask) GetResult() interface{} {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	r := *dt.Result
+	if dt.progress != nil {
+		p := dt.progress.Snapshot()
+		r.Progress = &p
+	}
+	return &r
+}
+
+func (dt *DownloadTask) Stop() error {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	if dt.cancel == nil {
+		return task.ErrTaskNotRunning
+	}
+	dt.Status = task.TaskStatusCanceled
+	dt.cancel()
+	return nil
+}
+
+// Run 执行下载，下载进度通过 "<消息类型>/Progress" 消息定期上报给服务器
+func (dt *DownloadTask) Run(ctx *Context) (err error) {
+	req := dt.Request
+	dt.Result.StartTime = time.Now()
+
+	defer func() {
+		dt.mu.Lock()
+		defer dt.mu.Unlock()
+		dt.Result.EndTime = time.Now()
+		dt.Updated = time.Now()
+		dt.cancel = nil
+		if err != nil {
+			dt.Result.Message = err.Error()
+			dt.Result.Code = ErrorCode(err)
+			if dt.Status != task.TaskStatusCanceled {
+				dt.Status = task.TaskStatusFailed
+			}
+			return
+		}
+		dt.Result.Code = CODE_SUCCESS
+		dt.Result.Message = "success"
+		dt.Status = task.TaskStatusCompleted
+	}()
+
+	c, span := trace.Start(ctx.Ctx, "download", "task.id", dt.TaskID, "http.url", spanURL(req.URL), "file.path", req.DestPath)
+	defer func() {
+		dt.mu.Lock()
+		pc := dt.progress
+		dt.mu.Unlock()
+		if pc != nil {
+			span.SetAttributes("download.bytes", pc.Snapshot().Done)
+		}
+		span.End(err)
+	}()
+
+	var cancel context.CancelFunc
+	if req.Timeout > 0 {
+		c, cancel = context.WithTimeout(c, time.Second*time.Duration(req.Timeout))
+	} else {
+		c, cancel = context.WithCancel(c)
+	}
+	defer cancel()
+
+	dt.mu.Lock()
+	dt.Status = task.TaskStatusRunning
+	dt.cancel = cancel
+	dt.mu.Unlock()
+
+	if err = dt.fm.CheckPath(PathOpWrite, req.DestPath); err != nil {
+		return err
+	}
+
+	// 创建目标文件夹（如果需要）
+	ctx.Logger.Println("autoCreateDir:", req.AutoCreateDir)
+	if req.AutoCreateDir {
+		if err = os.MkdirAll(filepath.Dir(req.DestPath), 0755); err != nil {
+			return err
+		}
+	}
+
+	ctx.Logger.Println("overwriteExisted:", req.OverwriteExisted)
+	ctx.Logger.Println("destPath:", req.DestPath)
+	// 检查目标文件是否存在
+	if _, err = os.Stat(req.DestPath); err == nil && !req.OverwriteExisted {
+		return fmt.Errorf("file already exists and overwriteExisted is set to false")
+	}
+
+	// 发起 HTTP 请求
+	httpReq, err := http.NewRequestWithContext(c, http.MethodGet, req.URL, nil)
+	if err != nil {
+		return err
+	}
+	trace.Inject(c, httpReq.Header)
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	// 创建目标文件
+	file, err := os.Create(req.DestPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pc := NewProgressCounter(dt.TaskID, resp.ContentLength)
+	dt.mu.Lock()
+	dt.progress = pc
+	dt.mu.Unlock()
+
+	ReportProgress(c, time.Duration(req.ProgressInterval)*time.Second, pc, func(p Progress) {
+		ctx.Notify(ctx.Message.Type+"/Progress", p)
+	})
+
+	// 将响应的内容写入文件
+	if _, err = io.Copy(file, countDownload(pc.Reader(resp.Body))); err != nil {
+		return err
+	}
+
+	// 最后上报一次，保证服务器能收到 100% 的进度
+	if req.ProgressInterval >= 0 {
+		ctx.Notify(ctx.Message.Type+"/Progress", pc.Snapshot())
+	}
+	return nil
+}
+
+// fetchFile 下载 url 到 dest：先写同目录下的临时文件，校验 sha256 后改名覆盖。
+// expectedSha256 为空时不校验，返回实际内容的 sha256
+func fetchFile(c context.Context, url, dest, expectedSha256 string, perm os.FileMode, pc *ProgressCounter) (sum string, err error) {
+	c, span := trace.StartWithKind(c, trace.SpanKindClient, "fetch", "http.url", spanURL(url), "file.path", dest)
+	defer func() { span.End(err) }()
+
+	httpReq, err := http.NewRequestWithContext(c, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	trace.Inject(c, httpReq.Header)
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s failed: %s", url, resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".download")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	body := countDownload(resp.Body)
+	if pc != nil {
+		body = pc.Reader(body)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	sum = hex.EncodeToString(hash.Sum(nil))
+	if expectedSha256 != "" && !strings.EqualFold(sum, expectedSha256) {
+		return "", fmt.Errorf("%w: %s expected sha256 %s, got %s", ErrChecksumMismatch, url, expectedSha256, sum)
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return "", err
+	}
+	return sum, os.Rename(tmp.Name(), dest)
+}
+
+// isTimeout 判断是否为超时错误
+func isTimeout(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
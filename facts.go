@@ -0,0 +1,308 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/logger"
+)
+
+const (
+	defaultFactsDir          = ".data/facts.d"
+	defaultFactScriptTimeout = 10 * time.Second
+	maxFactScriptOutput      = 64 * 1024
+	factsRegisterMaxAge      = 5 * time.Minute
+	factsCollectTimeout      = time.Minute
+)
+
+// Facts 是主机的清单信息，在注册时上报，也可以通过 v1/GetFacts 刷新
+type Facts struct {
+	Hostname    string                 `json:"hostname"`
+	OS          string                 `json:"os"`
+	Arch        string                 `json:"arch"`
+	Kernel      string                 `json:"kernel"`
+	Distro      DistroFacts            `json:"distro"`
+	CPU         CPUFacts               `json:"cpu"`
+	Memory      MemoryFacts            `json:"memory"`
+	Disks       []DiskFacts            `json:"disks"`
+	Mounts      []MountFacts           `json:"mounts"`
+	Interfaces  []InterfaceFacts       `json:"interfaces"`
+	DMI         DMIFacts               `json:"dmi"`
+	Uptime      int64                  `json:"uptime"` // 秒
+	BootTime    time.Time              `json:"bootTime"`
+	Timezone    string                 `json:"timezone"`         // 时区名称，例如 Asia/Shanghai
+	TZOffset    int                    `json:"timezoneOffset"`   // 与 UTC 的偏移（秒）
+	Custom      map[string]interface{} `json:"custom,omitempty"` // 自定义 provider 和 fact 脚本的结果
+	Errors      map[string]string      `json:"errors,omitempty"` // 采集失败的项
+	CollectedAt time.Time              `json:"collectedAt"`
+}
+
+// DistroFacts 来自 /etc/os-release
+type DistroFacts struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	VersionID  string `json:"versionId"`
+	PrettyName string `json:"prettyName"`
+}
+
+type CPUFacts struct {
+	Model   string `json:"model"`
+	Count   int    `json:"count"`   // 逻辑 CPU 数量
+	Sockets int    `json:"sockets"` // 物理 CPU 数量
+	Cores   int    `json:"cores"`   // 物理核数
+}
+
+// MemoryFacts 单位为字节
+type MemoryFacts struct {
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+	SwapTotal uint64 `json:"swapTotal"`
+}
+
+type DiskFacts struct {
+	Name       string `json:"name"`
+	Size       uint64 `json:"size"` // 字节
+	Model      string `json:"model"`
+	Rotational bool   `json:"rotational"`
+}
+
+type MountFacts struct {
+	Device     string `json:"device"`
+	MountPoint string `json:"mountPoint"`
+	FSType     string `json:"fsType"`
+	Total      uint64 `json:"total"` // 字节
+	Free       uint64 `json:"free"`  // 非特权用户可用的字节数
+}
+
+type InterfaceFacts struct {
+	Name string   `json:"name"`
+	MAC  string   `json:"mac"`
+	MTU  int      `json:"mtu"`
+	Up   bool     `json:"up"`
+	IPv4 []string `json:"ipv4"`
+	IPv6 []string `json:"ipv6"`
+}
+
+type DMIFacts struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Serial  string `json:"serial"`
+	UUID    string `json:"uuid"`
+}
+
+// FactProvider 是自定义的清单信息来源，结果保存在 Facts.Custom[Name()] 中
+type FactProvider interface {
+	Name() string
+	Collect(ctx context.Context) (interface{}, error)
+}
+
+// FactsCollector 采集主机清单信息，包括内置的信息、注册的 provider 和 fact 脚本目录中的可执行文件
+type FactsCollector struct {
+	mu        sync.Mutex
+	providers []FactProvider
+	scriptDir string
+	timeout   time.Duration
+	logger    *logger.Logger
+	fm        *FileManager
+	cache     *Facts
+}
+
+func NewFactsCollector(app *app.App) *FactsCollector {
+	fc := &FactsCollector{
+		scriptDir: app.Config.FactsDir,
+		timeout:   time.Duration(app.Config.FactScriptTimeout) * time.Second,
+		logger:    app.Logger,
+		fm:        NewFileManager(),
+	}
+	if fc.scriptDir == "" {
+		fc.scriptDir = defaultFactsDir
+	}
+	if fc.timeout <= 0 {
+		fc.timeout = defaultFactScriptTimeout
+	}
+	return fc
+}
+
+// RegisterProvider 添加一个自定义 provider，名称相同时替换原来的 provider
+func (fc *FactsCollector) RegisterProvider(p FactProvider) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for i, old := range fc.providers {
+		if old.Name() == p.Name() {
+			fc.providers[i] = p
+			return
+		}
+	}
+	fc.providers = append(fc.providers, p)
+}
+
+// Get 返回不超过 maxAge 的缓存结果，没有缓存或者已经过期时重新采集
+func (fc *FactsCollector) Get(ctx context.Context, maxAge time.Duration) *Facts {
+	fc.mu.Lock()
+	cached := fc.cache
+	fc.mu.Unlock()
+	if cached != nil && time.Since(cached.CollectedAt) < maxAge {
+		return cached
+	}
+	return fc.Collect(ctx)
+}
+
+// Collect 重新采集所有清单信息，单项失败时记录在 Errors 中，不影响其它项
+func (fc *FactsCollector) Collect(ctx context.Context) *Facts {
+	f := &Facts{
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		Hostname:    getHostName(),
+		CPU:         CPUFacts{Count: runtime.NumCPU()},
+		Custom:      make(map[string]interface{}),
+		Errors:      make(map[string]string),
+		CollectedAt: time.Now(),
+	}
+	name, offset := f.CollectedAt.Zone()
+	f.Timezone, f.TZOffset = name, offset
+
+	collectPlatformFacts(f)
+	if ifaces, err := collectInterfaces(); err != nil {
+		f.Errors["interfaces"] = err.Error()
+	} else {
+		f.Interfaces = ifaces
+	}
+
+	fc.mu.Lock()
+	providers := append([]FactProvider(nil), fc.providers...)
+	fc.mu.Unlock()
+	for _, p := range providers {
+		v, err := p.Collect(ctx)
+		if err != nil {
+			f.Errors[p.Name()] = err.Error()
+			continue
+		}
+		f.Custom[p.Name()] = v
+	}
+	fc.runScripts(ctx, f)
+
+	fc.mu.Lock()
+	fc.cache = f
+	fc.mu.Unlock()
+	return f
+}
+
+// runScripts 执行 fact 脚本目录中的所有可执行文件，文件名（去掉扩展名）作为 Custom 中的 key。
+// 输出是 JSON 时按 JSON 解析，每行都是 key=value 时解析为对象，否则保存原始输出
+func (fc *FactsCollector) runScripts(ctx context.Context, f *Facts) {
+	entries, err := ioutil.ReadDir(fc.scriptDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			f.Errors["scripts"] = err.Error()
+		}
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || e.Mode()&0111 == 0 || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(fc.scriptDir, e.Name())
+		name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+		v, err := fc.runScript(ctx, path)
+		if err != nil {
+			fc.logger.Println("fact script failed:", path, err)
+			f.Errors[name] = err.Error()
+			continue
+		}
+		f.Custom[name] = v
+	}
+}
+
+func (fc *FactsCollector) runScript(ctx context.Context, path string) (interface{}, error) {
+	if err := fc.fm.CheckPath(PathOpExecute, path); err != nil {
+		return nil, err
+	}
+	c, cancel := context.WithTimeout(ctx, fc.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(c, path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if c.Err() != nil {
+			return nil, c.Err()
+		}
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(truncateOutput(stderr.String())))
+	}
+	if stdout.Len() > maxFactScriptOutput {
+		return nil, fmt.Errorf("output exceeds %d bytes", maxFactScriptOutput)
+	}
+	return parseFactOutput(stdout.Bytes()), nil
+}
+
+func parseFactOutput(out []byte) interface{} {
+	out = bytes.TrimSpace(out)
+	var v interface{}
+	if json.Valid(out) && json.Unmarshal(out, &v) == nil {
+		return v
+	}
+
+	kv := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return string(out)
+		}
+		kv[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return kv
+}
+
+func collectInterfaces() ([]InterfaceFacts, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var list []InterfaceFacts
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		info := InterfaceFacts{
+			Name: iface.Name,
+			MAC:  iface.HardwareAddr.String(),
+			MTU:  iface.MTU,
+			Up:   iface.Flags&net.FlagUp != 0,
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ipnet.IP.To4() != nil {
+				info.IPv4 = append(info.IPv4, ipnet.String())
+			} else {
+				info.IPv6 = append(info.IPv6, ipnet.String())
+			}
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
@@ -0,0 +1,229 @@
+package updater
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pseudoFilesystems 是不需要上报的虚拟文件系统
+var pseudoFilesystems = map[string]bool{
+	"proc": true, "sysfs": true, "devpts": true, "devtmpfs": true, "cgroup": true, "cgroup2": true,
+	"securityfs": true, "debugfs": true, "tracefs": true, "pstore": true, "bpf": true, "mqueue": true,
+	"hugetlbfs": true, "configfs": true, "fusectl": true, "autofs": true, "binfmt_misc": true,
+	"rpc_pipefs": true, "nsfs": true, "efivarfs": true,
+}
+
+// collectPlatformFacts 从 /proc、/sys 和 /etc 采集 Linux 主机信息
+func collectPlatformFacts(f *Facts) {
+	readFile := func(name, path string) string {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) && !os.IsPermission(err) {
+				f.Errors[name] = err.Error()
+			}
+			return ""
+		}
+		return strings.TrimSpace(string(b))
+	}
+
+	f.Kernel = readFile("kernel", "/proc/sys/kernel/osrelease")
+	if s := readFile("distro", "/etc/os-release"); s != "" {
+		f.Distro = parseOSRelease(s)
+	}
+	if s := readFile("cpu", "/proc/cpuinfo"); s != "" {
+		cpu := parseCPUInfo(s)
+		if cpu.Count == 0 {
+			cpu.Count = f.CPU.Count
+		}
+		f.CPU = cpu
+	}
+	if s := readFile("memory", "/proc/meminfo"); s != "" {
+		f.Memory = parseMemInfo(s)
+	}
+	if s := readFile("uptime", "/proc/uptime"); s != "" {
+		if fields := strings.Fields(s); len(fields) > 0 {
+			sec, _ := strconv.ParseFloat(fields[0], 64)
+			f.Uptime = int64(sec)
+			f.BootTime = f.CollectedAt.Add(-time.Duration(sec * float64(time.Second))).Truncate(time.Second)
+		}
+	}
+
+	f.DMI = DMIFacts{
+		Vendor:  readFile("dmi", "/sys/class/dmi/id/sys_vendor"),
+		Product: readFile("dmi", "/sys/class/dmi/id/product_name"),
+		Serial:  readFile("dmi", "/sys/class/dmi/id/product_serial"),
+		UUID:    readFile("dmi", "/sys/class/dmi/id/product_uuid"),
+	}
+
+	if tz := readFile("timezone", "/etc/timezone"); tz != "" {
+		f.Timezone = tz
+	} else if link, err := os.Readlink("/etc/localtime"); err == nil {
+		if i := strings.Index(link, "zoneinfo/"); i >= 0 {
+			f.Timezone = link[i+len("zoneinfo/"):]
+		}
+	}
+
+	f.Disks = collectDisks()
+	if s := readFile("mounts", "/proc/mounts"); s != "" {
+		f.Mounts = collectMounts(s)
+	}
+}
+
+// parseOSRelease 解析 /etc/os-release 的 KEY="value" 格式
+func parseOSRelease(s string) DistroFacts {
+	var d DistroFacts
+	for _, line := range strings.Split(s, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		switch key {
+		case "ID":
+			d.ID = value
+		case "NAME":
+			d.Name = value
+		case "VERSION":
+			d.Version = value
+		case "VERSION_ID":
+			d.VersionID = value
+		case "PRETTY_NAME":
+			d.PrettyName = value
+		}
+	}
+	return d
+}
+
+// parseCPUInfo 解析 /proc/cpuinfo，按 physical id 和 core id 统计物理 CPU 和核数
+func parseCPUInfo(s string) CPUFacts {
+	var cpu CPUFacts
+	sockets := make(map[string]bool)
+	cores := make(map[string]bool)
+	var physical string
+	for _, line := range strings.Split(s, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "processor":
+			cpu.Count++
+		case "model name", "Model", "cpu model":
+			if cpu.Model == "" {
+				cpu.Model = value
+			}
+		case "physical id":
+			physical = value
+			sockets[value] = true
+		case "core id":
+			cores[physical+"/"+value] = true
+		}
+	}
+	cpu.Sockets, cpu.Cores = len(sockets), len(cores)
+	return cpu
+}
+
+// parseMemInfo 解析 /proc/meminfo，单位从 kB 转换为字节
+func parseMemInfo(s string) MemoryFacts {
+	var m MemoryFacts
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		v, _ := strconv.ParseUint(fields[1], 10, 64)
+		v *= 1024
+		switch fields[0] {
+		case "MemTotal:":
+			m.Total = v
+		case "MemAvailable:":
+			m.Available = v
+		case "SwapTotal:":
+			m.SwapTotal = v
+		}
+	}
+	return m
+}
+
+// collectDisks 读取 /sys/block 中的块设备，忽略 loop、ram 和 zram 设备
+func collectDisks() []DiskFacts {
+	entries, err := ioutil.ReadDir("/sys/block")
+	if err != nil {
+		return nil
+	}
+	var disks []DiskFacts
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+		dir := filepath.Join("/sys/block", name)
+		read := func(p string) string {
+			b, _ := ioutil.ReadFile(filepath.Join(dir, p))
+			return strings.TrimSpace(string(b))
+		}
+		sectors, _ := strconv.ParseUint(read("size"), 10, 64)
+		disks = append(disks, DiskFacts{
+			Name:       name,
+			Size:       sectors * 512,
+			Model:      read("device/model"),
+			Rotational: read("queue/rotational") == "1",
+		})
+	}
+	return disks
+}
+
+// collectMounts 解析 /proc/mounts 并统计容量，忽略虚拟文件系统和重复的挂载点
+func collectMounts(s string) []MountFacts {
+	var mounts []MountFacts
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || pseudoFilesystems[fields[2]] {
+			continue
+		}
+		// 挂载点中的空格等字符以八进制转义
+		mountPoint := unescapeMountPath(fields[1])
+		if seen[mountPoint] {
+			continue
+		}
+		seen[mountPoint] = true
+
+		m := MountFacts{Device: fields[0], MountPoint: mountPoint, FSType: fields[2]}
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(mountPoint, &st); err == nil {
+			m.Total = st.Blocks * uint64(st.Bsize)
+			m.Free = st.Bavail * uint64(st.Bsize)
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts
+}
+
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
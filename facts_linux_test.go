@@ -0,0 +1,129 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func TestParseFacts(t *testing.T) {
+	cpu := parseCPUInfo(`processor	: 0
+model name	: Intel(R) Xeon(R) CPU
+physical id	: 0
+core id		: 0
+
+processor	: 1
+model name	: Intel(R) Xeon(R) CPU
+physical id	: 0
+core id		: 1
+
+processor	: 2
+model name	: Intel(R) Xeon(R) CPU
+physical id	: 1
+core id		: 0
+`)
+	if cpu != (CPUFacts{Model: "Intel(R) Xeon(R) CPU", Count: 3, Sockets: 2, Cores: 3}) {
+		t.Fatalf("unexpected cpu: %+v", cpu)
+	}
+
+	mem := parseMemInfo("MemTotal:        2048 kB\nMemFree:  100 kB\nMemAvailable:    1024 kB\nSwapTotal:       0 kB\n")
+	if mem != (MemoryFacts{Total: 2048 * 1024, Available: 1024 * 1024}) {
+		t.Fatalf("unexpected memory: %+v", mem)
+	}
+
+	distro := parseOSRelease("PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nNAME='Debian GNU/Linux'\nVERSION_ID=\"12\"\nID=debian\n")
+	if distro != (DistroFacts{ID: "debian", Name: "Debian GNU/Linux", VersionID: "12", PrettyName: "Debian GNU/Linux 12 (bookworm)"}) {
+		t.Fatalf("unexpected distro: %+v", distro)
+	}
+
+	mounts := collectMounts("proc /proc proc rw 0 0\n/dev/sda1 / ext4 rw 0 0\n/dev/sdb1 /mnt/my\\040disk xfs rw 0 0\n")
+	if len(mounts) != 2 || mounts[0].MountPoint != "/" || mounts[0].Total == 0 || mounts[1].MountPoint != "/mnt/my disk" {
+		t.Fatalf("unexpected mounts: %+v", mounts)
+	}
+
+	for out, want := range map[string]interface{}{
+		`{"rack": "A1", "slot": 3}`: map[string]interface{}{"rack": "A1", "slot": float64(3)},
+		"rack=A1\nslot = 3\n":       map[string]interface{}{"rack": "A1", "slot": "3"},
+		"hello world\n":             "hello world",
+	} {
+		if got := parseFactOutput([]byte(out)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("parse %q: got %#v", out, got)
+		}
+	}
+}
+
+type testFactProvider struct {
+	calls int
+	err   error
+}
+
+func (p *testFactProvider) Name() string { return "test" }
+
+func (p *testFactProvider) Collect(ctx context.Context) (interface{}, error) {
+	p.calls++
+	return p.calls, p.err
+}
+
+func TestFactsCollector(t *testing.T) {
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{})
+
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"role.sh":  "#!/bin/sh\necho role=web\necho env=prod\n",
+		"fail.sh":  "#!/bin/sh\necho boom >&2\nexit 3\n",
+		"slow.sh":  "#!/bin/sh\nexec sleep 5\n",
+		"plain.sh": "#!/bin/sh\necho '[1, 2]'\n",
+	}
+	for name, content := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 没有执行权限的文件不是 fact 脚本
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("docs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fc := NewFactsCollector(&app.App{
+		Config: &config.Config{FactsDir: dir, FactScriptTimeout: 1},
+		Logger: &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+	})
+	provider := &testFactProvider{}
+	fc.RegisterProvider(provider)
+
+	f := fc.Get(context.Background(), time.Minute)
+	if f.Kernel == "" || f.CPU.Count == 0 || f.Memory.Total == 0 || f.Uptime <= 0 || f.Hostname == "" {
+		t.Fatalf("unexpected builtin facts: %+v", f)
+	}
+	if !reflect.DeepEqual(f.Custom["role"], map[string]interface{}{"role": "web", "env": "prod"}) ||
+		!reflect.DeepEqual(f.Custom["plain"], []interface{}{float64(1), float64(2)}) || f.Custom["test"] != 1 {
+		t.Fatalf("unexpected custom facts: %+v", f.Custom)
+	}
+	if f.Errors["fail"] == "" || f.Errors["slow"] != context.DeadlineExceeded.Error() {
+		t.Fatalf("unexpected errors: %+v", f.Errors)
+	}
+	if _, ok := f.Custom["README"]; ok {
+		t.Fatal("non executable file should be ignored")
+	}
+
+	// 缓存没有过期时不重新采集，Collect 总是重新采集
+	if fc.Get(context.Background(), time.Minute) != f || provider.calls != 1 {
+		t.Fatal("expected cached facts")
+	}
+	provider.err = errors.New("unavailable")
+	f = fc.Collect(context.Background())
+	if provider.calls != 2 || f.Errors["test"] != "unavailable" || f.Custom["test"] != nil {
+		t.Fatalf("unexpected provider result: %+v %+v", f.Custom, f.Errors)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package updater
+
+// collectPlatformFacts 其它平台只采集通用的信息
+func collectPlatformFacts(f *Facts) {}
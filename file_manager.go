@@ -1,6 +1,7 @@
 package updater
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -31,23 +32,87 @@ func NewFileManager() *FileManager {
 	return &FileManager{}
 }
 
+// FileRequest 是针对单个路径的请求参数
+type FileRequest struct {
+	Path string `json:"path"` // 文件或目录路径
+}
+
+// DeleteRequest 是删除请求参数
+type DeleteRequest struct {
+	FilePath  string `json:"filePath"`  // 文件路径
+	Recursive bool   `json:"recursive"` // 是否递归删除目录
+}
+
+// MoveRequest 是移动请求参数
+type MoveRequest struct {
+	Src              string `json:"src"`              // 源路径
+	Dest             string `json:"dest"`             // 目标路径
+	AutoCreateDir    bool   `json:"autoCreateDir"`    // 是否自动创建文件夹
+	OverwriteExisted bool   `json:"overwriteExisted"` // 文件存在是否覆盖文件
+}
+
 // GetFileInfo 获取一个文件或者目录的信息
 func (fm *FileManager) GetFileInfo(path string) (os.FileInfo, error) {
+	if err := fm.CheckPath(PathOpRead, path); err != nil {
+		return nil, err
+	}
 	return os.Stat(path)
 }
 
 // DeleteFile 删除一个文件
 func (fm *FileManager) DeleteFile(path string) error {
+	if err := fm.CheckPath(PathOpDelete, path); err != nil {
+		return err
+	}
 	return os.Remove(path)
 }
 
+// DeleteAll 递归删除一个目录，不允许删除根目录
+func (fm *FileManager) DeleteAll(path string) error {
+	if err := fm.CheckPath(PathOpDelete, path); err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(abs) == abs {
+		return &PathPolicyError{Op: PathOpDelete, Path: path, Resolved: abs, Rule: abs}
+	}
+	return os.RemoveAll(path)
+}
+
 // MoveFile 移动一个文件
-func (fm *FileManager) MoveFile(src, dest string) error {
-	return os.Rename(src, dest)
+func (fm *FileManager) MoveFile(req *MoveRequest) error {
+	if err := fm.CheckPath(PathOpDelete, req.Src); err != nil {
+		return err
+	}
+	if err := fm.CheckPath(PathOpWrite, req.Dest); err != nil {
+		return err
+	}
+	// 先检查策略再访问目标路径，被拒绝的移动不能创建任何目录
+	if _, err := os.Stat(req.Dest); err == nil && !req.OverwriteExisted {
+		return fmt.Errorf("file already exists and overwriteExisted is set to false")
+	}
+	if req.AutoCreateDir {
+		if err := os.MkdirAll(filepath.Dir(req.Dest), 0755); err != nil {
+			return err
+		}
+	}
+	return os.Rename(req.Src, req.Dest)
 }
 
-// BackupFile 备份一个文件
+// BackupFile 备份一个文件，备份到备份目录中时不检查写入策略
 func (fm *FileManager) BackupFile(src, dest string) error {
+	if err := fm.CheckPath(PathOpRead, src); err != nil {
+		return err
+	}
+	if !isBackupPath(dest) {
+		if err := fm.CheckPath(PathOpWrite, dest); err != nil {
+			return err
+		}
+	}
+
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return err
@@ -73,10 +138,15 @@ type DownloadRequest struct {
 	AutoCreateDir    bool   `json:"autoCreateDir"`    // 是否自动创建文件夹
 	OverwriteExisted bool   `json:"overwriteExisted"` // 文件存在是否覆盖文件
 	Timeout          int    `json:"timeout"`          // 超时时间
+	ProgressInterval int    `json:"progressInterval"` // 进度上报间隔（秒），0 使用默认值，小于 0 不上报
 }
 
 // DownloadFile 从URL下载文件
 func (fm *FileManager) DownloadFile(req DownloadRequest) error {
+	if err := fm.CheckPath(PathOpWrite, req.DestPath); err != nil {
+		return err
+	}
+
 	// 判断目标文件是否存在
 	_, err := os.Stat(req.DestPath)
 	if !os.IsNotExist(err) && !req.OverwriteExisted {
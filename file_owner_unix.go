@@ -0,0 +1,17 @@
+//go:build !windows
+
+package updater
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner 返回文件的属主和属组
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
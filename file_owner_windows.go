@@ -0,0 +1,8 @@
+package updater
+
+import "os"
+
+// fileOwner Windows 下不支持文件属主
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return -1, -1, false
+}
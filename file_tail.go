@@ -0,0 +1,426 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+	"updater/pkg/task"
+)
+
+const (
+	TaskTypeTail = "tail"
+
+	EncodingText   = "text"
+	EncodingBase64 = "base64"
+
+	defaultReadLength   = 64 * 1024
+	maxReadLength       = 4 * 1024 * 1024
+	defaultTailLines    = 10
+	maxTailLines        = 10000
+	defaultTailInterval = time.Second
+	maxTailBatchLines   = 500
+	maxTailLineLength   = 64 * 1024 // 超过这个长度还没有换行时，已读到的内容作为一行推送
+)
+
+// ReadFileRequest 是读取文件请求参数
+type ReadFileRequest struct {
+	Path     string `json:"path"`     // 文件路径
+	Offset   int64  `json:"offset"`   // 起始位置，小于 0 时从文件末尾倒数
+	Length   int64  `json:"length"`   // 读取长度，0 使用默认值
+	Encoding string `json:"encoding"` // 内容编码：text、base64，为空时自动判断
+}
+
+// ReadFileResult 是读取文件的结果
+type ReadFileResult struct {
+	Path     string `json:"path"`
+	Offset   int64  `json:"offset"`   // 实际起始位置
+	Length   int64  `json:"length"`   // 实际读取的字节数
+	Size     int64  `json:"size"`     // 文件大小
+	EOF      bool   `json:"eof"`      // 是否读到文件末尾
+	Encoding string `json:"encoding"` // 内容编码
+	Content  string `json:"content"`
+}
+
+// TailFileRequest 是 tail 请求参数
+type TailFileRequest struct {
+	TaskID   string `json:"taskId"`   // 任务ID，follow 模式下用于取消
+	Path     string `json:"path"`     // 文件路径
+	Lines    int    `json:"lines"`    // 返回最后几行
+	Follow   bool   `json:"follow"`   // 是否持续跟踪新增内容
+	Interval int    `json:"interval"` // follow 模式下的检查间隔（毫秒）
+	Timeout  int    `json:"timeout"`  // follow 模式的最长持续时间（秒），0 不限制
+}
+
+// TailLines 是 tail 返回或推送的行
+type TailLines struct {
+	TaskID    string   `json:"taskId"`
+	Path      string   `json:"path"`
+	Lines     []string `json:"lines"`
+	Offset    int64    `json:"offset"`              // 下一次读取的位置
+	Rotated   bool     `json:"rotated,omitempty"`   // 文件被轮转，已重新打开
+	Truncated bool     `json:"truncated,omitempty"` // 文件被截断，已从头开始读取
+}
+
+// ReadFile 按偏移和长度读取文件内容，二进制内容使用 base64 编码
+func (fm *FileManager) ReadFile(req *ReadFileRequest) (*ReadFileResult, error) {
+	switch req.Encoding {
+	case "", EncodingText, EncodingBase64:
+	default:
+		return nil, fmt.Errorf("unknown encoding: %s", req.Encoding)
+	}
+	if err := fm.CheckPath(PathOpRead, req.Path); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := req.Offset
+	if offset < 0 {
+		offset = info.Size() + offset
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	length := req.Length
+	if length <= 0 {
+		length = defaultReadLength
+	}
+	if length > maxReadLength {
+		length = maxReadLength
+	}
+
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	encoding := req.Encoding
+	if encoding == "" {
+		encoding = EncodingText
+		if !isText(buf) {
+			encoding = EncodingBase64
+		}
+	}
+
+	result := &ReadFileResult{
+		Path:     req.Path,
+		Offset:   offset,
+		Length:   int64(n),
+		Size:     info.Size(),
+		EOF:      offset+int64(n) >= info.Size(),
+		Encoding: encoding,
+	}
+	if encoding == EncodingBase64 {
+		result.Content = base64.StdEncoding.EncodeToString(buf)
+	} else {
+		result.Content = string(buf)
+	}
+	return result, nil
+}
+
+// isText 判断内容是否为文本。截断在多字节字符中间的末尾不影响判断
+func isText(b []byte) bool {
+	if bytes.IndexByte(b, 0) >= 0 {
+		return false
+	}
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			return len(b) < utf8.UTFMax && !utf8.FullRune(b)
+		}
+		b = b[size:]
+	}
+	return true
+}
+
+// TailFile 返回文件的最后 n 行以及文件当前大小
+func (fm *FileManager) TailFile(path string, n int) ([]string, int64, error) {
+	if err := fm.CheckPath(PathOpRead, path); err != nil {
+		return nil, 0, err
+	}
+	if n <= 0 {
+		n = defaultTailLines
+	}
+	if n > maxTailLines {
+		n = maxTailLines
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	lines, err := lastLines(f, info.Size(), n)
+	return lines, info.Size(), err
+}
+
+// lastLines 从文件末尾向前按块读取，直到找到 n 行
+func lastLines(r io.ReaderAt, size int64, n int) ([]string, error) {
+	const chunk = 8 * 1024
+	var data []byte
+	pos := size
+	for pos > 0 && bytes.Count(data, []byte{'\n'}) <= n {
+		start := pos - chunk
+		if start < 0 {
+			start = 0
+		}
+		buf := make([]byte, pos-start)
+		if _, err := r.ReadAt(buf, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(buf, data...)
+		pos = start
+	}
+
+	data = bytes.TrimSuffix(data, []byte{'\n'})
+	if len(data) == 0 {
+		return []string{}, nil
+	}
+	lines := bytes.Split(data, []byte{'\n'})
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		result = append(result, string(bytes.TrimSuffix(line, []byte{'\r'})))
+	}
+	return result, nil
+}
+
+// TailTask 持续跟踪文件新增的内容，处理按 inode 轮转和截断的情况
+type TailTask struct {
+	TaskID  string
+	Request *TailFileRequest
+	Status  task.TaskStatus
+	Created time.Time
+	Updated time.Time
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	offset int64
+	lines  int64
+	err    string
+}
+
+// TailResult 是 tail 任务的状态
+type TailResult struct {
+	TaskID string `json:"taskId"`
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"` // 当前读取位置
+	Lines  int64  `json:"lines"`  // 已推送的行数
+	Error  string `json:"error,omitempty"`
+}
+
+func NewTailTask(req *TailFileRequest) *TailTask {
+	return &TailTask{
+		TaskID:  req.TaskID,
+		Request: req,
+		Status:  task.TaskStatusCreated,
+		Created: time.Now(),
+		Updated: time.Now(),
+	}
+}
+
+func (tt *TailTask) GetTaskID() string {
+	return tt.TaskID
+}
+
+func (tt *TailTask) GetType() string {
+	return TaskTypeTail
+}
+
+func (tt *TailTask) GetStatus() task.TaskStatus {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return tt.Status
+}
+
+func (tt *TailTask) GetContent() []byte {
+	return []byte(tt.Request.Path)
+}
+
+func (tt *TailTask) SetStatus(status task.TaskStatus) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.Status = status
+	tt.Updated = time.Now()
+}
+
+func (tt *TailTask) GetResult() interface{} {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return &TailResult{
+		TaskID: tt.TaskID,
+		Path:   tt.Request.Path,
+		Offset: tt.offset,
+		Lines:  tt.lines,
+		Error:  tt.err,
+	}
+}
+
+func (tt *TailTask) Stop() error {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.cancel == nil {
+		return task.ErrTaskNotRunning
+	}
+	tt.Status = task.TaskStatusCanceled
+	tt.cancel()
+	return nil
+}
+
+// Follow 从 offset 开始跟踪文件，每次检查到新增的完整行时调用 fn，直到 ctx 结束或被取消
+func (tt *TailTask) Follow(ctx context.Context, offset int64, fn func(*TailLines)) error {
+	var cancel context.CancelFunc
+	if tt.Request.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(tt.Request.Timeout)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	tt.mu.Lock()
+	tt.Status = task.TaskStatusRunning
+	tt.cancel = cancel
+	tt.offset = offset
+	tt.mu.Unlock()
+
+	err := tt.follow(ctx, offset, fn)
+
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.Updated = time.Now()
+	tt.cancel = nil
+	if tt.Status == task.TaskStatusCanceled {
+		return nil
+	}
+	if err != nil && ctx.Err() == nil {
+		tt.err = err.Error()
+		tt.Status = task.TaskStatusFailed
+		return err
+	}
+	tt.Status = task.TaskStatusCompleted
+	return nil
+}
+
+func (tt *TailTask) follow(ctx context.Context, offset int64, fn func(*TailLines)) error {
+	path := tt.Request.Path
+	interval := time.Duration(tt.Request.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultTailInterval
+	}
+	fm := NewFileManager()
+	if err := fm.CheckPath(PathOpRead, path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	var partial []byte
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		out := &TailLines{TaskID: tt.TaskID, Path: path}
+
+		// 读取所有完整的行，不完整的行留到下一次。没有换行的内容最多保留 maxTailLineLength
+		for len(out.Lines) < maxTailBatchLines {
+			chunk, err := reader.ReadSlice('\n')
+			offset += int64(len(chunk))
+			partial = append(partial, chunk...)
+			if err == bufio.ErrBufferFull {
+				if len(partial) >= maxTailLineLength {
+					out.Lines = append(out.Lines, string(partial))
+					partial = nil
+				}
+				continue
+			}
+			if err != nil {
+				break
+			}
+			out.Lines = append(out.Lines, string(bytes.TrimRight(partial, "\r\n")))
+			partial = nil
+		}
+
+		if len(out.Lines) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+
+			cur, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			info, err := os.Stat(path)
+			switch {
+			case err == nil && !os.SameFile(cur, info) && cur.Size() <= offset:
+				// 文件被轮转：旧文件已经读完，打开新文件从头开始。新文件可能是指向其它位置的符号链接
+				if err := fm.CheckPath(PathOpRead, path); err != nil {
+					return err
+				}
+				nf, err := os.Open(path)
+				if err != nil {
+					continue
+				}
+				f.Close()
+				f = nf
+				reader.Reset(f)
+				if len(partial) > 0 {
+					out.Lines = append(out.Lines, string(partial))
+				}
+				offset, partial = 0, nil
+				out.Rotated = true
+			case cur.Size() < offset:
+				// 文件被截断，从头开始读取
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				reader.Reset(f)
+				offset, partial = 0, nil
+				out.Truncated = true
+			default:
+				continue
+			}
+		}
+
+		out.Offset = offset - int64(len(partial))
+		tt.mu.Lock()
+		tt.offset = out.Offset
+		tt.lines += int64(len(out.Lines))
+		tt.mu.Unlock()
+		fn(out)
+	}
+}
@@ -0,0 +1,162 @@
+package updater
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"updater/pkg/config"
+)
+
+func TestReadFileEncoding(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "text.txt")
+	binary := filepath.Join(dir, "binary.bin")
+	ioutil.WriteFile(text, []byte("hello 世界\n"), 0644)
+	ioutil.WriteFile(binary, []byte{0, 1, 2, 0xff}, 0644)
+
+	fm := NewFileManager()
+	cases := []struct {
+		req      ReadFileRequest
+		encoding string
+		content  string
+	}{
+		{ReadFileRequest{Path: text}, EncodingText, "hello 世界\n"},
+		{ReadFileRequest{Path: binary}, EncodingBase64, base64.StdEncoding.EncodeToString([]byte{0, 1, 2, 0xff})},
+		{ReadFileRequest{Path: text, Encoding: EncodingBase64}, EncodingBase64, base64.StdEncoding.EncodeToString([]byte("hello 世界\n"))},
+		{ReadFileRequest{Path: text, Offset: -4, Length: 3}, EncodingText, "界"},
+	}
+	for _, c := range cases {
+		result, err := fm.ReadFile(&c.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Encoding != c.encoding || result.Content != c.content {
+			t.Errorf("%+v: got %s %q, want %s %q", c.req, result.Encoding, result.Content, c.encoding, c.content)
+		}
+	}
+
+	if _, err := fm.ReadFile(&ReadFileRequest{Path: text, Encoding: "hex"}); err == nil {
+		t.Error("unknown encoding accepted")
+	}
+}
+
+// nextLines 等待下一批推送，超时返回 nil
+func nextLines(ch chan *TailLines) *TailLines {
+	select {
+	case lines := <-ch:
+		return lines
+	case <-time.After(2 * time.Second):
+		return nil
+	}
+}
+
+func TestTailFollow(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(path, []byte("a\nb\npart"), 0644)
+
+	tt := NewTailTask(&TailFileRequest{TaskID: "t1", Path: path, Follow: true, Interval: 10})
+	ch := make(chan *TailLines, 100)
+	errc := make(chan error, 1)
+	go func() { errc <- tt.Follow(context.Background(), 0, func(l *TailLines) { ch <- l }) }()
+
+	if l := nextLines(ch); l == nil || strings.Join(l.Lines, ",") != "a,b" || l.Offset != 4 {
+		t.Fatalf("unexpected lines: %+v", l)
+	}
+
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("ial\n")
+	f.Close()
+	if l := nextLines(ch); l == nil || strings.Join(l.Lines, ",") != "partial" {
+		t.Fatalf("unexpected lines: %+v", l)
+	}
+
+	// 截断后从头开始读取
+	ioutil.WriteFile(path, []byte("c\n"), 0644)
+	if l := nextLines(ch); l == nil || !l.Truncated {
+		t.Fatalf("truncation not detected: %+v", l)
+	}
+	for l := nextLines(ch); ; l = nextLines(ch) {
+		if l == nil {
+			t.Fatal("no lines after truncation")
+		}
+		if strings.Join(l.Lines, ",") == "c" {
+			break
+		}
+	}
+
+	// 轮转后打开新文件
+	os.Rename(path, path+".1")
+	ioutil.WriteFile(path, []byte("d\n"), 0644)
+	l := nextLines(ch)
+	if l == nil || !l.Rotated {
+		t.Fatalf("rotation not detected: %+v", l)
+	}
+	if len(l.Lines) == 0 {
+		l = nextLines(ch)
+	}
+	if l == nil || strings.Join(l.Lines, ",") != "d" {
+		t.Fatalf("unexpected lines after rotation: %+v", l)
+	}
+
+	// 没有换行的内容达到上限后作为一行推送
+	f, _ = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(strings.Repeat("x", maxTailLineLength+1000))
+	f.Close()
+	if l := nextLines(ch); l == nil || len(l.Lines) != 1 || len(l.Lines[0]) < maxTailLineLength || len(l.Lines[0]) > maxTailLineLength+4096 {
+		t.Fatalf("long line not emitted: %+v", l)
+	}
+
+	if err := tt.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailFollowRotatedIntoDeniedPath(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	os.MkdirAll(secret, 0755)
+	ioutil.WriteFile(filepath.Join(secret, "shadow"), []byte("secret\n"), 0644)
+	path := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(path, []byte("a\n"), 0644)
+
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{PathPolicy: config.PathPolicy{
+		Read: config.PathRule{Deny: []string{secret}},
+	}})
+
+	tt := NewTailTask(&TailFileRequest{TaskID: "t1", Path: path, Follow: true, Interval: 10})
+	ch := make(chan *TailLines, 100)
+	errc := make(chan error, 1)
+	go func() { errc <- tt.Follow(context.Background(), 0, func(l *TailLines) { ch <- l }) }()
+	if l := nextLines(ch); l == nil {
+		t.Fatal("no lines")
+	}
+
+	// 轮转出的新文件是指向被禁止读取的文件的符号链接
+	os.Rename(path, path+".1")
+	os.Symlink(filepath.Join(secret, "shadow"), path)
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrPathDenied) {
+			t.Fatalf("err = %v, want denied", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("follow did not stop")
+	}
+	for len(ch) > 0 {
+		if l := <-ch; strings.Contains(strings.Join(l.Lines, ","), "secret") {
+			t.Fatal("denied file was read")
+		}
+	}
+}
@@ -0,0 +1,560 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/logger"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/uuid"
+)
+
+const (
+	WatchOpCreate = "create"
+	WatchOpWrite  = "write"
+	WatchOpRemove = "remove"
+	WatchOpChmod  = "chmod"
+
+	defaultWatchStorePath = ".data/watches.json"
+	defaultWatchLimit     = 32
+	defaultWatchDebounce  = 500 * time.Millisecond
+	defaultWatchInterval  = 5 * time.Second
+	minWatchInterval      = 100 * time.Millisecond
+
+	// 超过这个大小的文件不计算 sha256，只比较大小和修改时间
+	maxWatchHashSize = 64 * 1024 * 1024
+)
+
+var (
+	ErrWatchLimit    = errors.New("watch limit reached")
+	ErrWatchNotFound = errors.New("watch not found")
+)
+
+// WatchRequest 是监听路径的请求参数，同时也是持久化的内容
+type WatchRequest struct {
+	ID       string `json:"id"`       // 监听ID，为空时自动生成
+	Path     string `json:"path"`     // 文件或目录路径，目录只监听直接子项
+	Debounce int    `json:"debounce"` // 合并连续变化的时间（毫秒），0 使用默认值
+	Poll     bool   `json:"poll"`     // 是否强制使用轮询
+	Interval int    `json:"interval"` // 轮询间隔（毫秒），0 使用默认值
+}
+
+// UnwatchRequest 是取消监听的请求参数
+type UnwatchRequest struct {
+	ID string `json:"id"` // 监听ID
+}
+
+// WatchInfo 是监听的状态
+type WatchInfo struct {
+	ID      string `json:"id"`
+	Path    string `json:"path"`
+	Polling bool   `json:"polling"` // 是否使用轮询
+}
+
+// WatchEvent 是推送给服务器的文件变化事件
+type WatchEvent struct {
+	WatchID   string    `json:"watchId"`
+	Path      string    `json:"path"`
+	Op        string    `json:"op"`               // create、write、remove、chmod
+	Sha256    string    `json:"sha256,omitempty"` // 新内容的 sha256，删除或文件过大时为空
+	Size      int64     `json:"size"`
+	Mode      string    `json:"mode,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// fileState 是文件上一次检查时的状态，用于判断变化类型
+type fileState struct {
+	exists  bool
+	isDir   bool
+	sha256  string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+type watch struct {
+	req      WatchRequest
+	path     string // 绝对路径
+	isDir    bool
+	polling  bool
+	lastPoll time.Time
+	states   map[string]fileState
+	timers   map[string]*time.Timer
+}
+
+// FileWatcher 监听文件变化并推送事件。优先使用 inotify，不可用时退回到轮询
+type FileWatcher struct {
+	mu        sync.Mutex
+	watches   map[string]*watch
+	dirs      map[string]int // inotify 监听的目录和引用计数
+	fsw       *fsnotify.Watcher
+	notify    func(*WatchEvent)
+	storePath string
+	limit     int
+	fm        *FileManager
+	logger    *logger.Logger
+	done      chan struct{}
+}
+
+// NewFileWatcher 创建文件监听器，持久化路径和数量限制来自配置
+func NewFileWatcher(app *app.App) *FileWatcher {
+	storePath, limit := app.Config.WatchStorePath, app.Config.WatchLimit
+	if storePath == "" {
+		storePath = defaultWatchStorePath
+	}
+	if limit <= 0 {
+		limit = defaultWatchLimit
+	}
+	return &FileWatcher{
+		watches:   make(map[string]*watch),
+		dirs:      make(map[string]int),
+		storePath: storePath,
+		limit:     limit,
+		fm:        NewFileManager(),
+		logger:    app.Logger,
+		done:      make(chan struct{}),
+	}
+}
+
+// Start 恢复持久化的监听并开始推送事件
+func (fw *FileWatcher) Start(notify func(*WatchEvent)) error {
+	fw.mu.Lock()
+	fw.notify = notify
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		fw.logger.Println("inotify is not available, fallback to polling:", err)
+	} else {
+		fw.fsw = fsw
+		go fw.eventLoop(fsw)
+	}
+	fw.mu.Unlock()
+	go fw.pollLoop()
+
+	reqs, err := fw.load()
+	if err != nil {
+		return err
+	}
+	for i := range reqs {
+		if _, err := fw.Watch(&reqs[i]); err != nil {
+			fw.logger.Println("restore watch failed:", reqs[i].Path, err)
+		}
+	}
+	return nil
+}
+
+// SetLimit 修改监听的数量上限，已有的监听不受影响
+func (fw *FileWatcher) SetLimit(limit int) {
+	if limit <= 0 {
+		limit = defaultWatchLimit
+	}
+	fw.mu.Lock()
+	fw.limit = limit
+	fw.mu.Unlock()
+}
+
+// Stop 停止所有监听，持久化的内容保持不变
+func (fw *FileWatcher) Stop() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	select {
+	case <-fw.done:
+		return
+	default:
+	}
+	close(fw.done)
+	for _, w := range fw.watches {
+		for _, t := range w.timers {
+			t.Stop()
+		}
+	}
+	if fw.fsw != nil {
+		fw.fsw.Close()
+	}
+}
+
+// Watch 添加一个监听
+func (fw *FileWatcher) Watch(req *WatchRequest) (*WatchInfo, error) {
+	if req.Path == "" {
+		return nil, errors.New("path is empty")
+	}
+	if err := fw.fm.CheckPath(PathOpRead, req.Path); err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watch{
+		req:    *req,
+		path:   abs,
+		states: make(map[string]fileState),
+		timers: make(map[string]*time.Timer),
+	}
+	if w.req.ID == "" {
+		w.req.ID = uuid.New().String()
+	}
+
+	// 文件可以暂时不存在，但所在目录必须存在，这样才能收到创建事件
+	info, err := os.Stat(abs)
+	switch {
+	case err == nil:
+		w.isDir = info.IsDir()
+	case os.IsNotExist(err):
+		if _, err := os.Stat(filepath.Dir(abs)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	w.snapshot()
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if old, ok := fw.watches[w.req.ID]; ok {
+		fw.remove(old)
+	} else if len(fw.watches) >= fw.limit {
+		return nil, fmt.Errorf("%w: %d", ErrWatchLimit, fw.limit)
+	}
+
+	w.polling = w.req.Poll || fw.fsw == nil
+	if !w.polling {
+		if err := fw.addDir(w.dir()); err != nil {
+			fw.logger.Println("inotify watch failed, fallback to polling:", abs, err)
+			w.polling = true
+		}
+	}
+	w.lastPoll = time.Now()
+	fw.watches[w.req.ID] = w
+
+	if err := fw.save(); err != nil {
+		fw.remove(w)
+		return nil, err
+	}
+	return w.info(), nil
+}
+
+// Unwatch 取消一个监听
+func (fw *FileWatcher) Unwatch(id string) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	w, ok := fw.watches[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrWatchNotFound, id)
+	}
+	fw.remove(w)
+	return fw.save()
+}
+
+// List 返回所有监听
+func (fw *FileWatcher) List() []*WatchInfo {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	list := make([]*WatchInfo, 0, len(fw.watches))
+	for _, w := range fw.watches {
+		list = append(list, w.info())
+	}
+	return list
+}
+
+// remove 删除监听并释放 inotify 目录，调用者需要持有锁
+func (fw *FileWatcher) remove(w *watch) {
+	delete(fw.watches, w.req.ID)
+	for _, t := range w.timers {
+		t.Stop()
+	}
+	if w.polling {
+		return
+	}
+	dir := w.dir()
+	if fw.dirs[dir]--; fw.dirs[dir] <= 0 {
+		delete(fw.dirs, dir)
+		fw.fsw.Remove(dir)
+	}
+}
+
+func (fw *FileWatcher) addDir(dir string) error {
+	if fw.dirs[dir] == 0 {
+		if err := fw.fsw.Add(dir); err != nil {
+			return err
+		}
+	}
+	fw.dirs[dir]++
+	return nil
+}
+
+func (fw *FileWatcher) eventLoop(fsw *fsnotify.Watcher) {
+	for {
+		select {
+		case ev, ok := <-fsw.Events:
+			if !ok {
+				return
+			}
+			fw.handleEvent(ev)
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return
+			}
+			fw.logger.Println("inotify error:", err)
+		}
+	}
+}
+
+func (fw *FileWatcher) handleEvent(ev fsnotify.Event) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	name := filepath.Clean(ev.Name)
+	for _, w := range fw.watches {
+		if w.polling {
+			continue
+		}
+		// 监听的目录本身被删除或改名后 inotify 不再有效，改为轮询
+		if name == w.dir() && (ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename)) {
+			fw.dirs[name]--
+			w.polling = true
+			continue
+		}
+		if w.matches(name) {
+			fw.schedule(w, name)
+		}
+	}
+	if fw.dirs[name] <= 0 {
+		delete(fw.dirs, name)
+	}
+}
+
+// schedule 在 debounce 时间内合并同一路径的多次变化，调用者需要持有锁
+func (fw *FileWatcher) schedule(w *watch, name string) {
+	if t, ok := w.timers[name]; ok {
+		t.Reset(w.debounce())
+		return
+	}
+	w.timers[name] = time.AfterFunc(w.debounce(), func() {
+		fw.mu.Lock()
+		delete(w.timers, name)
+		fw.mu.Unlock()
+		fw.check(w, name)
+	})
+}
+
+func (fw *FileWatcher) pollLoop() {
+	ticker := time.NewTicker(minWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fw.done:
+			return
+		case now := <-ticker.C:
+			fw.mu.Lock()
+			var due []*watch
+			for _, w := range fw.watches {
+				if w.polling && now.Sub(w.lastPoll) >= w.interval() {
+					w.lastPoll = now
+					due = append(due, w)
+				}
+			}
+			fw.mu.Unlock()
+
+			for _, w := range due {
+				for _, name := range w.names() {
+					fw.check(w, name)
+				}
+			}
+		}
+	}
+}
+
+// check 比较路径当前状态和上一次的状态，有变化时推送事件
+func (fw *FileWatcher) check(w *watch, name string) {
+	cur := statFile(name)
+
+	fw.mu.Lock()
+	if fw.watches[w.req.ID] != w {
+		// 已经取消监听
+		fw.mu.Unlock()
+		return
+	}
+	prev := w.states[name]
+	if cur.exists {
+		w.states[name] = cur
+	} else {
+		delete(w.states, name)
+	}
+	notify := fw.notify
+	fw.mu.Unlock()
+
+	op := diffState(prev, cur)
+	if op == "" || notify == nil {
+		return
+	}
+	ev := &WatchEvent{
+		WatchID:   w.req.ID,
+		Path:      name,
+		Op:        op,
+		Sha256:    cur.sha256,
+		Size:      cur.size,
+		Timestamp: time.Now(),
+	}
+	if cur.exists {
+		ev.Mode = cur.mode.String()
+	}
+	notify(ev)
+}
+
+// diffState 根据前后状态判断变化类型，没有变化时返回空字符串
+func diffState(prev, cur fileState) string {
+	switch {
+	case !prev.exists && !cur.exists:
+		return ""
+	case !prev.exists:
+		return WatchOpCreate
+	case !cur.exists:
+		return WatchOpRemove
+	case prev.isDir != cur.isDir:
+		return WatchOpCreate
+	case cur.isDir:
+		if prev.mode != cur.mode {
+			return WatchOpChmod
+		}
+		return ""
+	case prev.sha256 != cur.sha256:
+		return WatchOpWrite
+	case prev.sha256 == "" && (prev.size != cur.size || !prev.modTime.Equal(cur.modTime)):
+		return WatchOpWrite
+	case prev.mode != cur.mode:
+		return WatchOpChmod
+	}
+	return ""
+}
+
+func statFile(name string) fileState {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fileState{}
+	}
+	st := fileState{
+		exists:  true,
+		isDir:   info.IsDir(),
+		size:    info.Size(),
+		mode:    info.Mode(),
+		modTime: info.ModTime(),
+	}
+	if info.Mode().IsRegular() && info.Size() <= maxWatchHashSize {
+		st.sha256, _ = fileSha256(name)
+	}
+	return st
+}
+
+func (w *watch) info() *WatchInfo {
+	return &WatchInfo{ID: w.req.ID, Path: w.req.Path, Polling: w.polling}
+}
+
+// dir 返回 inotify 需要监听的目录：目录本身或文件所在目录
+func (w *watch) dir() string {
+	if w.isDir {
+		return w.path
+	}
+	return filepath.Dir(w.path)
+}
+
+// matches 判断事件路径是否属于这个监听
+func (w *watch) matches(name string) bool {
+	if w.isDir {
+		return filepath.Dir(name) == w.path
+	}
+	return name == w.path
+}
+
+// names 返回轮询时需要检查的路径，包括已经被删除的子项
+func (w *watch) names() []string {
+	if !w.isDir {
+		return []string{w.path}
+	}
+	seen := make(map[string]bool)
+	var names []string
+	if entries, err := ioutil.ReadDir(w.path); err == nil {
+		for _, e := range entries {
+			name := filepath.Join(w.path, e.Name())
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for name := range w.states {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// snapshot 记录初始状态，之后只推送相对初始状态的变化
+func (w *watch) snapshot() {
+	if !w.isDir {
+		if st := statFile(w.path); st.exists {
+			w.states[w.path] = st
+		}
+		return
+	}
+	for _, name := range w.names() {
+		if st := statFile(name); st.exists {
+			w.states[name] = st
+		}
+	}
+}
+
+func (w *watch) debounce() time.Duration {
+	if w.req.Debounce > 0 {
+		return time.Duration(w.req.Debounce) * time.Millisecond
+	}
+	return defaultWatchDebounce
+}
+
+func (w *watch) interval() time.Duration {
+	d := time.Duration(w.req.Interval) * time.Millisecond
+	if d <= 0 {
+		return defaultWatchInterval
+	}
+	if d < minWatchInterval {
+		return minWatchInterval
+	}
+	return d
+}
+
+func (fw *FileWatcher) load() ([]WatchRequest, error) {
+	b, err := ioutil.ReadFile(fw.storePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var reqs []WatchRequest
+	if err := json.Unmarshal(b, &reqs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", fw.storePath, err)
+	}
+	return reqs, nil
+}
+
+// save 持久化所有监听，调用者需要持有锁
+func (fw *FileWatcher) save() error {
+	reqs := make([]WatchRequest, 0, len(fw.watches))
+	for _, w := range fw.watches {
+		reqs = append(reqs, w.req)
+	}
+	b, err := json.MarshalIndent(reqs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fw.storePath), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(fw.storePath, b, 0644)
+}
@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func newTestWatcher(t *testing.T, store string, limit int) (*FileWatcher, chan *WatchEvent) {
+	fw := NewFileWatcher(&app.App{
+		Logger: &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+		Config: &config.Config{WatchStorePath: store, WatchLimit: limit},
+	})
+	events := make(chan *WatchEvent, 16)
+	if err := fw.Start(func(ev *WatchEvent) { events <- ev }); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fw.Stop)
+	return fw, events
+}
+
+func waitEvent(t *testing.T, events chan *WatchEvent) *WatchEvent {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for watch event")
+	}
+	return nil
+}
+
+func TestFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "store", "watches.json")
+	file := filepath.Join(dir, "hosts")
+
+	for _, poll := range []bool{false, true} {
+		fw, events := newTestWatcher(t, store, 2)
+		info, err := fw.Watch(&WatchRequest{ID: "hosts", Path: file, Debounce: 50, Poll: poll, Interval: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Polling != poll {
+			t.Fatalf("polling = %v, want %v", info.Polling, poll)
+		}
+
+		ioutil.WriteFile(file, []byte("127.0.0.1 localhost\n"), 0644)
+		if ev := waitEvent(t, events); ev.Op != WatchOpCreate || ev.Sha256 == "" {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+
+		// 连续多次写入只推送一次事件
+		for i := 0; i < 3; i++ {
+			ioutil.WriteFile(file, []byte("127.0.0.1 localhost\n::1 localhost\n"[:20+i]), 0644)
+		}
+		ev := waitEvent(t, events)
+		if ev.Op != WatchOpWrite || ev.Size != 22 {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+		select {
+		case ev := <-events:
+			t.Fatalf("unexpected extra event: %+v", ev)
+		case <-time.After(300 * time.Millisecond):
+		}
+
+		if err := fw.Unwatch("hosts"); err != nil {
+			t.Fatal(err)
+		}
+		fw.Stop()
+		// 下一轮从不存在的文件开始
+		os.Remove(file)
+	}
+}
+
+func TestFileWatcherPersistAndLimit(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "watches.json")
+
+	fw, _ := newTestWatcher(t, store, 2)
+	for _, id := range []string{"a", "b"} {
+		if _, err := fw.Watch(&WatchRequest{ID: id, Path: filepath.Join(dir, id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fw.Watch(&WatchRequest{ID: "c", Path: filepath.Join(dir, "c")}); !errors.Is(err, ErrWatchLimit) {
+		t.Fatalf("expected ErrWatchLimit, got %v", err)
+	}
+	// 相同的 ID 替换原来的监听，不占用新的名额
+	if _, err := fw.Watch(&WatchRequest{ID: "a", Path: filepath.Join(dir, "a2")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Unwatch("b"); err != nil {
+		t.Fatal(err)
+	}
+	fw.Stop()
+
+	restored, _ := newTestWatcher(t, store, 2)
+	list := restored.List()
+	if len(list) != 1 || list[0].ID != "a" || list[0].Path != filepath.Join(dir, "a2") {
+		t.Fatalf("unexpected restored watches: %+v", list)
+	}
+	if err := restored.Unwatch("b"); !errors.Is(err, ErrWatchNotFound) {
+		t.Fatalf("expected ErrWatchNotFound, got %v", err)
+	}
+}
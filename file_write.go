@@ -0,0 +1,334 @@
+package updater
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/config"
+)
+
+const defaultBackupDir = ".data/backups"
+
+var ErrContentMismatch = errors.New("current file content does not match expected sha256")
+
+// WriteFileRequest 是写文件请求参数
+type WriteFileRequest struct {
+	Path           string `json:"path"`           // 文件路径
+	Content        string `json:"content"`        // 文件内容
+	Encoding       string `json:"encoding"`       // 内容编码：text、base64，默认 text
+	Mode           string `json:"mode"`           // 文件权限，八进制字符串，例如 "0644"，为空时保留原权限
+	Owner          string `json:"owner"`          // 文件属主，"user" 或 "user:group"
+	Atomic         bool   `json:"atomic"`         // 是否先写临时文件再改名
+	ExpectedSha256 string `json:"expectedSha256"` // 当前内容的 sha256，不一致时拒绝修改
+	AutoCreateDir  bool   `json:"autoCreateDir"`  // 是否自动创建文件夹
+
+	HealthCheck *HealthCheckSpec `json:"healthCheck"` // 写入后的健康检查，失败时用备份恢复
+}
+
+// PatchFileRequest 是 patch 请求参数
+type PatchFileRequest struct {
+	Path           string `json:"path"`           // 文件路径
+	Patch          string `json:"patch"`          // unified diff
+	Atomic         bool   `json:"atomic"`         // 是否先写临时文件再改名
+	ExpectedSha256 string `json:"expectedSha256"` // 当前内容的 sha256，不一致时拒绝修改
+
+	HealthCheck *HealthCheckSpec `json:"healthCheck"` // 修改后的健康检查，失败时用备份恢复
+}
+
+// RestoreFileRequest 是从备份恢复文件的请求参数
+type RestoreFileRequest struct {
+	Path       string `json:"path"`       // 要恢复的文件路径
+	BackupPath string `json:"backupPath"` // 备份文件路径
+}
+
+// WriteFileResult 是写文件的结果
+type WriteFileResult struct {
+	Path           string `json:"path"`
+	Size           int64  `json:"size"`
+	Sha256         string `json:"sha256"`         // 新内容的 sha256
+	PreviousSha256 string `json:"previousSha256"` // 修改前内容的 sha256，文件不存在时为空
+	BackupPath     string `json:"backupPath"`     // 修改前内容的备份，文件不存在时为空
+	Mode           string `json:"mode"`
+
+	HealthChecks []*HealthCheckResult `json:"healthChecks,omitempty"`
+	Restored     bool                 `json:"restored,omitempty"` // 健康检查失败后已经恢复
+}
+
+// fileLocks 保证同一个文件的读-校验-写过程不会交错
+var fileLocks sync.Map
+
+func lockFile(path string) func() {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	v, _ := fileLocks.LoadOrStore(abs, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// WriteFile 写入文件内容，修改前备份原文件
+func (fm *FileManager) WriteFile(req *WriteFileRequest) (*WriteFileResult, error) {
+	var content []byte
+	switch req.Encoding {
+	case "", EncodingText:
+		content = []byte(req.Content)
+	case EncodingBase64:
+		var err error
+		if content, err = base64.StdEncoding.DecodeString(req.Content); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding: %s", req.Encoding)
+	}
+
+	if req.AutoCreateDir {
+		if err := fm.CheckPath(PathOpWrite, req.Path); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(req.Path), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return fm.modifyFile(req.Path, req.ExpectedSha256, req.Atomic, req.Mode, req.Owner, func([]byte) ([]byte, error) {
+		return content, nil
+	})
+}
+
+// PatchFile 将 unified diff 应用到文件上，修改前备份原文件
+func (fm *FileManager) PatchFile(req *PatchFileRequest) (*WriteFileResult, error) {
+	return fm.modifyFile(req.Path, req.ExpectedSha256, req.Atomic, "", "", func(orig []byte) ([]byte, error) {
+		return applyUnifiedDiff(orig, req.Patch)
+	})
+}
+
+// RestoreFile 用备份覆盖文件
+func (fm *FileManager) RestoreFile(req *RestoreFileRequest) (*WriteFileResult, error) {
+	if err := fm.CheckPath(PathOpRead, req.BackupPath); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(req.BackupPath)
+	if err != nil {
+		return nil, err
+	}
+	return fm.modifyFile(req.Path, "", true, "", "", func([]byte) ([]byte, error) {
+		return content, nil
+	})
+}
+
+// VerifyWrite 执行写入后的健康检查，失败时用备份恢复文件，原来不存在的文件直接删除
+func (fm *FileManager) VerifyWrite(ctx *Context, result *WriteFileResult, spec *HealthCheckSpec) error {
+	checks, err := RunHealthChecks(ctx, spec)
+	result.HealthChecks = checks
+	if err == nil {
+		return nil
+	}
+
+	ctx.Logger.Println("health check failed, restore", result.Path, "from", result.BackupPath)
+	if result.BackupPath == "" {
+		if rerr := fm.DeleteFile(result.Path); rerr != nil {
+			return fmt.Errorf("%w, remove failed: %s", err, rerr)
+		}
+	} else if _, rerr := fm.RestoreFile(&RestoreFileRequest{Path: result.Path, BackupPath: result.BackupPath}); rerr != nil {
+		return fmt.Errorf("%w, restore failed: %s", err, rerr)
+	}
+	result.Restored = true
+	return err
+}
+
+// modifyFile 校验当前内容、备份、写入新内容并设置权限和属主
+func (fm *FileManager) modifyFile(path, expected string, atomic bool, mode, owner string, change func([]byte) ([]byte, error)) (*WriteFileResult, error) {
+	if err := fm.CheckPath(PathOpWrite, path); err != nil {
+		return nil, err
+	}
+
+	unlock := lockFile(path)
+	defer unlock()
+
+	result := &WriteFileResult{Path: path}
+
+	perm := os.FileMode(0644)
+	var origInfo os.FileInfo
+	orig, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		sum := sha256.Sum256(orig)
+		result.PreviousSha256 = hex.EncodeToString(sum[:])
+		if origInfo, err = os.Stat(path); err == nil {
+			perm = origInfo.Mode().Perm()
+		}
+	case os.IsNotExist(err):
+		orig = nil
+	default:
+		return nil, err
+	}
+
+	if expected != "" && !strings.EqualFold(expected, result.PreviousSha256) {
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrContentMismatch, expected, result.PreviousSha256)
+	}
+
+	content, err := change(orig)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode: %s", mode)
+		}
+		perm = os.FileMode(m)
+	}
+
+	if orig != nil {
+		backup, err := backupPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := fm.BackupFile(path, backup); err != nil {
+			return nil, err
+		}
+		result.BackupPath = backup
+	}
+
+	if atomic {
+		err = writeFileAtomic(path, content, perm)
+	} else {
+		err = ioutil.WriteFile(path, content, perm)
+		if err == nil {
+			// WriteFile 不会修改已存在文件的权限
+			err = os.Chmod(path, perm)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if owner != "" {
+		if err := chownFile(path, owner); err != nil {
+			return nil, err
+		}
+	} else if atomic && origInfo != nil {
+		// 改名替换会产生新文件，尽量保留原来的属主，没有权限时忽略
+		if uid, gid, ok := fileOwner(origInfo); ok {
+			os.Chown(path, uid, gid)
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	result.Sha256 = hex.EncodeToString(sum[:])
+	result.Size = int64(len(content))
+	result.Mode = perm.String()
+	return result, nil
+}
+
+func backupDir() string {
+	if cfg := config.GetConfig(); cfg != nil && cfg.BackupDir != "" {
+		return cfg.BackupDir
+	}
+	return defaultBackupDir
+}
+
+// isBackupPath 判断路径是否在备份目录中
+func isBackupPath(path string) bool {
+	dir, err := filepath.Abs(backupDir())
+	if err != nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return isWithin(dir, abs)
+}
+
+// backupPath 返回文件的备份路径：<backupDir>/<原路径>.<时间戳>
+func backupPath(path string) (string, error) {
+	dir := backupDir()
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	abs = strings.TrimPrefix(abs, filepath.VolumeName(abs))
+
+	backup := filepath.Join(dir, abs+"."+time.Now().Format("20060102150405.000000000"))
+	if err := os.MkdirAll(filepath.Dir(backup), 0700); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// writeFileAtomic 在同一目录下写临时文件并同步到磁盘，然后改名覆盖目标文件
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.ReadFrom(bytes.NewReader(content)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// chownFile 修改文件属主，owner 为 "user" 或 "user:group"，也可以是数字 id
+func chownFile(path, owner string) error {
+	name, group := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		name, group = owner[:i], owner[i+1:]
+	}
+
+	uid, gid := -1, -1
+	if name != "" {
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return err
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return err
+			}
+		}
+		uid = id
+	}
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return err
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return err
+			}
+		}
+		gid = id
+	}
+	return os.Chown(path, uid, gid)
+}
@@ -0,0 +1,84 @@
+package updater
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"updater/pkg/config"
+)
+
+func TestApplyUnifiedDiff(t *testing.T) {
+	orig := "a\nb\nc\nd\ne\nf\n"
+	// 行号故意偏移一行，验证可以按上下文定位
+	patch := `--- a/file
++++ b/file
+@@ -3,3 +3,3 @@
+ b
+-c
++C
+ d
+@@ -6,1 +6,2 @@
+ f
++g
+\ No newline at end of file
+`
+	got, err := applyUnifiedDiff([]byte(orig), patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\nb\nC\nd\ne\nf\ng"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := applyUnifiedDiff([]byte("x\ny\n"), patch); !errors.Is(err, ErrPatchConflict) {
+		t.Errorf("err = %v, want ErrPatchConflict", err)
+	}
+}
+
+func TestWriteFileExpectedSha256(t *testing.T) {
+	dir := t.TempDir()
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{BackupDir: filepath.Join(dir, "backups")})
+
+	path := filepath.Join(dir, "app.conf")
+	fm := NewFileManager()
+	first, err := fm.WriteFile(&WriteFileRequest{Path: path, Content: "v1\n", Mode: "0600"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 使用过期的 sha256 修改时应该被拒绝
+	_, err = fm.WriteFile(&WriteFileRequest{Path: path, Content: "v2\n", ExpectedSha256: "deadbeef", Atomic: true})
+	if !errors.Is(err, ErrContentMismatch) {
+		t.Fatalf("err = %v, want ErrContentMismatch", err)
+	}
+
+	second, err := fm.PatchFile(&PatchFileRequest{
+		Path:           path,
+		Patch:          "@@ -1 +1 @@\n-v1\n+v2\n",
+		ExpectedSha256: first.Sha256,
+		Atomic:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "v2\n" {
+		t.Errorf("content = %q, want v2", b)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	if b, _ := ioutil.ReadFile(second.BackupPath); string(b) != "v1\n" {
+		t.Errorf("backup = %q, want v1", b)
+	}
+
+	if _, err := fm.RestoreFile(&RestoreFileRequest{Path: path, BackupPath: second.BackupPath}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "v1\n" {
+		t.Errorf("restored content = %q, want v1", b)
+	}
+}
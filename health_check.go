@@ -0,0 +1,234 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	HealthCheckHTTP    = "http"
+	HealthCheckTCP     = "tcp"
+	HealthCheckCommand = "command"
+
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultHealthCheckInterval = 2 * time.Second
+	defaultHealthCheckDeadline = 60 * time.Second
+	maxHealthCheckOutput       = 4 * 1024
+)
+
+var ErrHealthCheckFailed = errors.New("health check failed")
+
+// HealthCheck 是一项健康检查
+type HealthCheck struct {
+	Name           string `json:"name"`           // 名称，为空时使用类型和目标
+	Type           string `json:"type"`           // 类型：http、tcp、command
+	URL            string `json:"url"`            // http：请求地址，使用 GET
+	ExpectStatus   int    `json:"expectStatus"`   // http：期望的状态码，0 表示任意 2xx
+	BodyMatch      string `json:"bodyMatch"`      // http：响应内容需要匹配的正则表达式
+	Address        string `json:"address"`        // tcp：host:port
+	Command        string `json:"command"`        // command：脚本内容
+	ExpectExitCode int    `json:"expectExitCode"` // command：期望的退出码
+	Timeout        int    `json:"timeout"`        // 每次检查的超时时间（秒）
+	Retries        int    `json:"retries"`        // 失败后的重试次数
+	Interval       int    `json:"interval"`       // 重试间隔（秒）
+}
+
+// HealthCheckSpec 是部署后需要通过的健康检查
+type HealthCheckSpec struct {
+	Checks   []HealthCheck `json:"checks"`
+	Deadline int           `json:"deadline"` // 所有检查的总时限（秒），0 使用默认值
+}
+
+// HealthCheckResult 是一项健康检查的结果
+type HealthCheckResult struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Passed   bool   `json:"passed"`
+	Attempts int    `json:"attempts"`
+	Output   string `json:"output"` // 最后一次检查的输出
+	Error    string `json:"error,omitempty"`
+}
+
+// RunHealthChecks 依次执行所有检查，每项检查失败后按间隔重试，直到成功、重试用完或超过总时限。
+// 有检查失败时返回 ErrHealthCheckFailed，结果中包含每项检查的输出
+func RunHealthChecks(ctx *Context, spec *HealthCheckSpec) ([]*HealthCheckResult, error) {
+	if spec == nil || len(spec.Checks) == 0 {
+		return nil, nil
+	}
+	deadline := time.Duration(spec.Deadline) * time.Second
+	if deadline <= 0 {
+		deadline = defaultHealthCheckDeadline
+	}
+	c, cancel := context.WithTimeout(ctx.Ctx, deadline)
+	defer cancel()
+
+	var results []*HealthCheckResult
+	for i := range spec.Checks {
+		hc := &spec.Checks[i]
+		r := runHealthCheck(c, ctx, hc)
+		results = append(results, r)
+		ctx.Logger.Println("health check:", r.Name, "passed:", r.Passed, "attempts:", r.Attempts, "output:", r.Output, r.Error)
+		if !r.Passed {
+			return results, fmt.Errorf("%w: %s: %s", ErrHealthCheckFailed, r.Name, r.Error)
+		}
+	}
+	return results, nil
+}
+
+func runHealthCheck(c context.Context, ctx *Context, hc *HealthCheck) *HealthCheckResult {
+	r := &HealthCheckResult{Name: hc.name(), Type: hc.Type}
+	interval := time.Duration(hc.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	for attempt := 0; attempt <= hc.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-c.Done():
+				r.Error = "deadline exceeded: " + r.Error
+				return r
+			case <-time.After(interval):
+			}
+		}
+
+		r.Attempts++
+		output, err := hc.run(c, ctx)
+		r.Output = truncateOutput(output)
+		if err == nil {
+			r.Passed = true
+			r.Error = ""
+			return r
+		}
+		r.Error = err.Error()
+		if c.Err() != nil {
+			r.Error = "deadline exceeded: " + r.Error
+			return r
+		}
+	}
+	return r
+}
+
+func (hc *HealthCheck) name() string {
+	if hc.Name != "" {
+		return hc.Name
+	}
+	switch hc.Type {
+	case HealthCheckHTTP:
+		return "http " + hc.URL
+	case HealthCheckTCP:
+		return "tcp " + hc.Address
+	}
+	return hc.Type
+}
+
+func (hc *HealthCheck) timeout() time.Duration {
+	if hc.Timeout > 0 {
+		return time.Duration(hc.Timeout) * time.Second
+	}
+	return defaultHealthCheckTimeout
+}
+
+// run 执行一次检查，返回输出和失败原因
+func (hc *HealthCheck) run(c context.Context, ctx *Context) (string, error) {
+	c, cancel := context.WithTimeout(c, hc.timeout())
+	defer cancel()
+
+	switch hc.Type {
+	case HealthCheckHTTP:
+		return hc.checkHTTP(c)
+	case HealthCheckTCP:
+		return hc.checkTCP(c)
+	case HealthCheckCommand:
+		return hc.checkCommand(c, ctx)
+	}
+	return "", fmt.Errorf("unknown health check type: %s", hc.Type)
+}
+
+func (hc *HealthCheck) checkHTTP(c context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(c, http.MethodGet, hc.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthCheckOutput))
+	output := resp.Status + "\n" + string(body)
+	if err != nil {
+		return output, err
+	}
+
+	if hc.ExpectStatus != 0 && resp.StatusCode != hc.ExpectStatus {
+		return output, fmt.Errorf("status %d, expected %d", resp.StatusCode, hc.ExpectStatus)
+	}
+	if hc.ExpectStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return output, fmt.Errorf("status %d, expected 2xx", resp.StatusCode)
+	}
+	if hc.BodyMatch != "" {
+		re, err := regexp.Compile(hc.BodyMatch)
+		if err != nil {
+			return output, err
+		}
+		if !re.Match(body) {
+			return output, fmt.Errorf("body does not match %q", hc.BodyMatch)
+		}
+	}
+	return output, nil
+}
+
+func (hc *HealthCheck) checkTCP(c context.Context) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(c, "tcp", hc.Address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return "connected to " + conn.RemoteAddr().String(), nil
+}
+
+// checkCommand 通过 ScriptTask 执行检查脚本，超时时间不超过剩余的总时限
+func (hc *HealthCheck) checkCommand(c context.Context, ctx *Context) (string, error) {
+	timeout := hc.timeout()
+	if d, ok := c.Deadline(); ok && time.Until(d) < timeout {
+		timeout = time.Until(d)
+	}
+	if timeout < time.Second {
+		timeout = time.Second
+	}
+
+	st := NewScriptTask(&ScriptTaskRequest{
+		TaskID:  "health-check",
+		Type:    HealthCheckCommand,
+		Content: hc.Command,
+		Timeout: int(timeout / time.Second),
+	})
+	st.Run(ctx)
+
+	r := st.ScriptResult
+	output := strings.TrimSpace(r.Stdout + r.Stderr)
+	if r.Code != CodeSuccess {
+		return output, fmt.Errorf("%s: %s", r.Code, r.Error)
+	}
+	if r.ExitCode != hc.ExpectExitCode {
+		return output, fmt.Errorf("exit code %d, expected %d", r.ExitCode, hc.ExpectExitCode)
+	}
+	return output, nil
+}
+
+func truncateOutput(s string) string {
+	if len(s) > maxHealthCheckOutput {
+		return s[:maxHealthCheckOutput] + "..."
+	}
+	return s
+}
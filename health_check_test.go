@@ -0,0 +1,106 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func newTestContext() *Context {
+	return &Context{Ctx: context.Background(), Logger: &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}}
+}
+
+func TestRunHealthChecks(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 前两次请求返回 503，模拟服务正在启动
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":"ok","version":"2.0.0"}`))
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	ctx := newTestContext()
+	results, err := RunHealthChecks(ctx, &HealthCheckSpec{
+		Checks: []HealthCheck{
+			{Type: HealthCheckHTTP, URL: srv.URL, BodyMatch: `"version":"2\.0\.0"`, Retries: 3, Interval: 1},
+			{Type: HealthCheckTCP, Address: addr},
+			{Type: HealthCheckCommand, Command: "echo ready; exit 3", ExpectExitCode: 3},
+		},
+		Deadline: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].Attempts != 3 || results[2].Output != "ready" {
+		t.Fatalf("unexpected results: %+v %+v %+v", results[0], results[1], results[2])
+	}
+
+	cases := []HealthCheck{
+		{Type: HealthCheckHTTP, URL: srv.URL, ExpectStatus: http.StatusNoContent},
+		{Type: HealthCheckHTTP, URL: srv.URL, BodyMatch: "degraded"},
+		{Type: HealthCheckCommand, Command: "echo broken >&2; exit 1"},
+	}
+	for _, hc := range cases {
+		results, err := RunHealthChecks(ctx, &HealthCheckSpec{Checks: []HealthCheck{hc}})
+		if !errors.Is(err, ErrHealthCheckFailed) {
+			t.Fatalf("%s: expected ErrHealthCheckFailed, got %v", hc.name(), err)
+		}
+		if results[0].Passed || results[0].Output == "" {
+			t.Fatalf("%s: unexpected result: %+v", hc.name(), results[0])
+		}
+	}
+
+	// 总时限先于重试次数用完
+	srv.Close()
+	start := time.Now()
+	results, err = RunHealthChecks(ctx, &HealthCheckSpec{
+		Checks:   []HealthCheck{{Type: HealthCheckTCP, Address: addr, Retries: 100, Interval: 1}},
+		Deadline: 2,
+	})
+	if !errors.Is(err, ErrHealthCheckFailed) || time.Since(start) > 5*time.Second {
+		t.Fatalf("expected deadline failure, got %v after %v", err, time.Since(start))
+	}
+	if results[0].Attempts >= 100 {
+		t.Fatalf("retries were not limited by deadline: %+v", results[0])
+	}
+}
+
+func TestVerifyWriteRestoresBackup(t *testing.T) {
+	dir := t.TempDir()
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{BackupDir: filepath.Join(dir, "backups")})
+
+	path := filepath.Join(dir, "app.conf")
+	os.WriteFile(path, []byte("port=80\n"), 0644)
+
+	fm := NewFileManager()
+	result, err := fm.WriteFile(&WriteFileRequest{Path: path, Content: "port=broken\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fm.VerifyWrite(newTestContext(), result, &HealthCheckSpec{
+		Checks: []HealthCheck{{Type: HealthCheckCommand, Command: "grep -q 'port=[0-9]' " + path}},
+	})
+	if !errors.Is(err, ErrHealthCheckFailed) || !result.Restored {
+		t.Fatalf("expected restore after failed check, got %v %+v", err, result)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "port=80\n" {
+		t.Fatalf("content = %q after restore", b)
+	}
+}
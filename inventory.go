@@ -0,0 +1,338 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/app"
+)
+
+const (
+	defaultInventoryStorePath = ".data/inventory.json"
+	inventoryCommandTimeout   = 2 * time.Minute
+
+	rpmQueryFormat = `%{NAME}\t%{EPOCH}:%{VERSION}-%{RELEASE}\t%{ARCH}\t%{SOURCERPM}\n`
+)
+
+var ErrInventoryUnsupported = errors.New("no package database found")
+
+// SystemPackage 是系统包管理器中已安装的一个软件包
+type SystemPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+	Source  string `json:"source"`  // 源码包名称，dpkg 的 Source、rpm 的 SOURCERPM、apk 的 origin
+	Manager string `json:"manager"` // dpkg、rpm 或 apk
+}
+
+func (p *SystemPackage) key() string {
+	return p.Manager + "/" + p.Name + "/" + p.Arch
+}
+
+// InventoryRequest 是 v1/Packages/List 的请求参数
+type InventoryRequest struct {
+	Delta bool   `json:"delta"` // 只返回与上次上报相比的变化
+	Since string `json:"since"` // 服务器收到的上次上报的 ID，与 agent 记录的不一致时返回全量
+}
+
+// InventoryReport 是一次软件清单上报，Full 为 true 时 Packages 是全部软件包，否则只有变化
+type InventoryReport struct {
+	ID          string            `json:"id"`              // 本次清单内容的摘要，下次增量上报时作为 since
+	Since       string            `json:"since,omitempty"` // 增量的基准
+	Full        bool              `json:"full"`
+	Managers    []string          `json:"managers"`
+	Count       int               `json:"count"` // 已安装的软件包总数
+	Packages    []*SystemPackage  `json:"packages,omitempty"`
+	Added       []*SystemPackage  `json:"added,omitempty"`
+	Removed     []*SystemPackage  `json:"removed,omitempty"`
+	Changed     []*SystemPackage  `json:"changed,omitempty"` // 版本或来源变化的软件包，内容为新的值
+	Errors      map[string]string `json:"errors,omitempty"`  // 读取失败的包管理器
+	CollectedAt time.Time         `json:"collectedAt"`
+}
+
+type inventorySnapshot struct {
+	ID       string           `json:"id"`
+	Packages []*SystemPackage `json:"packages"`
+}
+
+// Inventory 读取本地的包管理器数据库，记录上次上报的清单用于计算增量
+type Inventory struct {
+	mu           sync.Mutex
+	storePath    string
+	dpkgStatus   string
+	apkInstalled string
+	rpm          string
+}
+
+func NewInventory(app *app.App) *Inventory {
+	inv := &Inventory{
+		storePath:    app.Config.InventoryStorePath,
+		dpkgStatus:   "/var/lib/dpkg/status",
+		apkInstalled: "/lib/apk/db/installed",
+		rpm:          "rpm",
+	}
+	if inv.storePath == "" {
+		inv.storePath = defaultInventoryStorePath
+	}
+	return inv
+}
+
+// List 读取已安装的软件包。增量上报时基准不存在或者与 since 不一致则返回全量，
+// 每次上报之后都把本次清单记录为新的基准
+func (inv *Inventory) List(ctx context.Context, req *InventoryRequest) (*InventoryReport, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	report := &InventoryReport{Errors: make(map[string]string), CollectedAt: time.Now()}
+	var packages []*SystemPackage
+	for _, source := range []struct {
+		name string
+		read func(context.Context) ([]*SystemPackage, error)
+	}{
+		{"dpkg", inv.readDpkg},
+		{"rpm", inv.readRpm},
+		{"apk", inv.readApk},
+	} {
+		list, err := source.read(ctx)
+		if errors.Is(err, ErrInventoryUnsupported) {
+			continue
+		}
+		report.Managers = append(report.Managers, source.name)
+		if err != nil {
+			report.Errors[source.name] = err.Error()
+			continue
+		}
+		packages = append(packages, list...)
+	}
+	if len(report.Managers) == 0 {
+		return nil, ErrInventoryUnsupported
+	}
+	if len(report.Errors) == len(report.Managers) {
+		return nil, fmt.Errorf("read package database: %v", report.Errors)
+	}
+
+	sort.Slice(packages, func(i, j int) bool { return packages[i].key() < packages[j].key() })
+	report.ID = inventoryID(packages)
+	report.Count = len(packages)
+
+	base, err := inv.load()
+	if err != nil {
+		return nil, err
+	}
+	if req.Delta && base != nil && (req.Since == "" || req.Since == base.ID) {
+		report.Since = base.ID
+		report.Added, report.Removed, report.Changed = diffInventory(base.Packages, packages)
+	} else {
+		report.Full = true
+		report.Packages = packages
+	}
+
+	// 只有部分包管理器读取成功时不更新基准，避免下次增量把读取失败的软件包当作删除
+	if len(report.Errors) == 0 {
+		if err := inv.save(&inventorySnapshot{ID: report.ID, Packages: packages}); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func (inv *Inventory) load() (*inventorySnapshot, error) {
+	b, err := ioutil.ReadFile(inv.storePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := new(inventorySnapshot)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", inv.storePath, err)
+	}
+	return s, nil
+}
+
+func (inv *Inventory) save(s *inventorySnapshot) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(inv.storePath), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(inv.storePath, b, 0644)
+}
+
+func (inv *Inventory) readDpkg(ctx context.Context) ([]*SystemPackage, error) {
+	f, err := os.Open(inv.dpkgStatus)
+	if os.IsNotExist(err) {
+		return nil, ErrInventoryUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseDpkgStatus(f)
+}
+
+func (inv *Inventory) readApk(ctx context.Context) ([]*SystemPackage, error) {
+	f, err := os.Open(inv.apkInstalled)
+	if os.IsNotExist(err) {
+		return nil, ErrInventoryUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseApkInstalled(f)
+}
+
+func (inv *Inventory) readRpm(ctx context.Context) ([]*SystemPackage, error) {
+	path, err := exec.LookPath(inv.rpm)
+	if err != nil {
+		return nil, ErrInventoryUnsupported
+	}
+	c, cancel := context.WithTimeout(ctx, inventoryCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(c, path, "-qa", "--queryformat", rpmQueryFormat)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if c.Err() != nil {
+			return nil, c.Err()
+		}
+		return nil, fmt.Errorf("rpm -qa: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseRpmOutput(stdout.String()), nil
+}
+
+// parseStanzas 解析以空行分隔的记录，每行是 key: value（dpkg）或 k:value（apk），忽略以空白开头的续行
+func parseStanzas(r io.Reader, fn func(map[string]string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	fields := make(map[string]string)
+	flush := func() {
+		if len(fields) > 0 {
+			fn(fields)
+			fields = make(map[string]string)
+		}
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	flush()
+	return scanner.Err()
+}
+
+// parseDpkgStatus 解析 dpkg 的 status 文件，只返回状态为 installed 的软件包
+func parseDpkgStatus(r io.Reader) ([]*SystemPackage, error) {
+	var list []*SystemPackage
+	err := parseStanzas(r, func(f map[string]string) {
+		if f["Package"] == "" || !strings.HasSuffix(f["Status"], " installed") {
+			return
+		}
+		p := &SystemPackage{Name: f["Package"], Version: f["Version"], Arch: f["Architecture"], Source: f["Package"], Manager: "dpkg"}
+		// Source 可能带有版本，例如 "glibc (2.36-9)"
+		if src := strings.Fields(f["Source"]); len(src) > 0 {
+			p.Source = src[0]
+		}
+		list = append(list, p)
+	})
+	return list, err
+}
+
+// parseApkInstalled 解析 apk 的 installed 数据库
+func parseApkInstalled(r io.Reader) ([]*SystemPackage, error) {
+	var list []*SystemPackage
+	err := parseStanzas(r, func(f map[string]string) {
+		if f["P"] == "" {
+			return
+		}
+		p := &SystemPackage{Name: f["P"], Version: f["V"], Arch: f["A"], Source: f["o"], Manager: "apk"}
+		if p.Source == "" {
+			p.Source = p.Name
+		}
+		list = append(list, p)
+	})
+	return list, err
+}
+
+// parseRpmOutput 解析 rpmQueryFormat 格式的输出，没有 epoch 时去掉版本前的 (none):
+func parseRpmOutput(out string) []*SystemPackage {
+	var list []*SystemPackage
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		version := strings.TrimPrefix(fields[1], "(none):")
+		arch := fields[2]
+		if arch == "(none)" {
+			arch = ""
+		}
+		source := fields[3]
+		if source == "(none)" {
+			source = ""
+		}
+		list = append(list, &SystemPackage{Name: fields[0], Version: version, Arch: arch, Source: source, Manager: "rpm"})
+	}
+	return list
+}
+
+// inventoryID 按排序后的清单内容计算摘要，内容不变时 ID 不变
+func inventoryID(packages []*SystemPackage) string {
+	h := sha256.New()
+	for _, p := range packages {
+		fmt.Fprintf(h, "%s\t%s\t%s\n", p.key(), p.Version, p.Source)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func diffInventory(old, cur []*SystemPackage) (added, removed, changed []*SystemPackage) {
+	before := make(map[string]*SystemPackage, len(old))
+	for _, p := range old {
+		before[p.key()] = p
+	}
+	for _, p := range cur {
+		o, ok := before[p.key()]
+		if !ok {
+			added = append(added, p)
+			continue
+		}
+		delete(before, p.key())
+		if o.Version != p.Version || o.Source != p.Source {
+			changed = append(changed, p)
+		}
+	}
+	for _, p := range old {
+		if _, ok := before[p.key()]; ok {
+			removed = append(removed, p)
+		}
+	}
+	return
+}
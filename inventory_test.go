@@ -0,0 +1,91 @@
+package updater
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"updater/pkg/app"
+	"updater/pkg/config"
+)
+
+const testDpkgStatus = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Source: glibc (2.36-9)
+Version: 2.36-9+deb12u4
+Description: GNU C Library
+ Contains the standard libraries.
+
+Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.2.15-2+b2
+
+Package: vim
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 2:9.0.1378-2
+`
+
+func TestParsePackageDatabases(t *testing.T) {
+	list, err := parseApkInstalled(strings.NewReader("C:Q1abc=\nP:musl\nV:1.2.4-r2\nA:x86_64\no:musl\nF:lib\n\nP:busybox-binsh\nV:1.36.1-r5\nA:x86_64\no:busybox\n"))
+	if err != nil || len(list) != 2 || *list[1] != (SystemPackage{Name: "busybox-binsh", Version: "1.36.1-r5", Arch: "x86_64", Source: "busybox", Manager: "apk"}) {
+		t.Fatalf("unexpected apk packages: %+v %v", list, err)
+	}
+
+	list = parseRpmOutput("bash\t(none):5.1.8-6.el9\tx86_64\tbash-5.1.8-6.el9.src.rpm\ngpg-pubkey\t(none):fd431d51-4ae0493b\t(none)\t(none)\nperl-Time\t1:1.9764-462.el9\tnoarch\tperl-5.32.1-480.el9.src.rpm\n")
+	if len(list) != 2 || list[0].Version != "5.1.8-6.el9" || list[1].Version != "1:1.9764-462.el9" || list[1].Source != "perl-5.32.1-480.el9.src.rpm" {
+		t.Fatalf("unexpected rpm packages: %+v", list)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	status := filepath.Join(dir, "status")
+	if err := os.WriteFile(status, []byte(testDpkgStatus), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inv := NewInventory(&app.App{Config: &config.Config{InventoryStorePath: filepath.Join(dir, "inventory.json")}})
+	inv.dpkgStatus, inv.apkInstalled, inv.rpm = status, filepath.Join(dir, "missing"), "missing-rpm"
+
+	// 没有基准时增量请求也返回全量
+	r, err := inv.List(context.Background(), &InventoryRequest{Delta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Full || r.Count != 2 || len(r.Managers) != 1 || r.Managers[0] != "dpkg" {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+	if *r.Packages[1] != (SystemPackage{Name: "libc6", Version: "2.36-9+deb12u4", Arch: "amd64", Source: "glibc", Manager: "dpkg"}) {
+		t.Fatalf("unexpected package: %+v", r.Packages[1])
+	}
+	first := r.ID
+
+	// 没有变化时 ID 不变，增量为空
+	r, err = inv.List(context.Background(), &InventoryRequest{Delta: true, Since: first})
+	if err != nil || r.Full || r.ID != first || len(r.Added)+len(r.Removed)+len(r.Changed) != 0 {
+		t.Fatalf("unexpected report: %+v %v", r, err)
+	}
+
+	updated := strings.Replace(testDpkgStatus, "5.2.15-2+b2", "5.2.15-3", 1)
+	updated = strings.Replace(updated, "Package: libc6", "Package: curl", 1)
+	if err := os.WriteFile(status, []byte(updated), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err = inv.List(context.Background(), &InventoryRequest{Delta: true, Since: first})
+	if err != nil || r.Full || r.Since != first || r.ID == first {
+		t.Fatalf("unexpected report: %+v %v", r, err)
+	}
+	if len(r.Added) != 1 || r.Added[0].Name != "curl" || len(r.Removed) != 1 || r.Removed[0].Name != "libc6" ||
+		len(r.Changed) != 1 || r.Changed[0].Version != "5.2.15-3" {
+		t.Fatalf("unexpected delta: %+v", r)
+	}
+
+	// 服务器没有收到上次的上报时，since 与基准不一致，返回全量
+	r, err = inv.List(context.Background(), &InventoryRequest{Delta: true, Since: first})
+	if err != nil || !r.Full || len(r.Packages) != 2 {
+		t.Fatalf("expected full report: %+v %v", r, err)
+	}
+}
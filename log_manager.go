@@ -0,0 +1,303 @@
+package updater
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultLogFetchLimit = 200
+	maxLogFetchLimit     = 5000
+	maxLogLineSize       = 1024 * 1024
+
+	// logTimeLayout 与 zapcore.ISO8601TimeEncoder 一致
+	logTimeLayout = "2006-01-02T15:04:05.000Z0700"
+)
+
+// LogLevelRequest 是 v1/Log/SetLevel 的请求参数
+type LogLevelRequest struct {
+	Level    string `json:"level"`    // debug、info、warn、error
+	Duration int    `json:"duration"` // 临时修改的时间（秒），到期后恢复为配置的级别，0 表示不自动恢复
+}
+
+// LogLevelResult 是修改之后的日志级别
+type LogLevelResult struct {
+	Level    string     `json:"level"`
+	Previous string     `json:"previous"`
+	RevertAt *time.Time `json:"revertAt,omitempty"` // 自动恢复的时间
+}
+
+// LogFetchRequest 是 v1/Log/Fetch 的请求参数，所有条件同时满足
+type LogFetchRequest struct {
+	Since    time.Time `json:"since"`    // 开始时间，零值表示不限制
+	Until    time.Time `json:"until"`    // 结束时间，零值表示不限制
+	Level    string    `json:"level"`    // 最低级别
+	TraceID  string    `json:"traceId"`  // 只返回这个 traceId 的日志
+	TaskID   string    `json:"taskId"`   // 只返回这个 taskId 的日志
+	Contains string    `json:"contains"` // 日志内容包含的字符串
+	Limit    int       `json:"limit"`    // 最多返回的条数，超过时返回最新的，0 使用默认值 200
+}
+
+// LogEntry 是一条日志
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Caller  string                 `json:"caller,omitempty"`
+	Message string                 `json:"msg"`
+	TraceID string                 `json:"traceId,omitempty"`
+	TaskID  string                 `json:"taskId,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"` // 其它结构化字段
+}
+
+// LogFetchResult 是 v1/Log/Fetch 的结果，按时间从早到晚排列
+type LogFetchResult struct {
+	Entries   []*LogEntry `json:"entries"`
+	Truncated bool        `json:"truncated"` // 匹配的日志超过 limit，较早的日志没有返回
+}
+
+// LogManager 在运行时修改日志级别，并从日志文件中检索日志
+type LogManager struct {
+	mu       sync.Mutex
+	logger   *logger.Logger
+	file     string
+	timer    *time.Timer
+	gen      uint64 // 每次修改级别时加一，过期的自动恢复不生效
+	revertAt time.Time
+}
+
+func NewLogManager(app *app.App) *LogManager {
+	return &LogManager{
+		logger: app.Logger,
+		file:   logger.FileName(app.Config.LogConfig),
+	}
+}
+
+// SetLevel 修改日志级别。duration 大于 0 时到期后恢复为当前配置的级别，
+// 再次修改会取消之前的自动恢复
+func (lm *LogManager) SetLevel(req *LogLevelRequest) (*LogLevelResult, error) {
+	var level zapcore.Level
+	if err := level.Set(req.Level); err != nil {
+		return nil, fmt.Errorf("unknown log level: %q", req.Level)
+	}
+	if req.Duration < 0 {
+		return nil, errors.New("duration must not be negative")
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	result := &LogLevelResult{Previous: lm.logger.Level()}
+	if err := lm.logger.SetLevel(level.String()); err != nil {
+		return nil, err
+	}
+	result.Level = level.String()
+
+	lm.gen++
+	if lm.timer != nil {
+		lm.timer.Stop()
+		lm.timer = nil
+	}
+	if req.Duration > 0 {
+		d := time.Duration(req.Duration) * time.Second
+		lm.revertAt = time.Now().Add(d)
+		revertAt := lm.revertAt
+		result.RevertAt = &revertAt
+		gen := lm.gen
+		lm.timer = time.AfterFunc(d, func() { lm.revert(gen) })
+	}
+	return result, nil
+}
+
+// revert 恢复为配置的日志级别，之后又修改过级别时不做任何事
+func (lm *LogManager) revert(gen uint64) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.gen != gen {
+		return
+	}
+	lm.timer = nil
+	level := "info"
+	if c := config.GetConfig(); c != nil && c.LogConfig.Level != "" {
+		level = c.LogConfig.Level
+	}
+	if err := lm.logger.SetLevel(level); err != nil {
+		lm.logger.Errorw("revert log level failed", "error", err)
+		return
+	}
+	lm.logger.Infow("log level reverted", "level", level)
+}
+
+// Fetch 按条件检索日志文件和 lumberjack 轮转的备份文件，包括压缩的备份
+func (lm *LogManager) Fetch(req *LogFetchRequest) (*LogFetchResult, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultLogFetchLimit
+	}
+	if limit > maxLogFetchLimit {
+		limit = maxLogFetchLimit
+	}
+	var minLevel zapcore.Level = zapcore.DebugLevel
+	if req.Level != "" {
+		if err := minLevel.Set(req.Level); err != nil {
+			return nil, fmt.Errorf("unknown log level: %q", req.Level)
+		}
+	}
+
+	files, err := logFiles(lm.file, req.Since)
+	if err != nil {
+		return nil, err
+	}
+	result := &LogFetchResult{Entries: []*LogEntry{}}
+	for _, file := range files {
+		err := scanLogFile(file, func(e *LogEntry) {
+			if !matchLogEntry(e, req, minLevel) {
+				return
+			}
+			// 只保留最新的 limit 条
+			if len(result.Entries) == limit {
+				copy(result.Entries, result.Entries[1:])
+				result.Entries = result.Entries[:limit-1]
+				result.Truncated = true
+			}
+			result.Entries = append(result.Entries, e)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", file, err)
+		}
+	}
+	return result, nil
+}
+
+// logFiles 返回按时间排序的备份文件和当前的日志文件，忽略最后修改时间早于 since 的备份
+func logFiles(file string, since time.Time) ([]string, error) {
+	dir, base := filepath.Dir(file), filepath.Base(file)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !(strings.HasSuffix(name, ext) || strings.HasSuffix(name, ext+".gz")) {
+			continue
+		}
+		if !since.IsZero() && e.ModTime().Before(since) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	// 备份文件名中的时间戳格式保证按名称排序就是按时间排序
+	sort.Strings(files)
+	if _, err := os.Stat(file); err == nil {
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func scanLogFile(file string, fn func(*LogEntry)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+	for scanner.Scan() {
+		if e := parseLogLine(scanner.Text()); e != nil {
+			fn(e)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseLogLine 解析 json 或 console 格式的一行日志，不是日志开头的行（例如堆栈）返回 nil
+func parseLogLine(line string) *LogEntry {
+	fields := make(map[string]interface{})
+	if strings.HasPrefix(line, "{") {
+		if json.Unmarshal([]byte(line), &fields) != nil {
+			return nil
+		}
+	} else {
+		// console 格式：时间、级别、调用位置、消息和 JSON 格式的其它字段，以 tab 分隔
+		parts := strings.SplitN(line, "\t", 5)
+		if len(parts) < 4 {
+			return nil
+		}
+		if len(parts) == 5 {
+			if json.Unmarshal([]byte(parts[4]), &fields) != nil {
+				parts[3] += "\t" + parts[4]
+			}
+		}
+		fields["ts"], fields["level"], fields["caller"], fields["msg"] = parts[0], strings.ToLower(parts[1]), parts[2], parts[3]
+	}
+
+	ts, _ := fields["ts"].(string)
+	t, err := time.Parse(logTimeLayout, ts)
+	if err != nil {
+		return nil
+	}
+	e := &LogEntry{Time: t}
+	e.Level, _ = fields["level"].(string)
+	e.Caller, _ = fields["caller"].(string)
+	e.Message, _ = fields["msg"].(string)
+	e.TraceID, _ = fields[logger.TraceIDKey].(string)
+	e.TaskID, _ = fields[logger.TaskIDKey].(string)
+	for _, k := range []string{"ts", "level", "caller", "msg", logger.TraceIDKey, logger.TaskIDKey} {
+		delete(fields, k)
+	}
+	if len(fields) > 0 {
+		e.Fields = fields
+	}
+	return e
+}
+
+func matchLogEntry(e *LogEntry, req *LogFetchRequest, minLevel zapcore.Level) bool {
+	if !req.Since.IsZero() && e.Time.Before(req.Since) {
+		return false
+	}
+	if !req.Until.IsZero() && e.Time.After(req.Until) {
+		return false
+	}
+	var level zapcore.Level
+	if level.Set(e.Level) == nil && level < minLevel {
+		return false
+	}
+	if req.TraceID != "" && e.TraceID != req.TraceID {
+		return false
+	}
+	if req.TaskID != "" && e.TaskID != req.TaskID {
+		return false
+	}
+	if req.Contains != "" && !strings.Contains(e.Message, req.Contains) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package updater
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+)
+
+func TestLogManagerSetLevel(t *testing.T) {
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	c := &config.Config{LogConfig: config.LogConfig{Level: "info", Filename: filepath.Join(t.TempDir(), "agent.log")}}
+	config.SetConfig(c)
+
+	a := &app.App{Config: c, Logger: logger.NewLogger(c.LogConfig)}
+	lm := NewLogManager(a)
+	if _, err := lm.SetLevel(&LogLevelRequest{Level: "verbose"}); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+
+	result, err := lm.SetLevel(&LogLevelRequest{Level: "debug", Duration: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Level != "debug" || result.Previous != "info" || result.RevertAt == nil {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for a.Logger.Level() != "info" {
+		if time.Now().After(deadline) {
+			t.Fatalf("level not reverted: %s", a.Logger.Level())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// 再次修改会取消之前的自动恢复
+	if _, err := lm.SetLevel(&LogLevelRequest{Level: "warn", Duration: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lm.SetLevel(&LogLevelRequest{Level: "error"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if level := a.Logger.Level(); level != "error" {
+		t.Fatalf("level = %s, want error", level)
+	}
+}
+
+func TestLogManagerFetch(t *testing.T) {
+	dir := t.TempDir()
+	c := &config.Config{LogConfig: config.LogConfig{Level: "debug", Filename: filepath.Join(dir, "agent.log")}}
+
+	// lumberjack 轮转的压缩备份
+	backup := `{"level":"info","ts":"2024-01-01T08:00:00.000+0800","caller":"a.go:1","msg":"old entry","traceId":"t0","taskId":""}` + "\n"
+	f, err := os.Create(filepath.Join(dir, "agent-2024-01-01T00-00-00.000.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(backup))
+	gz.Close()
+	f.Close()
+
+	a := &app.App{Config: c, Logger: logger.NewLogger(c.LogConfig)}
+	a.Logger.Infow("plain entry", "path", "/tmp/a")
+	a.Logger.WithTrace("t1", "task-1").Debugw("task debug")
+	a.Logger.WithTrace("t1", "task-1").Errorw("task failed", "error", "boom")
+	a.Logger.WithTrace("t2", "task-2").Warnw("other task")
+
+	lm := NewLogManager(a)
+	result, err := lm.Fetch(&LogFetchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 5 || result.Entries[0].Message != "old entry" {
+		t.Fatalf("unexpected entries: %+v", result.Entries)
+	}
+	plain := result.Entries[1]
+	if plain.TraceID != "" || plain.TaskID != "" || plain.Fields["path"] != "/tmp/a" {
+		t.Fatalf("unexpected entry: %+v", plain)
+	}
+
+	result, err = lm.Fetch(&LogFetchRequest{TaskID: "task-1", Level: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].Message != "task failed" || result.Entries[0].TraceID != "t1" {
+		t.Fatalf("unexpected entries: %+v", result.Entries)
+	}
+
+	result, err = lm.Fetch(&LogFetchRequest{Since: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 2 || !result.Truncated || result.Entries[1].Message != "other task" {
+		t.Fatalf("unexpected entries: %+v", result.Entries)
+	}
+
+	if _, err := lm.Fetch(&LogFetchRequest{Level: "verbose"}); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	e := parseLogLine("2024-05-01T10:00:00.000+0800\twarn\tclient.go:10\tconnection lost\t{\"server\": \"ws://a\", \"traceId\": \"t1\", \"taskId\": \"\"}")
+	if e == nil || e.Level != "warn" || e.Message != "connection lost" || e.TraceID != "t1" || e.Fields["server"] != "ws://a" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if e := parseLogLine("goroutine 1 [running]:"); e != nil {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
@@ -0,0 +1,13 @@
+package updater
+
+import (
+	"os"
+	"testing"
+	"updater/pkg/config"
+)
+
+// 没有加载配置时路径策略拒绝所有操作，测试默认使用不限制路径的空配置
+func TestMain(m *testing.M) {
+	config.SetConfig(&config.Config{})
+	os.Exit(m.Run())
+}
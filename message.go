@@ -3,13 +3,12 @@ package updater
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"reflect"
 	"runtime"
 	"time"
 	log "updater/pkg/logger"
-
-	"go.uber.org/zap"
+	"updater/pkg/trace"
 )
 
 const (
@@ -19,6 +18,9 @@ const (
 	CODE_SUCCESS = "success"
 	CODE_ERROR   = "error"
 	CODE_TIMEOUT = "timeout"
+
+	CODE_PERMISSION_DENIED = "permission_denied"
+	CODE_CONFLICT          = "conflict"
 )
 
 type Message struct {
@@ -33,6 +35,24 @@ type Message struct {
 	TraceId string          `json:"traceId"`
 	Timeout time.Duration   // 添加 Timeout 字段
 	TaskId  string          `json:"taskId"`
+
+	TraceParent string `json:"traceparent,omitempty"` // W3C trace context
+	TraceState  string `json:"tracestate,omitempty"`
+}
+
+// ErrorCode 根据错误类型返回响应码
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrPathDenied), errors.Is(err, ErrProcessDenied):
+		return CODE_PERMISSION_DENIED
+	case errors.Is(err, ErrContentMismatch), errors.Is(err, ErrPatchConflict),
+		errors.Is(err, ErrSelfUpdateRunning), errors.Is(err, ErrPackageInstalled),
+		errors.Is(err, ErrScheduleExists), errors.Is(err, ErrConfigUpdating):
+		return CODE_CONFLICT
+	case isTimeout(err):
+		return CODE_TIMEOUT
+	}
+	return CODE_ERROR
 }
 
 type HandlerFunc func(ctx *Context) error
@@ -40,6 +60,8 @@ type HandlerFunc func(ctx *Context) error
 type MessageHandler struct {
 	handlers map[string]HandlerFunc
 	in       chan *Message
+	TaskLogs *TaskLogs // 不为空时带有 taskId 的消息的日志同时写入任务日志
+	Audit    *AuditLog // 不为空时记录需要审计的消息的处理结果
 }
 
 func NewMessageHandler(bufferSize int) *MessageHandler {
@@ -58,14 +80,11 @@ func (h *MessageHandler) RegisterHandler(messageType string, handler HandlerFunc
 }
 
 func (h *MessageHandler) PrintRegisteredHandlers() {
-	fmt.Println("Registered Handlers:")
 	for messageType, handler := range h.handlers {
 		// 使用反射获取处理程序的名称
 		handlerName := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
-		fmt.Printf("Message Type:%s  %s\n", messageType, handlerName)
-
+		log.GetLogger().Debugw("registered handler", "type", messageType, "handler", handlerName)
 	}
-	fmt.Println("------------------------")
 }
 
 func (h *MessageHandler) HandleMessages(client *Client, numWorkers int) {
@@ -74,18 +93,33 @@ func (h *MessageHandler) HandleMessages(client *Client, numWorkers int) {
 			// 用于防止 panic 造成的程序崩溃
 			defer func() {
 				if r := recover(); r != nil {
-					log.Printf("Recovered from panic in HandleMessages: %v", r)
+					client.app.Logger.Errorw("recovered from panic in HandleMessages", "panic", r)
 				}
 			}()
 
 			for msg := range h.in {
-				ctx := context.Background()
-				if msg.Timeout > 0 {
-					ctx, _ = context.WithTimeout(ctx, msg.Timeout)
+				handler, ok := h.handlers[msg.Type]
+				spanName := "unknown"
+				if ok {
+					spanName = msg.Type
 				}
+				base, span := trace.StartWithKind(messageSpanContext(context.Background(), msg), trace.SpanKindServer, spanName,
+					"message.type", msg.Type, "message.id", msg.Id, "task.id", msg.TaskId)
 
-				ctxWithCancel, cancel := context.WithCancel(ctx)
+				var (
+					ctxWithCancel context.Context
+					cancel        context.CancelFunc
+				)
+				if msg.Timeout > 0 {
+					ctxWithCancel, cancel = context.WithTimeout(base, msg.Timeout)
+				} else {
+					ctxWithCancel, cancel = context.WithCancel(base)
+				}
 
+				logger := client.app.Logger
+				if h.TaskLogs != nil {
+					logger = h.TaskLogs.Logger(logger, msg.TaskId)
+				}
 				context := &Context{
 					Client:  client,
 					Message: msg,
@@ -93,22 +127,38 @@ func (h *MessageHandler) HandleMessages(client *Client, numWorkers int) {
 					Cancel:  cancel,
 					app:     client.app,
 					Extra:   make(map[string]interface{}),
-					Logger:  client.app.Logger.With(zap.String("traceId", msg.TraceId), zap.String("taskId", msg.TaskId)),
+					Logger:  logger.WithTrace(msg.TraceId, msg.TaskId),
 				}
 
-				if handler, ok := h.handlers[msg.Type]; ok {
-					err := handler(context)
+				// 响应会覆盖 msg 的 Method 和 Data，审计使用处理前的请求
+				req := *msg
+				start := time.Now()
+				var err error
+				if ok {
+					err = handler(context)
 					if err != nil {
-						log.Printf("Error handling message: %s", err)
+						context.Logger.Errorw("handle message failed", "type", msg.Type, "id", msg.Id, "error", err)
 					}
 				} else {
-					log.Printf("No handler registered for message type: %s", msg.Type)
+					err = errors.New("no handler registered")
+					context.Logger.Warnw("no handler registered for message type", "type", msg.Type, "id", msg.Id)
 				}
+				telemetry.observeHandler(msg.Type, ok, start)
+				if h.Audit != nil && ok {
+					h.Audit.RecordMessage(&req, msg, err, start)
+				}
+				span.End(err)
+				cancel()
 			}
 		}()
 	}
 }
 
+// QueueDepth 返回等待处理的消息数量
+func (h *MessageHandler) QueueDepth() int {
+	return len(h.in)
+}
+
 func (h *MessageHandler) SubmitMessage(msg *Message) {
 	h.in <- msg
 }
@@ -0,0 +1,236 @@
+package updater
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/logger"
+)
+
+const (
+	defaultMetricsBatchSize   = 10
+	defaultMetricsBufferLimit = 1440
+)
+
+var ErrMetricsUnsupported = errors.New("metrics collection is not supported on this platform")
+
+// MetricsSample 是一次系统指标采样，速率和使用率按距离上次采样的增量计算，第一次采样时为 0
+type MetricsSample struct {
+	Time    time.Time          `json:"time"`
+	CPU     CPUMetrics         `json:"cpu"`
+	Load    [3]float64         `json:"load"` // 1、5、15 分钟平均负载
+	Memory  MemoryMetrics      `json:"memory"`
+	Disks   []DiskUsageMetrics `json:"disks"`
+	DiskIO  []DiskIOMetrics    `json:"diskIo"`
+	Network []NetworkMetrics   `json:"network"`
+	FDs     FDMetrics          `json:"fds"`
+	Agent   AgentMetrics       `json:"agent"`
+}
+
+// CPUMetrics 单位为百分比，所有 CPU 合计为 100
+type CPUMetrics struct {
+	Usage  float64 `json:"usage"`
+	User   float64 `json:"user"`
+	System float64 `json:"system"`
+	IOWait float64 `json:"iowait"`
+	Steal  float64 `json:"steal"`
+}
+
+// MemoryMetrics 单位为字节
+type MemoryMetrics struct {
+	Total     uint64  `json:"total"`
+	Available uint64  `json:"available"`
+	Used      uint64  `json:"used"`
+	Usage     float64 `json:"usage"` // 百分比
+	SwapTotal uint64  `json:"swapTotal"`
+	SwapUsed  uint64  `json:"swapUsed"`
+}
+
+type DiskUsageMetrics struct {
+	MountPoint string  `json:"mountPoint"`
+	Total      uint64  `json:"total"`
+	Free       uint64  `json:"free"`
+	Usage      float64 `json:"usage"` // 百分比
+}
+
+// DiskIOMetrics 是一个块设备每秒的读写量
+type DiskIOMetrics struct {
+	Name       string  `json:"name"`
+	ReadBytes  float64 `json:"readBytes"`
+	WriteBytes float64 `json:"writeBytes"`
+	ReadOps    float64 `json:"readOps"`
+	WriteOps   float64 `json:"writeOps"`
+}
+
+// NetworkMetrics 是一个网卡每秒的收发量，错误数为采样间隔内的增量
+type NetworkMetrics struct {
+	Name      string  `json:"name"`
+	RxBytes   float64 `json:"rxBytes"`
+	TxBytes   float64 `json:"txBytes"`
+	RxPackets float64 `json:"rxPackets"`
+	TxPackets float64 `json:"txPackets"`
+	RxErrors  uint64  `json:"rxErrors"`
+	TxErrors  uint64  `json:"txErrors"`
+}
+
+// FDMetrics 是系统已分配和允许的最大文件描述符数量
+type FDMetrics struct {
+	Allocated uint64 `json:"allocated"`
+	Max       uint64 `json:"max"`
+}
+
+// AgentMetrics 是 agent 进程自身的资源占用，用于发现 agent 的泄漏
+type AgentMetrics struct {
+	PID        int     `json:"pid"`
+	CPU        float64 `json:"cpu"` // 百分比，单个 CPU 为 100
+	RSS        uint64  `json:"rss"` // 字节
+	Threads    int     `json:"threads"`
+	FDs        int     `json:"fds"`
+	Goroutines int     `json:"goroutines"`
+	HeapAlloc  uint64  `json:"heapAlloc"` // 字节
+}
+
+// MetricsBatch 是一次上报的 Metrics 消息，Dropped 是缓冲区满时丢弃的最早的采样数量
+type MetricsBatch struct {
+	Interval int              `json:"interval"` // 采样间隔（秒）
+	Dropped  int              `json:"dropped"`
+	Samples  []*MetricsSample `json:"samples"`
+}
+
+// MetricsCollector 按固定间隔采样系统指标，攒够一批后上报。
+// 连接不可用时采样保存在内存中，超过上限时丢弃最早的采样
+type MetricsCollector struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	batchSize int
+	limit     int
+	buffer    []*MetricsSample
+	dropped   int
+	sampler   *metricsSampler
+	upload    func(*MetricsBatch) bool
+	logger    *logger.Logger
+	done      chan struct{}
+}
+
+func NewMetricsCollector(app *app.App) *MetricsCollector {
+	mc := &MetricsCollector{
+		interval:  time.Duration(app.Config.MetricsInterval) * time.Second,
+		batchSize: app.Config.MetricsBatchSize,
+		limit:     app.Config.MetricsBufferLimit,
+		sampler:   newMetricsSampler(),
+		logger:    app.Logger,
+		done:      make(chan struct{}),
+	}
+	if mc.batchSize <= 0 {
+		mc.batchSize = defaultMetricsBatchSize
+	}
+	if mc.limit <= 0 {
+		mc.limit = defaultMetricsBufferLimit
+	}
+	if mc.limit < mc.batchSize {
+		mc.limit = mc.batchSize
+	}
+	return mc
+}
+
+// Enabled 没有配置采样间隔时不采集
+func (mc *MetricsCollector) Enabled() bool {
+	return mc.interval > 0
+}
+
+// Start 开始定时采样，upload 在连接不可用时返回 false，采样留到下次上报
+func (mc *MetricsCollector) Start(upload func(*MetricsBatch) bool) error {
+	if !mc.Enabled() {
+		return nil
+	}
+	mc.mu.Lock()
+	mc.upload = upload
+	mc.mu.Unlock()
+	// 第一次采样只用于初始化计数器
+	if _, err := mc.sampler.sample(); err != nil {
+		return err
+	}
+	go mc.loop()
+	return nil
+}
+
+func (mc *MetricsCollector) Stop() {
+	close(mc.done)
+}
+
+func (mc *MetricsCollector) loop() {
+	ticker := time.NewTicker(mc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mc.done:
+			return
+		case <-ticker.C:
+			if err := mc.Sample(); err != nil {
+				mc.logger.Println("sample metrics failed:", err)
+				continue
+			}
+			mc.mu.Lock()
+			full := len(mc.buffer) >= mc.batchSize
+			mc.mu.Unlock()
+			if full {
+				mc.Flush()
+			}
+		}
+	}
+}
+
+// Sample 采样一次并加入缓冲区
+func (mc *MetricsCollector) Sample() error {
+	s, err := mc.sampler.sample()
+	if err != nil {
+		return err
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.buffer = append(mc.buffer, s)
+	if n := len(mc.buffer) - mc.limit; n > 0 {
+		mc.buffer = append([]*MetricsSample(nil), mc.buffer[n:]...)
+		mc.dropped += n
+	}
+	return nil
+}
+
+// Flush 按批上报缓冲区中的采样，返回上报的采样数量。上报失败时停止，剩余的采样留在缓冲区
+func (mc *MetricsCollector) Flush() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.upload == nil {
+		return 0
+	}
+	sent := 0
+	for len(mc.buffer) > 0 {
+		n := len(mc.buffer)
+		if n > mc.batchSize {
+			n = mc.batchSize
+		}
+		batch := &MetricsBatch{
+			Interval: int(mc.interval / time.Second),
+			Dropped:  mc.dropped,
+			Samples:  mc.buffer[:n],
+		}
+		if !mc.upload(batch) {
+			break
+		}
+		mc.buffer = mc.buffer[n:]
+		mc.dropped = 0
+		sent += n
+	}
+	if len(mc.buffer) == 0 {
+		mc.buffer = nil
+	}
+	return sent
+}
+
+// Buffered 返回缓冲区中还没有上报的采样数量
+func (mc *MetricsCollector) Buffered() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return len(mc.buffer)
+}
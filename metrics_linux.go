@@ -0,0 +1,271 @@
+package updater
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ioCounters 是 /proc/diskstats 或 /proc/net/dev 中一个设备的累计值
+type ioCounters struct {
+	readBytes, writeBytes, readOps, writeOps, readErrors, writeErrors uint64
+}
+
+// metricsSampler 保存上次采样的累计计数器，用于计算增量
+type metricsSampler struct {
+	time      time.Time
+	cpu       []uint64
+	agentCPU  uint64
+	disks     map[string]ioCounters
+	network   map[string]ioCounters
+	hasSample bool
+}
+
+func newMetricsSampler() *metricsSampler {
+	return &metricsSampler{}
+}
+
+func (s *metricsSampler) sample() (*MetricsSample, error) {
+	now := time.Now()
+	stat, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	cpu := parseCPUStat(string(stat))
+	m := &MetricsSample{Time: now}
+
+	if b, err := ioutil.ReadFile("/proc/loadavg"); err == nil {
+		fields := strings.Fields(string(b))
+		for i := 0; i < 3 && i < len(fields); i++ {
+			m.Load[i], _ = strconv.ParseFloat(fields[i], 64)
+		}
+	}
+	if b, err := ioutil.ReadFile("/proc/meminfo"); err == nil {
+		m.Memory = parseMemoryMetrics(string(b))
+	}
+	if b, err := ioutil.ReadFile("/proc/mounts"); err == nil {
+		for _, mount := range collectMounts(string(b)) {
+			if mount.Total == 0 {
+				continue
+			}
+			m.Disks = append(m.Disks, DiskUsageMetrics{
+				MountPoint: mount.MountPoint,
+				Total:      mount.Total,
+				Free:       mount.Free,
+				Usage:      percent(float64(mount.Total-mount.Free), float64(mount.Total)),
+			})
+		}
+	}
+	if b, err := ioutil.ReadFile("/proc/sys/fs/file-nr"); err == nil {
+		if fields := strings.Fields(string(b)); len(fields) == 3 {
+			m.FDs.Allocated, _ = strconv.ParseUint(fields[0], 10, 64)
+			m.FDs.Max, _ = strconv.ParseUint(fields[2], 10, 64)
+		}
+	}
+
+	var disks, network map[string]ioCounters
+	if b, err := ioutil.ReadFile("/proc/diskstats"); err == nil {
+		disks = parseDiskStats(string(b))
+	}
+	if b, err := ioutil.ReadFile("/proc/net/dev"); err == nil {
+		network = parseNetDev(string(b))
+	}
+	agentCPU := s.sampleAgent(&m.Agent)
+
+	if s.hasSample {
+		elapsed := now.Sub(s.time).Seconds()
+		m.CPU = cpuMetrics(s.cpu, cpu)
+		if elapsed > 0 {
+			m.Agent.CPU = round1(float64(agentCPU-s.agentCPU) / clockTicks / elapsed * 100)
+			for name, cur := range disks {
+				if prev, ok := s.disks[name]; ok {
+					m.DiskIO = append(m.DiskIO, DiskIOMetrics{
+						Name:       name,
+						ReadBytes:  rate(prev.readBytes, cur.readBytes, elapsed),
+						WriteBytes: rate(prev.writeBytes, cur.writeBytes, elapsed),
+						ReadOps:    rate(prev.readOps, cur.readOps, elapsed),
+						WriteOps:   rate(prev.writeOps, cur.writeOps, elapsed),
+					})
+				}
+			}
+			for name, cur := range network {
+				if prev, ok := s.network[name]; ok {
+					m.Network = append(m.Network, NetworkMetrics{
+						Name:      name,
+						RxBytes:   rate(prev.readBytes, cur.readBytes, elapsed),
+						TxBytes:   rate(prev.writeBytes, cur.writeBytes, elapsed),
+						RxPackets: rate(prev.readOps, cur.readOps, elapsed),
+						TxPackets: rate(prev.writeOps, cur.writeOps, elapsed),
+						RxErrors:  delta(prev.readErrors, cur.readErrors),
+						TxErrors:  delta(prev.writeErrors, cur.writeErrors),
+					})
+				}
+			}
+			sortMetrics(m)
+		}
+	}
+
+	s.time, s.cpu, s.agentCPU, s.disks, s.network, s.hasSample = now, cpu, agentCPU, disks, network, true
+	return m, nil
+}
+
+// sampleAgent 读取 agent 自身的资源占用，返回累计的 CPU 时间（tick）
+func (s *metricsSampler) sampleAgent(a *AgentMetrics) uint64 {
+	a.PID = os.Getpid()
+	a.Goroutines = runtime.NumGoroutine()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	a.HeapAlloc = ms.HeapAlloc
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		a.FDs = len(fds)
+	}
+
+	b, err := ioutil.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0
+	}
+	p, err := parseProcStat(b)
+	if err != nil {
+		return 0
+	}
+	a.RSS, a.Threads = p.RSS, p.Threads
+	// 进程名之后的第 12、13 个字段是 utime 和 stime
+	fields := strings.Fields(string(b[strings.LastIndexByte(string(b), ')')+1:]))
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	return utime + stime
+}
+
+// parseCPUStat 返回 /proc/stat 中 cpu 行的 user nice system idle iowait irq softirq steal
+func parseCPUStat(s string) []uint64 {
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || fields[0] != "cpu" {
+			continue
+		}
+		values := make([]uint64, 8)
+		for i := range values {
+			values[i], _ = strconv.ParseUint(fields[i+1], 10, 64)
+		}
+		return values
+	}
+	return nil
+}
+
+func cpuMetrics(prev, cur []uint64) CPUMetrics {
+	if len(prev) != 8 || len(cur) != 8 {
+		return CPUMetrics{}
+	}
+	d := make([]float64, 8)
+	var total float64
+	for i := range d {
+		d[i] = float64(delta(prev[i], cur[i]))
+		total += d[i]
+	}
+	return CPUMetrics{
+		Usage:  percent(total-d[3]-d[4], total),
+		User:   percent(d[0]+d[1], total),
+		System: percent(d[2]+d[5]+d[6], total),
+		IOWait: percent(d[4], total),
+		Steal:  percent(d[7], total),
+	}
+}
+
+func parseMemoryMetrics(s string) MemoryMetrics {
+	info := parseMemInfo(s)
+	m := MemoryMetrics{Total: info.Total, Available: info.Available, SwapTotal: info.SwapTotal}
+	for _, line := range strings.Split(s, "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "SwapFree:" {
+			free, _ := strconv.ParseUint(fields[1], 10, 64)
+			m.SwapUsed = m.SwapTotal - free*1024
+		}
+	}
+	if m.Total > m.Available {
+		m.Used = m.Total - m.Available
+	}
+	m.Usage = percent(float64(m.Used), float64(m.Total))
+	return m
+}
+
+// parseDiskStats 只统计整块磁盘，忽略分区和 loop、ram 等虚拟设备
+func parseDiskStats(s string) map[string]ioCounters {
+	disks := make(map[string]ioCounters)
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+		if _, err := os.Stat("/sys/block/" + name); err != nil {
+			continue
+		}
+		v := func(i int) uint64 {
+			n, _ := strconv.ParseUint(fields[i], 10, 64)
+			return n
+		}
+		// 扇区固定为 512 字节
+		disks[name] = ioCounters{readOps: v(3), readBytes: v(5) * 512, writeOps: v(7), writeBytes: v(9) * 512}
+	}
+	return disks
+}
+
+// parseNetDev 解析 /proc/net/dev，忽略 lo
+func parseNetDev(s string) map[string]ioCounters {
+	network := make(map[string]ioCounters)
+	for _, line := range strings.Split(s, "\n") {
+		name, data, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "lo" {
+			continue
+		}
+		fields := strings.Fields(data)
+		if len(fields) < 16 {
+			continue
+		}
+		v := func(i int) uint64 {
+			n, _ := strconv.ParseUint(fields[i], 10, 64)
+			return n
+		}
+		network[name] = ioCounters{
+			readBytes: v(0), readOps: v(1), readErrors: v(2),
+			writeBytes: v(8), writeOps: v(9), writeErrors: v(10),
+		}
+	}
+	return network
+}
+
+func sortMetrics(m *MetricsSample) {
+	sort.Slice(m.DiskIO, func(i, j int) bool { return m.DiskIO[i].Name < m.DiskIO[j].Name })
+	sort.Slice(m.Network, func(i, j int) bool { return m.Network[i].Name < m.Network[j].Name })
+}
+
+// delta 计数器回绕或设备重置时返回 0
+func delta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+func rate(prev, cur uint64, seconds float64) float64 {
+	return round1(float64(delta(prev, cur)) / seconds)
+}
+
+func percent(part, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return round1(part / total * 100)
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
@@ -0,0 +1,82 @@
+package updater
+
+import (
+	"os"
+	"testing"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func TestParseMetrics(t *testing.T) {
+	prev := parseCPUStat("cpu  100 0 50 800 50 0 0 0 0 0\ncpu0 100 0 50 800 50 0 0 0 0 0\n")
+	cur := parseCPUStat("cpu  160 0 70 900 60 5 5 0 0 0\n")
+	cpu := cpuMetrics(prev, cur)
+	if cpu != (CPUMetrics{Usage: 45, User: 30, System: 15, IOWait: 5}) {
+		t.Fatalf("unexpected cpu: %+v", cpu)
+	}
+
+	mem := parseMemoryMetrics("MemTotal: 1000 kB\nMemAvailable: 250 kB\nSwapTotal: 100 kB\nSwapFree: 40 kB\n")
+	if mem != (MemoryMetrics{Total: 1024000, Available: 256000, Used: 768000, Usage: 75, SwapTotal: 102400, SwapUsed: 61440}) {
+		t.Fatalf("unexpected memory: %+v", mem)
+	}
+
+	net := parseNetDev(`Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:  1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 5000      50    1    0    0     0          0         0     3000      30    2    0    0     0       0          0
+`)
+	if len(net) != 1 || net["eth0"] != (ioCounters{readBytes: 5000, readOps: 50, readErrors: 1, writeBytes: 3000, writeOps: 30, writeErrors: 2}) {
+		t.Fatalf("unexpected network: %+v", net)
+	}
+	if delta(10, 5) != 0 || rate(100, 400, 2) != 150 {
+		t.Fatal("unexpected delta or rate")
+	}
+}
+
+func TestMetricsCollector(t *testing.T) {
+	mc := NewMetricsCollector(&app.App{
+		Config: &config.Config{MetricsInterval: 1, MetricsBatchSize: 2, MetricsBufferLimit: 3},
+		Logger: &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+	})
+	online := false
+	var batches []*MetricsBatch
+	if err := mc.Start(func(b *MetricsBatch) bool {
+		if online {
+			batches = append(batches, b)
+		}
+		return online
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mc.Stop()
+
+	// 离线时采样留在缓冲区，超过上限丢弃最早的采样
+	for i := 0; i < 5; i++ {
+		if err := mc.Sample(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mc.Flush() != 0 || mc.Buffered() != 3 {
+		t.Fatalf("expected 3 buffered samples, got %d", mc.Buffered())
+	}
+
+	online = true
+	if n := mc.Flush(); n != 3 || mc.Buffered() != 0 {
+		t.Fatalf("expected 3 samples sent, got %d", n)
+	}
+	if len(batches) != 2 || len(batches[0].Samples) != 2 || batches[0].Dropped != 2 || batches[1].Dropped != 0 || batches[0].Interval != 1 {
+		t.Fatalf("unexpected batches: %+v", batches)
+	}
+
+	s := batches[1].Samples[0]
+	if s.Memory.Total == 0 || len(s.Disks) == 0 || s.FDs.Max == 0 {
+		t.Fatalf("unexpected sample: %+v", s)
+	}
+	a := s.Agent
+	if a.PID != os.Getpid() || a.RSS == 0 || a.Threads == 0 || a.FDs == 0 || a.Goroutines == 0 || a.HeapAlloc == 0 {
+		t.Fatalf("unexpected agent metrics: %+v", a)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package updater
+
+type metricsSampler struct{}
+
+func newMetricsSampler() *metricsSampler {
+	return &metricsSampler{}
+}
+
+func (s *metricsSampler) sample() (*MetricsSample, error) {
+	return nil, ErrMetricsUnsupported
+}
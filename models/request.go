@@ -1,5 +1,6 @@
 package models
 
 type ReqTaskInfo struct {
-	TaskID string `json:"task_id"`
+	TaskID   string `json:"task_id"`
+	WithLogs bool   `json:"with_logs"` // 同时返回任务的日志
 }
@@ -0,0 +1,191 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/logger"
+	"updater/pkg/prom"
+	"updater/pkg/task"
+)
+
+// MonitorServer 是本地的 HTTP 监听，提供 Prometheus 指标、健康检查和可选的 pprof，
+// 默认只监听 localhost 或者 unix socket
+type MonitorServer struct {
+	enabled  bool
+	listen   string
+	pprof    bool
+	app      *app.App
+	handler  *MessageHandler
+	client   atomic.Pointer[Client]
+	registry *prom.Registry
+	server   *http.Server
+	logger   *logger.Logger
+}
+
+// MonitorStatus 是 /healthz 和 /readyz 返回的状态
+type MonitorStatus struct {
+	Status     string `json:"status"` // ok 或者 unavailable
+	Connected  bool   `json:"connected"`
+	Registered bool   `json:"registered"`
+	Server     string `json:"server,omitempty"`
+}
+
+func NewMonitorServer(app *app.App, handler *MessageHandler) *MonitorServer {
+	ms := &MonitorServer{
+		enabled:  app.Config.Monitor.Enabled,
+		listen:   app.Config.Monitor.Listen,
+		pprof:    app.Config.Monitor.Pprof,
+		app:      app,
+		handler:  handler,
+		registry: prom.NewRegistry(),
+		logger:   app.Logger,
+	}
+	if ms.listen == "" {
+		ms.listen = "127.0.0.1:9464"
+	}
+
+	boolValue := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	ms.registry.NewGaugeFunc("updater_connected", "Whether the agent is connected to the server.", func() float64 {
+		return boolValue(ms.status().Connected)
+	})
+	ms.registry.NewGaugeFunc("updater_registered", "Whether the agent is registered with the server.", func() float64 {
+		return boolValue(ms.status().Registered)
+	})
+	ms.registry.NewGaugeFunc("updater_message_queue_depth", "Messages waiting for a handler.", func() float64 {
+		if ms.handler == nil {
+			return 0
+		}
+		return float64(ms.handler.QueueDepth())
+	})
+	ms.registry.NewGaugeFunc("updater_running_tasks", "Tasks currently running.", func() float64 {
+		return float64(ms.runningTasks())
+	})
+	return ms
+}
+
+// SetClient 设置连接状态的来源，没有设置时认为没有连接
+func (ms *MonitorServer) SetClient(c *Client) {
+	ms.client.Store(c)
+}
+
+// Start 开始监听，没有启用时不做任何事
+func (ms *MonitorServer) Start() error {
+	if !ms.enabled {
+		return nil
+	}
+	l, err := ms.listener()
+	if err != nil {
+		return err
+	}
+	ms.server = &http.Server{Handler: ms.mux(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := ms.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			ms.logger.Errorw("monitor server stopped", "listen", ms.listen, "error", err)
+		}
+	}()
+	ms.logger.Infow("monitor server started", "listen", ms.listen, "pprof", ms.pprof)
+	return nil
+}
+
+func (ms *MonitorServer) Stop() {
+	if ms.server != nil {
+		ms.server.Close()
+	}
+}
+
+// listener 监听 host:port 或者 unix:/path，unix socket 只有当前用户可以访问
+func (ms *MonitorServer) listener() (net.Listener, error) {
+	path, ok := strings.CutPrefix(ms.listen, "unix:")
+	if !ok {
+		return net.Listen("tcp", ms.listen)
+	}
+	// 删除上次运行留下的 socket
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (ms *MonitorServer) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", ms.handleMetrics)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s := ms.status()
+		ms.writeStatus(w, s, s.Connected)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		s := ms.status()
+		ms.writeStatus(w, s, s.Connected && s.Registered)
+	})
+	if ms.pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+func (ms *MonitorServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := ms.registry.Write(w); err != nil {
+		return
+	}
+	telemetry.registry.Write(w)
+}
+
+func (ms *MonitorServer) writeStatus(w http.ResponseWriter, s *MonitorStatus, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		s.Status = "ok"
+	} else {
+		s.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(s)
+}
+
+func (ms *MonitorServer) status() *MonitorStatus {
+	s := new(MonitorStatus)
+	if c := ms.client.Load(); c != nil {
+		s.Connected = c.Connected.Load()
+		s.Registered = c.Connected.Load() && c.Registered.Load()
+		s.Server = c.ServerURL()
+	}
+	return s
+}
+
+func (ms *MonitorServer) runningTasks() int {
+	if ms.app.TaskManager == nil {
+		return 0
+	}
+	n := 0
+	for _, t := range ms.app.TaskManager.GetAllTasks() {
+		if t.GetStatus() == task.TaskStatusRunning {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,98 @@
+package updater
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+	"updater/pkg/task"
+
+	"go.uber.org/zap"
+)
+
+func TestMonitorServer(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "monitor.sock")
+	a := &app.App{
+		Config:      &config.Config{Monitor: config.MonitorConfig{Enabled: true, Listen: "unix:" + sock}},
+		Logger:      &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+		TaskManager: task.NewTaskManager(),
+	}
+	h := NewMessageHandler(10)
+	ms := NewMonitorServer(a, h)
+	if err := ms.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Stop()
+
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	get := func(path string) (int, string) {
+		resp, err := hc.Get("http://monitor" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("healthz without client = %d", code)
+	}
+	u, _ := url.Parse("ws://127.0.0.1/ws/")
+	c := &Client{Server: &Server{Url: u}}
+	c.Connected.Store(true)
+	ms.SetClient(c)
+	if code, body := get("/healthz"); code != http.StatusOK || !strings.Contains(body, `"connected":true`) {
+		t.Fatalf("healthz = %d %s", code, body)
+	}
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz before register = %d", code)
+	}
+	c.Registered.Store(true)
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Fatalf("readyz = %d", code)
+	}
+
+	h.in <- &Message{Type: "v1/Test/Queued"}
+	telemetry.observeHandler("v1/Test/Monitor", true, time.Now().Add(-30*time.Millisecond))
+	telemetry.observeHandler("v1/Test/Unregistered", false, time.Now())
+	code, body := get("/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("metrics = %d", code)
+	}
+	for _, want := range []string{
+		"# TYPE updater_connected gauge\nupdater_connected 1\n",
+		"updater_registered 1\n",
+		"updater_message_queue_depth 1\n",
+		"updater_running_tasks 0\n",
+		`updater_messages_received_total{type="v1/Test/Monitor"} 1`,
+		`updater_handler_duration_seconds_bucket{type="v1/Test/Monitor",le="0.025"} 0`,
+		`updater_handler_duration_seconds_bucket{type="v1/Test/Monitor",le="0.05"} 1`,
+		`updater_handler_duration_seconds_count{type="v1/Test/Monitor"} 1`,
+		`updater_messages_received_total{type="unknown"}`,
+		"# TYPE updater_download_bytes_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+	if strings.Contains(body, "v1/Test/Unregistered") {
+		t.Error("unregistered message type should not be a label")
+	}
+
+	if code, _ := get("/debug/pprof/"); code != http.StatusNotFound {
+		t.Fatalf("pprof should be disabled, got %d", code)
+	}
+}
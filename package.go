@@ -0,0 +1,664 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/app"
+)
+
+const (
+	PackageFormatFile = "file" // 制品是单个文件，直接放到发布目录中
+
+	PackageHookPreInstall  = "preInstall"
+	PackageHookPostInstall = "postInstall"
+	PackageHookPreRemove   = "preRemove"
+	PackageHookPostRemove  = "postRemove"
+	PackageHookRestore     = "restore" // 恢复到原来的版本后重新执行它的 postInstall
+
+	defaultPackageStorePath    = ".data/packages.json"
+	defaultPackageKeepReleases = 5
+	defaultPackageHookTimeout  = 300
+
+	packageReleasesDir = "releases"
+	packageCurrentLink = "current"
+)
+
+var (
+	ErrPackageInstalled    = errors.New("package is already installed")
+	ErrPackageNotInstalled = errors.New("package is not installed")
+	ErrReleaseNotFound     = errors.New("release not found")
+	ErrHookFailed          = errors.New("package hook failed")
+)
+
+// PackageHooks 是安装和卸载时执行的脚本内容
+type PackageHooks struct {
+	PreInstall  string `json:"preInstall"`  // 切换 current 之前执行，工作目录为新的发布目录
+	PostInstall string `json:"postInstall"` // 切换 current 之后执行，失败时切换回原来的版本
+	PreRemove   string `json:"preRemove"`   // 卸载前执行
+	PostRemove  string `json:"postRemove"`  // 卸载后执行
+}
+
+// PackageManifest 描述一个软件包版本
+type PackageManifest struct {
+	Name            string            `json:"name"`            // 包名
+	Version         string            `json:"version"`         // 版本号
+	URL             string            `json:"url"`             // 制品下载地址，为空时使用 downloadPath
+	DownloadPath    string            `json:"downloadPath"`    // 服务器 /api/v1/pkg/ 下的路径
+	Sha256          string            `json:"sha256"`          // 制品的 sha256
+	Format          string            `json:"format"`          // 制品格式：tar、tar.gz、tar.zst、zip、file，为空时根据扩展名判断
+	StripComponents int               `json:"stripComponents"` // 解压时去掉路径的前几级目录
+	InstallDir      string            `json:"installDir"`      // 安装目录，发布目录和 current 链接都在这个目录下
+	Hooks           PackageHooks      `json:"hooks"`           // 钩子脚本
+	HookTimeout     int               `json:"hookTimeout"`     // 每个钩子的超时时间（秒）
+	Env             map[string]string `json:"env"`             // 钩子脚本的额外环境变量
+	KeepReleases    int               `json:"keepReleases"`    // 保留的发布目录数量，0 使用默认值
+	HealthCheck     *HealthCheckSpec  `json:"healthCheck"`     // postInstall 之后的健康检查，失败时恢复原来的版本
+}
+
+// PackageRelease 是已经安装到本地的一个版本
+type PackageRelease struct {
+	Version     string          `json:"version"`
+	Dir         string          `json:"dir"`
+	Manifest    PackageManifest `json:"manifest"`
+	InstalledAt time.Time       `json:"installedAt"`
+}
+
+// InstalledPackage 是本地存储中记录的已安装软件包
+type InstalledPackage struct {
+	Name       string            `json:"name"`
+	InstallDir string            `json:"installDir"`
+	Current    string            `json:"current"`  // 当前版本
+	Previous   string            `json:"previous"` // 上一个版本，回滚时默认使用
+	Releases   []*PackageRelease `json:"releases"` // 按安装时间排序
+	UpdatedAt  time.Time         `json:"updatedAt"`
+}
+
+// PackageRequest 是 Rollback 和 Uninstall 的请求参数
+type PackageRequest struct {
+	TaskID    string `json:"taskId"`    // 任务ID
+	Name      string `json:"name"`      // 包名
+	Version   string `json:"version"`   // 回滚的目标版本，为空时回滚到上一个版本
+	KeepFiles bool   `json:"keepFiles"` // 卸载时是否保留发布目录
+}
+
+// PackageInstallRequest 是 Install 和 Upgrade 的请求参数
+type PackageInstallRequest struct {
+	TaskID   string          `json:"taskId"`   // 任务ID
+	Manifest PackageManifest `json:"manifest"` // 软件包清单
+}
+
+// PackageResult 是软件包操作的结果
+type PackageResult struct {
+	Name            string                   `json:"name"`
+	Version         string                   `json:"version"`         // 操作后的当前版本，卸载后为空
+	PreviousVersion string                   `json:"previousVersion"` // 操作前的版本
+	ReleaseDir      string                   `json:"releaseDir"`
+	Hooks           map[string]*ScriptResult `json:"hooks,omitempty"`  // 执行过的钩子和输出
+	Pruned          []string                 `json:"pruned,omitempty"` // 清理掉的旧版本
+	HealthChecks    []*HealthCheckResult     `json:"healthChecks,omitempty"`
+	Restored        bool                     `json:"restored,omitempty"` // 失败后已经恢复到原来的版本
+}
+
+// PackageManager 管理软件包的安装、升级、回滚和卸载
+type PackageManager struct {
+	mu        sync.Mutex // 保护 store 文件
+	locks     sync.Map   // 每个包一把锁，同一个包的操作串行执行
+	storePath string
+	fm        *FileManager
+}
+
+func NewPackageManager(app *app.App) *PackageManager {
+	storePath := app.Config.PackageStorePath
+	if storePath == "" {
+		storePath = defaultPackageStorePath
+	}
+	return &PackageManager{
+		storePath: storePath,
+		fm:        NewFileManager(),
+	}
+}
+
+func (pm *PackageManager) lock(name string) func() {
+	v, _ := pm.locks.LoadOrStore(name, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Install 安装一个新的软件包
+func (pm *PackageManager) Install(ctx *Context, req *PackageInstallRequest) (*PackageResult, error) {
+	return pm.deploy(ctx, req, false)
+}
+
+// Upgrade 将已经安装的软件包升级到清单中的版本，也可以用来重新部署当前版本
+func (pm *PackageManager) Upgrade(ctx *Context, req *PackageInstallRequest) (*PackageResult, error) {
+	return pm.deploy(ctx, req, true)
+}
+
+func (pm *PackageManager) deploy(ctx *Context, req *PackageInstallRequest, upgrade bool) (result *PackageResult, err error) {
+	m := req.Manifest
+	if err := validateManifest(&m); err != nil {
+		return nil, err
+	}
+	defer pm.lock(m.Name)()
+
+	pkg, err := pm.get(m.Name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case pkg != nil && !upgrade:
+		return nil, fmt.Errorf("%w: %s %s", ErrPackageInstalled, pkg.Name, pkg.Current)
+	case pkg == nil && upgrade:
+		return nil, fmt.Errorf("%w: %s", ErrPackageNotInstalled, m.Name)
+	case pkg == nil:
+		pkg = &InstalledPackage{Name: m.Name, InstallDir: m.InstallDir}
+	}
+	if upgrade && m.InstallDir == "" {
+		m.InstallDir = pkg.InstallDir
+	}
+	if m.InstallDir == "" {
+		return nil, errors.New("package installDir is empty")
+	}
+	if err := pm.fm.CheckPath(PathOpWrite, m.InstallDir); err != nil {
+		return nil, err
+	}
+
+	result = &PackageResult{
+		Name:            m.Name,
+		Version:         m.Version,
+		PreviousVersion: pkg.Current,
+		ReleaseDir:      releaseDir(m.InstallDir, m.Version),
+		Hooks:           make(map[string]*ScriptResult),
+	}
+
+	backup, err := pm.fetchRelease(ctx, &m, result.ReleaseDir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if backup != "" {
+			// 重新部署已有的版本，成功时删除备份，失败时换回原来的发布目录
+			if err == nil {
+				os.RemoveAll(backup)
+			} else if rerr := restoreRelease(result.ReleaseDir, backup); rerr != nil {
+				ctx.Logger.Println("restore release", m.Version, "failed:", rerr)
+			}
+			return
+		}
+		if err == nil || pkg.release(m.Version) != nil {
+			return
+		}
+		// 失败的新版本没有记录下来，删除它的发布目录；全新安装时同时删除 current 链接
+		if pkg.Current == "" {
+			os.Remove(filepath.Join(m.InstallDir, packageCurrentLink))
+		}
+		os.RemoveAll(result.ReleaseDir)
+	}()
+
+	env := packageEnv(&m, result.ReleaseDir, pkg.Current)
+	if err := pm.runHook(ctx, req.TaskID, PackageHookPreInstall, m.Hooks.PreInstall, result.ReleaseDir, env, m.HookTimeout, result); err != nil {
+		return result, err
+	}
+
+	if err := pm.activate(ctx, req.TaskID, m.InstallDir, &m, result.ReleaseDir, env, result); err != nil {
+		// 重新部署当前版本失败时先换回原来的发布目录，再恢复原来的版本
+		if backup != "" {
+			if rerr := restoreRelease(result.ReleaseDir, backup); rerr != nil {
+				ctx.Logger.Println("restore release", m.Version, "failed:", rerr)
+				return result, err
+			}
+			backup = ""
+		}
+		pm.restore(ctx, req.TaskID, pkg, result)
+		return result, err
+	}
+
+	if pkg.Current != m.Version {
+		pkg.Previous = pkg.Current
+	}
+	pkg.Current = m.Version
+	pkg.InstallDir = m.InstallDir
+	pkg.UpdatedAt = time.Now()
+	pkg.setRelease(&PackageRelease{
+		Version:     m.Version,
+		Dir:         result.ReleaseDir,
+		Manifest:    m,
+		InstalledAt: time.Now(),
+	})
+	result.Pruned = pm.prune(pkg, m.KeepReleases)
+
+	if err := pm.put(pkg); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Rollback 切换到之前安装过的版本，执行该版本的 postInstall 钩子和健康检查，失败时切换回来
+func (pm *PackageManager) Rollback(ctx *Context, req *PackageRequest) (*PackageResult, error) {
+	defer pm.lock(req.Name)()
+
+	pkg, err := pm.get(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPackageNotInstalled, req.Name)
+	}
+
+	version := req.Version
+	if version == "" {
+		version = pkg.Previous
+	}
+	rel := pkg.release(version)
+	if rel == nil || version == "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrReleaseNotFound, req.Name, version)
+	}
+	if _, err := os.Stat(rel.Dir); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrReleaseNotFound, err)
+	}
+
+	result := &PackageResult{
+		Name:            pkg.Name,
+		Version:         version,
+		PreviousVersion: pkg.Current,
+		ReleaseDir:      rel.Dir,
+		Hooks:           make(map[string]*ScriptResult),
+	}
+	m := rel.Manifest
+	env := packageEnv(&m, rel.Dir, pkg.Current)
+	if err := pm.activate(ctx, req.TaskID, pkg.InstallDir, &m, rel.Dir, env, result); err != nil {
+		pm.restore(ctx, req.TaskID, pkg, result)
+		return result, err
+	}
+
+	pkg.Previous, pkg.Current = pkg.Current, version
+	pkg.UpdatedAt = time.Now()
+	if err := pm.put(pkg); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Uninstall 执行卸载钩子，删除 current 链接和发布目录，并从本地存储中删除
+func (pm *PackageManager) Uninstall(ctx *Context, req *PackageRequest) (*PackageResult, error) {
+	defer pm.lock(req.Name)()
+
+	pkg, err := pm.get(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if pkg == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPackageNotInstalled, req.Name)
+	}
+	if err := pm.fm.CheckPath(PathOpDelete, pkg.InstallDir); err != nil {
+		return nil, err
+	}
+
+	result := &PackageResult{
+		Name:            pkg.Name,
+		PreviousVersion: pkg.Current,
+		Hooks:           make(map[string]*ScriptResult),
+	}
+	var m PackageManifest
+	dir := pkg.InstallDir
+	if rel := pkg.release(pkg.Current); rel != nil {
+		m, dir = rel.Manifest, rel.Dir
+		result.ReleaseDir = rel.Dir
+	}
+	env := packageEnv(&m, dir, pkg.Current)
+	if _, err := os.Stat(dir); err != nil {
+		dir = ""
+	}
+
+	if err := pm.runHook(ctx, req.TaskID, PackageHookPreRemove, m.Hooks.PreRemove, dir, env, m.HookTimeout, result); err != nil {
+		return result, err
+	}
+
+	if err := os.Remove(filepath.Join(pkg.InstallDir, packageCurrentLink)); err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+	if !req.KeepFiles {
+		if err := pm.fm.DeleteAll(filepath.Join(pkg.InstallDir, packageReleasesDir)); err != nil {
+			return result, err
+		}
+		// 安装目录为空时一并删除
+		os.Remove(pkg.InstallDir)
+	}
+
+	// 发布目录已经删除，postRemove 在安装目录的上级目录中执行
+	if err := pm.runHook(ctx, req.TaskID, PackageHookPostRemove, m.Hooks.PostRemove, filepath.Dir(pkg.InstallDir), env, m.HookTimeout, result); err != nil {
+		ctx.Logger.Println("postRemove hook failed:", err)
+	}
+
+	if err := pm.delete(pkg.Name); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// List 返回所有已安装的软件包
+func (pm *PackageManager) List() ([]*InstalledPackage, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pkgs, err := pm.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*InstalledPackage, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		list = append(list, pkg)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// fetchRelease 下载制品并解压到发布目录。先解压到临时目录，成功后再改名。
+// 发布目录已经存在时返回原来目录的备份，由调用方在失败时恢复、成功时删除
+func (pm *PackageManager) fetchRelease(ctx *Context, m *PackageManifest, dir string) (backup string, err error) {
+	url := m.URL
+	if url == "" {
+		url = ctx.Client.PackageURL(m.DownloadPath)
+	}
+	name := path.Base(m.URL)
+	if m.URL == "" {
+		name = path.Base(m.DownloadPath)
+	}
+	format := m.Format
+	if format == "" {
+		if format = DetectArchiveFormat(name); format == "" {
+			return "", fmt.Errorf("%w: %s", ErrUnknownArchiveFormat, name)
+		}
+	}
+
+	releases := filepath.Join(m.InstallDir, packageReleasesDir)
+	if err := os.MkdirAll(releases, 0755); err != nil {
+		return "", err
+	}
+	staging, err := ioutil.TempDir(releases, "."+m.Version+".")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	root := filepath.Join(staging, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		return "", err
+	}
+	if format == PackageFormatFile {
+		if _, err := fetchFile(ctx.Ctx, url, filepath.Join(root, name), m.Sha256, 0755, nil); err != nil {
+			return "", err
+		}
+	} else {
+		artifact := filepath.Join(staging, "artifact")
+		if _, err := fetchFile(ctx.Ctx, url, artifact, m.Sha256, 0644, nil); err != nil {
+			return "", err
+		}
+		_, err := pm.fm.Extract(ctx.Ctx, &ExtractRequest{
+			Src:                 artifact,
+			DestPath:            root,
+			Format:              format,
+			StripComponents:     m.StripComponents,
+			PreservePermissions: true,
+			Overwrite:           OverwriteAlways,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// 重新部署已有的版本时先把原来的发布目录移到备份目录，部署成功后再删除
+	if _, err := os.Lstat(dir); err == nil {
+		if backup, err = ioutil.TempDir(releases, "."+m.Version+".old."); err != nil {
+			return "", err
+		}
+		if err := os.Rename(dir, filepath.Join(backup, "root")); err != nil {
+			os.RemoveAll(backup)
+			return "", err
+		}
+	}
+	if err := os.Rename(root, dir); err != nil {
+		if backup != "" {
+			restoreRelease(dir, backup)
+		}
+		return "", err
+	}
+	return backup, nil
+}
+
+// restoreRelease 用 fetchRelease 留下的备份替换发布目录
+func restoreRelease(dir, backup string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(backup, "root"), dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(backup)
+}
+
+// activate 将 current 指向新的版本，执行 postInstall 钩子和健康检查
+func (pm *PackageManager) activate(ctx *Context, taskID, installDir string, m *PackageManifest, dir string, env map[string]string, result *PackageResult) error {
+	if err := switchCurrent(installDir, m.Version); err != nil {
+		return err
+	}
+	if err := pm.runHook(ctx, taskID, PackageHookPostInstall, m.Hooks.PostInstall, dir, env, m.HookTimeout, result); err != nil {
+		return err
+	}
+	checks, err := RunHealthChecks(ctx, m.HealthCheck)
+	result.HealthChecks = checks
+	return err
+}
+
+// restore 在新版本启动失败或健康检查失败后切换回原来的版本，并重新执行原来版本的 postInstall 钩子。
+// 全新安装时没有可以恢复的版本
+func (pm *PackageManager) restore(ctx *Context, taskID string, pkg *InstalledPackage, result *PackageResult) {
+	rel := pkg.release(pkg.Current)
+	if rel == nil {
+		return
+	}
+	ctx.Logger.Println("restore package", pkg.Name, "to version", pkg.Current)
+	if err := switchCurrent(pkg.InstallDir, pkg.Current); err != nil {
+		ctx.Logger.Println("switch back to", pkg.Current, "failed:", err)
+		return
+	}
+	result.Version = pkg.Current
+	result.Restored = true
+
+	m := rel.Manifest
+	env := packageEnv(&m, rel.Dir, result.Version)
+	if err := pm.runHook(ctx, taskID, PackageHookRestore, m.Hooks.PostInstall, rel.Dir, env, m.HookTimeout, result); err != nil {
+		ctx.Logger.Println("restore hook failed:", err)
+	}
+}
+
+// runHook 通过 ScriptTask 执行钩子脚本，脚本为空时跳过
+func (pm *PackageManager) runHook(ctx *Context, taskID, name, content, dir string, env map[string]string, timeout int, result *PackageResult) error {
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = defaultPackageHookTimeout
+	}
+
+	st := NewScriptTask(&ScriptTaskRequest{
+		TaskID:  taskID + "-" + name,
+		Type:    name,
+		Content: content,
+		WorkDir: dir,
+		Env:     env,
+		Timeout: timeout,
+	})
+	ctx.Logger.Println("run package hook:", name, "dir:", dir)
+	st.Run(ctx)
+	result.Hooks[name] = st.ScriptResult
+	// 脚本非零退出时 ScriptTask 的 Code 仍然是 SUCCESS，需要同时检查退出码
+	if r := st.ScriptResult; r.Code != CodeSuccess || r.ExitCode != 0 {
+		return fmt.Errorf("%w: %s: %s exit code %d: %s", ErrHookFailed, name, r.Code, r.ExitCode, strings.TrimSpace(r.Stderr+" "+r.Error))
+	}
+	return nil
+}
+
+// prune 删除超出保留数量的旧版本，当前版本和上一个版本总是保留
+func (pm *PackageManager) prune(pkg *InstalledPackage, keep int) []string {
+	if keep <= 0 {
+		keep = defaultPackageKeepReleases
+	}
+	var pruned []string
+	for len(pkg.Releases) > keep {
+		i := 0
+		for i < len(pkg.Releases) && (pkg.Releases[i].Version == pkg.Current || pkg.Releases[i].Version == pkg.Previous) {
+			i++
+		}
+		if i == len(pkg.Releases) {
+			break
+		}
+		rel := pkg.Releases[i]
+		if err := pm.fm.DeleteAll(rel.Dir); err != nil && !os.IsNotExist(err) {
+			break
+		}
+		pkg.Releases = append(pkg.Releases[:i], pkg.Releases[i+1:]...)
+		pruned = append(pruned, rel.Version)
+	}
+	return pruned
+}
+
+func (pkg *InstalledPackage) release(version string) *PackageRelease {
+	for _, rel := range pkg.Releases {
+		if rel.Version == version {
+			return rel
+		}
+	}
+	return nil
+}
+
+// setRelease 记录新安装的版本，重新部署的版本移到最后
+func (pkg *InstalledPackage) setRelease(rel *PackageRelease) {
+	for i, r := range pkg.Releases {
+		if r.Version == rel.Version {
+			pkg.Releases = append(pkg.Releases[:i], pkg.Releases[i+1:]...)
+			break
+		}
+	}
+	pkg.Releases = append(pkg.Releases, rel)
+}
+
+func validateManifest(m *PackageManifest) error {
+	switch {
+	case m.Name == "":
+		return errors.New("package name is empty")
+	case m.Version == "":
+		return errors.New("package version is empty")
+	case m.URL == "" && m.DownloadPath == "":
+		return errors.New("package url and downloadPath are both empty")
+	case m.Sha256 == "":
+		return errors.New("package sha256 is empty")
+	}
+	// 包名和版本号会作为目录名
+	for _, s := range []string{m.Name, m.Version} {
+		if s == "." || s == ".." || strings.ContainsAny(s, `/\`) {
+			return fmt.Errorf("invalid package name or version: %s", s)
+		}
+	}
+	return nil
+}
+
+func releaseDir(installDir, version string) string {
+	return filepath.Join(installDir, packageReleasesDir, version)
+}
+
+// switchCurrent 将 current 链接原子地指向指定版本：先创建临时链接再改名覆盖
+func switchCurrent(installDir, version string) error {
+	link := filepath.Join(installDir, packageCurrentLink)
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Join(packageReleasesDir, version), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func packageEnv(m *PackageManifest, dir, previous string) map[string]string {
+	env := map[string]string{
+		"PKG_NAME":             m.Name,
+		"PKG_VERSION":          m.Version,
+		"PKG_INSTALL_DIR":      m.InstallDir,
+		"PKG_RELEASE_DIR":      dir,
+		"PKG_PREVIOUS_VERSION": previous,
+	}
+	for k, v := range m.Env {
+		env[k] = v
+	}
+	return env
+}
+
+func (pm *PackageManager) get(name string) (*InstalledPackage, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pkgs, err := pm.load()
+	if err != nil {
+		return nil, err
+	}
+	return pkgs[name], nil
+}
+
+func (pm *PackageManager) put(pkg *InstalledPackage) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pkgs, err := pm.load()
+	if err != nil {
+		return err
+	}
+	pkgs[pkg.Name] = pkg
+	return pm.save(pkgs)
+}
+
+func (pm *PackageManager) delete(name string) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pkgs, err := pm.load()
+	if err != nil {
+		return err
+	}
+	delete(pkgs, name)
+	return pm.save(pkgs)
+}
+
+func (pm *PackageManager) load() (map[string]*InstalledPackage, error) {
+	pkgs := make(map[string]*InstalledPackage)
+	b, err := ioutil.ReadFile(pm.storePath)
+	if os.IsNotExist(err) {
+		return pkgs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &pkgs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", pm.storePath, err)
+	}
+	return pkgs, nil
+}
+
+func (pm *PackageManager) save(pkgs map[string]*InstalledPackage) error {
+	b, err := json.MarshalIndent(pkgs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pm.storePath), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(pm.storePath, b, 0644)
+}
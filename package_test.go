@@ -0,0 +1,161 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"updater/pkg/app"
+	"updater/pkg/config"
+)
+
+// buildTestPackage 打包一个只包含 VERSION 文件的 tar.gz，返回路径和 sha256
+func buildTestPackage(t *testing.T, version string) (string, string) {
+	src := filepath.Join(t.TempDir(), "app")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "VERSION"), []byte(version), 0644)
+
+	archive := filepath.Join(t.TempDir(), "app-"+version+".tar.gz")
+	result, err := NewFileManager().Archive(context.Background(), &ArchiveRequest{Src: src, DestPath: archive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive, result.Sha256
+}
+
+func TestPackageLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	installDir := filepath.Join(dir, "opt", "app")
+	pm := NewPackageManager(&app.App{Config: &config.Config{PackageStorePath: filepath.Join(dir, "packages.json")}})
+	ctx := newTestContext()
+
+	artifacts := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := artifacts[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, path)
+	}))
+	defer srv.Close()
+
+	manifest := func(version, postInstall string) *PackageInstallRequest {
+		path, sum := buildTestPackage(t, version)
+		artifacts["/app-"+version+".tar.gz"] = path
+		return &PackageInstallRequest{
+			TaskID: "pkg-" + version,
+			Manifest: PackageManifest{
+				Name:       "app",
+				Version:    version,
+				URL:        srv.URL + "/app-" + version + ".tar.gz",
+				Sha256:     sum,
+				InstallDir: installDir,
+				Hooks: PackageHooks{
+					PreInstall:  `test "$(cat VERSION)" = "$PKG_VERSION"`,
+					PostInstall: postInstall,
+				},
+			},
+		}
+	}
+	current := func() string {
+		b, _ := os.ReadFile(filepath.Join(installDir, "current", "VERSION"))
+		return string(b)
+	}
+
+	if _, err := pm.Install(ctx, manifest("1.0.0", "")); err != nil {
+		t.Fatal(err)
+	}
+	if v := current(); v != "1.0.0" {
+		t.Fatalf("current = %q after install", v)
+	}
+	if _, err := pm.Install(ctx, manifest("1.0.0", "")); !errors.Is(err, ErrPackageInstalled) {
+		t.Fatalf("expected ErrPackageInstalled, got %v", err)
+	}
+
+	result, err := pm.Upgrade(ctx, manifest("2.0.0", "echo started"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := current(); v != "2.0.0" || result.PreviousVersion != "1.0.0" {
+		t.Fatalf("current = %q, previous = %q after upgrade", v, result.PreviousVersion)
+	}
+	if out := result.Hooks[PackageHookPostInstall]; out == nil || out.Stdout != "started\n" {
+		t.Fatalf("unexpected postInstall result: %+v", out)
+	}
+
+	// postInstall 失败时保持原来的版本，并删除失败的发布目录
+	result, err = pm.Upgrade(ctx, manifest("3.0.0", "exit 1"))
+	if !errors.Is(err, ErrHookFailed) {
+		t.Fatalf("expected ErrHookFailed, got %v", err)
+	}
+	if v := current(); v != "2.0.0" || result.Version != "2.0.0" {
+		t.Fatalf("current = %q after failed upgrade", v)
+	}
+	if _, err := os.Stat(releaseDir(installDir, "3.0.0")); !os.IsNotExist(err) {
+		t.Fatal("failed release directory was not removed")
+	}
+
+	// 健康检查失败时恢复原来的版本，并重新执行原来版本的 postInstall
+	req := manifest("4.0.0", "")
+	req.Manifest.HealthCheck = &HealthCheckSpec{
+		Checks: []HealthCheck{{Type: HealthCheckHTTP, URL: srv.URL + "/healthz", ExpectStatus: http.StatusOK}},
+	}
+	result, err = pm.Upgrade(ctx, req)
+	if !errors.Is(err, ErrHealthCheckFailed) || !result.Restored {
+		t.Fatalf("expected restore after failed health check, got %v %+v", err, result)
+	}
+	if v := current(); v != "2.0.0" || result.Hooks[PackageHookRestore] == nil {
+		t.Fatalf("current = %q, hooks = %v after failed health check", v, result.Hooks)
+	}
+	if len(result.HealthChecks) != 1 || !strings.Contains(result.HealthChecks[0].Output, "404") {
+		t.Fatalf("unexpected health check output: %+v", result.HealthChecks)
+	}
+
+	if _, err := pm.Rollback(ctx, &PackageRequest{Name: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := current(); v != "1.0.0" {
+		t.Fatalf("current = %q after rollback", v)
+	}
+
+	list, err := pm.List()
+	if err != nil || len(list) != 1 || list[0].Current != "1.0.0" || list[0].Previous != "2.0.0" || len(list[0].Releases) != 2 {
+		t.Fatalf("unexpected list: %+v, %v", list, err)
+	}
+
+	// 重新部署当前版本失败时保留原来的发布目录
+	marker := filepath.Join(releaseDir(installDir, "1.0.0"), "marker")
+	os.WriteFile(marker, []byte("old"), 0644)
+	result, err = pm.Upgrade(ctx, manifest("1.0.0", "exit 1"))
+	if !errors.Is(err, ErrHookFailed) || !result.Restored {
+		t.Fatalf("expected restore after failed redeploy, got %v %+v", err, result)
+	}
+	if _, err := os.Stat(marker); err != nil || current() != "1.0.0" {
+		t.Fatalf("active release lost after failed redeploy: %v", err)
+	}
+	if _, err = pm.Upgrade(ctx, manifest("1.0.0", "")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) || current() != "1.0.0" {
+		t.Fatal("release directory not replaced after redeploy")
+	}
+	entries, _ := os.ReadDir(filepath.Join(installDir, packageReleasesDir))
+	if len(entries) != 2 {
+		t.Fatalf("unexpected release directories after redeploy: %v", entries)
+	}
+
+	if _, err := pm.Uninstall(ctx, &PackageRequest{Name: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(installDir); !os.IsNotExist(err) {
+		t.Fatal("install directory was not removed")
+	}
+	if list, _ := pm.List(); len(list) != 0 {
+		t.Fatalf("package still listed after uninstall: %+v", list)
+	}
+}
@@ -0,0 +1,205 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrPatchConflict = errors.New("patch does not apply")
+
+// hunk 是 unified diff 中的一段修改
+type hunk struct {
+	oldStart int
+	oldLines []string // 上下文和删除的行，包含换行符
+	newLines []string // 上下文和新增的行，包含换行符
+}
+
+// applyUnifiedDiff 将 unified diff 应用到 orig 上。hunk 的位置可以有偏移，但上下文必须完全一致
+func applyUnifiedDiff(orig []byte, diff string) ([]byte, error) {
+	hunks, err := parseUnifiedDiff(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := splitLines(string(orig))
+	var out strings.Builder
+	cursor := 0
+	shift := 0
+	for i, h := range hunks {
+		want := h.oldStart - 1 + shift
+		if len(h.oldLines) == 0 {
+			// 纯新增的 hunk，"-N,0" 表示插入到第 N 行之后
+			want = h.oldStart + shift
+		}
+		pos := findHunk(lines, h.oldLines, want, cursor)
+		if pos < 0 {
+			return nil, fmt.Errorf("%w: hunk #%d at line %d", ErrPatchConflict, i+1, h.oldStart)
+		}
+
+		for _, line := range lines[cursor:pos] {
+			out.WriteString(line)
+		}
+		for _, line := range h.newLines {
+			out.WriteString(line)
+		}
+		cursor = pos + len(h.oldLines)
+		shift = pos - (h.oldStart - 1)
+		if len(h.oldLines) == 0 {
+			shift = pos - h.oldStart
+		}
+	}
+	for _, line := range lines[cursor:] {
+		out.WriteString(line)
+	}
+	return []byte(out.String()), nil
+}
+
+// findHunk 从期望位置开始向两边查找匹配的位置，不能早于 cursor
+func findHunk(lines, old []string, want, cursor int) int {
+	match := func(pos int) bool {
+		if pos < cursor || pos+len(old) > len(lines) {
+			return false
+		}
+		for i, line := range old {
+			if lines[pos+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+
+	if want < cursor {
+		want = cursor
+	}
+	for delta := 0; want-delta >= cursor || want+delta <= len(lines); delta++ {
+		if match(want + delta) {
+			return want + delta
+		}
+		if delta > 0 && match(want-delta) {
+			return want - delta
+		}
+	}
+	return -1
+}
+
+func parseUnifiedDiff(diff string) ([]*hunk, error) {
+	var (
+		hunks     []*hunk
+		cur       *hunk
+		oldRemain int
+		newRemain int
+		last      byte // 上一行的类型，用于处理 "\ No newline at end of file"
+		headers   int
+	)
+
+	diffLines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for _, line := range diffLines {
+		if cur != nil && oldRemain == 0 && newRemain == 0 && !strings.HasPrefix(line, `\`) {
+			cur = nil
+		}
+
+		switch {
+		case cur == nil && strings.HasPrefix(line, "--- "):
+			if headers++; headers > 1 {
+				return nil, errors.New("patch contains more than one file")
+			}
+		case cur == nil && strings.HasPrefix(line, "@@"):
+			h, oldCount, newCount, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			cur, oldRemain, newRemain = h, oldCount, newCount
+			hunks = append(hunks, cur)
+		case cur == nil:
+			// hunk 之外的说明文字，例如 "diff --git"、"index" 或 "+++ "
+		case strings.HasPrefix(line, `\`):
+			switch last {
+			case ' ':
+				trimLast(cur.oldLines)
+				trimLast(cur.newLines)
+			case '-':
+				trimLast(cur.oldLines)
+			case '+':
+				trimLast(cur.newLines)
+			}
+		case line == "" || line[0] == ' ':
+			text := strings.TrimPrefix(line, " ") + "\n"
+			cur.oldLines = append(cur.oldLines, text)
+			cur.newLines = append(cur.newLines, text)
+			oldRemain--
+			newRemain--
+			last = ' '
+		case line[0] == '-':
+			cur.oldLines = append(cur.oldLines, line[1:]+"\n")
+			oldRemain--
+			last = '-'
+		case line[0] == '+':
+			cur.newLines = append(cur.newLines, line[1:]+"\n")
+			newRemain--
+			last = '+'
+		default:
+			return nil, fmt.Errorf("invalid patch line: %q", line)
+		}
+
+		if oldRemain < 0 || newRemain < 0 {
+			return nil, fmt.Errorf("hunk line count mismatch: %q", line)
+		}
+	}
+
+	if cur != nil && (oldRemain > 0 || newRemain > 0) {
+		return nil, errors.New("patch is truncated")
+	}
+	if len(hunks) == 0 {
+		return nil, errors.New("patch contains no hunks")
+	}
+	return hunks, nil
+}
+
+// parseHunkHeader 解析 "@@ -l,s +l,s @@"，省略的行数默认为 1
+func parseHunkHeader(line string) (h *hunk, oldCount, newCount int, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return nil, 0, 0, fmt.Errorf("invalid hunk header: %q", line)
+	}
+	oldStart, oldCount, err := parseRange(fields[1][1:])
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid hunk header: %q", line)
+	}
+	_, newCount, err = parseRange(fields[2][1:])
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid hunk header: %q", line)
+	}
+	return &hunk{oldStart: oldStart}, oldCount, newCount, nil
+}
+
+func parseRange(s string) (start, count int, err error) {
+	parts := strings.SplitN(s, ",", 2)
+	if start, err = strconv.Atoi(parts[0]); err != nil {
+		return
+	}
+	count = 1
+	if len(parts) == 2 {
+		count, err = strconv.Atoi(parts[1])
+	}
+	return
+}
+
+func trimLast(lines []string) {
+	if len(lines) > 0 {
+		lines[len(lines)-1] = strings.TrimSuffix(lines[len(lines)-1], "\n")
+	}
+}
+
+// splitLines 按行拆分，每行保留换行符
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
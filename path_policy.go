@@ -0,0 +1,174 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"updater/pkg/config"
+)
+
+// PathOp 文件操作类型，对应 config.PathPolicy 中的规则
+type PathOp string
+
+const (
+	PathOpRead    PathOp = "read"
+	PathOpWrite   PathOp = "write"
+	PathOpDelete  PathOp = "delete"
+	PathOpExecute PathOp = "execute"
+)
+
+var ErrPathDenied = errors.New("path denied by policy")
+
+// PathPolicyError 路径违反访问策略
+type PathPolicyError struct {
+	Op       PathOp
+	Path     string // 请求中的路径
+	Resolved string // 解析符号链接后的路径
+	Rule     string // 命中的 deny 前缀，为空表示不在 allow 列表中
+}
+
+func (e *PathPolicyError) Error() string {
+	if e.Rule != "" {
+		return fmt.Sprintf("%s %s: path denied by policy (resolved %s, denied prefix %s)", e.Op, e.Path, e.Resolved, e.Rule)
+	}
+	return fmt.Sprintf("%s %s: path denied by policy (resolved %s, not in allow list)", e.Op, e.Path, e.Resolved)
+}
+
+func (e *PathPolicyError) Is(target error) bool {
+	return target == ErrPathDenied
+}
+
+// CheckPath 检查路径是否允许执行 op 操作。所有文件相关的操作都要经过这里。
+// 无法确定是否允许时（配置没有加载、路径无法解析）一律拒绝
+func (fm *FileManager) CheckPath(op PathOp, path string) error {
+	cfg := config.GetConfig()
+	if cfg == nil {
+		return fmt.Errorf("%s %s: %w: config is not loaded", op, path, ErrPathDenied)
+	}
+
+	var rule config.PathRule
+	switch op {
+	case PathOpRead:
+		rule = cfg.PathPolicy.Read
+	case PathOpWrite:
+		rule = cfg.PathPolicy.Write
+	case PathOpDelete:
+		rule = cfg.PathPolicy.Delete
+	case PathOpExecute:
+		rule = cfg.PathPolicy.Execute
+	default:
+		return fmt.Errorf("unknown path op: %s", op)
+	}
+	if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+		return nil
+	}
+
+	resolved, err := resolvePath(path)
+	if op == PathOpDelete {
+		// 删除符号链接只会删除链接本身，只需要解析父目录
+		resolved, err = resolvePath(filepath.Dir(filepath.Clean(path)))
+		resolved = filepath.Join(resolved, filepath.Base(path))
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range rule.Deny {
+		// deny 前缀无法解析时不能确认路径不在其中
+		if p, err := resolvePath(prefix); err != nil || isWithin(p, resolved) {
+			return &PathPolicyError{Op: op, Path: path, Resolved: resolved, Rule: prefix}
+		}
+	}
+
+	if len(rule.Allow) == 0 {
+		return nil
+	}
+	for _, prefix := range rule.Allow {
+		if p, err := resolvePath(prefix); err == nil && isWithin(p, resolved) {
+			return nil
+		}
+	}
+	return &PathPolicyError{Op: op, Path: path, Resolved: resolved}
+}
+
+// resolvePath 返回绝对路径，按磁盘上的实际情况解析路径中已存在部分的符号链接。
+// 指向不存在文件的符号链接按目标解析，因为写入时会创建链接目标
+func resolvePath(path string) (string, error) {
+	dir := ""
+	if !filepath.IsAbs(path) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		dir = wd
+	}
+	return evalPath(dir, path)
+}
+
+// maxSymlinkHops 是解析一个路径时最多跟随的符号链接数量，与 Linux 的 ELOOP 上限相同
+const maxSymlinkHops = 40
+
+var errUnresolvablePath = errors.New("path contains .. after a missing component")
+
+// evalPath 按磁盘上的实际情况逐级解析 dir 下的 path（path 为绝对路径时忽略 dir），返回真实路径。
+// 不能像 filepath.Clean 那样先按文本消去 ".."：a 是符号链接时 "a/.." 不一定是 "."。
+// 不存在的部分直接拼接，其中包含 ".." 时无法确定结果，返回错误
+func evalPath(dir, path string) (string, error) {
+	cur := dir
+	if filepath.IsAbs(path) {
+		vol := filepath.VolumeName(path)
+		cur, path = vol+string(filepath.Separator), path[len(vol):]
+	}
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	missing := false
+	for hops := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if missing {
+				return "", errUnresolvablePath
+			}
+			cur = filepath.Dir(cur)
+			continue
+		}
+
+		next := filepath.Join(cur, part)
+		if missing {
+			cur = next
+			continue
+		}
+		info, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			missing = true
+			cur = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+
+		if hops++; hops > maxSymlinkHops {
+			return "", fmt.Errorf("too many levels of symbolic links: %s", next)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		// 相对路径的链接目标相对于链接所在的目录，即 cur
+		if filepath.IsAbs(target) {
+			vol := filepath.VolumeName(target)
+			cur, target = vol+string(filepath.Separator), target[len(vol):]
+		}
+		parts = append(strings.Split(filepath.ToSlash(target), "/"), parts...)
+	}
+	return cur, nil
+}
@@ -0,0 +1,108 @@
+package updater
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"updater/pkg/config"
+)
+
+func TestCheckPath(t *testing.T) {
+	root := t.TempDir()
+	allowed := filepath.Join(root, "allowed")
+	secret := filepath.Join(root, "secret")
+	os.MkdirAll(allowed, 0755)
+	os.MkdirAll(secret, 0755)
+	os.Symlink(secret, filepath.Join(allowed, "link"))
+	os.Symlink(filepath.Join(secret, "new"), filepath.Join(allowed, "dangling"))
+
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{
+		PathPolicy: config.PathPolicy{
+			Write: config.PathRule{
+				Allow: []string{allowed},
+				Deny:  []string{secret},
+			},
+			Delete: config.PathRule{
+				Deny: []string{secret},
+			},
+		},
+	})
+
+	fm := NewFileManager()
+	cases := []struct {
+		op     PathOp
+		path   string
+		denied bool
+	}{
+		{PathOpWrite, filepath.Join(allowed, "a", "b.txt"), false},
+		{PathOpWrite, filepath.Join(root, "other.txt"), true},
+		{PathOpWrite, filepath.Join(allowed, "..", "secret", "x"), true},
+		{PathOpWrite, filepath.Join(allowed, "link", "x"), true},
+		{PathOpWrite, filepath.Join(allowed, "dangling"), true},
+		{PathOpDelete, filepath.Join(allowed, "link"), false},
+		{PathOpDelete, filepath.Join(allowed, "link", "x"), true},
+		{PathOpRead, filepath.Join(secret, "x"), false},
+	}
+	for _, c := range cases {
+		err := fm.CheckPath(c.op, c.path)
+		if denied := errors.Is(err, ErrPathDenied); denied != c.denied {
+			t.Errorf("%s %s: err = %v, want denied = %v", c.op, c.path, err, c.denied)
+		}
+	}
+}
+
+func TestCheckPathFailsClosed(t *testing.T) {
+	root := t.TempDir()
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	fm := NewFileManager()
+
+	config.SetConfig(nil)
+	if err := fm.CheckPath(PathOpRead, root); !errors.Is(err, ErrPathDenied) {
+		t.Errorf("without config: err = %v, want denied", err)
+	}
+
+	// deny 前缀无法解析时拒绝
+	loop := filepath.Join(root, "loop")
+	os.Symlink(loop, loop)
+	config.SetConfig(&config.Config{PathPolicy: config.PathPolicy{
+		Write: config.PathRule{Deny: []string{filepath.Join(loop, "x")}},
+	}})
+	if err := fm.CheckPath(PathOpWrite, filepath.Join(root, "a")); !errors.Is(err, ErrPathDenied) {
+		t.Errorf("unresolvable deny prefix: err = %v, want denied", err)
+	}
+
+	// a -> . 时 a/a/../secret 在磁盘上是 root 的父目录下的 secret
+	secret := filepath.Join(filepath.Dir(root), "secret")
+	os.Symlink(".", filepath.Join(root, "a"))
+	config.SetConfig(&config.Config{PathPolicy: config.PathPolicy{
+		Write: config.PathRule{Deny: []string{secret}},
+	}})
+	if err := fm.CheckPath(PathOpWrite, root+"/a/a/../secret/x"); !errors.Is(err, ErrPathDenied) {
+		t.Errorf("dotdot through symlink: err = %v, want denied", err)
+	}
+}
+
+func TestMoveFileDeniedCreatesNothing(t *testing.T) {
+	root := t.TempDir()
+	secret := filepath.Join(root, "secret")
+	src := filepath.Join(root, "src.txt")
+	os.WriteFile(src, []byte("x"), 0644)
+
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{PathPolicy: config.PathPolicy{
+		Write: config.PathRule{Deny: []string{secret}},
+	}})
+
+	err := NewFileManager().MoveFile(&MoveRequest{Src: src, Dest: filepath.Join(secret, "a", "b.txt"), AutoCreateDir: true})
+	if !errors.Is(err, ErrPathDenied) {
+		t.Fatalf("err = %v, want denied", err)
+	}
+	if _, err := os.Stat(secret); !os.IsNotExist(err) {
+		t.Fatal("denied move created directories")
+	}
+}
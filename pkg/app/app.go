@@ -1,6 +1,7 @@
 package app
 
 import (
+	"fmt"
 	"updater/pkg/config"
 	"updater/pkg/logger"
 	"updater/pkg/task"
@@ -13,16 +14,22 @@ type App struct {
 	TaskStore   *task.TaskStore
 }
 
-func NewApp() *App {
+// NewApp 使用已经加载的配置和默认日志创建 App，需要先调用 config.InitConfig
+func NewApp() (*App, error) {
 	var err error
 	app := &App{}
-	config.InitConfig()
 	app.Config = config.GetConfig()
-	app.Logger = logger.NewLogger(app.Config.LogConfig)
+	if app.Config == nil {
+		return nil, fmt.Errorf("config is not initialized")
+	}
+	app.Logger = logger.GetLogger()
+	if app.Logger == nil {
+		app.Logger = logger.NewLogger(app.Config.LogConfig)
+	}
 	app.TaskManager = task.NewTaskManager()
 	app.TaskStore, err = task.NewTaskStore(app.Config.TaskStorePath)
 	if err != nil {
-		app.Logger.Fatalf("初始化任务存储失败: %s", err)
+		return nil, fmt.Errorf("init task store: %w", err)
 	}
-	return app
+	return app, nil
 }
@@ -1,22 +1,89 @@
 package config
 
 import (
-	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 )
 
-const (
-	Version = "0.0.1"
-)
+// Version 是客户端版本，构建时通过 -ldflags "-X updater/pkg/config.Version=x.y.z" 设置
+var Version = "0.0.1"
 
 type Config struct {
-	ServerAddress []string  `json:"serverAddress"` // 代理服务器地址
-	LogConfig     LogConfig `json:"logConfig"`
-	TaskStorePath string    `json:"taskStorePath"` // 任务存储路径
+	ServerAddress      []string      `json:"serverAddress"` // 代理服务器地址
+	LogConfig          LogConfig     `json:"logConfig"`
+	TaskStorePath      string        `json:"taskStorePath"`      // 任务存储路径
+	PathPolicy         PathPolicy    `json:"pathPolicy"`         // 文件操作的路径访问策略
+	BackupDir          string        `json:"backupDir"`          // 修改文件前的备份目录
+	WatchStorePath     string        `json:"watchStorePath"`     // 文件监听的持久化路径
+	WatchLimit         int           `json:"watchLimit"`         // 文件监听的数量上限
+	UpdateDir          string        `json:"updateDir"`          // 自更新的状态目录
+	UpdatePubKey       string        `json:"updatePubKey"`       // 校验自更新二进制签名的 ed25519 公钥（base64）
+	PackageStorePath   string        `json:"packageStorePath"`   // 已安装软件包的存储路径
+	InventoryStorePath string        `json:"inventoryStorePath"` // 上次上报的系统软件清单，用于计算增量
+	ScheduleStorePath  string        `json:"scheduleStorePath"`  // 定时任务的持久化路径
+	ScheduleSpoolDir   string        `json:"scheduleSpoolDir"`   // 等待上报的定时任务执行结果目录
+	ScheduleLimit      int           `json:"scheduleLimit"`      // 定时任务的数量上限
+	ScheduleSpoolLimit int           `json:"scheduleSpoolLimit"` // 最多保留的未上报执行结果数量
+	ServiceManager     string        `json:"serviceManager"`     // 服务管理的实现，为空时使用当前平台的默认实现
+	ProcessPolicy      ProcessPolicy `json:"processPolicy"`      // 发送信号的进程访问策略
+	FactsDir           string        `json:"factsDir"`           // fact 脚本目录，其中的可执行文件输出自定义的主机信息
+	FactScriptTimeout  int           `json:"factScriptTimeout"`  // 每个 fact 脚本的超时时间（秒）
+	MetricsInterval    int           `json:"metricsInterval"`    // 系统指标采样间隔（秒），0 表示不采集
+	MetricsBatchSize   int           `json:"metricsBatchSize"`   // 每条 Metrics 消息包含的采样数量
+	MetricsBufferLimit int           `json:"metricsBufferLimit"` // 断开连接时最多缓存的采样数量
+	TaskLogDir         string        `json:"taskLogDir"`         // 每个任务单独的日志目录
+	TaskLogRetention   int           `json:"taskLogRetention"`   // 任务日志保留天数
+	TaskLogMaxSize     int           `json:"taskLogMaxSize"`     // 每个任务日志的最大大小（KB），超过后不再记录
+	Monitor            MonitorConfig `json:"monitor"`            // 本地监控接口
+	Tracing            TracingConfig `json:"tracing"`            // 消息处理的链路追踪
+	AuditDir           string        `json:"auditDir"`           // 审计日志目录
+	AuditMaxSize       int           `json:"auditMaxSize"`       // 审计日志文件的最大大小（MB），超过后轮转
+	AuditRetention     int           `json:"auditRetention"`     // 轮转的审计日志保留天数
+}
+
+// PathPolicy 按操作类型限制可以访问的路径
+type PathPolicy struct {
+	Read    PathRule `json:"read"`    // 读取、上传、打包源
+	Write   PathRule `json:"write"`   // 下载、写入、解压目标
+	Delete  PathRule `json:"delete"`  // 删除、移动源
+	Execute PathRule `json:"execute"` // 脚本解释器和工作目录
+}
+
+// PathRule 路径前缀规则，deny 优先于 allow，allow 为空表示不限制
+type PathRule struct {
+	Allow []string `json:"allow"` // 允许的路径前缀
+	Deny  []string `json:"deny"`  // 禁止的路径前缀
+}
+
+// ProcessPolicy 限制 v1/Process/Signal 可以发送的信号和目标进程。
+// allow 和 users 都没有配置时不允许向任何进程发送信号
+type ProcessPolicy struct {
+	Signals    []string `json:"signals"`    // 允许的信号，为空时只允许 TERM、INT、HUP、QUIT、USR1、USR2
+	Allow      []string `json:"allow"`      // 允许的进程名 glob，为空时只按 users 限制
+	Deny       []string `json:"deny"`       // 禁止的进程名 glob，优先于 allow
+	Users      []string `json:"users"`      // 允许的进程用户，为空时只按 allow 限制
+	MaxTargets int      `json:"maxTargets"` // 一次最多向多少个进程发送信号，0 使用默认值
+}
+
+// MonitorConfig 本地 HTTP 监听，提供 /metrics、/healthz、/readyz 和 pprof
+type MonitorConfig struct {
+	Enabled bool   `json:"enabled"` // 是否启用
+	Listen  string `json:"listen"`  // 监听地址 host:port，或者 unix:/path/to.sock
+	Pprof   bool   `json:"pprof"`   // 是否提供 /debug/pprof/
+}
+
+// TracingConfig 记录消息处理、脚本执行、下载和文件操作的 span，导出到 OTLP collector 或者本地文件
+type TracingConfig struct {
+	Enabled       bool     `json:"enabled"`               // 是否启用
+	Exporter      string   `json:"exporter"`              // otlp 或者 file
+	Endpoint      string   `json:"endpoint"`              // OTLP/HTTP 地址，例如 http://127.0.0.1:4318
+	Headers       []string `json:"headers" secret:"true"` // 发送到 OTLP 的请求头，格式为 Name: value
+	File          string   `json:"file"`                  // file exporter 的输出文件
+	SamplePercent int      `json:"samplePercent"`         // 没有父 span 时的采样比例（1-100），0 使用默认值 100
 }
 
 type LogConfig struct {
@@ -30,38 +97,130 @@ type LogConfig struct {
 
 }
 
-var (
-	config *Config
-)
+var current atomic.Pointer[Config]
 
-func InitConfig() {
-	loadConfigFile()
+// InitConfig 从默认值、配置文件和 UPDATER_* 环境变量加载配置，成功后替换当前配置
+func InitConfig() error {
+	c, err := NewLoader("", nil).Load()
+	if err != nil {
+		return err
+	}
+	SetConfig(c)
+	return nil
 }
 
-func loadConfigFile() {
-
-	configFile := os.Getenv("CONFIG_FILE")
-	if configFile == "" {
-		configFile = "config.json"
-	}
+// LoadFile 只从默认值和配置文件加载配置
+func LoadFile(path string) (*Config, error) {
+	return (&Loader{File: path}).Load()
+}
 
-	b, err := ioutil.ReadFile(configFile)
+// WriteFile 原子地写入配置文件，写入过程中退出不会留下不完整的文件
+func WriteFile(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
 	if err != nil {
-		log.Fatal("读取配置文件失败")
+		return err
 	}
-	config = new(Config)
-	err = json.Unmarshal(b, config)
-	if err != nil {
-		log.Fatal("解析配置文件失败")
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
 	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
 
-	if config.TaskStorePath == "" {
-		config.TaskStorePath = ".data/tasks"
+// SetDefaults 填充没有配置的项
+func (c *Config) SetDefaults() {
+	if c.TaskStorePath == "" {
+		c.TaskStorePath = ".data/tasks"
+	}
+	if c.BackupDir == "" {
+		c.BackupDir = ".data/backups"
+	}
+	if c.WatchStorePath == "" {
+		c.WatchStorePath = ".data/watches.json"
+	}
+	if c.WatchLimit <= 0 {
+		c.WatchLimit = 32
+	}
+	if c.UpdateDir == "" {
+		c.UpdateDir = ".data/update"
+	}
+	if c.PackageStorePath == "" {
+		c.PackageStorePath = ".data/packages.json"
+	}
+	if c.InventoryStorePath == "" {
+		c.InventoryStorePath = ".data/inventory.json"
+	}
+	if c.ScheduleStorePath == "" {
+		c.ScheduleStorePath = ".data/schedules.json"
+	}
+	if c.ScheduleSpoolDir == "" {
+		c.ScheduleSpoolDir = ".data/schedule-results"
+	}
+	if c.ScheduleLimit <= 0 {
+		c.ScheduleLimit = 64
+	}
+	if c.ScheduleSpoolLimit <= 0 {
+		c.ScheduleSpoolLimit = 1000
+	}
+	if c.MetricsBatchSize <= 0 {
+		c.MetricsBatchSize = 10
+	}
+	if c.MetricsBufferLimit <= 0 {
+		c.MetricsBufferLimit = 1440
+	}
+	if c.FactsDir == "" {
+		c.FactsDir = ".data/facts.d"
+	}
+	if c.Monitor.Listen == "" {
+		c.Monitor.Listen = "127.0.0.1:9464"
+	}
+	if c.Tracing.Exporter == "" {
+		c.Tracing.Exporter = "file"
+	}
+	if c.Tracing.File == "" {
+		c.Tracing.File = ".data/traces.jsonl"
+	}
+	if c.Tracing.SamplePercent <= 0 {
+		c.Tracing.SamplePercent = 100
+	}
+	if c.AuditDir == "" {
+		c.AuditDir = ".data/audit"
+	}
+	if c.AuditMaxSize <= 0 {
+		c.AuditMaxSize = 10
+	}
+	if c.AuditRetention <= 0 {
+		c.AuditRetention = 90
+	}
+	if c.TaskLogDir == "" {
+		c.TaskLogDir = ".data/task-logs"
+	}
+	if c.TaskLogRetention <= 0 {
+		c.TaskLogRetention = 7
+	}
+	if c.TaskLogMaxSize <= 0 {
+		c.TaskLogMaxSize = 1024
 	}
 }
 
 func GetConfig() *Config {
-	return config
+	return current.Load()
+}
+
+// SetConfig 替换当前配置
+func SetConfig(c *Config) {
+	current.Store(c)
 }
 
 func GetLocalIPs() (string, error) {
@@ -83,7 +242,6 @@ func GetLocalIPs() (string, error) {
 		// 获取接口的地址列表
 		addrs, err := iface.Addrs()
 		if err != nil {
-			log.Println(err)
 			continue
 		}
 
@@ -0,0 +1,404 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix 是覆盖配置项的环境变量前缀，例如 UPDATER_LOG_CONFIG_LEVEL 对应 logConfig.level
+const EnvPrefix = "UPDATER_"
+
+// defaultFiles 是没有指定配置文件时依次查找的文件
+var defaultFiles = []string{"config.json", "config.yaml", "config.yml"}
+
+// Loader 按默认值、配置文件、UPDATER_* 环境变量、命令行参数的顺序合并配置，后面的覆盖前面的
+type Loader struct {
+	File string            // 配置文件，为空时使用 CONFIG_FILE 环境变量，或者查找 config.json、config.yaml、config.yml
+	Env  []string          // KEY=value 形式的环境变量，通常为 os.Environ()
+	Set  map[string]string // 命令行参数设置的配置项，key 是 JSON 路径，例如 logConfig.level
+}
+
+// NewLoader 创建使用当前进程环境变量的 Loader
+func NewLoader(file string, set map[string]string) *Loader {
+	return &Loader{File: file, Env: os.Environ(), Set: set}
+}
+
+// explicitFile 返回明确指定的配置文件，没有指定时返回空
+func (l *Loader) explicitFile() string {
+	if l.File != "" {
+		return l.File
+	}
+	return l.getenv("CONFIG_FILE")
+}
+
+// Path 返回配置文件路径。没有指定也没有找到时返回 config.json，配置更新时写入这个文件
+func (l *Loader) Path() string {
+	if f := l.explicitFile(); f != "" {
+		return f
+	}
+	for _, f := range defaultFiles {
+		if _, err := os.Stat(f); err == nil {
+			return f
+		}
+	}
+	return defaultFiles[0]
+}
+
+// Load 读取并合并所有来源的配置。指定的配置文件不存在时返回错误，
+// 没有指定时配置文件是可选的，可以只使用环境变量和命令行参数
+func (l *Loader) Load() (*Config, error) {
+	path := l.Path()
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && l.explicitFile() == "" {
+		b, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config %s: %w", path, err)
+	}
+	if b == nil {
+		// 没有配置文件时只使用默认值、环境变量和命令行参数
+		path = "(no config file)"
+	}
+	c, err := l.Parse(b, false)
+	if err != nil {
+		return nil, fmt.Errorf("load config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse 使用 b 作为配置文件的内容合并配置，填充默认值并校验。strict 为 true 时不允许未知的配置项
+func (l *Loader) Parse(b []byte, strict bool) (*Config, error) {
+	doc, err := l.decode(b)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := configFields()
+	v := &ValidationError{}
+	for _, kv := range l.Env {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		f, ok := fieldByEnv(fields, name)
+		if !ok {
+			v.add(name, "unknown config key")
+			continue
+		}
+		if err := f.set(doc, value); err != nil {
+			v.add(f.key, "%s: %v", name, err)
+		}
+	}
+	keys := make([]string, 0, len(l.Set))
+	for k := range l.Set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f, ok := fields[k]
+		if !ok {
+			v.add(k, "unknown config key")
+			continue
+		}
+		if err := f.set(doc, l.Set[k]); err != nil {
+			v.add(k, "%v", err)
+		}
+	}
+	if len(v.Fields) > 0 {
+		v.sort()
+		return nil, v
+	}
+
+	merged, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(c); err != nil {
+		return nil, decodeError(err)
+	}
+	c.SetDefaults()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ToJSON 把配置文件的内容转换为 JSON
+func (l *Loader) ToJSON(b []byte) ([]byte, error) {
+	doc, err := l.decode(b)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// FromJSON 把 JSON 转换为配置文件的格式
+func (l *Loader) FromJSON(b []byte) ([]byte, error) {
+	if !l.isYAML() {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, b, "", "  "); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+func (l *Loader) isYAML() bool {
+	ext := strings.ToLower(filepath.Ext(l.Path()))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// decode 按文件扩展名解析 JSON 或 YAML，内容为空时返回空的配置
+func (l *Loader) decode(b []byte) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	if len(bytes.TrimSpace(b)) == 0 {
+		return doc, nil
+	}
+	if l.isYAML() {
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("parse yaml: %w", err)
+		}
+		// 通过 JSON 统一处理，YAML 的键与 JSON 的字段名相同
+		jb, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("parse yaml: %w", err)
+		}
+		b = jb
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, decodeError(err)
+	}
+	return doc, nil
+}
+
+func (l *Loader) getenv(name string) string {
+	for i := len(l.Env) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(l.Env[i], "="); ok && k == name {
+			return v
+		}
+	}
+	return ""
+}
+
+// decodeError 把 JSON 的类型错误和未知字段转换为带配置项名称的错误
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		v := &ValidationError{}
+		v.add(typeErr.Field, "must be %s, got %s", typeName(typeErr.Type), typeErr.Value)
+		return v
+	}
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		v := &ValidationError{}
+		v.add(strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`), "unknown config key")
+		return v
+	}
+	return fmt.Errorf("parse config: %w", err)
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int:
+		return "an integer"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
+
+// configField 是一个可以通过环境变量和命令行参数设置的配置项
+type configField struct {
+	key    string // JSON 路径，例如 logConfig.level
+	env    string // 环境变量名称，例如 UPDATER_LOG_CONFIG_LEVEL
+	kind   reflect.Kind
+	secret bool
+}
+
+// configFields 返回 Config 中所有的叶子配置项
+func configFields() map[string]*configField {
+	fields := make(map[string]*configField)
+	var walk func(t reflect.Type, path []string)
+	walk = func(t reflect.Type, path []string) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name := strings.Split(sf.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			p := append(append([]string(nil), path...), name)
+			if sf.Type.Kind() == reflect.Struct {
+				walk(sf.Type, p)
+				continue
+			}
+			env := make([]string, len(p))
+			for i, seg := range p {
+				env[i] = snakeUpper(seg)
+			}
+			key := strings.Join(p, ".")
+			fields[key] = &configField{
+				key:    key,
+				env:    EnvPrefix + strings.Join(env, "_"),
+				kind:   sf.Type.Kind(),
+				secret: sf.Tag.Get("secret") == "true",
+			}
+		}
+	}
+	walk(reflect.TypeOf(Config{}), nil)
+	return fields
+}
+
+func fieldByEnv(fields map[string]*configField, env string) (*configField, bool) {
+	for _, f := range fields {
+		if f.env == env {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// snakeUpper 把 camelCase 转换为 CAMEL_CASE
+func snakeUpper(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// set 按配置项的类型解析字符串，写入 doc 中对应的位置。列表使用逗号分隔或者 JSON 数组
+func (f *configField) set(doc map[string]interface{}, value string) error {
+	var v interface{}
+	switch f.kind {
+	case reflect.String:
+		v = value
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("must be an integer, got %q", value)
+		}
+		v = n
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("must be a boolean, got %q", value)
+		}
+		v = b
+	case reflect.Slice:
+		list := []string{}
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			if err := json.Unmarshal([]byte(value), &list); err != nil {
+				return fmt.Errorf("must be a list: %v", err)
+			}
+		} else {
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+		}
+		v = list
+	default:
+		return fmt.Errorf("unsupported type %s", f.kind)
+	}
+
+	path := strings.Split(f.key, ".")
+	m := doc
+	for _, seg := range path[:len(path)-1] {
+		sub, ok := m[seg].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			m[seg] = sub
+		}
+		m = sub
+	}
+	m[path[len(path)-1]] = v
+	return nil
+}
+
+// Redacted 返回隐藏了敏感信息的配置：标记为 secret 的配置项和服务器地址中的密码
+func Redacted(c *Config) map[string]interface{} {
+	b, _ := json.Marshal(c)
+	doc := make(map[string]interface{})
+	json.Unmarshal(b, &doc)
+
+	for key, f := range configFields() {
+		path := strings.Split(key, ".")
+		m := doc
+		for _, seg := range path[:len(path)-1] {
+			m, _ = m[seg].(map[string]interface{})
+		}
+		if m == nil {
+			continue
+		}
+		last := path[len(path)-1]
+		switch {
+		case f.secret:
+			if s, _ := m[last].(string); s != "" {
+				m[last] = "xxxxx"
+			}
+			// 列表只保留 Name: 部分，例如请求头
+			if list, ok := m[last].([]interface{}); ok {
+				for i, v := range list {
+					name, _, _ := strings.Cut(fmt.Sprint(v), ":")
+					list[i] = name + ": xxxxx"
+				}
+			}
+		case key == "serverAddress":
+			list, _ := m[last].([]interface{})
+			for i, s := range list {
+				if u, err := url.Parse(fmt.Sprint(s)); err == nil {
+					list[i] = redactURL(u)
+				}
+			}
+		}
+	}
+	return doc
+}
+
+// redactURL 隐藏密码和查询参数的值，查询参数中通常带有 token
+func redactURL(u *url.URL) string {
+	if u.RawQuery != "" {
+		keys := make([]string, 0)
+		for k := range u.Query() {
+			keys = append(keys, url.QueryEscape(k)+"=xxxxx")
+		}
+		sort.Strings(keys)
+		u.RawQuery = strings.Join(keys, "&")
+	}
+	return u.Redacted()
+}
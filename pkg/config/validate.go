@@ -0,0 +1,151 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+// ValidationError 包含所有不合法的配置项
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// FieldError 是一个不合法的配置项，Key 是 JSON 中的路径，例如 logConfig.level
+type FieldError struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Key + ": " + f.Message
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(key, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// Validate 校验配置，返回的错误是 *ValidationError
+func (c *Config) Validate() error {
+	v := &ValidationError{}
+	fail := v.add
+
+	if len(c.ServerAddress) == 0 {
+		fail("serverAddress", "at least one server is required")
+	}
+	for i, addr := range c.ServerAddress {
+		u, err := url.Parse(addr)
+		if err != nil {
+			fail(fmt.Sprintf("serverAddress[%d]", i), "%v", err)
+		} else if (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			fail(fmt.Sprintf("serverAddress[%d]", i), "must be a ws:// or wss:// url")
+		}
+	}
+
+	if c.LogConfig.Level != "" {
+		var level zapcore.Level
+		if err := level.Set(c.LogConfig.Level); err != nil {
+			fail("logConfig.level", "unknown level %q", c.LogConfig.Level)
+		}
+	}
+	if f := c.LogConfig.Format; f != "" && f != "json" && f != "console" {
+		fail("logConfig.format", "must be json or console")
+	}
+	for key, n := range map[string]int{
+		"logConfig.maxSize":        c.LogConfig.MaxSize,
+		"logConfig.maxAge":         c.LogConfig.MaxAge,
+		"factScriptTimeout":        c.FactScriptTimeout,
+		"metricsInterval":          c.MetricsInterval,
+		"taskLogRetention":         c.TaskLogRetention,
+		"taskLogMaxSize":           c.TaskLogMaxSize,
+		"auditMaxSize":             c.AuditMaxSize,
+		"auditRetention":           c.AuditRetention,
+		"processPolicy.maxTargets": c.ProcessPolicy.MaxTargets,
+	} {
+		if n < 0 {
+			fail(key, "must not be negative")
+		}
+	}
+
+	for op, rule := range map[string]PathRule{
+		"read":    c.PathPolicy.Read,
+		"write":   c.PathPolicy.Write,
+		"delete":  c.PathPolicy.Delete,
+		"execute": c.PathPolicy.Execute,
+	} {
+		for kind, paths := range map[string][]string{"allow": rule.Allow, "deny": rule.Deny} {
+			for i, p := range paths {
+				if !filepath.IsAbs(p) {
+					fail(fmt.Sprintf("pathPolicy.%s.%s[%d]", op, kind, i), "must be an absolute path")
+				}
+			}
+		}
+	}
+	for kind, patterns := range map[string][]string{"allow": c.ProcessPolicy.Allow, "deny": c.ProcessPolicy.Deny} {
+		for i, p := range patterns {
+			if _, err := filepath.Match(p, ""); err != nil {
+				fail(fmt.Sprintf("processPolicy.%s[%d]", kind, i), "invalid pattern %q", p)
+			}
+		}
+	}
+
+	if c.UpdatePubKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(c.UpdatePubKey); err != nil || len(key) != 32 {
+			fail("updatePubKey", "must be a base64 encoded ed25519 public key")
+		}
+	}
+
+	if l := c.Monitor.Listen; l != "" && !strings.HasPrefix(l, "unix:") {
+		if _, _, err := net.SplitHostPort(l); err != nil {
+			fail("monitor.listen", "must be host:port or unix:/path")
+		}
+	}
+
+	if t := c.Tracing; t.Enabled {
+		switch t.Exporter {
+		case "otlp":
+			if u, err := url.Parse(t.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("tracing.endpoint", "must be an http:// or https:// url")
+			}
+		case "file", "":
+		default:
+			fail("tracing.exporter", "must be otlp or file")
+		}
+	}
+	for i, h := range c.Tracing.Headers {
+		if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+			fail(fmt.Sprintf("tracing.headers[%d]", i), "must be Name: value")
+		}
+	}
+	if c.Tracing.SamplePercent > 100 {
+		fail("tracing.samplePercent", "must not be greater than 100")
+	}
+
+	if len(v.Fields) == 0 {
+		return nil
+	}
+	v.sort()
+	return v
+}
+
+// sort 按配置项排序，保证错误信息稳定
+func (e *ValidationError) sort() {
+	sort.Slice(e.Fields, func(i, j int) bool { return e.Fields[i].Key < e.Fields[j].Key })
+}
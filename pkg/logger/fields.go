@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	TraceIDKey = "traceId"
+	TaskIDKey  = "taskId"
+)
+
+// requiredFieldsCore 保证每条日志都有 traceId 和 taskId，没有设置时为空字符串，便于按字段检索
+type requiredFieldsCore struct {
+	zapcore.Core
+	hasTrace, hasTask bool
+}
+
+func withRequiredFields(core zapcore.Core) zapcore.Core {
+	return &requiredFieldsCore{Core: core}
+}
+
+func (c *requiredFieldsCore) With(fields []zapcore.Field) zapcore.Core {
+	hasTrace, hasTask := c.hasTrace, c.hasTask
+	for _, f := range fields {
+		hasTrace = hasTrace || f.Key == TraceIDKey
+		hasTask = hasTask || f.Key == TaskIDKey
+	}
+	return &requiredFieldsCore{Core: c.Core.With(fields), hasTrace: hasTrace, hasTask: hasTask}
+}
+
+func (c *requiredFieldsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *requiredFieldsCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	hasTrace, hasTask := c.hasTrace, c.hasTask
+	for _, f := range fields {
+		hasTrace = hasTrace || f.Key == TraceIDKey
+		hasTask = hasTask || f.Key == TaskIDKey
+	}
+	if !hasTrace {
+		fields = append(fields, zap.String(TraceIDKey, ""))
+	}
+	if !hasTask {
+		fields = append(fields, zap.String(TaskIDKey, ""))
+	}
+	return c.Core.Write(ent, fields)
+}
@@ -1,6 +1,9 @@
 package logger
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"updater/pkg/config"
 
 	"go.uber.org/zap"
@@ -10,27 +13,60 @@ import (
 
 type Logger struct {
 	*zap.SugaredLogger
+	level *zap.AtomicLevel // 由 NewLogger 创建的日志和派生的日志共享，可以在运行时修改
 }
 
 func (l *Logger) With(args ...interface{}) *Logger {
-	return &Logger{l.SugaredLogger.With(args...)}
+	return &Logger{l.SugaredLogger.With(args...), l.level}
+}
+
+// WithTrace 返回带有 traceId 和 taskId 的日志
+func (l *Logger) WithTrace(traceID, taskID string) *Logger {
+	return l.With(zap.String(TraceIDKey, traceID), zap.String(TaskIDKey, taskID))
+}
+
+// Tee 返回同时以 JSON 格式写入 w 的日志，w 记录所有级别，不受 SetLevel 影响
+func (l *Logger) Tee(w zapcore.WriteSyncer) *Logger {
+	core := zapcore.NewCore(getEncoder("json"), w, zapcore.DebugLevel)
+	tee := zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(c, core)
+	})
+	return &Logger{l.Desugar().WithOptions(tee).Sugar(), l.level}
+}
+
+// SetLevel 修改日志级别，对所有派生的日志同时生效
+func (l *Logger) SetLevel(level string) error {
+	if l.level == nil {
+		return fmt.Errorf("logger level is not adjustable")
+	}
+	return l.level.UnmarshalText([]byte(level))
+}
+
+// Level 返回当前的日志级别
+func (l *Logger) Level() string {
+	if l.level == nil {
+		return l.SugaredLogger.Level().String()
+	}
+	return l.level.String()
 }
 
 func NewLogger(config config.LogConfig) *Logger {
 	writeSyncer := getLogWriter(config)
 	encoder := getEncoder(config.Format)
 
-	var logLevel zapcore.Level
-	err := logLevel.Set(config.Level)
-	if err != nil {
-		logLevel = zap.InfoLevel
+	logLevel := zap.NewAtomicLevel()
+	if err := logLevel.UnmarshalText([]byte(config.Level)); err != nil {
+		logLevel.SetLevel(zap.InfoLevel)
 	}
 
-	core := zapcore.NewCore(encoder, writeSyncer, logLevel)
+	if config.ShowConsole {
+		writeSyncer = zapcore.NewMultiWriteSyncer(writeSyncer, zapcore.Lock(zapcore.AddSync(os.Stdout)))
+	}
+	core := withRequiredFields(zapcore.NewCore(encoder, writeSyncer, logLevel))
 
 	logger := zap.New(core, zap.AddCaller())
 
-	return &Logger{logger.Sugar()}
+	return &Logger{logger.Sugar(), &logLevel}
 }
 
 func getEncoder(format string) zapcore.Encoder {
@@ -48,6 +84,14 @@ func getEncoder(format string) zapcore.Encoder {
 	}
 }
 
+// FileName 返回日志文件路径，没有配置时与 lumberjack 的默认路径相同
+func FileName(config config.LogConfig) string {
+	if config.Filename != "" {
+		return config.Filename
+	}
+	return filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+"-lumberjack.log")
+}
+
 func getLogWriter(config config.LogConfig) zapcore.WriteSyncer {
 	lumberJackLogger := &lumberjack.Logger{
 		Filename:   config.Filename,
@@ -72,12 +116,14 @@ func (l *Logger) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// Println 与 log.Println 一样在参数之间添加空格
 func (l *Logger) Println(args ...interface{}) {
-	l.Info(args...)
+	l.Infoln(args...)
 }
 
 var defaultLogger *Logger
 
+// InitLogger 使用当前配置创建默认日志
 func InitLogger() {
 	defaultLogger = NewLogger(config.GetConfig().LogConfig)
 }
@@ -111,5 +157,5 @@ func Fatalf(format string, args ...interface{}) {
 }
 
 func Println(args ...interface{}) {
-	defaultLogger.Info(args...)
+	defaultLogger.Infoln(args...)
 }
@@ -0,0 +1,329 @@
+// Package prom 以 Prometheus 文本格式输出指标，只实现 agent 需要的 counter、gauge 和 histogram
+package prom
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets 是 histogram 默认的桶（秒），与 Prometheus 客户端一致
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry 保存注册的指标，按注册顺序输出
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("prom: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Write 以 Prometheus 文本格式（version 0.0.4）输出所有指标
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+}
+
+// Counter 是只增加的计数
+type Counter struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add 增加 v，v 小于 0 时忽略
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.v += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v
+}
+
+// CounterVec 是按标签区分的一组 Counter
+type CounterVec struct {
+	desc
+	vec vec[*Counter]
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{desc: desc{name, help, "counter", labels}, vec: newVec(len(labels), func() *Counter { return new(Counter) })}
+	r.register(name, cv)
+	return cv
+}
+
+// With 返回标签值对应的 Counter，标签值的数量必须与注册时的标签一致
+func (cv *CounterVec) With(values ...string) *Counter {
+	return cv.vec.with(values)
+}
+
+func (cv *CounterVec) write(w *bufio.Writer) {
+	cv.writeHeader(w)
+	cv.vec.each(func(values []string, c *Counter) {
+		writeSample(w, cv.name, cv.labels, values, "", "", c.Value())
+	})
+}
+
+// Gauge 是可以任意设置的值
+type Gauge struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.v += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+type gauge struct {
+	desc
+	g *Gauge
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &gauge{desc: desc{name, help, "gauge", nil}, g: new(Gauge)}
+	r.register(name, g)
+	return g.g
+}
+
+func (g *gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	writeSample(w, g.name, nil, nil, "", "", g.g.Value())
+}
+
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc 注册一个在输出时调用 fn 取值的 gauge
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &gaugeFunc{desc: desc{name, help, "gauge", nil}, fn: fn})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	writeSample(w, g.name, nil, nil, "", "", g.fn())
+}
+
+// Histogram 统计观测值的分布
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec 是按标签区分的一组 Histogram
+type HistogramVec struct {
+	desc
+	vec vec[*Histogram]
+}
+
+// NewHistogramVec 注册一组 histogram，buckets 为空时使用 DefBuckets
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	hv := &HistogramVec{desc: desc{name, help, "histogram", labels}, vec: newVec(len(labels), func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	r.register(name, hv)
+	return hv
+}
+
+func (hv *HistogramVec) With(values ...string) *Histogram {
+	return hv.vec.with(values)
+}
+
+func (hv *HistogramVec) write(w *bufio.Writer) {
+	hv.writeHeader(w)
+	hv.vec.each(func(values []string, h *Histogram) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for i, b := range h.buckets {
+			writeSample(w, hv.name+"_bucket", hv.labels, values, "le", formatFloat(b), float64(h.counts[i]))
+		}
+		writeSample(w, hv.name+"_bucket", hv.labels, values, "le", "+Inf", float64(h.count))
+		writeSample(w, hv.name+"_sum", hv.labels, values, "", "", h.sum)
+		writeSample(w, hv.name+"_count", hv.labels, values, "", "", float64(h.count))
+	})
+}
+
+// vec 按标签值保存子指标
+type vec[T any] struct {
+	mu       sync.Mutex
+	n        int
+	newChild func() T
+	children map[string]T
+	values   map[string][]string
+}
+
+func newVec[T any](n int, newChild func() T) vec[T] {
+	return vec[T]{n: n, newChild: newChild, children: make(map[string]T), values: make(map[string][]string)}
+}
+
+func (v *vec[T]) with(values []string) T {
+	if len(values) != v.n {
+		panic(fmt.Sprintf("prom: expected %d label values, got %d", v.n, len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	child, ok := v.children[key]
+	if !ok {
+		child = v.newChild()
+		v.children[key] = child
+		v.values[key] = append([]string(nil), values...)
+	}
+	return child
+}
+
+// each 按标签值排序遍历子指标
+func (v *vec[T]) each(fn func(values []string, child T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	children, values := make([]T, len(keys)), make([][]string, len(keys))
+	sort.Strings(keys)
+	for i, k := range keys {
+		children[i], values[i] = v.children[k], v.values[k]
+	}
+	v.mu.Unlock()
+
+	for i := range keys {
+		fn(values[i], children[i])
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -7,6 +7,7 @@ const (
 	TaskStatusRunning
 	TaskStatusCompleted
 	TaskStatusFailed
+	TaskStatusCanceled
 )
 
 type Task interface {
@@ -15,7 +16,6 @@ type Task interface {
 	GetStatus() TaskStatus
 	GetContent() []byte
 	SetStatus(status TaskStatus)
-	Run() error
 	Stop() error
 	GetResult() interface{}
 }
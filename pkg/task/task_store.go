@@ -3,10 +3,52 @@ package task
 import (
 	"encoding/json"
 	"errors"
+	"updater/pkg/logger"
 
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
+// ErrTaskNotRunning 任务没有在执行。任务结束时清除取消函数，之后的 Stop 返回这个错误，
+// 避免已完成的任务被改为取消状态
+var ErrTaskNotRunning = errors.New("task is not running")
+
+// TaskInfo 是保存在 TaskStore 中的任务快照
+type TaskInfo struct {
+	TaskID string          `json:"taskId"`
+	Type   string          `json:"type"`
+	Status TaskStatus      `json:"status"`
+	Result json.RawMessage `json:"result"`
+}
+
+func (ti *TaskInfo) GetTaskID() string {
+	return ti.TaskID
+}
+
+func (ti *TaskInfo) GetType() string {
+	return ti.Type
+}
+
+func (ti *TaskInfo) GetStatus() TaskStatus {
+	return ti.Status
+}
+
+func (ti *TaskInfo) GetContent() []byte {
+	return nil
+}
+
+func (ti *TaskInfo) SetStatus(status TaskStatus) {
+	ti.Status = status
+}
+
+// Stop 已经保存的任务不能再停止
+func (ti *TaskInfo) Stop() error {
+	return ErrTaskNotRunning
+}
+
+func (ti *TaskInfo) GetResult() interface{} {
+	return ti.Result
+}
+
 type TaskStore struct {
 	db *leveldb.DB
 }
@@ -27,7 +69,17 @@ func (ts *TaskStore) Close() error {
 }
 
 func (ts *TaskStore) AddTask(t Task) error {
-	data, err := json.Marshal(t)
+	result, err := json.Marshal(t.GetResult())
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&TaskInfo{
+		TaskID: t.GetTaskID(),
+		Type:   t.GetType(),
+		Status: t.GetStatus(),
+		Result: result,
+	})
 	if err != nil {
 		return err
 	}
@@ -45,8 +97,8 @@ func (ts *TaskStore) GetTask(taskID string) (Task, error) {
 		return nil, err
 	}
 
-	var t Task
-	err = json.Unmarshal(data, &t)
+	t := new(TaskInfo)
+	err = json.Unmarshal(data, t)
 	if err != nil {
 		return nil, err
 	}
@@ -54,6 +106,25 @@ func (ts *TaskStore) GetTask(taskID string) (Task, error) {
 	return t, nil
 }
 
+// ListTasks 返回所有保存的任务，无法解析的记录记录日志后跳过
+func (ts *TaskStore) ListTasks() ([]*TaskInfo, error) {
+	iter := ts.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	var tasks []*TaskInfo
+	for iter.Next() {
+		t := new(TaskInfo)
+		if err := json.Unmarshal(iter.Value(), t); err != nil {
+			if l := logger.GetLogger(); l != nil {
+				l.Println("skip invalid task record:", string(iter.Key()), err)
+			}
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, iter.Error()
+}
+
 func (ts *TaskStore) RemoveTask(taskID string) error {
 	err := ts.db.Delete([]byte(taskID), nil)
 	if err != nil {
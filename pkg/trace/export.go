@@ -0,0 +1,230 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultMaxFileSize = 10 * 1024 * 1024
+
+// Resource 描述产生 span 的服务，例如 service.name、host.name
+type Resource map[string]interface{}
+
+// OTLPExporter 以 OTLP/HTTP JSON 格式发送到 collector
+type OTLPExporter struct {
+	endpoint string
+	headers  map[string]string
+	resource Resource
+	client   *http.Client
+}
+
+// NewOTLPExporter endpoint 没有路径时使用 /v1/traces，headers 例如 Authorization: Bearer xxx
+func NewOTLPExporter(endpoint string, headers map[string]string, resource Resource) *OTLPExporter {
+	if i := strings.Index(endpoint, "://"); i >= 0 && !strings.Contains(endpoint[i+3:], "/") {
+		endpoint += "/v1/traces"
+	}
+	return &OTLPExporter{endpoint: endpoint, headers: headers, resource: resource, client: &http.Client{}}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []*SpanData) error {
+	b, err := json.Marshal(encodeOTLP(e.resource, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("export spans to %s: %s: %s", e.endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// FileExporter 每批 span 写入一行 OTLP JSON，可以由 collector 的 otlpjsonfile receiver 读取。
+// 文件超过大小上限时改名为 <path>.1，覆盖之前的备份
+type FileExporter struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	resource Resource
+}
+
+func NewFileExporter(path string, resource Resource) *FileExporter {
+	return &FileExporter{path: path, maxSize: defaultMaxFileSize, resource: resource}
+}
+
+func (e *FileExporter) Export(ctx context.Context, spans []*SpanData) error {
+	b, err := json.Marshal(encodeOTLP(e.resource, spans))
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if info, err := os.Stat(e.path); err == nil && info.Size()+int64(len(b)) > e.maxSize {
+		if err := os.Rename(e.path, e.path+".1"); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(e.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (e *FileExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// 以下类型对应 OTLP 的 protobuf JSON 编码，trace ID 和 span ID 使用十六进制
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Flags             uint32         `json:"flags"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 在 JSON 中编码为字符串
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func encodeOTLP(resource Resource, spans []*SpanData) *otlpTraces {
+	list := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			TraceState:        s.State,
+			Flags:             uint32(s.Flags),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.Attributes),
+			Status:            otlpStatus{Code: s.Status, Message: s.Message},
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		list = append(list, span)
+	}
+	return &otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: encodeAttributes(resource)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "updater"}, Spans: list}},
+	}}}
+}
+
+// encodeAttributes 按 key 排序，不支持的类型转换为字符串
+func encodeAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		var v otlpAnyValue
+		switch x := attrs[k].(type) {
+		case string:
+			v.StringValue = &x
+		case bool:
+			v.BoolValue = &x
+		case int:
+			s := strconv.Itoa(x)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		case error:
+			s := x.Error()
+			v.StringValue = &s
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		list = append(list, otlpKeyValue{Key: k, Value: v})
+	}
+	return list
+}
@@ -0,0 +1,415 @@
+// Package trace 实现 W3C trace context 的传递和 span 的记录，导出格式与 OTLP/JSON 一致
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+type TraceID [16]byte
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+type SpanID [8]byte
+
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+const flagSampled = 0x01
+
+// SpanContext 是在进程之间传递的 trace 信息
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	State   string // tracestate，原样传递
+	Remote  bool   // 来自其它进程
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// TraceParent 返回 W3C traceparent 头，无效时返回空字符串
+func (sc SpanContext) TraceParent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceParent 解析 W3C traceparent 头，例如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceParent(traceParent, traceState string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 ||
+		decodeHex(sc.TraceID[:], parts[1]) != nil || decodeHex(sc.SpanID[:], parts[2]) != nil || decodeHex(flags[:], parts[3]) != nil {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	sc.Flags = flags[0]
+	sc.State = traceState
+	sc.Remote = true
+	return sc, nil
+}
+
+// decodeHex 只接受小写的十六进制
+func decodeHex(dst []byte, s string) error {
+	if strings.ToLower(s) != s {
+		return ErrInvalidTraceParent
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+type SpanKind int
+
+// 与 OTLP 的 SpanKind 取值一致
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type StatusCode int
+
+// 与 OTLP 的 Status.code 取值一致
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// SpanData 是结束的 span，交给 Exporter 导出
+type SpanData struct {
+	SpanContext
+	Parent     SpanID
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Status     StatusCode
+	Message    string // 状态为 error 时的错误信息
+}
+
+// Span 记录一个操作，不采样的 span 只用于传递 trace context
+type Span struct {
+	mu        sync.Mutex
+	tracer    *Tracer
+	data      SpanData
+	recording bool
+	ended     bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// IsRecording span 结束时是否会导出
+func (s *Span) IsRecording() bool {
+	return s != nil && s.recording
+}
+
+// SetAttributes 设置属性，参数是交替的 key 和 value，与 zap 的 Infow 相同
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	setAttributes(s.data.Attributes, kv)
+}
+
+// End 结束 span，err 不为空时状态为 error。多次调用时只有第一次生效
+func (s *Span) End(err error) {
+	if !s.IsRecording() {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Status, s.data.Message = StatusError, err.Error()
+	} else if s.data.Status == StatusUnset {
+		s.data.Status = StatusOK
+	}
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.enqueue(&data)
+}
+
+func setAttributes(attrs map[string]interface{}, kv []interface{}) {
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		attrs[key] = kv[i+1]
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpan 返回带有 span 的 context，之后在这个 context 上创建的 span 都是它的子 span
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// ContextWithRemote 返回以其它进程的 span 作为父 span 的 context
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return ContextWithSpan(ctx, &Span{data: SpanData{SpanContext: sc}})
+}
+
+// SpanFromContext 返回 context 中的 span，没有时返回 nil
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start 创建一个内部操作的 span
+func Start(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	return StartWithKind(ctx, SpanKindInternal, name, kv...)
+}
+
+// StartWithKind 使用当前的 Tracer 创建 span，没有设置 Tracer 时只传递父 span 的 trace context
+func StartWithKind(ctx context.Context, kind SpanKind, name string, kv ...interface{}) (context.Context, *Span) {
+	parent := SpanFromContext(ctx).SpanContext()
+	t := GetTracer()
+	if t == nil {
+		if !parent.IsValid() {
+			return ctx, nil
+		}
+		s := &Span{data: SpanData{SpanContext: parent}}
+		s.data.Remote = false
+		return ContextWithSpan(ctx, s), s
+	}
+
+	s := &Span{tracer: t}
+	s.data.Name, s.data.Kind, s.data.Start = name, kind, time.Now()
+	if parent.IsValid() {
+		s.data.TraceID, s.data.Flags, s.data.State = parent.TraceID, parent.Flags, parent.State
+		s.data.Parent = parent.SpanID
+	} else {
+		s.data.TraceID = newTraceID()
+		if t.sample(s.data.TraceID) {
+			s.data.Flags = flagSampled
+		}
+	}
+	s.data.SpanID = newSpanID()
+	if s.data.IsSampled() {
+		s.recording = true
+		s.data.Attributes = make(map[string]interface{})
+		setAttributes(s.data.Attributes, kv)
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return
+}
+
+// Exporter 导出结束的 span
+type Exporter interface {
+	Export(ctx context.Context, spans []*SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+const (
+	defaultQueueSize     = 2048
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+	exportTimeout        = 30 * time.Second
+)
+
+// Tracer 采样并批量导出 span，队列满时丢弃
+type Tracer struct {
+	exporter Exporter
+	ratio    float64
+	queue    chan *SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	dropped  uint64
+	onError  func(error)
+}
+
+// NewTracer 创建 Tracer，没有父 span 时按 ratio（0 到 1）采样，有父 span 时与父 span 一致
+func NewTracer(exporter Exporter, ratio float64, onError func(error)) *Tracer {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	t := &Tracer{
+		exporter: exporter,
+		ratio:    ratio,
+		queue:    make(chan *SpanData, defaultQueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		onError:  onError,
+	}
+	go t.loop()
+	return t
+}
+
+// sample 按 trace ID 的前 8 个字节采样，同一个 trace 在不同进程中的结果相同
+func (t *Tracer) sample(id TraceID) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	if t.ratio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(id[:8])>>1) < t.ratio*(1<<63)
+}
+
+func (t *Tracer) enqueue(s *SpanData) {
+	select {
+	case t.queue <- s:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// Dropped 返回因为队列满而丢弃的 span 数量
+func (t *Tracer) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// ForceFlush 导出队列中所有的 span
+func (t *Tracer) ForceFlush() {
+	ch := make(chan struct{})
+	select {
+	case t.flush <- ch:
+		<-ch
+	case <-t.stopped:
+	}
+}
+
+// Shutdown 导出剩余的 span 后关闭 Exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	close(t.done)
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *Tracer) loop() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, defaultBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			t.onError(err)
+		}
+		cancel()
+		batch = make([]*SpanData, 0, defaultBatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case s := <-t.queue:
+				batch = append(batch, s)
+				if len(batch) >= defaultBatchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= defaultBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ch := <-t.flush:
+			drain()
+			close(ch)
+		case <-t.done:
+			drain()
+			return
+		}
+	}
+}
+
+var current atomic.Pointer[Tracer]
+
+// GetTracer 返回当前的 Tracer，没有启用时返回 nil
+func GetTracer() *Tracer {
+	return current.Load()
+}
+
+// SetTracer 替换当前的 Tracer，nil 表示不记录 span
+func SetTracer(t *Tracer) {
+	current.Store(t)
+}
+
+// Inject 把 context 中的 trace context 写入 HTTP 请求头
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	h.Set("traceparent", sc.TraceParent())
+	if sc.State != "" {
+		h.Set("tracestate", sc.State)
+	}
+}
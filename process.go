@@ -0,0 +1,271 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"updater/pkg/config"
+)
+
+const defaultProcessMaxTargets = 10
+
+var (
+	ErrProcessDenied      = errors.New("process signal denied by policy")
+	ErrProcessNotFound    = errors.New("process not found")
+	ErrProcessUnsupported = errors.New("process inspection is not supported on this platform")
+
+	defaultProcessSignals = []string{"TERM", "INT", "HUP", "QUIT", "USR1", "USR2"}
+)
+
+// ProcessInfo 是一个进程的信息
+type ProcessInfo struct {
+	PID        int       `json:"pid"`
+	PPID       int       `json:"ppid"`
+	Name       string    `json:"name"` // 进程名
+	User       string    `json:"user"`
+	UID        int       `json:"uid"`
+	State      string    `json:"state"`
+	Threads    int       `json:"threads"`
+	CPUPercent float64   `json:"cpuPercent"` // 启动以来的平均 CPU 使用率，与 ps 一致
+	RSS        uint64    `json:"rss"`        // 常驻内存（字节）
+	StartTime  time.Time `json:"startTime"`
+	Cmdline    string    `json:"cmdline"`
+}
+
+// ProcessFilter 按条件筛选进程，多个条件同时满足
+type ProcessFilter struct {
+	PID     int    `json:"pid"`     // 进程 ID
+	Name    string `json:"name"`    // 进程名 glob
+	User    string `json:"user"`    // 用户名或 UID
+	Cmdline string `json:"cmdline"` // 命令行正则表达式
+}
+
+// ProcessListRequest 是 v1/Process/List 的请求参数
+type ProcessListRequest struct {
+	ProcessFilter
+	Limit int `json:"limit"` // 最多返回的进程数量，0 表示不限制
+}
+
+// ProcessSignalRequest 是 v1/Process/Signal 的请求参数，按 PID 或者条件选择目标进程
+type ProcessSignalRequest struct {
+	ProcessFilter
+	Signal string `json:"signal"` // 信号名称或编号，例如 TERM、SIGHUP、9，为空时使用 TERM
+	DryRun bool   `json:"dryRun"` // 只返回匹配的进程，不发送信号
+}
+
+// ProcessSignalTarget 是收到信号的一个进程
+type ProcessSignalTarget struct {
+	PID     int    `json:"pid"`
+	Name    string `json:"name"`
+	User    string `json:"user"`
+	Cmdline string `json:"cmdline"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ProcessSignalResult 是 v1/Process/Signal 的结果
+type ProcessSignalResult struct {
+	Signal  string                 `json:"signal"`
+	DryRun  bool                   `json:"dryRun"`
+	Targets []*ProcessSignalTarget `json:"targets"`
+}
+
+// ProcessPolicyError 发送信号违反进程访问策略
+type ProcessPolicyError struct {
+	PID    int
+	Name   string
+	Reason string
+}
+
+func (e *ProcessPolicyError) Error() string {
+	if e.PID == 0 {
+		return "process signal denied by policy: " + e.Reason
+	}
+	return fmt.Sprintf("signal %d (%s): process signal denied by policy: %s", e.PID, e.Name, e.Reason)
+}
+
+func (e *ProcessPolicyError) Is(target error) bool {
+	return target == ErrProcessDenied
+}
+
+// ListProcesses 返回满足条件的进程，按 PID 排序
+func ListProcesses(filter *ProcessFilter) ([]*ProcessInfo, error) {
+	var re *regexp.Regexp
+	if filter.Cmdline != "" {
+		var err error
+		if re, err = regexp.Compile(filter.Cmdline); err != nil {
+			return nil, err
+		}
+	}
+	if filter.Name != "" {
+		if _, err := filepath.Match(filter.Name, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	procs, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+	var list []*ProcessInfo
+	for _, p := range procs {
+		if filter.PID != 0 && p.PID != filter.PID {
+			continue
+		}
+		if filter.Name != "" {
+			if ok, _ := filepath.Match(filter.Name, p.Name); !ok {
+				continue
+			}
+		}
+		if filter.User != "" && filter.User != p.User && filter.User != strconv.Itoa(p.UID) {
+			continue
+		}
+		if re != nil && !re.MatchString(p.Cmdline) {
+			continue
+		}
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PID < list[j].PID })
+	return list, nil
+}
+
+// SignalProcesses 向匹配的进程发送信号。所有目标进程都要满足策略，否则一个信号也不发送
+func SignalProcesses(req *ProcessSignalRequest) (*ProcessSignalResult, error) {
+	if req.PID == 0 && req.Name == "" && req.Cmdline == "" {
+		return nil, errors.New("pid, name or cmdline is required")
+	}
+	name, sig, err := parseSignal(req.Signal)
+	if err != nil {
+		return nil, err
+	}
+	policy := processPolicy()
+	if !containsSignal(policy.Signals, name) {
+		return nil, &ProcessPolicyError{Reason: "signal " + name + " is not allowed"}
+	}
+
+	procs, err := ListProcesses(&req.ProcessFilter)
+	if err != nil {
+		return nil, err
+	}
+	if len(procs) == 0 {
+		return nil, ErrProcessNotFound
+	}
+	if len(procs) > policy.MaxTargets {
+		return nil, &ProcessPolicyError{Reason: fmt.Sprintf("%d processes matched, limit %d", len(procs), policy.MaxTargets)}
+	}
+	for _, p := range procs {
+		if err := checkProcessPolicy(&policy, p); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &ProcessSignalResult{Signal: name, DryRun: req.DryRun}
+	var failed error
+	for _, p := range procs {
+		target := &ProcessSignalTarget{PID: p.PID, Name: p.Name, User: p.User, Cmdline: p.Cmdline}
+		result.Targets = append(result.Targets, target)
+		if req.DryRun {
+			continue
+		}
+		if err := sendSignal(p.PID, sig); err != nil {
+			target.Error = err.Error()
+			failed = fmt.Errorf("signal %d: %w", p.PID, err)
+		}
+	}
+	return result, failed
+}
+
+// processPolicy 返回当前配置的进程访问策略，没有配置的项使用默认值
+func processPolicy() config.ProcessPolicy {
+	var policy config.ProcessPolicy
+	if cfg := config.GetConfig(); cfg != nil {
+		policy = cfg.ProcessPolicy
+	}
+	if len(policy.Signals) == 0 {
+		policy.Signals = defaultProcessSignals
+	}
+	if policy.MaxTargets <= 0 {
+		policy.MaxTargets = defaultProcessMaxTargets
+	}
+	return policy
+}
+
+// checkProcessPolicy init 进程和 agent 自身总是不允许，deny 优先于 allow。
+// allow 和 users 都没有配置时全部拒绝，避免默认配置下可以向 sshd 之类的系统进程发送信号
+func checkProcessPolicy(policy *config.ProcessPolicy, p *ProcessInfo) error {
+	deny := func(reason string) error {
+		return &ProcessPolicyError{PID: p.PID, Name: p.Name, Reason: reason}
+	}
+	if p.PID <= 1 || p.PID == os.Getpid() {
+		return deny("protected process")
+	}
+	if len(policy.Allow) == 0 && len(policy.Users) == 0 {
+		return deny("no allow or users rule configured")
+	}
+	for _, pattern := range policy.Deny {
+		if ok, _ := filepath.Match(pattern, p.Name); ok {
+			return deny("denied by " + pattern)
+		}
+	}
+	if len(policy.Allow) > 0 {
+		allowed := false
+		for _, pattern := range policy.Allow {
+			if ok, _ := filepath.Match(pattern, p.Name); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return deny("not in allow list")
+		}
+	}
+	if len(policy.Users) > 0 {
+		allowed := false
+		for _, u := range policy.Users {
+			if u == p.User || u == strconv.Itoa(p.UID) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return deny("user " + p.User + " is not allowed")
+		}
+	}
+	return nil
+}
+
+// parseSignal 解析信号名称或编号，返回不带 SIG 前缀的名称
+func parseSignal(s string) (string, syscall.Signal, error) {
+	if s == "" {
+		s = "TERM"
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		for name, sig := range signalNames {
+			if int(sig) == n {
+				return name, sig, nil
+			}
+		}
+		return "", 0, fmt.Errorf("unknown signal: %s", s)
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	sig, ok := signalNames[name]
+	if !ok {
+		return "", 0, fmt.Errorf("unknown signal: %s", s)
+	}
+	return name, sig, nil
+}
+
+func containsSignal(list []string, name string) bool {
+	for _, s := range list {
+		if strings.TrimPrefix(strings.ToUpper(s), "SIG") == name {
+			return true
+		}
+	}
+	return false
+}
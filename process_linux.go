@@ -0,0 +1,163 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// clockTicks 是 /proc 中 CPU 时间的单位，Linux 上基本都是 100
+const clockTicks = 100
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"CONT": syscall.SIGCONT,
+	"STOP": syscall.SIGSTOP,
+}
+
+var (
+	bootTimeOnce sync.Once
+	bootTime     time.Time
+
+	userNamesMu sync.Mutex
+	userNames   = make(map[int]string)
+)
+
+// listProcesses 读取 /proc 中的所有进程，读取过程中退出的进程会被忽略
+func listProcesses() ([]*ProcessInfo, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var procs []*ProcessInfo
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		if p, err := readProcess(pid); err == nil {
+			procs = append(procs, p)
+		}
+	}
+	return procs, nil
+}
+
+func readProcess(pid int) (*ProcessInfo, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	stat, err := ioutil.ReadFile(dir + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseProcStat(stat)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s/stat: %w", dir, err)
+	}
+
+	if b, err := ioutil.ReadFile(dir + "/cmdline"); err == nil {
+		p.Cmdline = strings.TrimSpace(string(bytes.ReplaceAll(b, []byte{0}, []byte{' '})))
+	}
+	if p.Cmdline == "" {
+		// 内核线程没有命令行
+		p.Cmdline = "[" + p.Name + "]"
+	}
+	if f, err := os.Open(dir + "/status"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) > 1 && fields[0] == "Uid:" {
+				p.UID, _ = strconv.Atoi(fields[1])
+				break
+			}
+		}
+		f.Close()
+	}
+	p.User = userName(p.UID)
+	return p, nil
+}
+
+// parseProcStat 解析 /proc/<pid>/stat，进程名可能包含空格和括号，以最后一个 ) 为准
+func parseProcStat(b []byte) (*ProcessInfo, error) {
+	s := string(b)
+	open, end := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if open < 0 || end < open {
+		return nil, fmt.Errorf("invalid stat: %q", s)
+	}
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat: %q", s)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(s[:open]))
+	if err != nil {
+		return nil, err
+	}
+
+	// fields[0] 是 stat 的第 3 个字段
+	field := func(n int) uint64 {
+		v, _ := strconv.ParseUint(fields[n-3], 10, 64)
+		return v
+	}
+	p := &ProcessInfo{
+		PID:     pid,
+		Name:    s[open+1 : end],
+		State:   fields[0],
+		PPID:    int(field(4)),
+		Threads: int(field(20)),
+		RSS:     field(24) * uint64(os.Getpagesize()),
+	}
+
+	cpu := float64(field(14)+field(15)) / clockTicks
+	p.StartTime = getBootTime().Add(time.Duration(field(22)) * time.Second / clockTicks)
+	if elapsed := time.Since(p.StartTime).Seconds(); elapsed > 0 {
+		p.CPUPercent = math.Round(cpu/elapsed*1000) / 10
+	}
+	return p, nil
+}
+
+func getBootTime() time.Time {
+	bootTimeOnce.Do(func() {
+		b, err := ioutil.ReadFile("/proc/stat")
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "btime" {
+				sec, _ := strconv.ParseInt(fields[1], 10, 64)
+				bootTime = time.Unix(sec, 0)
+				return
+			}
+		}
+	})
+	return bootTime
+}
+
+func userName(uid int) string {
+	userNamesMu.Lock()
+	defer userNamesMu.Unlock()
+	name, ok := userNames[uid]
+	if !ok {
+		name = strconv.Itoa(uid)
+		if u, err := user.LookupId(name); err == nil {
+			name = u.Username
+		}
+		userNames[uid] = name
+	}
+	return name
+}
+
+func sendSignal(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
@@ -0,0 +1,78 @@
+package updater
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+	"updater/pkg/config"
+)
+
+func TestParseProcStat(t *testing.T) {
+	stat := "4321 (tmux: server) S 1 4321 4321 0 -1 4194560 1000 0 0 0 150 50 0 0 20 0 3 0 500 10000000 256 18446744073709551615\n"
+	p, err := parseProcStat([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PID != 4321 || p.Name != "tmux: server" || p.State != "S" || p.PPID != 1 || p.Threads != 3 {
+		t.Fatalf("unexpected process: %+v", p)
+	}
+	if p.RSS != 256*uint64(os.Getpagesize()) || !p.StartTime.Equal(getBootTime().Add(5*time.Second)) {
+		t.Fatalf("unexpected rss or start time: %+v", p)
+	}
+}
+
+func TestSignalProcesses(t *testing.T) {
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{})
+
+	procs, err := ListProcesses(&ProcessFilter{PID: os.Getpid()})
+	if err != nil || len(procs) != 1 || !strings.Contains(procs[0].Cmdline, ".test") || procs[0].RSS == 0 {
+		t.Fatalf("unexpected self process: %+v %v", procs, err)
+	}
+
+	cmd := exec.Command("sleep", "31.4159")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	filter := ProcessFilter{Name: "sleep", Cmdline: `^sleep 31\.4159$`}
+	if _, err := SignalProcesses(&ProcessSignalRequest{ProcessFilter: filter, Signal: "KILL"}); !errors.Is(err, ErrProcessDenied) {
+		t.Fatalf("expected KILL to be denied by default policy, got %v", err)
+	}
+	if _, err := SignalProcesses(&ProcessSignalRequest{ProcessFilter: ProcessFilter{PID: os.Getpid()}}); !errors.Is(err, ErrProcessDenied) {
+		t.Fatalf("expected signalling self to be denied, got %v", err)
+	}
+	// 没有配置 allow 和 users 时不允许任何进程
+	if _, err := SignalProcesses(&ProcessSignalRequest{ProcessFilter: filter, DryRun: true}); !errors.Is(err, ErrProcessDenied) {
+		t.Fatalf("expected empty policy to deny, got %v", err)
+	}
+	config.SetConfig(&config.Config{ProcessPolicy: config.ProcessPolicy{Allow: []string{"*"}, Deny: []string{"sl*"}}})
+	if _, err := SignalProcesses(&ProcessSignalRequest{ProcessFilter: filter}); !errors.Is(err, ErrProcessDenied) {
+		t.Fatalf("expected deny rule to match, got %v", err)
+	}
+	config.SetConfig(&config.Config{ProcessPolicy: config.ProcessPolicy{Allow: []string{"other"}}})
+	if _, err := SignalProcesses(&ProcessSignalRequest{ProcessFilter: filter, DryRun: true}); !errors.Is(err, ErrProcessDenied) {
+		t.Fatalf("expected allow list to deny, got %v", err)
+	}
+	config.SetConfig(&config.Config{ProcessPolicy: config.ProcessPolicy{Allow: []string{"sleep"}}})
+
+	result, err := SignalProcesses(&ProcessSignalRequest{ProcessFilter: filter, Signal: "SIGTERM", DryRun: true})
+	if err != nil || len(result.Targets) != 1 || result.Targets[0].PID != cmd.Process.Pid {
+		t.Fatalf("unexpected dry run: %+v %v", result, err)
+	}
+	if _, err := SignalProcesses(&ProcessSignalRequest{ProcessFilter: filter, Signal: "15"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit after SIGTERM")
+	}
+}
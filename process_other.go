@@ -0,0 +1,21 @@
+//go:build !linux
+
+package updater
+
+import (
+	"syscall"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+func listProcesses() ([]*ProcessInfo, error) {
+	return nil, ErrProcessUnsupported
+}
+
+func sendSignal(pid int, sig syscall.Signal) error {
+	return ErrProcessUnsupported
+}
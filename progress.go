@@ -0,0 +1,131 @@
+package updater
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultProgressInterval = 5 * time.Second
+
+// Progress 传输进度
+type Progress struct {
+	TaskID  string  `json:"taskId"`  // 任务ID
+	Done    int64   `json:"done"`    // 已传输字节数
+	Total   int64   `json:"total"`   // 总字节数，未知时为 -1
+	Percent float64 `json:"percent"` // 完成百分比，总大小未知时为 0
+	Rate    float64 `json:"rate"`    // 传输速率（字节/秒）
+	ETA     int64   `json:"eta"`     // 预计剩余时间（秒），未知时为 -1
+}
+
+// ProgressCounter 统计经过的字节数，可以包装 io.Reader 或 io.Writer
+type ProgressCounter struct {
+	TaskID string
+	total  int64
+	done   int64
+	start  time.Time
+
+	mu       sync.Mutex
+	lastDone int64
+	lastTime time.Time
+}
+
+func NewProgressCounter(taskID string, total int64) *ProgressCounter {
+	now := time.Now()
+	return &ProgressCounter{
+		TaskID:   taskID,
+		total:    total,
+		start:    now,
+		lastTime: now,
+	}
+}
+
+// SetTotal 设置总字节数，-1 表示未知
+func (pc *ProgressCounter) SetTotal(total int64) {
+	atomic.StoreInt64(&pc.total, total)
+}
+
+func (pc *ProgressCounter) Add(n int64) {
+	atomic.AddInt64(&pc.done, n)
+}
+
+func (pc *ProgressCounter) Write(p []byte) (int, error) {
+	pc.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// Reader 返回一个在读取时统计字节数的 io.Reader
+func (pc *ProgressCounter) Reader(r io.Reader) io.Reader {
+	return io.TeeReader(r, pc)
+}
+
+// Snapshot 返回当前进度，速率为开始以来的平均速率。只读取计数，可以随时调用
+func (pc *ProgressCounter) Snapshot() Progress {
+	done := atomic.LoadInt64(&pc.done)
+	var rate float64
+	if elapsed := time.Since(pc.start).Seconds(); elapsed > 0 {
+		rate = float64(done) / elapsed
+	}
+	return pc.progress(done, rate)
+}
+
+// sample 返回当前进度，速率按距离上次采样的增量计算，只由 ReportProgress 调用
+func (pc *ProgressCounter) sample() Progress {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	now := time.Now()
+	done := atomic.LoadInt64(&pc.done)
+	var rate float64
+	if elapsed := now.Sub(pc.lastTime).Seconds(); elapsed > 0 && done > pc.lastDone {
+		rate = float64(done-pc.lastDone) / elapsed
+	} else if elapsed := now.Sub(pc.start).Seconds(); elapsed > 0 {
+		rate = float64(done) / elapsed
+	}
+	pc.lastDone = done
+	pc.lastTime = now
+	return pc.progress(done, rate)
+}
+
+func (pc *ProgressCounter) progress(done int64, rate float64) Progress {
+	total := atomic.LoadInt64(&pc.total)
+	p := Progress{
+		TaskID: pc.TaskID,
+		Done:   done,
+		Total:  total,
+		Rate:   rate,
+		ETA:    -1,
+	}
+	if total > 0 {
+		p.Percent = float64(done) * 100 / float64(total)
+		if rate > 0 && total >= done {
+			p.ETA = int64(float64(total-done) / rate)
+		}
+	}
+	return p
+}
+
+// ReportProgress 按 interval 定时调用 fn 上报进度，直到 ctx 结束。interval 小于 0 时不上报
+func ReportProgress(ctx context.Context, interval time.Duration, pc *ProgressCounter, fn func(Progress)) {
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultProgressInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn(pc.sample())
+			}
+		}
+	}()
+}
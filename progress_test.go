@@ -0,0 +1,129 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+	"updater/pkg/config"
+	"updater/pkg/task"
+)
+
+func TestProgressCounter(t *testing.T) {
+	pc := NewProgressCounter("t1", 200)
+	pc.start = time.Now().Add(-10 * time.Second)
+	pc.lastTime = pc.start
+	pc.Add(100)
+
+	p := pc.Snapshot()
+	if p.Done != 100 || p.Total != 200 || p.Percent != 50 || p.Rate < 9 || p.Rate > 11 || p.ETA < 9 || p.ETA > 11 {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+	// Snapshot 不影响上报使用的增量速率
+	pc.Snapshot()
+	if pc.lastDone != 0 || !pc.lastTime.Equal(pc.start) {
+		t.Fatalf("snapshot changed sample state: %d %v", pc.lastDone, pc.lastTime)
+	}
+	if s := pc.sample(); s.Done != 100 || pc.lastDone != 100 {
+		t.Fatalf("unexpected sample: %+v", s)
+	}
+
+	pc.SetTotal(-1)
+	if p := pc.Snapshot(); p.Percent != 0 || p.ETA != -1 {
+		t.Fatalf("unexpected progress with unknown total: %+v", p)
+	}
+}
+
+func TestReportProgress(t *testing.T) {
+	pc := NewProgressCounter("t1", -1)
+	ctx, cancel := context.WithCancel(context.Background())
+	reports := make(chan Progress, 100)
+	ReportProgress(ctx, 10*time.Millisecond, pc, func(p Progress) { reports <- p })
+	pc.Add(10)
+
+	select {
+	case p := <-reports:
+		if p.TaskID != "t1" {
+			t.Fatalf("unexpected report: %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no progress reported")
+	}
+	cancel()
+	time.Sleep(30 * time.Millisecond)
+	for len(reports) > 0 {
+		<-reports
+	}
+	time.Sleep(30 * time.Millisecond)
+	if len(reports) != 0 {
+		t.Fatal("progress reported after cancel")
+	}
+
+	// interval 小于 0 时不上报
+	ReportProgress(context.Background(), -1, pc, func(p Progress) { t.Error("unexpected report") })
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestDownloadTaskCancel(t *testing.T) {
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			w.Header().Set("Content-Length", "1000000")
+			w.Write(make([]byte, 1000))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("done"))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+
+	dt := NewDownloadTask(&DownloadRequest{TaskID: "d1", URL: srv.URL + "/slow", DestPath: filepath.Join(dir, "slow"), ProgressInterval: -1})
+	errc := make(chan error, 1)
+	go func() { errc <- dt.Run(newTestContext()) }()
+	for i := 0; ; i++ {
+		dt.mu.Lock()
+		started := dt.progress != nil
+		dt.mu.Unlock()
+		if started {
+			break
+		}
+		if i == 100 {
+			t.Fatal("download not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := dt.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("canceled download succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("download not canceled")
+	}
+	if dt.GetStatus() != task.TaskStatusCanceled {
+		t.Fatalf("status = %v, want canceled", dt.GetStatus())
+	}
+
+	// 已完成的任务不能再取消
+	dt = NewDownloadTask(&DownloadRequest{TaskID: "d2", URL: srv.URL, DestPath: filepath.Join(dir, "done"), ProgressInterval: -1})
+	if err := dt.Run(newTestContext()); err != nil {
+		t.Fatal(err)
+	}
+	if err := dt.Stop(); !errors.Is(err, task.ErrTaskNotRunning) {
+		t.Fatalf("stop finished task: %v", err)
+	}
+	if dt.GetStatus() != task.TaskStatusCompleted {
+		t.Fatalf("status = %v, want completed", dt.GetStatus())
+	}
+}
@@ -0,0 +1,574 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultScheduleStorePath  = ".data/schedules.json"
+	defaultScheduleSpoolDir   = ".data/schedule-results"
+	defaultScheduleLimit      = 64
+	defaultScheduleSpoolLimit = 1000
+	defaultScheduleTimeout    = 3600
+
+	scheduleUploadInterval = 30 * time.Second
+	scheduleResendInterval = 2 * time.Minute
+)
+
+var (
+	ErrScheduleLimit    = errors.New("too many schedules")
+	ErrScheduleNotFound = errors.New("schedule not found")
+	ErrScheduleExists   = errors.New("schedule already exists")
+)
+
+// ScheduleJob 是一个定时执行的脚本，Cron 和 Interval 二选一
+type ScheduleJob struct {
+	ID        string            `json:"id"`        // 为空时自动生成
+	Name      string            `json:"name"`      // 名称
+	Cron      string            `json:"cron"`      // 5 段 cron 表达式，使用本地时区
+	Interval  int               `json:"interval"`  // 执行间隔（秒）
+	Script    ScriptTaskRequest `json:"script"`    // 执行的脚本，timeout 为 0 时使用 1 小时
+	Paused    bool              `json:"paused"`    // 是否暂停
+	CreatedAt time.Time         `json:"createdAt"` // 创建时间
+	LastRun   time.Time         `json:"lastRun"`   // 上次开始执行的时间
+	NextRun   time.Time         `json:"nextRun"`   // 下次执行的时间，暂停时为零值
+	Runs      int               `json:"runs"`      // 执行次数
+	Skipped   int               `json:"skipped"`   // 因为上一次还没有结束而跳过的次数
+	Running   bool              `json:"running"`   // 是否正在执行
+}
+
+// ScheduleRequest 删除、暂停和恢复定时任务的请求参数
+type ScheduleRequest struct {
+	ID     string `json:"id"`
+	Resume bool   `json:"resume"` // Pause：为 true 时恢复暂停的任务
+}
+
+// ScheduleRunResult 是一次定时执行的结果，保存在本地直到服务器确认收到
+type ScheduleRunResult struct {
+	RunID       string        `json:"runId"`
+	JobID       string        `json:"jobId"`
+	Name        string        `json:"name"`
+	ScheduledAt time.Time     `json:"scheduledAt"` // 计划执行的时间
+	Result      *ScriptResult `json:"result"`
+}
+
+type scheduledJob struct {
+	job     ScheduleJob
+	cron    *cronSchedule
+	running bool
+}
+
+// Scheduler 在本地按 cron 表达式或固定间隔执行脚本，不依赖与服务器的连接。
+// 同一个任务上一次执行还没有结束时跳过本次执行；执行结果先写入本地目录，
+// 通过 upload 上报，服务器确认收到后才删除
+type Scheduler struct {
+	mu         sync.Mutex
+	jobs       map[string]*scheduledJob
+	storePath  string
+	spoolDir   string
+	limit      int
+	spoolLimit int
+	logger     *logger.Logger
+	audit      *AuditLog
+
+	upload  func(*ScheduleRunResult) bool
+	sent    map[string]time.Time
+	wake    chan struct{}
+	flush   chan struct{}
+	done    chan struct{}
+	running sync.WaitGroup
+	ctx     context.Context // 正在执行的任务使用，Stop 时取消
+	cancel  context.CancelFunc
+}
+
+func NewScheduler(app *app.App) *Scheduler {
+	s := &Scheduler{
+		jobs:       make(map[string]*scheduledJob),
+		storePath:  app.Config.ScheduleStorePath,
+		spoolDir:   app.Config.ScheduleSpoolDir,
+		limit:      app.Config.ScheduleLimit,
+		spoolLimit: app.Config.ScheduleSpoolLimit,
+		logger:     app.Logger,
+		sent:       make(map[string]time.Time),
+		wake:       make(chan struct{}, 1),
+		flush:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if s.storePath == "" {
+		s.storePath = defaultScheduleStorePath
+	}
+	if s.spoolDir == "" {
+		s.spoolDir = defaultScheduleSpoolDir
+	}
+	if s.limit <= 0 {
+		s.limit = defaultScheduleLimit
+	}
+	if s.spoolLimit <= 0 {
+		s.spoolLimit = defaultScheduleSpoolLimit
+	}
+	return s
+}
+
+// Start 恢复持久化的定时任务并开始调度，upload 在连接不可用时返回 false，结果留到下次上报。
+// agent 停止期间错过的执行不会补执行
+func (s *Scheduler) Start(upload func(*ScheduleRunResult) bool) error {
+	jobs, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.upload = upload
+	now := time.Now()
+	for _, job := range jobs {
+		sj, err := newScheduledJob(job)
+		if err != nil {
+			s.logger.Println("restore schedule failed:", job.ID, err)
+			continue
+		}
+		sj.job.Running = false
+		sj.schedule(now)
+		s.jobs[job.ID] = sj
+	}
+	s.mu.Unlock()
+
+	go s.scheduleLoop()
+	go s.uploadLoop()
+	return nil
+}
+
+// SetAuditLog 设置审计日志，每次执行定时任务都会记录
+func (s *Scheduler) SetAuditLog(a *AuditLog) {
+	s.mu.Lock()
+	s.audit = a
+	s.mu.Unlock()
+}
+
+// SetLimits 修改定时任务和未上报结果的数量上限，已有的定时任务不受影响
+func (s *Scheduler) SetLimits(limit, spoolLimit int) {
+	if limit <= 0 {
+		limit = defaultScheduleLimit
+	}
+	if spoolLimit <= 0 {
+		spoolLimit = defaultScheduleSpoolLimit
+	}
+	s.mu.Lock()
+	s.limit, s.spoolLimit = limit, spoolLimit
+	s.mu.Unlock()
+}
+
+// Stop 停止调度，结束正在执行的任务并等待它们退出
+func (s *Scheduler) Stop() {
+	close(s.done)
+	s.cancel()
+	s.running.Wait()
+}
+
+// Create 添加一个定时任务
+func (s *Scheduler) Create(job *ScheduleJob) (*ScheduleJob, error) {
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+	job.CreatedAt = time.Now()
+	job.LastRun, job.Runs, job.Skipped, job.Running = time.Time{}, 0, 0, false
+	sj, err := newScheduledJob(job)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[job.ID]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrScheduleExists, job.ID)
+	}
+	if len(s.jobs) >= s.limit {
+		return nil, fmt.Errorf("%w: limit %d", ErrScheduleLimit, s.limit)
+	}
+	sj.schedule(time.Now())
+	s.jobs[job.ID] = sj
+	if err := s.save(); err != nil {
+		delete(s.jobs, job.ID)
+		return nil, err
+	}
+	s.notifyLoop()
+	return sj.info(), nil
+}
+
+// List 返回所有定时任务，按创建时间排序
+func (s *Scheduler) List() []*ScheduleJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*ScheduleJob, 0, len(s.jobs))
+	for _, sj := range s.jobs {
+		list = append(list, sj.info())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Delete 删除一个定时任务，正在执行的脚本会执行完
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrScheduleNotFound, id)
+	}
+	delete(s.jobs, id)
+	return s.save()
+}
+
+// Pause 暂停或恢复一个定时任务
+func (s *Scheduler) Pause(id string, paused bool) (*ScheduleJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sj, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrScheduleNotFound, id)
+	}
+	sj.job.Paused = paused
+	sj.schedule(time.Now())
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	s.notifyLoop()
+	return sj.info(), nil
+}
+
+// Ack 服务器确认收到执行结果后删除本地保存的结果
+func (s *Scheduler) Ack(runID string) error {
+	if runID == "" || strings.ContainsAny(runID, `/\`) {
+		return fmt.Errorf("invalid run id: %q", runID)
+	}
+	s.mu.Lock()
+	delete(s.sent, runID)
+	s.mu.Unlock()
+	err := os.Remove(filepath.Join(s.spoolDir, runID+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Flush 立即上报所有还没有确认的执行结果，例如重新连接到服务器之后
+func (s *Scheduler) Flush() {
+	select {
+	case s.flush <- struct{}{}:
+	default:
+	}
+}
+
+func newScheduledJob(job *ScheduleJob) (*scheduledJob, error) {
+	if (job.Cron == "") == (job.Interval <= 0) {
+		return nil, errors.New("exactly one of cron and interval must be set")
+	}
+	if strings.TrimSpace(job.Script.Content) == "" {
+		return nil, errors.New("script content is empty")
+	}
+	sj := &scheduledJob{job: *job}
+	if job.Cron != "" {
+		c, err := parseCron(job.Cron)
+		if err != nil {
+			return nil, err
+		}
+		sj.cron = c
+	}
+	return sj, nil
+}
+
+// schedule 计算 after 之后的下一次执行时间
+func (sj *scheduledJob) schedule(after time.Time) {
+	switch {
+	case sj.job.Paused:
+		sj.job.NextRun = time.Time{}
+	case sj.cron != nil:
+		sj.job.NextRun = sj.cron.Next(after)
+	default:
+		sj.job.NextRun = after.Add(time.Duration(sj.job.Interval) * time.Second)
+	}
+}
+
+func (sj *scheduledJob) info() *ScheduleJob {
+	job := sj.job
+	job.Running = sj.running
+	return &job
+}
+
+func (s *Scheduler) notifyLoop() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) scheduleLoop() {
+	for {
+		timer := time.NewTimer(s.nextWake())
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-s.wake:
+		case now := <-timer.C:
+			s.runDue(now)
+		}
+		timer.Stop()
+	}
+}
+
+// nextWake 返回距离最近一次执行的时间
+func (s *Scheduler) nextWake() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := time.Hour
+	for _, sj := range s.jobs {
+		if sj.job.NextRun.IsZero() {
+			continue
+		}
+		if until := time.Until(sj.job.NextRun); until < d {
+			d = until
+		}
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// runDue 执行所有到期的任务，上一次还没有结束的任务跳过本次执行
+func (s *Scheduler) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, sj := range s.jobs {
+		if sj.job.NextRun.IsZero() || sj.job.NextRun.After(now) {
+			continue
+		}
+		scheduled := sj.job.NextRun
+		sj.schedule(now)
+		changed = true
+		if sj.running {
+			sj.job.Skipped++
+			s.logger.Println("schedule is still running, skip:", sj.job.ID, sj.job.Name)
+			continue
+		}
+		sj.running = true
+		sj.job.LastRun = now
+		sj.job.Runs++
+		s.running.Add(1)
+		go s.run(sj, sj.job, scheduled)
+	}
+	if changed {
+		if err := s.save(); err != nil {
+			s.logger.Println("save schedules failed:", err)
+		}
+	}
+}
+
+func (s *Scheduler) run(sj *scheduledJob, job ScheduleJob, scheduled time.Time) {
+	defer s.running.Done()
+
+	runID := uuid.New().String()
+	req := job.Script
+	req.TaskID = "schedule-" + job.ID + "-" + runID[:8]
+	if req.Timeout <= 0 {
+		req.Timeout = defaultScheduleTimeout
+	}
+	s.logger.Println("run schedule:", job.ID, job.Name, "run:", runID)
+
+	start := time.Now()
+	st := NewScriptTask(&req)
+	st.Run(&Context{
+		Message: &Message{Id: runID, Type: "v1/Schedule/Run", TaskId: req.TaskID},
+		Ctx:     s.ctx,
+		Logger:  s.logger,
+	})
+
+	result := &ScheduleRunResult{
+		RunID:       runID,
+		JobID:       job.ID,
+		Name:        job.Name,
+		ScheduledAt: scheduled,
+		Result:      st.ScriptResult,
+	}
+	if err := s.spool(result); err != nil {
+		s.logger.Println("spool schedule result failed:", runID, err)
+	}
+
+	s.mu.Lock()
+	sj.running = false
+	audit := s.audit
+	s.mu.Unlock()
+	if audit != nil {
+		s.record(audit, job, &req, runID, st.ScriptResult, start)
+	}
+	s.Flush()
+}
+
+// record 记录定时任务的执行，requester 为定时任务的 ID
+func (s *Scheduler) record(audit *AuditLog, job ScheduleJob, req *ScriptTaskRequest, runID string, r *ScriptResult, start time.Time) {
+	rec := &AuditRecord{
+		Time:         start,
+		Action:       AuditActionSchedule,
+		Type:         "schedule.run",
+		MessageID:    runID,
+		Requester:    "schedule:" + job.ID,
+		TaskID:       req.TaskID,
+		ScriptHashes: []string{scriptHash(req.Content)},
+		Code:         CODE_SUCCESS,
+		Duration:     time.Since(start).Milliseconds(),
+	}
+	if req.WorkDir != "" {
+		rec.Paths = []string{req.WorkDir}
+	}
+	if r == nil {
+		rec.Code = CODE_ERROR
+	} else if e, failed := scriptFailure(r); failed {
+		rec.Code, rec.Error = CODE_ERROR, e
+	}
+	if err := audit.Record(rec); err != nil {
+		s.logger.Println("write audit log failed:", err)
+	}
+}
+
+// spool 把执行结果写入本地目录，超过数量上限时删除最早的结果
+func (s *Scheduler) spool(result *ScheduleRunResult) error {
+	if err := os.MkdirAll(s.spoolDir, 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.spoolDir, result.RunID+".json"), b, 0644); err != nil {
+		return err
+	}
+
+	files, err := s.spooled()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	limit := s.spoolLimit
+	s.mu.Unlock()
+	for len(files) > limit {
+		s.logger.Println("schedule result spool is full, drop:", files[0])
+		os.Remove(files[0])
+		files = files[1:]
+	}
+	return nil
+}
+
+// spooled 返回所有保存的执行结果文件，按写入时间排序
+func (s *Scheduler) spooled() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.spoolDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, filepath.Join(s.spoolDir, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+func (s *Scheduler) uploadLoop() {
+	ticker := time.NewTicker(scheduleUploadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.flush:
+		}
+		s.uploadSpooled()
+	}
+}
+
+// uploadSpooled 上报还没有确认的执行结果，最近已经上报过的结果等待确认，不重复上报
+func (s *Scheduler) uploadSpooled() {
+	files, err := s.spooled()
+	if err != nil {
+		s.logger.Println("read schedule result spool failed:", err)
+		return
+	}
+	for _, file := range files {
+		runID := strings.TrimSuffix(filepath.Base(file), ".json")
+		s.mu.Lock()
+		sentAt, ok := s.sent[runID]
+		s.mu.Unlock()
+		if ok && time.Since(sentAt) < scheduleResendInterval {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		result := new(ScheduleRunResult)
+		if err := json.Unmarshal(b, result); err != nil {
+			s.logger.Println("invalid schedule result, drop:", file, err)
+			os.Remove(file)
+			continue
+		}
+		if !s.upload(result) {
+			return
+		}
+		s.mu.Lock()
+		s.sent[runID] = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+func (s *Scheduler) load() ([]*ScheduleJob, error) {
+	b, err := ioutil.ReadFile(s.storePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*ScheduleJob
+	if err := json.Unmarshal(b, &jobs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", s.storePath, err)
+	}
+	return jobs, nil
+}
+
+// save 持久化所有定时任务，调用者需要持有锁
+func (s *Scheduler) save() error {
+	jobs := make([]*ScheduleJob, 0, len(s.jobs))
+	for _, sj := range s.jobs {
+		jobs = append(jobs, sj.info())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	b, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.storePath), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.storePath, b, 0644)
+}
@@ -0,0 +1,195 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 17, 30, 0, time.UTC) // 周三
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 1, 31, 13, 0, 0, 0, time.UTC)},
+		{"30 2 * * mon-fri", time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 7", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}, // 日和周满足其中一个
+		{"@weekly", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := parseCron(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		if got := s.Next(from); !got.Equal(c.want) {
+			t.Fatalf("%s: next = %v, want %v", c.expr, got, c.want)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Fatalf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	newScheduler := func() *Scheduler {
+		return NewScheduler(&app.App{
+			Logger: &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+			Config: &config.Config{
+				ScheduleStorePath: filepath.Join(dir, "schedules.json"),
+				ScheduleSpoolDir:  filepath.Join(dir, "spool"),
+			},
+		})
+	}
+
+	var (
+		mu       sync.Mutex
+		online   bool
+		uploaded []*ScheduleRunResult
+	)
+	upload := func(r *ScheduleRunResult) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if online {
+			uploaded = append(uploaded, r)
+		}
+		return online
+	}
+
+	s := newScheduler()
+	if err := s.Start(upload); err != nil {
+		t.Fatal(err)
+	}
+	// 每秒触发一次，每次执行 1.5 秒，下一次触发时上一次还没有结束
+	job, err := s.Create(&ScheduleJob{
+		ID:       "rotate",
+		Interval: 1,
+		Script:   ScriptTaskRequest{Content: "echo run >> " + log + "; sleep 1.5", Timeout: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(job); !errors.Is(err, ErrScheduleExists) {
+		t.Fatalf("expected ErrScheduleExists, got %v", err)
+	}
+	if _, err := s.Create(&ScheduleJob{Cron: "* * * * *", Interval: 1, Script: job.Script}); err == nil {
+		t.Fatal("expected error for cron and interval")
+	}
+
+	time.Sleep(4200 * time.Millisecond)
+	if _, err := s.Pause("rotate", true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+	s.Stop()
+
+	list := s.List()
+	if len(list) != 1 || !list[0].Paused || list[0].Skipped == 0 || list[0].Runs < 2 {
+		t.Fatalf("unexpected schedules: %+v", list[0])
+	}
+	b, _ := os.ReadFile(log)
+	if runs := strings.Count(string(b), "run"); runs != list[0].Runs {
+		t.Fatalf("runs = %d, log = %q", list[0].Runs, b)
+	}
+	spooled, _ := s.spooled()
+	if len(spooled) != list[0].Runs {
+		t.Fatalf("spooled %d results, want %d", len(spooled), list[0].Runs)
+	}
+
+	// 重启后恢复暂停状态，连接可用后上报保存的结果，确认后删除
+	s = newScheduler()
+	mu.Lock()
+	online = true
+	mu.Unlock()
+	if err := s.Start(upload); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if list := s.List(); len(list) != 1 || !list[0].Paused || !list[0].NextRun.IsZero() {
+		t.Fatalf("unexpected restored schedules: %+v", list)
+	}
+	s.uploadSpooled()
+	mu.Lock()
+	results := uploaded
+	mu.Unlock()
+	if len(results) != len(spooled) || results[0].Result.ExitCode != 0 || results[0].JobID != "rotate" {
+		t.Fatalf("unexpected uploads: %+v", results)
+	}
+	for _, r := range results {
+		if err := s.Ack(r.RunID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if spooled, _ := s.spooled(); len(spooled) != 0 {
+		t.Fatalf("results not removed after ack: %v", spooled)
+	}
+
+	if err := s.Delete("rotate"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("rotate"); !errors.Is(err, ErrScheduleNotFound) {
+		t.Fatalf("expected ErrScheduleNotFound, got %v", err)
+	}
+}
+
+func TestSchedulerStopCancelsRunningJob(t *testing.T) {
+	dir := t.TempDir()
+	s := NewScheduler(&app.App{
+		Logger: &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+		Config: &config.Config{
+			ScheduleStorePath: filepath.Join(dir, "schedules.json"),
+			ScheduleSpoolDir:  filepath.Join(dir, "spool"),
+		},
+	})
+	if err := s.Start(func(*ScheduleRunResult) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(&ScheduleJob{ID: "hung", Interval: 1, Script: ScriptTaskRequest{Content: "sleep 30"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; len(s.List()) == 0 || !s.List()[0].Running; i++ {
+		if i == 300 {
+			t.Fatal("schedule not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// 正在执行的任务不会阻塞 Stop
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop blocked by running schedule")
+	}
+	spooled, _ := s.spooled()
+	if len(spooled) != 1 {
+		t.Fatalf("spooled %d results, want 1", len(spooled))
+	}
+	var result ScheduleRunResult
+	if b, err := os.ReadFile(spooled[0]); err != nil || json.Unmarshal(b, &result) != nil || result.Result.Code != CodeStopped {
+		t.Fatalf("unexpected result of stopped schedule: %+v %v", result.Result, err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package updater
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 让脚本在单独的进程组中运行，取消或超时时结束整个进程组，避免子进程继续运行
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,6 @@
+package updater
+
+import "os/exec"
+
+// setProcessGroup Windows 下只结束脚本进程本身
+func setProcessGroup(cmd *exec.Cmd) {}
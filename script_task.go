@@ -12,8 +12,8 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
-	log "updater/pkg/logger"
 	"updater/pkg/task"
 )
 
@@ -32,6 +32,7 @@ type ScriptTask struct {
 	Status          task.TaskStatus
 	Suffix          string
 	Cancel          context.CancelFunc
+	mu              sync.Mutex // 保护 Cancel、Status 和 ScriptResult
 	ScriptResult    *ScriptResult
 	Env             map[string]string
 	MachineID       string
@@ -47,6 +48,7 @@ const (
 	CodeStartFailed          ScriptErrorCode = "START_FAILED"
 	CodeTimeout              ScriptErrorCode = "TIMEOUT"
 	CodeStopped              ScriptErrorCode = "STOPPED"
+	CodePermissionDenied     ScriptErrorCode = "PERMISSION_DENIED"
 	CodeSuccess              ScriptErrorCode = "SUCCESS"
 )
 
@@ -97,11 +99,17 @@ func NewScriptTask(request *ScriptTaskRequest) *ScriptTask {
 	return st
 }
 
+func (st *ScriptTask) GetTaskID() string {
+	return st.TaskID
+}
+
 func (st *ScriptTask) GetType() string {
 	return st.Type
 }
 
 func (st *ScriptTask) GetStatus() task.TaskStatus {
+	st.mu.Lock()
+	defer st.mu.Unlock()
 	return st.Status
 }
 
@@ -109,20 +117,42 @@ func (st *ScriptTask) GetContent() []byte {
 	return []byte(st.Content)
 }
 
-// GetResult returns the result of the task
+// GetResult returns a copy of the result of the task
 func (st *ScriptTask) GetResult() interface{} {
-	return st.ScriptResult
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	r := *st.ScriptResult
+	return &r
 }
 
 func (st *ScriptTask) Run(ctx *Context) (err error) {
 	defer func() {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.Cancel = nil
+		st.Updated = time.Now()
+		if st.Status == task.TaskStatusCanceled {
+			return
+		}
 		if st.ScriptResult.Code != CodeSuccess {
 			st.Status = task.TaskStatusFailed
+			return
 		}
+		st.Status = task.TaskStatusCompleted
 	}()
 
 	st.SetStatus(task.TaskStatusRunning)
 
+	span := ctx.StartSpan("script.run", "task.id", st.TaskID, "script.interpreter", st.Interpreter, "script.workDir", st.WorkDir)
+	defer func() {
+		span.SetAttributes("script.exitCode", st.ScriptResult.ExitCode, "script.code", st.ScriptResult.Code)
+		if st.ScriptResult.Code != CodeSuccess {
+			span.End(errors.New(st.ScriptResult.Error))
+			return
+		}
+		span.End(err)
+	}()
+
 	if len(st.Interpreter) == 0 {
 		st.Interpreter = defaultInterpreter
 	}
@@ -133,18 +163,29 @@ func (st *ScriptTask) Run(ctx *Context) (err error) {
 		st.Suffix = defaultScriptSuffix
 	}
 
+	// 解释器和工作目录需要满足路径访问策略
+	interpreter := st.Interpreter
+	if p, err := exec.LookPath(interpreter); err == nil {
+		interpreter = p
+	}
+	fm := NewFileManager()
+	for _, path := range []string{interpreter, st.WorkDir} {
+		if err = fm.CheckPath(PathOpExecute, path); err != nil {
+			st.fail(CodePermissionDenied, err.Error())
+			return
+		}
+	}
+
 	tmpfile, err := ioutil.TempFile("", st.TaskID+st.Suffix)
 	if err != nil {
-		st.ScriptResult.Error = err.Error()
-		st.ScriptResult.Code = CodeCreateTempFileFailed
+		st.fail(CodeCreateTempFileFailed, err.Error())
 		return
 	}
 	defer os.Remove(tmpfile.Name())
 
 	stdoutFile, err := ioutil.TempFile("", st.TaskID+".stdout")
 	if err != nil {
-		st.ScriptResult.Error = err.Error()
-		st.ScriptResult.Code = CodeCreateTempFileFailed
+		st.fail(CodeCreateTempFileFailed, err.Error())
 		return err
 	}
 	defer stdoutFile.Close()
@@ -155,8 +196,7 @@ func (st *ScriptTask) Run(ctx *Context) (err error) {
 	// 创建标准错误输出文件
 	stderrFile, err := ioutil.TempFile("", st.TaskID+".stderr")
 	if err != nil {
-		st.ScriptResult.Error = err.Error()
-		st.ScriptResult.Code = CodeCreateTempFileFailed
+		st.fail(CodeCreateTempFileFailed, err.Error())
 		return err
 	}
 
@@ -165,21 +205,18 @@ func (st *ScriptTask) Run(ctx *Context) (err error) {
 	defer os.Remove(stderrFile.Name())
 
 	if _, err = tmpfile.Write([]byte(st.Content)); err != nil {
-		st.ScriptResult.Error = err.Error()
-		st.ScriptResult.Code = CodeWriteTempFileFailed
+		st.fail(CodeWriteTempFileFailed, err.Error())
 		return
 	}
 
 	if err = tmpfile.Close(); err != nil {
-		st.ScriptResult.Error = err.Error()
-		st.ScriptResult.Code = CodeCloseTempFileFailed
+		st.fail(CodeCloseTempFileFailed, err.Error())
 		return
 	}
 
 	err = os.Chmod(tmpfile.Name(), 0755)
 	if err != nil {
-		st.ScriptResult.Error = err.Error()
-		st.ScriptResult.Code = CodeChmodTempFileFailed
+		st.fail(CodeChmodTempFileFailed, err.Error())
 		return
 	}
 
@@ -192,11 +229,21 @@ func (st *ScriptTask) Run(ctx *Context) (err error) {
 	ctx.Logger.Println("content", st.Content)
 	ctx.Logger.Println("args:", args)
 
-	ctx0, cancel := context.WithTimeout(context.Background(), st.Timeout)
+	// 调用方的 context 被取消时（例如工作流被取消）同时结束脚本
+	parent := ctx.Ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx0, cancel := context.WithTimeout(parent, st.Timeout)
 	defer cancel()
+	st.mu.Lock()
 	st.Cancel = cancel
+	st.mu.Unlock()
 
 	cmd := exec.CommandContext(ctx0, st.Interpreter, args...)
+	setProcessGroup(cmd)
+	// 结束后仍有子进程占用输出管道时不再等待
+	cmd.WaitDelay = time.Second
 
 	ctx.Logger.Println("cmd.Args:", cmd.Args)
 
@@ -231,7 +278,9 @@ func (st *ScriptTask) Run(ctx *Context) (err error) {
 				break
 			}
 			stdoutFile.WriteString(line)
+			st.mu.Lock()
 			st.ScriptResult.Stdout += line
+			st.mu.Unlock()
 		}
 	}()
 
@@ -246,14 +295,15 @@ func (st *ScriptTask) Run(ctx *Context) (err error) {
 				break
 			}
 			stderrFile.WriteString(line)
+			st.mu.Lock()
 			st.ScriptResult.Stderr += line
+			st.mu.Unlock()
 		}
 	}()
 	startTime := time.Now()
 	err = cmd.Start()
 	if err != nil {
-		st.ScriptResult.Error = err.Error()
-		st.ScriptResult.Code = CodeStartFailed
+		st.fail(CodeStartFailed, err.Error())
 		return
 	}
 
@@ -271,10 +321,14 @@ func (st *ScriptTask) Run(ctx *Context) (err error) {
 		exitCode = exitErr.ExitCode()
 	}
 
-	if errors.Is(err, context.DeadlineExceeded) {
-		st.ScriptResult.Error = "script execution timeout"
-		st.ScriptResult.Code = CodeTimeout
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx0.Err(), context.DeadlineExceeded) {
+		st.fail(CodeTimeout, "script execution timeout")
+
+		return
+	}
 
+	if errors.Is(ctx0.Err(), context.Canceled) {
+		st.fail(CodeStopped, "script execution stopped")
 		return
 	}
 
@@ -282,37 +336,54 @@ func (st *ScriptTask) Run(ctx *Context) (err error) {
 	var errorMsg string
 	if err != nil {
 		errorMsg = err.Error()
-		fmt.Println("err:", errorMsg)
 	}
 
-	log.Println("exitCode:", exitCode)
-	log.Println("startTime:", startTime)
-	log.Println("endTime:", endTime)
-	log.Println("errorMsg:", errorMsg)
-	log.Println("stdout:", st.ScriptResult.Stdout)
-	log.Println("stderr:", st.ScriptResult.Stderr)
-	log.Println("code:", CodeSuccess)
+	ctx.Logger.Println("exitCode:", exitCode)
+	ctx.Logger.Println("startTime:", startTime)
+	ctx.Logger.Println("endTime:", endTime)
+	ctx.Logger.Println("errorMsg:", errorMsg)
+	ctx.Logger.Println("stdout:", st.ScriptResult.Stdout)
+	ctx.Logger.Println("stderr:", st.ScriptResult.Stderr)
+	ctx.Logger.Println("code:", CodeSuccess)
 
+	st.mu.Lock()
 	st.ScriptResult.Code = CodeSuccess
 	st.ScriptResult.EndTime = endTime
 	st.ScriptResult.StartTime = startTime
 	st.ScriptResult.ExitCode = exitCode
 	st.ScriptResult.Error = errorMsg
+	st.mu.Unlock()
 
 	return nil
 }
 
 func (st *ScriptTask) Stop() error {
-	// 实现停止脚本的逻辑
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.Cancel == nil {
+		return task.ErrTaskNotRunning
+	}
+	st.Status = task.TaskStatusCanceled
+	st.Updated = time.Now()
 	st.Cancel()
 	return nil
 }
 
 func (st *ScriptTask) SetStatus(status task.TaskStatus) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
 	st.Status = status
 	st.Updated = time.Now()
 }
 
+// fail 记录脚本没有执行成功的原因
+func (st *ScriptTask) fail(code ScriptErrorCode, msg string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.ScriptResult.Code = code
+	st.ScriptResult.Error = msg
+}
+
 func (st *ScriptTask) MarshalJSON() ([]byte, error) {
 	return json.Marshal(st)
 }
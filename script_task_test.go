@@ -1,7 +1,15 @@
 package updater
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
+	"updater/pkg/logger"
+	"updater/pkg/task"
+
+	"go.uber.org/zap"
 )
 
 var scriptContent = `
@@ -22,15 +30,21 @@ func TestScriptTaskRun(t *testing.T) {
 		Params:      []string{"1234"},
 		Timeout:     10, // 设置超时时间（秒）
 		Interpreter: "",
-		WorkDir:     "/Users/luxingwen/lxw/updater-project/updater",
+		WorkDir:     t.TempDir(),
 		Stdin:       "",
 	}
 
 	// 创建一个 ScriptTask 对象
 	scriptTask := NewScriptTask(request)
 
+	ctx := &Context{
+		Message: &Message{},
+		Ctx:     context.Background(),
+		Logger:  &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+	}
+
 	// 调用 Run 方法
-	err := scriptTask.Run()
+	err := scriptTask.Run(ctx)
 	if err != nil {
 		t.Errorf("ScriptTask Run failed: %v", err)
 	}
@@ -46,3 +60,29 @@ func TestScriptTaskRun(t *testing.T) {
 	t.Log("ScriptTask Run error:", scriptTask.ScriptResult.Error)
 	t.Log("ScriptTask Run exit code:", scriptTask.ScriptResult.ExitCode)
 }
+
+func TestScriptTaskStop(t *testing.T) {
+	st := NewScriptTask(&ScriptTaskRequest{TaskID: "stop", Content: "echo started; sleep 30", Timeout: 60, WorkDir: t.TempDir()})
+	errc := make(chan error, 1)
+	go func() { errc <- st.Run(newTestContext()) }()
+	for i := 0; !strings.Contains(st.GetResult().(*ScriptResult).Stdout, "started"); i++ {
+		if i == 300 {
+			t.Fatal("script not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := st.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("script not stopped")
+	}
+	if r := st.GetResult().(*ScriptResult); r.Code != CodeStopped || st.GetStatus() != task.TaskStatusCanceled {
+		t.Fatalf("unexpected result after stop: %+v %v", r, st.GetStatus())
+	}
+	if err := st.Stop(); !errors.Is(err, task.ErrTaskNotRunning) {
+		t.Fatalf("stop finished script: %v", err)
+	}
+}
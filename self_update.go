@@ -0,0 +1,406 @@
+package updater
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+)
+
+const (
+	SelfUpdatePending    = "pending"
+	SelfUpdateSuccess    = "success"
+	SelfUpdateRolledBack = "rolled_back"
+	SelfUpdateFailed     = "failed"
+
+	defaultUpdateDir           = ".data/update"
+	defaultSelfUpdateDeadline  = 120 * time.Second
+	maxSelfUpdateAttempts      = 3
+	selfUpdateRestartDelay     = time.Second
+	selfUpdateStateFile        = "selfupdate.json"
+	selfUpdatePreviousSuffix   = ".prev"
+	selfUpdateStagedNameSuffix = ".new"
+)
+
+var (
+	ErrSignatureInvalid       = errors.New("signature verification failed")
+	ErrSelfUpdateRunning      = errors.New("self update is already in progress")
+	ErrUpdateKeyNotConfigured = errors.New("update public key is not configured")
+)
+
+// SelfUpdateRequest 是自更新请求参数
+type SelfUpdateRequest struct {
+	TaskID       string `json:"taskId"`       // 任务ID
+	Version      string `json:"version"`      // 新版本号
+	URL          string `json:"url"`          // 下载地址，为空时使用 downloadPath
+	DownloadPath string `json:"downloadPath"` // 服务器 /api/v1/pkg/ 下的路径
+	Sha256       string `json:"sha256"`       // 新二进制的 sha256
+	Signature    string `json:"signature"`    // 对 sha256 摘要的 ed25519 签名（base64）
+	Deadline     int    `json:"deadline"`     // 新版本必须在多少秒内注册成功，否则回滚
+	Timeout      int    `json:"timeout"`      // 下载超时时间（秒）
+}
+
+// SelfUpdateStatus 是自更新的结果，在下一次心跳时上报
+type SelfUpdateStatus struct {
+	TaskID      string    `json:"taskId"`
+	FromVersion string    `json:"fromVersion"`
+	ToVersion   string    `json:"toVersion"`
+	Status      string    `json:"status"` // pending、success、rolled_back、failed
+	Message     string    `json:"message"`
+	Time        time.Time `json:"time"`
+}
+
+// selfUpdateState 是持久化的自更新状态，新进程启动后根据它决定确认还是回滚
+type selfUpdateState struct {
+	SelfUpdateStatus
+	Executable string    `json:"executable"`
+	Previous   string    `json:"previous"`
+	Deadline   time.Time `json:"deadline"`
+	Attempts   int       `json:"attempts"`
+	Reported   bool      `json:"reported"`
+}
+
+// SelfUpdater 负责下载、替换、重启以及注册失败时的回滚
+type SelfUpdater struct {
+	mu       sync.Mutex
+	dir      string
+	pubKey   string
+	state    *selfUpdateState
+	updating bool
+	timer    *time.Timer
+	logger   *logger.Logger
+}
+
+func NewSelfUpdater(app *app.App) *SelfUpdater {
+	dir := app.Config.UpdateDir
+	if dir == "" {
+		dir = defaultUpdateDir
+	}
+	return &SelfUpdater{
+		dir:    dir,
+		pubKey: app.Config.UpdatePubKey,
+		logger: app.Logger,
+	}
+}
+
+// Resume 在启动时检查上一次自更新的状态。如果当前进程是刚替换的新版本，
+// 启动回滚计时器，在截止时间前没有调用 Confirm 时回滚到旧版本
+func (su *SelfUpdater) Resume() error {
+	state, err := su.load()
+	if err != nil || state == nil {
+		return err
+	}
+
+	su.mu.Lock()
+	defer su.mu.Unlock()
+	su.state = state
+	if state.Status != SelfUpdatePending {
+		return nil
+	}
+
+	if config.Version != state.ToVersion {
+		// 替换后的二进制版本不对，无法确认新版本可用，恢复旧版本
+		return su.rollback(fmt.Sprintf("running version %s after update, expected %s", config.Version, state.ToVersion))
+	}
+
+	state.Attempts++
+	remaining := time.Until(state.Deadline)
+	if remaining <= 0 || state.Attempts > maxSelfUpdateAttempts {
+		return su.rollback(fmt.Sprintf("version %s did not register after %d attempts", state.ToVersion, state.Attempts))
+	}
+	if err := su.save(); err != nil {
+		return err
+	}
+
+	su.logger.Println("self update pending, waiting for register until", state.Deadline)
+	su.timer = time.AfterFunc(remaining, func() {
+		su.mu.Lock()
+		defer su.mu.Unlock()
+		if su.state.Status != SelfUpdatePending {
+			return
+		}
+		if err := su.rollback(fmt.Sprintf("version %s did not register before deadline", su.state.ToVersion)); err != nil {
+			su.logger.Println("self update rollback failed:", err)
+		}
+	})
+	return nil
+}
+
+// Confirm 在注册成功后调用，确认新版本可用。旧版本保留到下一次更新
+func (su *SelfUpdater) Confirm() {
+	su.mu.Lock()
+	defer su.mu.Unlock()
+	if su.state == nil || su.state.Status != SelfUpdatePending {
+		return
+	}
+	if su.timer != nil {
+		su.timer.Stop()
+	}
+	su.state.Status = SelfUpdateSuccess
+	su.state.Message = "registered"
+	su.state.Time = time.Now()
+	if err := su.save(); err != nil {
+		su.logger.Println("save self update state failed:", err)
+	}
+	su.logger.Println("self update confirmed, version:", su.state.ToVersion)
+}
+
+// Report 返回还没有上报的自更新结果
+func (su *SelfUpdater) Report() *SelfUpdateStatus {
+	su.mu.Lock()
+	defer su.mu.Unlock()
+	if su.state == nil || su.state.Reported || su.state.Status == SelfUpdatePending {
+		return nil
+	}
+	status := su.state.SelfUpdateStatus
+	return &status
+}
+
+// MarkReported 标记结果已经上报，之后的心跳不再携带
+func (su *SelfUpdater) MarkReported() {
+	su.mu.Lock()
+	defer su.mu.Unlock()
+	if su.state == nil || su.state.Reported {
+		return
+	}
+	su.state.Reported = true
+	if err := su.save(); err != nil {
+		su.logger.Println("save self update state failed:", err)
+	}
+}
+
+// Prepare 下载并校验新版本，替换当前二进制并保留旧版本，成功后需要调用 Restart
+func (su *SelfUpdater) Prepare(c context.Context, client *Client, req *SelfUpdateRequest) (*SelfUpdateStatus, error) {
+	su.mu.Lock()
+	if su.updating || (su.state != nil && su.state.Status == SelfUpdatePending) {
+		su.mu.Unlock()
+		return nil, ErrSelfUpdateRunning
+	}
+	su.updating = true
+	su.mu.Unlock()
+
+	defer func() {
+		su.mu.Lock()
+		su.updating = false
+		su.mu.Unlock()
+	}()
+
+	if req.Version == "" {
+		return nil, errors.New("version is empty")
+	}
+	if req.Sha256 == "" || req.Signature == "" {
+		return nil, errors.New("sha256 and signature are required")
+	}
+	pubKey, err := su.publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	exe, err := executablePath()
+	if err != nil {
+		return nil, err
+	}
+
+	url := req.URL
+	if url == "" {
+		if req.DownloadPath == "" {
+			return nil, errors.New("url and downloadPath are both empty")
+		}
+		url = client.PackageURL(req.DownloadPath)
+	}
+
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(c, time.Duration(req.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	staged := exe + selfUpdateStagedNameSuffix
+	defer os.Remove(staged)
+	sum, err := fetchFile(c, url, staged, req.Sha256, 0755, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(pubKey, sum, req.Signature); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Duration(req.Deadline) * time.Second
+	if deadline <= 0 {
+		deadline = defaultSelfUpdateDeadline
+	}
+	state := &selfUpdateState{
+		SelfUpdateStatus: SelfUpdateStatus{
+			TaskID:      req.TaskID,
+			FromVersion: config.Version,
+			ToVersion:   req.Version,
+			Status:      SelfUpdatePending,
+			Message:     "restarting",
+			Time:        time.Now(),
+		},
+		Executable: exe,
+		Previous:   exe + selfUpdatePreviousSuffix,
+		Deadline:   time.Now().Add(deadline),
+	}
+
+	// 先写状态再替换，保证新进程启动时一定能找到回滚信息
+	su.mu.Lock()
+	defer su.mu.Unlock()
+	su.state = state
+	if err := su.save(); err != nil {
+		su.state = nil
+		return nil, err
+	}
+	if err := swapBinary(exe, staged, state.Previous); err != nil {
+		state.Status = SelfUpdateFailed
+		state.Message = err.Error()
+		su.save()
+		return nil, err
+	}
+	status := state.SelfUpdateStatus
+	return &status, nil
+}
+
+// Restart 稍等片刻让响应发送出去，然后用新的二进制替换当前进程
+func (su *SelfUpdater) Restart() {
+	time.Sleep(selfUpdateRestartDelay)
+	su.mu.Lock()
+	exe := ""
+	if su.state != nil {
+		exe = su.state.Executable
+	}
+	su.mu.Unlock()
+	if exe == "" {
+		return
+	}
+
+	su.logger.Println("restarting with", exe)
+	if err := reExec(exe); err != nil {
+		su.logger.Println("self update restart failed:", err)
+		su.mu.Lock()
+		defer su.mu.Unlock()
+		if err := su.rollback("restart failed: " + err.Error()); err != nil {
+			su.logger.Println("self update rollback failed:", err)
+		}
+	}
+}
+
+// rollback 用旧版本覆盖当前二进制并重启，调用者需要持有锁
+func (su *SelfUpdater) rollback(reason string) error {
+	state := su.state
+	su.logger.Println("self update rollback:", reason)
+	if err := restoreBinary(state.Executable, state.Previous); err != nil {
+		state.Status = SelfUpdateFailed
+		state.Message = reason + ", rollback failed: " + err.Error()
+		state.Time = time.Now()
+		su.save()
+		return err
+	}
+	state.Status = SelfUpdateRolledBack
+	state.Message = reason
+	state.Time = time.Now()
+	if err := su.save(); err != nil {
+		return err
+	}
+	return reExec(state.Executable)
+}
+
+func (su *SelfUpdater) publicKey() (ed25519.PublicKey, error) {
+	if su.pubKey == "" {
+		return nil, ErrUpdateKeyNotConfigured
+	}
+	key, err := base64.StdEncoding.DecodeString(su.pubKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid update public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func (su *SelfUpdater) statePath() string {
+	return filepath.Join(su.dir, selfUpdateStateFile)
+}
+
+func (su *SelfUpdater) load() (*selfUpdateState, error) {
+	b, err := ioutil.ReadFile(su.statePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := new(selfUpdateState)
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// save 持久化当前状态，调用者需要持有锁
+func (su *SelfUpdater) save() error {
+	b, err := json.MarshalIndent(su.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(su.dir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(su.statePath(), b, 0644)
+}
+
+// verifySignature 校验对 sha256 摘要（十六进制字符串）的 ed25519 签名
+func verifySignature(pubKey ed25519.PublicKey, sha256Hex, signature string) error {
+	digest, err := hex.DecodeString(sha256Hex)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSignatureInvalid, err)
+	}
+	if !ed25519.Verify(pubKey, digest, sig) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func executablePath() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
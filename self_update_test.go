@@ -0,0 +1,132 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func TestVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("new binary"))
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, sum[:]))
+
+	if err := verifySignature(pub, hex.EncodeToString(sum[:]), sig); err != nil {
+		t.Fatal(err)
+	}
+	other := sha256.Sum256([]byte("tampered binary"))
+	if err := verifySignature(pub, hex.EncodeToString(other[:]), sig); !errors.Is(err, ErrSignatureInvalid) {
+		t.Fatalf("expected ErrSignatureInvalid, got %v", err)
+	}
+}
+
+func TestSwapAndRestoreBinary(t *testing.T) {
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "updater")
+	staged := exe + selfUpdateStagedNameSuffix
+	previous := exe + selfUpdatePreviousSuffix
+	ioutil.WriteFile(exe, []byte("v1"), 0755)
+	ioutil.WriteFile(staged, []byte("v2"), 0755)
+
+	if err := swapBinary(exe, staged, previous); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(exe); string(b) != "v2" {
+		t.Fatalf("exe = %q after swap", b)
+	}
+	if b, _ := ioutil.ReadFile(previous); string(b) != "v1" {
+		t.Fatalf("previous = %q after swap", b)
+	}
+
+	if err := restoreBinary(exe, previous); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(exe); string(b) != "v1" {
+		t.Fatalf("exe = %q after restore", b)
+	}
+}
+
+func TestSelfUpdateConfirmAndReport(t *testing.T) {
+	dir := t.TempDir()
+	newUpdater := func() *SelfUpdater {
+		return NewSelfUpdater(&app.App{
+			Logger: &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+			Config: &config.Config{UpdateDir: dir},
+		})
+	}
+
+	// 模拟旧版本替换二进制后写下的状态
+	su := newUpdater()
+	su.state = &selfUpdateState{
+		SelfUpdateStatus: SelfUpdateStatus{FromVersion: "0.0.0", ToVersion: config.Version, Status: SelfUpdatePending},
+		Deadline:         time.Now().Add(time.Minute),
+	}
+	if err := su.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	su = newUpdater()
+	if err := su.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if su.Report() != nil {
+		t.Fatal("pending update should not be reported")
+	}
+	su.Confirm()
+	status := su.Report()
+	if status == nil || status.Status != SelfUpdateSuccess {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	su.MarkReported()
+
+	// 重启后不再重复上报
+	su = newUpdater()
+	if err := su.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if status := su.Report(); status != nil {
+		t.Fatalf("status reported twice: %+v", status)
+	}
+}
+
+func TestSelfUpdateVersionMismatch(t *testing.T) {
+	dir := t.TempDir()
+	su := NewSelfUpdater(&app.App{
+		Logger: &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+		Config: &config.Config{UpdateDir: dir},
+	})
+	// 旧版本已经不在，回滚失败时不会重新执行当前进程
+	su.state = &selfUpdateState{
+		SelfUpdateStatus: SelfUpdateStatus{FromVersion: "0.0.0", ToVersion: config.Version + "-next", Status: SelfUpdatePending},
+		Executable:       filepath.Join(dir, "agent"),
+		Previous:         filepath.Join(dir, "agent.previous"),
+		Deadline:         time.Now().Add(time.Minute),
+	}
+	if err := su.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := su.Resume(); err == nil {
+		t.Fatal("expected rollback error")
+	}
+	status := su.Report()
+	if status == nil || status.Status != SelfUpdateFailed || !strings.Contains(status.Message, "rollback failed") {
+		t.Fatalf("version mismatch not rolled back: %+v", status)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package updater
+
+import (
+	"os"
+	"syscall"
+)
+
+// swapBinary 把当前二进制硬链接为 previous，再把 staged 改名覆盖当前二进制，替换过程是原子的
+func swapBinary(exe, staged, previous string) error {
+	os.Remove(previous)
+	if err := os.Link(exe, previous); err != nil {
+		// 不支持硬链接时复制一份
+		if err := copyFile(exe, previous); err != nil {
+			return err
+		}
+	}
+	return os.Rename(staged, exe)
+}
+
+// restoreBinary 用 previous 覆盖当前二进制
+func restoreBinary(exe, previous string) error {
+	if _, err := os.Stat(previous); err != nil {
+		return err
+	}
+	return os.Rename(previous, exe)
+}
+
+// reExec 用 exe 替换当前进程，参数和环境变量保持不变
+func reExec(exe string) error {
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
@@ -0,0 +1,46 @@
+package updater
+
+import (
+	"os"
+	"os/exec"
+)
+
+// swapBinary 在 Windows 上运行中的二进制不能被覆盖，但可以改名，所以先把它改名为 previous
+func swapBinary(exe, staged, previous string) error {
+	os.Remove(previous)
+	if err := os.Rename(exe, previous); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		os.Rename(previous, exe)
+		return err
+	}
+	return nil
+}
+
+// restoreBinary 把当前二进制改名移开，再用 previous 替换
+func restoreBinary(exe, previous string) error {
+	if _, err := os.Stat(previous); err != nil {
+		return err
+	}
+	failed := exe + ".failed"
+	os.Remove(failed)
+	if err := os.Rename(exe, failed); err != nil {
+		return err
+	}
+	return os.Rename(previous, exe)
+}
+
+// reExec 启动新进程后退出当前进程
+func reExec(exe string) error {
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
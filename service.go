@@ -0,0 +1,215 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ServiceActionStatus  = "status"
+	ServiceActionStart   = "start"
+	ServiceActionStop    = "stop"
+	ServiceActionRestart = "restart"
+	ServiceActionEnable  = "enable"
+	ServiceActionDisable = "disable"
+
+	defaultServiceTimeout = 30
+)
+
+var (
+	ErrServiceNotFound    = errors.New("service not found")
+	ErrServiceUnsupported = errors.New("service manager is not supported")
+	ErrInvalidServiceName = errors.New("invalid service name")
+)
+
+// ServiceStatus 是服务的当前状态，字段含义与 systemd 一致
+type ServiceStatus struct {
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	LoadState     string    `json:"loadState"`     // loaded、not-found 等
+	ActiveState   string    `json:"activeState"`   // active、inactive、failed 等
+	SubState      string    `json:"subState"`      // running、dead、exited 等
+	MainPID       int       `json:"mainPid"`       // 主进程 ID，没有运行时为 0
+	Since         time.Time `json:"since"`         // 进入当前状态的时间
+	UnitFileState string    `json:"unitFileState"` // enabled、disabled、static 等
+}
+
+// ServiceRequest 是服务管理消息的请求参数
+type ServiceRequest struct {
+	Name    string `json:"name"`    // 服务名称，例如 nginx 或 nginx.service
+	Timeout int    `json:"timeout"` // 超时时间（秒），0 使用默认值
+}
+
+// ServiceManager 管理系统服务，不同的平台和测试使用不同的实现
+type ServiceManager interface {
+	Status(ctx context.Context, name string) (*ServiceStatus, error)
+	Start(ctx context.Context, name string) error
+	Stop(ctx context.Context, name string) error
+	Restart(ctx context.Context, name string) error
+	Enable(ctx context.Context, name string) error
+	Disable(ctx context.Context, name string) error
+}
+
+var (
+	serviceManagersMu sync.Mutex
+	serviceManagers   = make(map[string]func() (ServiceManager, error))
+
+	// defaultServiceManager 是没有配置时使用的实现，由平台相关的文件设置
+	defaultServiceManager string
+)
+
+// RegisterServiceManager 注册一个服务管理实现
+func RegisterServiceManager(name string, factory func() (ServiceManager, error)) {
+	serviceManagersMu.Lock()
+	defer serviceManagersMu.Unlock()
+	serviceManagers[name] = factory
+}
+
+// NewServiceManager 按名称创建服务管理实现，名称为空时使用当前平台的默认实现
+func NewServiceManager(name string) (ServiceManager, error) {
+	if name == "" {
+		name = defaultServiceManager
+	}
+	serviceManagersMu.Lock()
+	factory, ok := serviceManagers[name]
+	serviceManagersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrServiceUnsupported, name)
+	}
+	return factory()
+}
+
+// RunServiceAction 执行服务操作，返回操作之后的服务状态
+func RunServiceAction(c context.Context, m ServiceManager, action string, req *ServiceRequest) (*ServiceStatus, error) {
+	if m == nil {
+		return nil, ErrServiceUnsupported
+	}
+	if err := validateServiceName(req.Name); err != nil {
+		return nil, err
+	}
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = defaultServiceTimeout
+	}
+	c, cancel := context.WithTimeout(c, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var err error
+	switch action {
+	case ServiceActionStatus:
+	case ServiceActionStart:
+		err = m.Start(c, req.Name)
+	case ServiceActionStop:
+		err = m.Stop(c, req.Name)
+	case ServiceActionRestart:
+		err = m.Restart(c, req.Name)
+	case ServiceActionEnable:
+		err = m.Enable(c, req.Name)
+	case ServiceActionDisable:
+		err = m.Disable(c, req.Name)
+	default:
+		return nil, fmt.Errorf("unknown service action: %s", action)
+	}
+
+	// 操作失败时同样返回服务状态，便于服务器判断原因
+	status, serr := m.Status(c, req.Name)
+	if err != nil {
+		return status, fmt.Errorf("%s %s: %w", action, req.Name, err)
+	}
+	return status, serr
+}
+
+// validateServiceName 服务名称会作为命令行参数，不允许以 - 开头或包含空白和路径分隔符
+func validateServiceName(name string) error {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, " \t\r\n/\\") {
+		return fmt.Errorf("%w: %q", ErrInvalidServiceName, name)
+	}
+	return nil
+}
+
+// FakeServiceManager 是保存在内存中的服务管理实现，用于测试
+type FakeServiceManager struct {
+	mu       sync.Mutex
+	services map[string]*ServiceStatus
+	nextPID  int
+}
+
+func NewFakeServiceManager(names ...string) *FakeServiceManager {
+	m := &FakeServiceManager{services: make(map[string]*ServiceStatus), nextPID: 1000}
+	for _, name := range names {
+		m.Add(name)
+	}
+	return m
+}
+
+// Add 添加一个停止的服务
+func (m *FakeServiceManager) Add(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.services[name] = &ServiceStatus{
+		Name:          name,
+		LoadState:     "loaded",
+		ActiveState:   "inactive",
+		SubState:      "dead",
+		Since:         time.Now(),
+		UnitFileState: "disabled",
+	}
+}
+
+func (m *FakeServiceManager) Status(ctx context.Context, name string) (*ServiceStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.services[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	status := *s
+	return &status, nil
+}
+
+func (m *FakeServiceManager) Start(ctx context.Context, name string) error {
+	return m.update(name, func(s *ServiceStatus) {
+		if s.ActiveState != "active" {
+			m.nextPID++
+			s.ActiveState, s.SubState, s.MainPID, s.Since = "active", "running", m.nextPID, time.Now()
+		}
+	})
+}
+
+func (m *FakeServiceManager) Stop(ctx context.Context, name string) error {
+	return m.update(name, func(s *ServiceStatus) {
+		if s.ActiveState != "inactive" {
+			s.ActiveState, s.SubState, s.MainPID, s.Since = "inactive", "dead", 0, time.Now()
+		}
+	})
+}
+
+func (m *FakeServiceManager) Restart(ctx context.Context, name string) error {
+	return m.update(name, func(s *ServiceStatus) {
+		m.nextPID++
+		s.ActiveState, s.SubState, s.MainPID, s.Since = "active", "running", m.nextPID, time.Now()
+	})
+}
+
+func (m *FakeServiceManager) Enable(ctx context.Context, name string) error {
+	return m.update(name, func(s *ServiceStatus) { s.UnitFileState = "enabled" })
+}
+
+func (m *FakeServiceManager) Disable(ctx context.Context, name string) error {
+	return m.update(name, func(s *ServiceStatus) { s.UnitFileState = "disabled" })
+}
+
+func (m *FakeServiceManager) update(name string, fn func(*ServiceStatus)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.services[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	fn(s)
+	return nil
+}
@@ -0,0 +1,117 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const systemdTimestampLayout = "Mon 2006-01-02 15:04:05 MST"
+
+var systemdStatusProperties = []string{
+	"Id", "Description", "LoadState", "ActiveState", "SubState", "MainPID", "StateChangeTimestamp", "UnitFileState",
+}
+
+func init() {
+	RegisterServiceManager("systemd", func() (ServiceManager, error) {
+		path, err := exec.LookPath("systemctl")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrServiceUnsupported, err)
+		}
+		return &SystemdServiceManager{systemctl: path}, nil
+	})
+	defaultServiceManager = "systemd"
+}
+
+// SystemdServiceManager 通过 systemctl 管理 systemd 服务
+type SystemdServiceManager struct {
+	systemctl string
+}
+
+func (m *SystemdServiceManager) Status(ctx context.Context, name string) (*ServiceStatus, error) {
+	out, err := m.run(ctx, "show", "--property="+strings.Join(systemdStatusProperties, ","), "--", name)
+	if err != nil {
+		return nil, err
+	}
+	status := parseSystemctlShow(out)
+	if status.LoadState == "not-found" {
+		return status, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	return status, nil
+}
+
+func (m *SystemdServiceManager) Start(ctx context.Context, name string) error {
+	_, err := m.run(ctx, "start", "--", name)
+	return err
+}
+
+func (m *SystemdServiceManager) Stop(ctx context.Context, name string) error {
+	_, err := m.run(ctx, "stop", "--", name)
+	return err
+}
+
+func (m *SystemdServiceManager) Restart(ctx context.Context, name string) error {
+	_, err := m.run(ctx, "restart", "--", name)
+	return err
+}
+
+func (m *SystemdServiceManager) Enable(ctx context.Context, name string) error {
+	_, err := m.run(ctx, "enable", "--", name)
+	return err
+}
+
+func (m *SystemdServiceManager) Disable(ctx context.Context, name string) error {
+	_, err := m.run(ctx, "disable", "--", name)
+	return err
+}
+
+// run 执行 systemctl，失败时错误中包含命令的输出
+func (m *SystemdServiceManager) run(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, m.systemctl, append([]string{"--no-pager", "--no-ask-password"}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("systemctl %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// parseSystemctlShow 解析 systemctl show 输出的 key=value
+func parseSystemctlShow(out string) *ServiceStatus {
+	s := new(ServiceStatus)
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Id":
+			s.Name = value
+		case "Description":
+			s.Description = value
+		case "LoadState":
+			s.LoadState = value
+		case "ActiveState":
+			s.ActiveState = value
+		case "SubState":
+			s.SubState = value
+		case "MainPID":
+			s.MainPID, _ = strconv.Atoi(value)
+		case "StateChangeTimestamp":
+			if t, err := time.ParseInLocation(systemdTimestampLayout, value, time.Local); err == nil {
+				s.Since = t
+			}
+		case "UnitFileState":
+			s.UnitFileState = value
+		}
+	}
+	return s
+}
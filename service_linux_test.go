@@ -0,0 +1,28 @@
+package updater
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSystemctlShow(t *testing.T) {
+	s := parseSystemctlShow(`Id=nginx.service
+Description=A high performance web server
+LoadState=loaded
+ActiveState=active
+SubState=running
+MainPID=1234
+StateChangeTimestamp=Mon 2024-01-29 10:00:00 UTC
+UnitFileState=enabled
+`)
+	since := time.Date(2024, 1, 29, 10, 0, 0, 0, time.UTC)
+	if s.Name != "nginx.service" || s.ActiveState != "active" || s.SubState != "running" || s.MainPID != 1234 ||
+		s.UnitFileState != "enabled" || !s.Since.Equal(since) {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+
+	s = parseSystemctlShow("Id=missing.service\nLoadState=not-found\nMainPID=0\nStateChangeTimestamp=\n")
+	if s.LoadState != "not-found" || !s.Since.IsZero() {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+}
@@ -0,0 +1,42 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunServiceAction(t *testing.T) {
+	m := NewFakeServiceManager("nginx")
+	c := context.Background()
+	req := &ServiceRequest{Name: "nginx"}
+
+	status, err := RunServiceAction(c, m, ServiceActionStart, req)
+	if err != nil || status.ActiveState != "active" || status.SubState != "running" || status.MainPID == 0 {
+		t.Fatalf("unexpected status after start: %+v %v", status, err)
+	}
+	pid := status.MainPID
+
+	status, err = RunServiceAction(c, m, ServiceActionRestart, req)
+	if err != nil || status.MainPID == pid {
+		t.Fatalf("main pid not changed after restart: %+v %v", status, err)
+	}
+	if status, err = RunServiceAction(c, m, ServiceActionEnable, req); err != nil || status.UnitFileState != "enabled" {
+		t.Fatalf("unexpected status after enable: %+v %v", status, err)
+	}
+	if status, err = RunServiceAction(c, m, ServiceActionStop, req); err != nil || status.ActiveState != "inactive" || status.MainPID != 0 {
+		t.Fatalf("unexpected status after stop: %+v %v", status, err)
+	}
+
+	if _, err := RunServiceAction(c, m, ServiceActionStart, &ServiceRequest{Name: "missing"}); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("expected ErrServiceNotFound, got %v", err)
+	}
+	for _, name := range []string{"", "--force", "a b", "../etc/passwd"} {
+		if _, err := RunServiceAction(c, m, ServiceActionStatus, &ServiceRequest{Name: name}); !errors.Is(err, ErrInvalidServiceName) {
+			t.Fatalf("%q: expected ErrInvalidServiceName, got %v", name, err)
+		}
+	}
+	if _, err := RunServiceAction(c, nil, ServiceActionStatus, req); !errors.Is(err, ErrServiceUnsupported) {
+		t.Fatalf("expected ErrServiceUnsupported, got %v", err)
+	}
+}
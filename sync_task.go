@@ -0,0 +1,445 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/task"
+)
+
+const TaskTypeSync = "sync"
+
+// SyncEntry 是服务器清单中的一个条目
+type SyncEntry struct {
+	Path         string `json:"path"`         // 相对于同步目录的路径，使用 "/" 分隔
+	Size         int64  `json:"size"`         // 文件大小
+	Sha256       string `json:"sha256"`       // 文件内容的 sha256，为空时按大小和 modTime 判断是否变化
+	ModTime      int64  `json:"modTime"`      // 文件修改时间（Unix 秒），下载后设置到本地文件
+	Mode         string `json:"mode"`         // 文件权限，八进制字符串，为空时文件 0644、目录 0755
+	IsDir        bool   `json:"isDir"`        // 是否是目录
+	DownloadPath string `json:"downloadPath"` // 服务器 /api/v1/pkg/ 下的路径，为空时使用 downloadBase + path
+}
+
+// SyncRequest 是目录同步请求参数
+type SyncRequest struct {
+	TaskID           string      `json:"taskId"`           // 任务ID
+	DestPath         string      `json:"destPath"`         // 本地同步目录
+	DownloadBase     string      `json:"downloadBase"`     // 服务器 /api/v1/pkg/ 下的基础路径
+	Files            []SyncEntry `json:"files"`            // 文件清单
+	Delete           bool        `json:"delete"`           // 是否删除清单之外的文件
+	Exclude          []string    `json:"exclude"`          // 不参与删除的文件 glob
+	DryRun           bool        `json:"dryRun"`           // 只计算差异，不做修改
+	Timeout          int         `json:"timeout"`          // 超时时间
+	ProgressInterval int         `json:"progressInterval"` // 进度上报间隔（秒），0 使用默认值，小于 0 不上报
+}
+
+// SyncResult 是同步的变更汇总
+type SyncResult struct {
+	TaskID      string    `json:"taskId"`
+	Code        string    `json:"code"`
+	Message     string    `json:"message"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	DryRun      bool      `json:"dryRun"`
+	Added       []string  `json:"added"`       // 新增的文件和目录
+	Updated     []string  `json:"updated"`     // 内容变化的文件
+	ModeChanged []string  `json:"modeChanged"` // 只有权限变化的文件
+	Deleted     []string  `json:"deleted"`     // 删除的多余文件
+	Unchanged   int       `json:"unchanged"`   // 未变化的条目数
+	Bytes       int64     `json:"bytes"`       // 需要下载的字节数
+	Progress    *Progress `json:"progress,omitempty"`
+}
+
+type SyncTask struct {
+	TaskID  string
+	Request *SyncRequest
+	Status  task.TaskStatus
+	Created time.Time
+	Updated time.Time
+	Result  *SyncResult
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	progress *ProgressCounter
+	fm       *FileManager
+}
+
+func NewSyncTask(req *SyncRequest) *SyncTask {
+	return &SyncTask{
+		TaskID:  req.TaskID,
+		Request: req,
+		Status:  task.TaskStatusCreated,
+		Created: time.Now(),
+		Updated: time.Now(),
+		Result: &SyncResult{
+			TaskID: req.TaskID,
+			DryRun: req.DryRun,
+		},
+		fm: NewFileManager(),
+	}
+}
+
+func (st *SyncTask) GetTaskID() string {
+	return st.TaskID
+}
+
+func (st *SyncTask) GetType() string {
+	return TaskTypeSync
+}
+
+func (st *SyncTask) GetStatus() task.TaskStatus {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.Status
+}
+
+func (st *SyncTask) GetContent() []byte {
+	return []byte(st.Request.DestPath)
+}
+
+func (st *SyncTask) SetStatus(status task.TaskStatus) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Status = status
+	st.Updated = time.Now()
+}
+
+// GetResult 返回同步结果，任务运行中时附带当前下载进度
+func (st *SyncTask) GetResult() interface{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	r := *st.Result
+	if st.progress != nil {
+		p := st.progress.Snapshot()
+		r.Progress = &p
+	}
+	return &r
+}
+
+func (st *SyncTask) Stop() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.cancel == nil {
+		return task.ErrTaskNotRunning
+	}
+	st.Status = task.TaskStatusCanceled
+	st.cancel()
+	return nil
+}
+
+// syncAction 是比较后需要执行的一个操作
+type syncAction struct {
+	entry  SyncEntry
+	rel    string
+	target string
+	perm   os.FileMode
+	kind   string // add、update、mode
+}
+
+// Run 比较清单和本地目录，下载变化的文件并按需删除多余的文件
+func (st *SyncTask) Run(ctx *Context) (err error) {
+	req := st.Request
+	st.Result.StartTime = time.Now()
+
+	defer func() {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.Result.EndTime = time.Now()
+		st.Updated = time.Now()
+		st.cancel = nil
+		if err != nil {
+			st.Result.Message = err.Error()
+			st.Result.Code = ErrorCode(err)
+			if st.Status != task.TaskStatusCanceled {
+				st.Status = task.TaskStatusFailed
+			}
+			return
+		}
+		st.Result.Code = CODE_SUCCESS
+		st.Result.Message = "success"
+		st.Status = task.TaskStatusCompleted
+	}()
+
+	var (
+		c      context.Context
+		cancel context.CancelFunc
+	)
+	if req.Timeout > 0 {
+		c, cancel = context.WithTimeout(ctx.Ctx, time.Second*time.Duration(req.Timeout))
+	} else {
+		c, cancel = context.WithCancel(ctx.Ctx)
+	}
+	defer cancel()
+
+	st.mu.Lock()
+	st.Status = task.TaskStatusRunning
+	st.cancel = cancel
+	st.mu.Unlock()
+
+	if err = st.fm.CheckPath(PathOpWrite, req.DestPath); err != nil {
+		return err
+	}
+	// 目标目录本身可以是符号链接，按解析后的真实目录比较
+	dest, err := resolvePath(req.DestPath)
+	if err != nil {
+		return err
+	}
+
+	actions, wanted, err := st.diff(dest)
+	if err != nil {
+		return err
+	}
+	extras, err := st.extras(dest, wanted)
+	if err != nil {
+		return err
+	}
+
+	if req.DryRun {
+		return nil
+	}
+
+	pc := NewProgressCounter(st.TaskID, st.Result.Bytes)
+	st.mu.Lock()
+	st.progress = pc
+	st.mu.Unlock()
+	ReportProgress(c, time.Duration(req.ProgressInterval)*time.Second, pc, func(p Progress) {
+		ctx.Notify(ctx.Message.Type+"/Progress", p)
+	})
+
+	for _, a := range actions {
+		if err := c.Err(); err != nil {
+			return err
+		}
+		if err := st.apply(c, ctx, dest, a, pc); err != nil {
+			return fmt.Errorf("%s: %w", a.entry.Path, err)
+		}
+	}
+
+	// 先删除深层的路径，保证目录为空后再删除目录
+	sort.Sort(sort.Reverse(sort.StringSlice(extras)))
+	for _, rel := range extras {
+		target, err := st.target(dest, filepath.FromSlash(rel), PathOpDelete)
+		if err == nil {
+			err = st.fm.DeleteAll(target)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+
+	if req.ProgressInterval >= 0 {
+		ctx.Notify(ctx.Message.Type+"/Progress", pc.Snapshot())
+	}
+	return nil
+}
+
+// diff 比较清单和本地文件，返回需要执行的操作和清单中所有路径的集合
+func (st *SyncTask) diff(dest string) ([]syncAction, map[string]bool, error) {
+	var actions []syncAction
+	wanted := make(map[string]bool)
+	result := st.Result
+
+	for _, entry := range st.Request.Files {
+		rel := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(entry.Path, "/")))
+		if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrIllegalArchivePath, entry.Path)
+		}
+		target, err := st.target(dest, rel, PathOpWrite)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// 清单中路径的所有父目录都需要保留
+		for p := filepath.ToSlash(rel); p != "."; p = filepath.ToSlash(filepath.Dir(p)) {
+			wanted[p] = true
+		}
+
+		perm := os.FileMode(0644)
+		if entry.IsDir {
+			perm = 0755
+		}
+		if entry.Mode != "" {
+			m, err := strconv.ParseUint(entry.Mode, 8, 32)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: invalid mode %s", entry.Path, entry.Mode)
+			}
+			perm = os.FileMode(m)
+		}
+
+		a := syncAction{entry: entry, rel: rel, target: target, perm: perm}
+		info, err := os.Stat(target)
+		switch {
+		case os.IsNotExist(err):
+			a.kind = "add"
+			result.Added = append(result.Added, entry.Path)
+		case err != nil:
+			return nil, nil, err
+		case entry.IsDir != info.IsDir():
+			a.kind = "update"
+			result.Updated = append(result.Updated, entry.Path)
+		case entry.IsDir:
+			if info.Mode().Perm() != perm && entry.Mode != "" {
+				a.kind = "mode"
+				result.ModeChanged = append(result.ModeChanged, entry.Path)
+			}
+		case info.Size() != entry.Size:
+			a.kind = "update"
+			result.Updated = append(result.Updated, entry.Path)
+		case entry.Sha256 == "":
+			// 没有校验和时大小相同并且修改时间相同（清单没有提供时只比较大小）就认为没有变化
+			if entry.ModTime != 0 && info.ModTime().Unix() != entry.ModTime {
+				a.kind = "update"
+				result.Updated = append(result.Updated, entry.Path)
+			} else if info.Mode().Perm() != perm {
+				a.kind = "mode"
+				result.ModeChanged = append(result.ModeChanged, entry.Path)
+			}
+		default:
+			sum, err := fileSha256(target)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !strings.EqualFold(sum, entry.Sha256) {
+				a.kind = "update"
+				result.Updated = append(result.Updated, entry.Path)
+			} else if info.Mode().Perm() != perm {
+				a.kind = "mode"
+				result.ModeChanged = append(result.ModeChanged, entry.Path)
+			}
+		}
+
+		if a.kind == "" {
+			result.Unchanged++
+			continue
+		}
+		if !entry.IsDir && a.kind != "mode" {
+			result.Bytes += entry.Size
+		}
+		actions = append(actions, a)
+	}
+	return actions, wanted, nil
+}
+
+// extras 返回本地目录中不在清单里的路径。只返回最上层的多余目录
+func (st *SyncTask) extras(dest string, wanted map[string]bool) ([]string, error) {
+	if !st.Request.Delete {
+		return nil, nil
+	}
+
+	var extras []string
+	err := filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dest {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(dest, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if matchGlobs(rel, st.Request.Exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel = filepath.ToSlash(rel)
+		if wanted[rel] {
+			return nil
+		}
+		if err := st.fm.CheckPath(PathOpDelete, path); err != nil {
+			return err
+		}
+		extras = append(extras, rel)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	st.Result.Deleted = extras
+	return extras, nil
+}
+
+// target 按磁盘上的实际情况解析 dest 下的 rel，拒绝经过已存在的符号链接逃逸出 dest 的路径，
+// 并按解析后的路径检查访问策略。删除时不解析最后一级，只删除链接本身
+func (st *SyncTask) target(dest, rel string, op PathOp) (string, error) {
+	parent, err := evalPath(dest, filepath.Dir(rel))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrIllegalArchivePath, filepath.ToSlash(rel), err)
+	}
+	target := filepath.Join(parent, filepath.Base(rel))
+	if op != PathOpDelete {
+		if target, err = evalPath(parent, filepath.Base(rel)); err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrIllegalArchivePath, filepath.ToSlash(rel), err)
+		}
+	}
+	if !isWithin(dest, parent) || !isWithin(dest, target) || target == dest {
+		return "", fmt.Errorf("%w: %s", ErrIllegalArchivePath, filepath.ToSlash(rel))
+	}
+	if err := st.fm.CheckPath(op, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+func (st *SyncTask) apply(c context.Context, ctx *Context, dest string, a syncAction, pc *ProgressCounter) error {
+	// 比较之后目录中的符号链接可能发生了变化，写入前重新检查
+	target, err := st.target(dest, a.rel, PathOpWrite)
+	if err != nil {
+		return err
+	}
+	a.target = target
+
+	if a.entry.IsDir {
+		if a.kind == "update" {
+			// 原来是文件，需要先删掉
+			if err := st.fm.DeleteFile(a.target); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(a.target, a.perm); err != nil {
+			return err
+		}
+		return os.Chmod(a.target, a.perm)
+	}
+
+	if a.kind == "mode" {
+		return os.Chmod(a.target, a.perm)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.target), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Stat(a.target); err == nil && info.IsDir() {
+		// 原来是目录，需要先删掉
+		if err := st.fm.DeleteAll(a.target); err != nil {
+			return err
+		}
+	}
+
+	downloadPath := a.entry.DownloadPath
+	if downloadPath == "" {
+		downloadPath = strings.TrimSuffix(st.Request.DownloadBase, "/") + "/" + strings.TrimPrefix(a.entry.Path, "/")
+	}
+	if _, err := fetchFile(c, ctx.Client.PackageURL(downloadPath), a.target, a.entry.Sha256, a.perm, pc); err != nil {
+		return err
+	}
+	// 没有校验和的文件下次同步时按修改时间比较
+	if a.entry.ModTime != 0 {
+		mtime := time.Unix(a.entry.ModTime, 0)
+		return os.Chtimes(a.target, mtime, mtime)
+	}
+	return nil
+}
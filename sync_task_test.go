@@ -0,0 +1,205 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestSyncTask(t *testing.T) {
+	remote := map[string]string{
+		"keep.txt":     "keep",
+		"changed.txt":  "new!",
+		"nosum.txt":    "same",
+		"mtime.txt":    "mtime",
+		"sub/add.txt":  "added",
+		"sub/deep/a.b": "deep",
+	}
+	var downloads int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := remote[strings.TrimPrefix(r.URL.Path, "/api/v1/pkg/base/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	dest := t.TempDir()
+	local := map[string]string{
+		"keep.txt":         "keep",
+		"changed.txt":      "old!",
+		"nosum.txt":        "diff",
+		"mtime.txt":        "mtime",
+		"extra.txt":        "extra",
+		"extradir/x.txt":   "x",
+		"logs/app.log":     "log",
+		"sub/deep/old.txt": "old",
+	}
+	for rel, content := range local {
+		path := filepath.Join(dest, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+
+	mtime := time.Now().Add(-time.Hour).Unix()
+	req := func(dryRun bool) *SyncRequest {
+		return &SyncRequest{
+			TaskID:       "sync-1",
+			DestPath:     dest,
+			DownloadBase: "base",
+			Files: []SyncEntry{
+				{Path: "keep.txt", Size: 4, Sha256: sha256Hex("keep")},
+				{Path: "changed.txt", Size: 4, Sha256: sha256Hex("new!")},
+				// 没有校验和和修改时间时大小相同就认为没有变化
+				{Path: "nosum.txt", Size: 4},
+				// 没有校验和时修改时间不同需要重新下载
+				{Path: "mtime.txt", Size: 5, ModTime: mtime},
+				{Path: "sub", IsDir: true},
+				{Path: "sub/add.txt", Size: 5, Sha256: sha256Hex("added")},
+				{Path: "sub/deep/a.b", Size: 4, Sha256: sha256Hex("deep"), Mode: "0600"},
+			},
+			Delete:           true,
+			Exclude:          []string{"logs"},
+			DryRun:           dryRun,
+			ProgressInterval: -1,
+		}
+	}
+	newCtx := func() *Context {
+		server, _ := NewServer("ws://" + strings.TrimPrefix(srv.URL, "http://") + "/ws")
+		ctx := newTestContext()
+		ctx.Client = &Client{Server: server}
+		ctx.Message = &Message{Type: "v1/SyncDir"}
+		return ctx
+	}
+
+	// dry run 只计算差异
+	st := NewSyncTask(req(true))
+	if err := st.Run(newCtx()); err != nil {
+		t.Fatal(err)
+	}
+	r := st.Result
+	sort.Strings(r.Deleted)
+	if strings.Join(r.Added, ",") != "sub/add.txt,sub/deep/a.b" || strings.Join(r.Updated, ",") != "changed.txt,mtime.txt" ||
+		strings.Join(r.Deleted, ",") != "extra.txt,extradir,sub/deep/old.txt" || r.Unchanged != 3 || r.Bytes != 18 {
+		t.Fatalf("unexpected diff: %+v", r)
+	}
+	if downloads != 0 {
+		t.Fatal("dry run downloaded files")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "extra.txt")); err != nil {
+		t.Fatal("dry run deleted files")
+	}
+
+	st = NewSyncTask(req(false))
+	if err := st.Run(newCtx()); err != nil {
+		t.Fatal(err)
+	}
+	if downloads != 4 {
+		t.Fatalf("downloaded %d files, want 4", downloads)
+	}
+	want := map[string]string{
+		"keep.txt":     "keep",
+		"changed.txt":  "new!",
+		"nosum.txt":    "diff",
+		"mtime.txt":    "mtime",
+		"logs/app.log": "log",
+		"sub/add.txt":  "added",
+		"sub/deep/a.b": "deep",
+	}
+	var got []string
+	filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dest, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if len(got) != len(want) {
+		t.Fatalf("unexpected files after sync: %v", got)
+	}
+	for rel, content := range want {
+		b, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(rel)))
+		if err != nil || string(b) != content {
+			t.Errorf("%s = %q, %v, want %q", rel, b, err, content)
+		}
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "sub", "deep", "a.b")); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "mtime.txt")); info.ModTime().Unix() != mtime {
+		t.Errorf("modTime = %v, want %v", info.ModTime().Unix(), mtime)
+	}
+
+	// 再次同步没有变化，也不会重复下载
+	st = NewSyncTask(req(false))
+	if err := st.Run(newCtx()); err != nil {
+		t.Fatal(err)
+	}
+	if r := st.Result; len(r.Added)+len(r.Updated)+len(r.ModeChanged)+len(r.Deleted) != 0 || r.Unchanged != 7 || downloads != 4 {
+		t.Fatalf("second sync not idempotent: %+v, downloads %d", r, downloads)
+	}
+}
+
+func TestSyncTaskRejectsSymlinkEscape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("evil"))
+	}))
+	defer srv.Close()
+
+	dest := t.TempDir()
+	outside := t.TempDir()
+	ioutil.WriteFile(filepath.Join(outside, "keep.txt"), []byte("keep"), 0644)
+	if err := os.Symlink(outside, filepath.Join(dest, "link")); err != nil {
+		t.Skip("symlink not supported:", err)
+	}
+	newCtx := func() *Context {
+		server, _ := NewServer("ws://" + strings.TrimPrefix(srv.URL, "http://") + "/ws")
+		ctx := newTestContext()
+		ctx.Client = &Client{Server: server}
+		ctx.Message = &Message{Type: "v1/SyncDir"}
+		return ctx
+	}
+
+	// 经过目标目录中指向外部的符号链接写入
+	for _, path := range []string{"link/evil.txt", "link/keep.txt"} {
+		st := NewSyncTask(&SyncRequest{TaskID: "sync-link", DestPath: dest, DownloadBase: "base", ProgressInterval: -1,
+			Files: []SyncEntry{{Path: path, Size: 4}}})
+		if err := st.Run(newCtx()); !errors.Is(err, ErrIllegalArchivePath) {
+			t.Fatalf("%s: err = %v, want illegal path", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil.txt")); !os.IsNotExist(err) {
+		t.Fatal("file written outside the destination")
+	}
+
+	// 删除多余的符号链接只删除链接本身
+	st := NewSyncTask(&SyncRequest{TaskID: "sync-delete", DestPath: dest, DownloadBase: "base", Delete: true, ProgressInterval: -1,
+		Files: []SyncEntry{{Path: "a.txt", Size: 4}}})
+	if err := st.Run(newCtx()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "link")); !os.IsNotExist(err) {
+		t.Fatal("extra symlink not deleted")
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(outside, "keep.txt")); err != nil || string(b) != "keep" {
+		t.Fatalf("file outside the destination changed: %q %v", b, err)
+	}
+}
@@ -0,0 +1,201 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/logger"
+)
+
+const (
+	defaultTaskLogDir       = ".data/task-logs"
+	defaultTaskLogRetention = 7 * 24 * time.Hour
+	defaultTaskLogMaxSize   = 1024 * 1024
+	taskLogCleanupInterval  = time.Hour
+	taskLogTruncatedMessage = "task log truncated"
+)
+
+// TaskLogResult 是一个任务的日志，按时间从早到晚排列
+type TaskLogResult struct {
+	TaskID    string      `json:"taskId"`
+	Entries   []*LogEntry `json:"entries"`
+	Truncated bool        `json:"truncated"` // 超过大小上限，之后的日志没有记录
+}
+
+// TaskLogs 把每个任务通过 ctx.Logger 记录的日志单独保存一份，超过保留时间后删除
+type TaskLogs struct {
+	mu        sync.Mutex
+	dir       string
+	retention time.Duration
+	maxSize   int64
+	truncated map[string]bool
+	logger    *logger.Logger
+	done      chan struct{}
+}
+
+func NewTaskLogs(app *app.App) *TaskLogs {
+	tl := &TaskLogs{
+		dir:       app.Config.TaskLogDir,
+		retention: time.Duration(app.Config.TaskLogRetention) * 24 * time.Hour,
+		maxSize:   int64(app.Config.TaskLogMaxSize) * 1024,
+		truncated: make(map[string]bool),
+		logger:    app.Logger,
+		done:      make(chan struct{}),
+	}
+	if tl.dir == "" {
+		tl.dir = defaultTaskLogDir
+	}
+	if tl.retention <= 0 {
+		tl.retention = defaultTaskLogRetention
+	}
+	if tl.maxSize <= 0 {
+		tl.maxSize = defaultTaskLogMaxSize
+	}
+	return tl
+}
+
+// Start 删除过期的任务日志，之后定时清理
+func (tl *TaskLogs) Start() error {
+	if err := os.MkdirAll(tl.dir, 0755); err != nil {
+		return err
+	}
+	tl.Cleanup()
+	go func() {
+		ticker := time.NewTicker(taskLogCleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tl.done:
+				return
+			case <-ticker.C:
+				tl.Cleanup()
+			}
+		}
+	}()
+	return nil
+}
+
+func (tl *TaskLogs) Stop() {
+	close(tl.done)
+}
+
+// Logger 返回同时写入任务日志的 base，taskID 为空时直接返回 base
+func (tl *TaskLogs) Logger(base *logger.Logger, taskID string) *logger.Logger {
+	if taskID == "" {
+		return base
+	}
+	return base.Tee(&taskLogWriter{tl: tl, path: tl.path(taskID)})
+}
+
+// Read 返回任务的日志，任务没有记录过日志时返回空的结果
+func (tl *TaskLogs) Read(taskID string) (*TaskLogResult, error) {
+	if taskID == "" {
+		return nil, fmt.Errorf("taskId is required")
+	}
+	result := &TaskLogResult{TaskID: taskID, Entries: []*LogEntry{}}
+	path := tl.path(taskID)
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	err := scanLogFile(path, func(e *LogEntry) {
+		result.Entries = append(result.Entries, e)
+		result.Truncated = result.Truncated || e.Message == taskLogTruncatedMessage
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Cleanup 删除最后修改时间超过保留时间的任务日志
+func (tl *TaskLogs) Cleanup() {
+	entries, err := ioutil.ReadDir(tl.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			tl.logger.Warnw("read task log dir failed", "dir", tl.dir, "error", err)
+		}
+		return
+	}
+	deadline := time.Now().Add(-tl.retention)
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".log") || e.ModTime().After(deadline) {
+			continue
+		}
+		path := filepath.Join(tl.dir, e.Name())
+		if err := os.Remove(path); err != nil {
+			tl.logger.Warnw("remove task log failed", "path", path, "error", err)
+			continue
+		}
+		delete(tl.truncated, path)
+	}
+}
+
+// path 返回任务日志的路径。文件名使用 taskID 的 sha256，不同的任务不会写到同一个文件，
+// 也不受 taskID 中特殊字符和长度的影响
+func (tl *TaskLogs) path(taskID string) string {
+	sum := sha256.Sum256([]byte(taskID))
+	return filepath.Join(tl.dir, hex.EncodeToString(sum[:])+".log")
+}
+
+// write 追加一行日志，超过大小上限时记录一条截断的日志，之后的日志丢弃
+func (tl *TaskLogs) write(path string, p []byte) error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if tl.truncated[path] {
+		return nil
+	}
+
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	if size >= tl.maxSize {
+		tl.truncated[path] = true
+		return nil
+	}
+	if size+int64(len(p)) > tl.maxSize {
+		tl.truncated[path] = true
+		p = []byte(fmt.Sprintf(`{"level":"warn","ts":%q,"msg":%q,"maxSize":%d}`+"\n",
+			time.Now().Format(logTimeLayout), taskLogTruncatedMessage, tl.maxSize))
+	}
+
+	if err := os.MkdirAll(tl.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(p); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// taskLogWriter 每次写入时打开文件，任务在消息处理结束后继续使用 ctx.Logger 也能记录
+type taskLogWriter struct {
+	tl   *TaskLogs
+	path string
+}
+
+func (w *taskLogWriter) Write(p []byte) (int, error) {
+	if err := w.tl.write(w.path, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *taskLogWriter) Sync() error {
+	return nil
+}
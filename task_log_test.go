@@ -0,0 +1,82 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+)
+
+func TestTaskLogs(t *testing.T) {
+	dir := t.TempDir()
+	c := &config.Config{
+		LogConfig:  config.LogConfig{Level: "info", Filename: filepath.Join(dir, "agent.log")},
+		TaskLogDir: filepath.Join(dir, "task-logs"),
+		// 只能写下几行
+		TaskLogMaxSize: 1,
+	}
+	a := &app.App{Config: c, Logger: logger.NewLogger(c.LogConfig)}
+	tl := NewTaskLogs(a)
+
+	l := tl.Logger(a.Logger, "task/1").WithTrace("t1", "task/1")
+	l.Debugw("prepare", "step", 1)
+	l.Infow("download finished", "size", 10)
+	tl.Logger(a.Logger, "task-2").WithTrace("t2", "task-2").Infow("other task")
+	a.Logger.Infow("global only")
+
+	result, err := tl.Read("task/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// debug 不受全局日志级别影响
+	if len(result.Entries) != 2 || result.Entries[0].Message != "prepare" || result.Entries[1].TraceID != "t1" || result.Truncated {
+		t.Fatalf("unexpected task log: %+v", result.Entries)
+	}
+	if _, err := os.Stat(tl.path("task/1")); err != nil {
+		t.Fatal(err)
+	}
+	// 只是特殊字符不同的任务使用不同的文件
+	if result, err := tl.Read("task_1"); err != nil || len(result.Entries) != 0 || tl.path("task_1") == tl.path("task/1") {
+		t.Fatalf("task logs mixed up: %+v, %v", result, err)
+	}
+
+	// 全局日志中也有任务的日志
+	all, err := NewLogManager(a).Fetch(&LogFetchRequest{TaskID: "task/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Entries) != 1 || all.Entries[0].Message != "download finished" {
+		t.Fatalf("unexpected global log: %+v", all.Entries)
+	}
+
+	for i := 0; i < 20; i++ {
+		l.Infow("progress", "percent", i*5)
+	}
+	result, err = tl.Read("task/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Truncated || result.Entries[len(result.Entries)-1].Message != taskLogTruncatedMessage {
+		t.Fatalf("task log not truncated: %d entries", len(result.Entries))
+	}
+
+	result, err = tl.Read("missing")
+	if err != nil || len(result.Entries) != 0 {
+		t.Fatalf("unexpected result: %+v, %v", result, err)
+	}
+
+	old := time.Now().Add(-8 * 24 * time.Hour)
+	if err := os.Chtimes(tl.path("task-2"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	tl.Cleanup()
+	if _, err := os.Stat(tl.path("task-2")); !os.IsNotExist(err) {
+		t.Fatalf("expired task log not removed: %v", err)
+	}
+	if _, err := os.Stat(tl.path("task/1")); err != nil {
+		t.Fatal(err)
+	}
+}
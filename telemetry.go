@@ -0,0 +1,53 @@
+package updater
+
+import (
+	"io"
+	"time"
+	"updater/pkg/prom"
+)
+
+// telemetry 是 agent 运行时的指标，由 MonitorServer 以 Prometheus 格式输出
+var telemetry = newTelemetry(prom.NewRegistry())
+
+type telemetryMetrics struct {
+	registry        *prom.Registry
+	reconnects      *prom.Counter
+	messagesIn      *prom.CounterVec
+	messagesOut     *prom.CounterVec
+	messagesDropped *prom.Counter
+	handlerDuration *prom.HistogramVec
+	downloadBytes   *prom.Counter
+}
+
+func newTelemetry(r *prom.Registry) *telemetryMetrics {
+	return &telemetryMetrics{
+		registry:        r,
+		reconnects:      r.NewCounter("updater_reconnects_total", "Number of reconnect attempts to the server."),
+		messagesIn:      r.NewCounterVec("updater_messages_received_total", "Messages received from the server by type.", "type"),
+		messagesOut:     r.NewCounterVec("updater_messages_sent_total", "Messages sent to the server by type.", "type"),
+		messagesDropped: r.NewCounter("updater_messages_dropped_total", "Messages dropped because the agent was not connected."),
+		handlerDuration: r.NewHistogramVec("updater_handler_duration_seconds", "Message handler latency by type.", nil, "type"),
+		downloadBytes:   r.NewCounter("updater_download_bytes_total", "Bytes downloaded by download, sync, package and self update tasks."),
+	}
+}
+
+// observeHandler 记录消息处理的耗时，没有注册处理函数的消息类型记为 unknown，避免标签无限增长
+func (t *telemetryMetrics) observeHandler(msgType string, known bool, start time.Time) {
+	if !known {
+		msgType = "unknown"
+	}
+	t.messagesIn.With(msgType).Inc()
+	t.handlerDuration.With(msgType).Observe(time.Since(start).Seconds())
+}
+
+// countDownload 返回在读取时统计下载字节数的 io.Reader
+func countDownload(r io.Reader) io.Reader {
+	return io.TeeReader(r, downloadCounter{})
+}
+
+type downloadCounter struct{}
+
+func (downloadCounter) Write(p []byte) (int, error) {
+	telemetry.downloadBytes.Add(float64(len(p)))
+	return len(p), nil
+}
@@ -0,0 +1,80 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/trace"
+)
+
+// StartTracing 按配置创建 Tracer 并设置为当前的 Tracer，没有启用时返回 nil
+func StartTracing(app *app.App) (*trace.Tracer, error) {
+	cfg := app.Config.Tracing
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	resource := trace.Resource{
+		"service.name":    "updater",
+		"service.version": config.Version,
+		"host.name":       getHostName(),
+	}
+
+	var exporter trace.Exporter
+	switch cfg.Exporter {
+	case "otlp":
+		headers := make(map[string]string)
+		for _, h := range cfg.Headers {
+			name, value, _ := strings.Cut(h, ":")
+			headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+		exporter = trace.NewOTLPExporter(cfg.Endpoint, headers, resource)
+	case "file", "":
+		exporter = trace.NewFileExporter(cfg.File, resource)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", cfg.Exporter)
+	}
+
+	ratio := float64(cfg.SamplePercent) / 100
+	if cfg.SamplePercent <= 0 {
+		ratio = 1
+	}
+	t := trace.NewTracer(exporter, ratio, func(err error) {
+		app.Logger.Warnw("export spans failed", "exporter", cfg.Exporter, "error", err)
+	})
+	trace.SetTracer(t)
+	return t, nil
+}
+
+// StopTracing 导出剩余的 span
+func StopTracing(t *trace.Tracer) {
+	if t == nil {
+		return
+	}
+	trace.SetTracer(nil)
+	t.Shutdown(context.Background())
+}
+
+// messageSpanContext 从消息中解析父 span，没有 traceId 时使用 traceparent 中的 trace ID，便于日志和 span 关联
+func messageSpanContext(ctx context.Context, msg *Message) context.Context {
+	sc, err := trace.ParseTraceParent(msg.TraceParent, msg.TraceState)
+	if err != nil {
+		return ctx
+	}
+	if msg.TraceId == "" {
+		msg.TraceId = sc.TraceID.String()
+	}
+	return trace.ContextWithRemote(ctx, sc)
+}
+
+// spanURL 去掉 URL 中的用户信息和查询参数，查询参数中通常带有 token
+func spanURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
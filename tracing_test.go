@@ -0,0 +1,157 @@
+package updater
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/config"
+	"updater/pkg/logger"
+	"updater/pkg/trace"
+
+	"go.uber.org/zap"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	sc, err := trace.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "vendor=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.IsSampled() || !sc.Remote {
+		t.Fatalf("unexpected span context: %+v", sc)
+	}
+	if got := sc.TraceParent(); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("traceparent = %s", got)
+	}
+	for _, s := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := trace.ParseTraceParent(s, ""); err == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
+
+// otlpLine 只解析测试需要的字段
+type otlpLine struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key string `json:"key"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID      string `json:"traceId"`
+				SpanID       string `json:"spanId"`
+				ParentSpanID string `json:"parentSpanId"`
+				Name         string `json:"name"`
+				Kind         int    `json:"kind"`
+				Status       struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestMessageTracing(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.jsonl")
+	a := &app.App{
+		Config: &config.Config{Tracing: config.TracingConfig{Enabled: true, Exporter: "file", File: file, SamplePercent: 100}},
+		Logger: &logger.Logger{SugaredLogger: zap.NewNop().Sugar()},
+	}
+	tracer, err := StartTracing(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer StopTracing(tracer)
+
+	h := NewMessageHandler(10)
+	h.RegisterHandler("v1/Test/Trace", func(ctx *Context) error {
+		span := ctx.StartSpan("step", "n", 1)
+		span.End(nil)
+		ctx.JSONSuccess(nil)
+		return nil
+	})
+	client := &Client{send: make(chan []byte, 10), app: a}
+	client.Connected.Store(true)
+	h.HandleMessages(client, 1)
+	h.SubmitMessage(&Message{
+		Id:          "m1",
+		Type:        "v1/Test/Trace",
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+
+	var resp Message
+	select {
+	case b := <-client.send:
+		if err := json.Unmarshal(b, &resp); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no response")
+	}
+	// 没有 traceId 时使用 traceparent 中的 trace ID
+	if resp.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || !strings.HasPrefix(resp.TraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") ||
+		strings.Contains(resp.TraceParent, "00f067aa0ba902b7") {
+		t.Fatalf("unexpected response trace: %s %s", resp.TraceId, resp.TraceParent)
+	}
+	serverSpanID := strings.Split(resp.TraceParent, "-")[2]
+
+	// 等待处理函数返回、span 结束
+	time.Sleep(100 * time.Millisecond)
+	tracer.ForceFlush()
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line otlpLine
+	if err := json.Unmarshal([]byte(strings.Split(string(b), "\n")[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if len(line.ResourceSpans) != 1 || len(line.ResourceSpans[0].Resource.Attributes) == 0 {
+		t.Fatalf("unexpected resource: %s", b)
+	}
+	spans := line.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %s", b)
+	}
+	step, server := spans[0], spans[1]
+	if server.Name != "v1/Test/Trace" || server.Kind != int(trace.SpanKindServer) || server.SpanID != serverSpanID ||
+		server.ParentSpanID != "00f067aa0ba902b7" || server.Status.Code != int(trace.StatusOK) {
+		t.Fatalf("unexpected server span: %+v", server)
+	}
+	if step.Name != "step" || step.ParentSpanID != server.SpanID || step.TraceID != server.TraceID {
+		t.Fatalf("unexpected step span: %+v", step)
+	}
+}
+
+func TestTracingDisabledPropagates(t *testing.T) {
+	trace.SetTracer(nil)
+	ctx := newTestContext()
+	sc, _ := trace.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "")
+	ctx.Ctx = trace.ContextWithRemote(ctx.Ctx, sc)
+	ctx.Message = &Message{}
+
+	span := ctx.StartSpan("noop")
+	if span.IsRecording() || span.SpanContext().TraceID != sc.TraceID {
+		t.Fatalf("unexpected span: %+v", span.SpanContext())
+	}
+	span.End(nil)
+
+	msg := &Message{}
+	ctx.setTraceParent(msg)
+	if msg.TraceParent != sc.TraceParent() {
+		t.Fatalf("traceparent = %s", msg.TraceParent)
+	}
+}
@@ -0,0 +1,433 @@
+package updater
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"updater/pkg/task"
+	"updater/pkg/trace"
+)
+
+const (
+	TaskTypeUpload = "upload"
+
+	defaultUploadChunkSize = 256 * 1024
+	// 发送队列中的消息达到这个数量时暂停发送数据块，限制上传占用的内存
+	uploadQueueLimit = 8
+)
+
+var (
+	ErrUploadTooLarge    = errors.New("upload size exceeds limit")
+	ErrUploadInterrupted = errors.New("connection lost during upload")
+)
+
+// UploadRequest 是上传请求参数
+type UploadRequest struct {
+	TaskID           string            `json:"taskId"`           // 任务ID
+	Path             string            `json:"path"`             // 本地文件或目录路径
+	URL              string            `json:"url"`              // 上传 URL，为空时通过 WebSocket 分块上传
+	Method           string            `json:"method"`           // HTTP 方法，默认 PUT
+	Headers          map[string]string `json:"headers"`          // 额外的 HTTP 头
+	Include          []string          `json:"include"`          // 目录打包时包含的文件 glob
+	Exclude          []string          `json:"exclude"`          // 目录打包时排除的文件 glob
+	Compress         bool              `json:"compress"`         // 单个文件是否使用 gzip 压缩，目录总是打包为 tar.gz
+	MaxSize          int64             `json:"maxSize"`          // 最大上传大小（字节），0 不限制
+	ChunkSize        int               `json:"chunkSize"`        // WebSocket 分块大小（字节）
+	Timeout          int               `json:"timeout"`          // 超时时间
+	ProgressInterval int               `json:"progressInterval"` // 进度上报间隔（秒），0 使用默认值，小于 0 不上报
+}
+
+// UploadChunk 是通过 WebSocket 上传时的数据块，最后一块 EOF 为 true 并携带校验和
+type UploadChunk struct {
+	TaskID string `json:"taskId"`
+	Name   string `json:"name"`
+	Seq    int    `json:"seq"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Data   []byte `json:"data"`
+	EOF    bool   `json:"eof"`
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// UploadResult 上传任务的执行结果
+type UploadResult struct {
+	TaskID     string    `json:"taskId"`
+	Code       string    `json:"code"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+	Message    string    `json:"message"`
+	Name       string    `json:"name"`       // 上传的文件名
+	Size       int64     `json:"size"`       // 上传的字节数
+	Sha256     string    `json:"sha256"`     // 上传内容的 sha256
+	Compressed bool      `json:"compressed"` // 内容是否经过 gzip 压缩
+	Progress   *Progress `json:"progress,omitempty"`
+}
+
+type UploadTask struct {
+	TaskID  string
+	Request *UploadRequest
+	Status  task.TaskStatus
+	Created time.Time
+	Updated time.Time
+	Result  *UploadResult
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	progress *ProgressCounter
+	fm       *FileManager
+}
+
+func NewUploadTask(req *UploadRequest) *UploadTask {
+	return &UploadTask{
+		TaskID:  req.TaskID,
+		Request: req,
+		Status:  task.TaskStatusCreated,
+		Created: time.Now(),
+		Updated: time.Now(),
+		Result: &UploadResult{
+			TaskID: req.TaskID,
+		},
+		fm: NewFileManager(),
+	}
+}
+
+func (ut *UploadTask) GetTaskID() string {
+	return ut.TaskID
+}
+
+func (ut *UploadTask) GetType() string {
+	return TaskTypeUpload
+}
+
+func (ut *UploadTask) GetStatus() task.TaskStatus {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	return ut.Status
+}
+
+func (ut *UploadTask) GetContent() []byte {
+	return []byte(ut.Request.Path)
+}
+
+func (ut *UploadTask) SetStatus(status task.TaskStatus) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	ut.Status = status
+	ut.Updated = time.Now()
+}
+
+// GetResult 返回上传结果，任务运行中时附带当前进度
+func (ut *UploadTask) GetResult() interface{} {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	r := *ut.Result
+	if ut.progress != nil {
+		p := ut.progress.Snapshot()
+		r.Progress = &p
+	}
+	return &r
+}
+
+func (ut *UploadTask) Stop() error {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	if ut.cancel == nil {
+		return task.ErrTaskNotRunning
+	}
+	ut.Status = task.TaskStatusCanceled
+	ut.cancel()
+	return nil
+}
+
+// Run 执行上传，上传进度通过 "<消息类型>/Progress" 消息定期上报给服务器
+func (ut *UploadTask) Run(ctx *Context) (err error) {
+	req := ut.Request
+	ut.Result.StartTime = time.Now()
+
+	defer func() {
+		ut.mu.Lock()
+		defer ut.mu.Unlock()
+		ut.Result.EndTime = time.Now()
+		ut.Updated = time.Now()
+		ut.cancel = nil
+		if err != nil {
+			ut.Result.Message = err.Error()
+			ut.Result.Code = ErrorCode(err)
+			if ut.Status != task.TaskStatusCanceled {
+				ut.Status = task.TaskStatusFailed
+			}
+			return
+		}
+		ut.Result.Code = CODE_SUCCESS
+		ut.Result.Message = "success"
+		ut.Status = task.TaskStatusCompleted
+	}()
+
+	c, span := trace.Start(ctx.Ctx, "upload", "task.id", ut.TaskID, "file.path", req.Path, "http.url", spanURL(req.URL))
+	defer func() {
+		span.SetAttributes("upload.bytes", ut.Result.Size)
+		span.End(err)
+	}()
+
+	var cancel context.CancelFunc
+	if req.Timeout > 0 {
+		c, cancel = context.WithTimeout(c, time.Second*time.Duration(req.Timeout))
+	} else {
+		c, cancel = context.WithCancel(c)
+	}
+	defer cancel()
+
+	ut.mu.Lock()
+	ut.Status = task.TaskStatusRunning
+	ut.cancel = cancel
+	ut.mu.Unlock()
+
+	payload, err := ut.preparePayload()
+	if err != nil {
+		return err
+	}
+	if payload != req.Path {
+		defer os.Remove(payload)
+	}
+	ctx.Logger.Println("upload payload:", payload, "size:", ut.Result.Size, "sha256:", ut.Result.Sha256)
+
+	file, err := os.Open(payload)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pc := NewProgressCounter(ut.TaskID, ut.Result.Size)
+	ut.mu.Lock()
+	ut.progress = pc
+	ut.mu.Unlock()
+
+	ReportProgress(c, time.Duration(req.ProgressInterval)*time.Second, pc, func(p Progress) {
+		ctx.Notify(ctx.Message.Type+"/Progress", p)
+	})
+
+	if req.URL != "" {
+		err = ut.uploadHTTP(c, pc.Reader(file))
+	} else {
+		err = ut.uploadWebSocket(c, ctx, pc.Reader(file))
+	}
+	if err != nil {
+		return err
+	}
+
+	if req.ProgressInterval >= 0 {
+		ctx.Notify(ctx.Message.Type+"/Progress", pc.Snapshot())
+	}
+	return nil
+}
+
+// preparePayload 准备要上传的文件：目录打包为 tar.gz，需要压缩的文件先 gzip。
+// 返回实际上传的文件路径，同时计算大小和校验和
+func (ut *UploadTask) preparePayload() (string, error) {
+	req := ut.Request
+	if err := ut.fm.CheckPath(PathOpRead, req.Path); err != nil {
+		return "", err
+	}
+	info, err := os.Stat(req.Path)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(req.Path)
+	if !info.IsDir() && !req.Compress {
+		if req.MaxSize > 0 && info.Size() > req.MaxSize {
+			return "", ErrUploadTooLarge
+		}
+		sum, err := fileSha256(req.Path)
+		if err != nil {
+			return "", err
+		}
+		ut.Result.Name = name
+		ut.Result.Size = info.Size()
+		ut.Result.Sha256 = sum
+		return req.Path, nil
+	}
+
+	tmp, err := ioutil.TempFile("", ut.TaskID+".upload")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	hash := sha256.New()
+	w := io.MultiWriter(tmp, hash)
+	if req.MaxSize > 0 {
+		w = &limitedWriter{w: w, n: req.MaxSize}
+	}
+
+	if info.IsDir() {
+		name += ".tar.gz"
+		err = ut.fm.writeTarGz(w, req.Path, req.Include, req.Exclude)
+	} else {
+		name += ".gz"
+		err = gzipFile(w, req.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	ut.Result.Name = name
+	ut.Result.Size = size
+	ut.Result.Sha256 = hex.EncodeToString(hash.Sum(nil))
+	ut.Result.Compressed = true
+	return tmp.Name(), nil
+}
+
+func (ut *UploadTask) uploadHTTP(c context.Context, body io.Reader) error {
+	req := ut.Request
+	method := req.Method
+	if method == "" {
+		method = http.MethodPut
+	}
+
+	httpReq, err := http.NewRequestWithContext(c, method, req.URL, body)
+	if err != nil {
+		return err
+	}
+	httpReq.ContentLength = ut.Result.Size
+	httpReq.Header.Set("Content-Type", "application/octet-stream")
+	httpReq.Header.Set("X-File-Name", ut.Result.Name)
+	httpReq.Header.Set("X-Checksum-Sha256", ut.Result.Sha256)
+	trace.Inject(c, httpReq.Header)
+	httpReq.Header.Set("X-Task-Id", ut.TaskID)
+	if ut.Result.Compressed {
+		httpReq.Header.Set("X-Content-Compressed", strconv.FormatBool(true))
+	}
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("upload failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// uploadWebSocket 通过 "<消息类型>/Chunk" 消息分块发送。发送队列满时等待，
+// 连接中断过时数据块可能已经丢失，上传失败
+func (ut *UploadTask) uploadWebSocket(c context.Context, ctx *Context, r io.Reader) error {
+	chunkSize := ut.Request.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultUploadChunkSize
+	}
+
+	epoch := ctx.Client.Epoch()
+	buf := make([]byte, chunkSize)
+	var offset int64
+	for seq := 0; ; seq++ {
+		if err := ctx.Client.WaitQueue(c, uploadQueueLimit); err != nil {
+			return err
+		}
+		if ctx.Client.Epoch() != epoch {
+			return ErrUploadInterrupted
+		}
+
+		n, rerr := io.ReadFull(r, buf)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return rerr
+		}
+		eof := rerr != nil || offset+int64(n) >= ut.Result.Size
+
+		chunk := &UploadChunk{
+			TaskID: ut.TaskID,
+			Name:   ut.Result.Name,
+			Seq:    seq,
+			Offset: offset,
+			Size:   ut.Result.Size,
+			Data:   buf[:n],
+			EOF:    eof,
+		}
+		if eof {
+			chunk.Sha256 = ut.Result.Sha256
+		}
+		if err := ctx.Notify(ctx.Message.Type+"/Chunk", chunk); err != nil {
+			return err
+		}
+
+		offset += int64(n)
+		if eof {
+			break
+		}
+	}
+
+	// 等待所有数据块交给连接发送
+	if err := ctx.Client.WaitQueue(c, 1); err != nil {
+		return err
+	}
+	if ctx.Client.Epoch() != epoch {
+		return ErrUploadInterrupted
+	}
+	return nil
+}
+
+// limitedWriter 写入超过 n 字节时返回 ErrUploadTooLarge
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > lw.n {
+		return 0, ErrUploadTooLarge
+	}
+	n, err := lw.w.Write(p)
+	lw.n -= int64(n)
+	return n, err
+}
+
+func gzipFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(w)
+	if _, err := io.Copy(gw, f); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
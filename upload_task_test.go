@@ -0,0 +1,167 @@
+package updater
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+	"updater/pkg/config"
+)
+
+func writeUploadFile(t *testing.T, size int) (string, []byte) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestUploadHTTP(t *testing.T) {
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{})
+
+	path, data := writeUploadFile(t, 100000)
+	sum := sha256.Sum256(data)
+	var got []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		header = r.Header
+		got, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	ut := NewUploadTask(&UploadRequest{TaskID: "u1", Path: path, URL: srv.URL, Headers: map[string]string{"X-Extra": "1"}, ProgressInterval: -1})
+	if err := ut.Run(newTestContext()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("uploaded %d bytes, want %d", len(got), len(data))
+	}
+	if header.Get("X-Checksum-Sha256") != hex.EncodeToString(sum[:]) || header.Get("X-File-Name") != "data.bin" ||
+		header.Get("X-Task-Id") != "u1" || header.Get("X-Extra") != "1" {
+		t.Fatalf("unexpected headers: %v", header)
+	}
+
+	ut = NewUploadTask(&UploadRequest{TaskID: "u2", Path: path, URL: srv.URL + "/fail", ProgressInterval: -1})
+	if err := ut.Run(newTestContext()); err == nil || ut.Result.Code != CODE_ERROR {
+		t.Fatalf("failed upload: err = %v, code = %s", err, ut.Result.Code)
+	}
+
+	ut = NewUploadTask(&UploadRequest{TaskID: "u3", Path: path, URL: srv.URL, MaxSize: 1000, ProgressInterval: -1})
+	if err := ut.Run(newTestContext()); !errors.Is(err, ErrUploadTooLarge) {
+		t.Fatalf("upload over max size: %v", err)
+	}
+}
+
+func newUploadContext() (*Context, *Client) {
+	client := &Client{send: make(chan []byte, 4096)}
+	client.Connected.Store(true)
+	ctx := newTestContext()
+	ctx.Client = client
+	ctx.Message = &Message{Type: "v1/UploadFile", TaskId: "u1"}
+	return ctx, client
+}
+
+func TestUploadWebSocketChunks(t *testing.T) {
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{})
+
+	path, data := writeUploadFile(t, 10000)
+	ctx, client := newUploadContext()
+
+	var chunks []*UploadChunk
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for b := range client.send {
+			var msg Message
+			var chunk UploadChunk
+			if json.Unmarshal(b, &msg) != nil || msg.Type != "v1/UploadFile/Chunk" || json.Unmarshal(msg.Data, &chunk) != nil {
+				t.Errorf("unexpected message: %s", b)
+				continue
+			}
+			chunks = append(chunks, &chunk)
+			if chunk.EOF {
+				return
+			}
+		}
+	}()
+
+	ut := NewUploadTask(&UploadRequest{TaskID: "u1", Path: path, ChunkSize: 3000, ProgressInterval: -1})
+	if err := ut.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	var got []byte
+	for i, c := range chunks {
+		if c.Seq != i || c.Offset != int64(len(got)) || c.Size != int64(len(data)) || c.EOF != (i == len(chunks)-1) {
+			t.Fatalf("unexpected chunk %d: %+v", i, c)
+		}
+		got = append(got, c.Data...)
+	}
+	sum := sha256.Sum256(data)
+	if len(chunks) != 4 || !bytes.Equal(got, data) || chunks[3].Sha256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected chunks: %d chunks, %d bytes", len(chunks), len(got))
+	}
+}
+
+func TestUploadWebSocketFlowControl(t *testing.T) {
+	old := config.GetConfig()
+	defer config.SetConfig(old)
+	config.SetConfig(&config.Config{})
+
+	path, _ := writeUploadFile(t, 100000)
+	ctx, client := newUploadContext()
+
+	// 没有人读取发送队列，上传在队列满时等待
+	ut := NewUploadTask(&UploadRequest{TaskID: "u1", Path: path, ChunkSize: 1000, ProgressInterval: -1})
+	errc := make(chan error, 1)
+	go func() { errc <- ut.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+	if n := len(client.send); n != uploadQueueLimit {
+		t.Fatalf("queued %d chunks, want %d", n, uploadQueueLimit)
+	}
+
+	// 连接中断后上传失败，不会继续排队
+	client.Connected.Store(false)
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrNotConnected) {
+			t.Fatalf("upload after disconnect: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("upload not failed after disconnect")
+	}
+
+	// 上传过程中重连过，数据块可能已经丢失
+	ctx, client = newUploadContext()
+	go func() {
+		for range client.send {
+			atomic.AddUint64(&client.epoch, 1)
+		}
+	}()
+	ut = NewUploadTask(&UploadRequest{TaskID: "u2", Path: path, ChunkSize: 1000, ProgressInterval: -1})
+	if err := ut.Run(ctx); !errors.Is(err, ErrUploadInterrupted) {
+		t.Fatalf("upload after reconnect: %v", err)
+	}
+	close(client.send)
+}
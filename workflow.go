@@ -0,0 +1,587 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/logger"
+	"updater/pkg/task"
+)
+
+const (
+	TaskTypeWorkflow = "workflow"
+	MsgTypeWorkflow  = "v1/Workflow"
+
+	WorkflowStepDownload    = "download"
+	WorkflowStepScript      = "script"
+	WorkflowStepExtract     = "extract"
+	WorkflowStepMove        = "move"
+	WorkflowStepDelete      = "delete"
+	WorkflowStepWriteFile   = "writeFile"
+	WorkflowStepHealthCheck = "healthCheck"
+
+	StepStatusPending   = "pending"
+	StepStatusRunning   = "running"
+	StepStatusSucceeded = "succeeded"
+	StepStatusFailed    = "failed"
+	StepStatusIgnored   = "ignored" // 失败但设置了 continueOnError
+	StepStatusSkipped   = "skipped" // 前面的步骤失败或任务被取消，没有执行
+
+	defaultWorkflowRetryInterval = 2 * time.Second
+	defaultWorkflowScriptTimeout = 300
+)
+
+var (
+	ErrWorkflowStepFailed  = errors.New("workflow step failed")
+	ErrWorkflowInterrupted = errors.New("step interrupted by agent restart")
+)
+
+// WorkflowStep 是工作流中的一个步骤，根据 Type 使用对应的请求参数
+type WorkflowStep struct {
+	Name            string             `json:"name"` // 步骤名称，为空时使用 step-<序号>
+	Type            string             `json:"type"` // 类型：download、script、extract、move、delete、writeFile、healthCheck
+	Download        *DownloadRequest   `json:"download,omitempty"`
+	Script          *ScriptTaskRequest `json:"script,omitempty"`
+	Extract         *ExtractRequest    `json:"extract,omitempty"`
+	Move            *MoveRequest       `json:"move,omitempty"`
+	Delete          *DeleteRequest     `json:"delete,omitempty"`
+	WriteFile       *WriteFileRequest  `json:"writeFile,omitempty"`
+	HealthCheck     *HealthCheckSpec   `json:"healthCheck,omitempty"`
+	Timeout         int                `json:"timeout"`            // 每次执行的超时时间（秒），0 表示不限制
+	Retries         int                `json:"retries"`            // 失败后的重试次数
+	RetryInterval   int                `json:"retryInterval"`      // 重试间隔（秒）
+	ContinueOnError bool               `json:"continueOnError"`    // 失败后继续执行后面的步骤
+	Rollback        *WorkflowStep      `json:"rollback,omitempty"` // 工作流失败时执行的补偿步骤
+}
+
+// WorkflowRequest 是工作流任务的请求参数
+type WorkflowRequest struct {
+	TaskID string         `json:"taskId"`
+	Name   string         `json:"name"`
+	Steps  []WorkflowStep `json:"steps"`
+}
+
+// WorkflowStepResult 是一个步骤的执行结果
+type WorkflowStepResult struct {
+	Name      string              `json:"name"`
+	Type      string              `json:"type"`
+	Status    string              `json:"status"`
+	Attempts  int                 `json:"attempts"`
+	Error     string              `json:"error,omitempty"`
+	Output    json.RawMessage     `json:"output,omitempty"` // 步骤最后一次执行的结果
+	StartTime time.Time           `json:"startTime"`
+	EndTime   time.Time           `json:"endTime"`
+	Rollback  *WorkflowStepResult `json:"rollback,omitempty"` // 补偿步骤的执行结果
+}
+
+// WorkflowResult 是工作流任务的执行结果，同时也是保存在 TaskStore 中用来恢复执行的状态
+type WorkflowResult struct {
+	TaskID      string                `json:"taskId"`
+	Name        string                `json:"name"`
+	Code        string                `json:"code"`
+	Message     string                `json:"message"`
+	StartTime   time.Time             `json:"startTime"`
+	EndTime     time.Time             `json:"endTime"`
+	Current     int                   `json:"current"`     // 当前执行到的步骤
+	RollingBack bool                  `json:"rollingBack"` // 正在执行补偿步骤
+	Steps       []*WorkflowStepResult `json:"steps"`
+	Request     *WorkflowRequest      `json:"request"`
+}
+
+// WorkflowTask 在 agent 上按顺序执行一组步骤，某个步骤失败时按相反的顺序执行已经执行过的步骤的补偿步骤。
+// 每个步骤开始和结束时都会把状态保存到 TaskStore，agent 重启后从中断的步骤继续执行
+type WorkflowTask struct {
+	TaskID  string
+	Request *WorkflowRequest
+	Status  task.TaskStatus
+	Created time.Time
+	Updated time.Time
+	Result  *WorkflowResult
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	fm     *FileManager
+}
+
+func NewWorkflowTask(req *WorkflowRequest) *WorkflowTask {
+	for i := range req.Steps {
+		step := &req.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step-%d", i+1)
+		}
+		if step.Rollback != nil && step.Rollback.Name == "" {
+			step.Rollback.Name = step.Name + "-rollback"
+		}
+	}
+
+	result := &WorkflowResult{
+		TaskID:  req.TaskID,
+		Name:    req.Name,
+		Request: req,
+	}
+	for _, step := range req.Steps {
+		result.Steps = append(result.Steps, &WorkflowStepResult{Name: step.Name, Type: step.Type, Status: StepStatusPending})
+	}
+	return &WorkflowTask{
+		TaskID:  req.TaskID,
+		Request: req,
+		Status:  task.TaskStatusCreated,
+		Created: time.Now(),
+		Updated: time.Now(),
+		Result:  result,
+		fm:      NewFileManager(),
+	}
+}
+
+// LoadWorkflows 从 TaskStore 中读取 agent 重启前没有执行完的工作流
+func LoadWorkflows(store *task.TaskStore, logger *logger.Logger) ([]*WorkflowTask, error) {
+	infos, err := store.ListTasks()
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []*WorkflowTask
+	for _, info := range infos {
+		if info.Type != TaskTypeWorkflow || (info.Status != task.TaskStatusCreated && info.Status != task.TaskStatusRunning) {
+			continue
+		}
+		result := new(WorkflowResult)
+		if err := json.Unmarshal(info.Result, result); err != nil || result.Request == nil {
+			// 损坏的记录不影响其他工作流恢复
+			logger.Println("skip workflow:", info.TaskID, "invalid state:", err)
+			continue
+		}
+		tasks = append(tasks, &WorkflowTask{
+			TaskID:  info.TaskID,
+			Request: result.Request,
+			Status:  info.Status,
+			Created: result.StartTime,
+			Updated: time.Now(),
+			Result:  result,
+			fm:      NewFileManager(),
+		})
+	}
+	return tasks, nil
+}
+
+// ResumeWorkflows 在后台继续执行 agent 重启前没有执行完的工作流，结束后通过 "v1/Workflow/Result" 上报结果
+func ResumeWorkflows(client *Client, a *app.App) error {
+	tasks, err := LoadWorkflows(a.TaskStore, a.Logger)
+	if err != nil {
+		return err
+	}
+	for _, wt := range tasks {
+		if err := a.TaskManager.AddTask(wt); err != nil {
+			a.Logger.Println("resume workflow failed:", wt.TaskID, err)
+			continue
+		}
+		a.Logger.Println("resume workflow:", wt.TaskID, "step:", wt.Result.Current, "rollingBack:", wt.Result.RollingBack)
+
+		ctx := &Context{
+			Client:  client,
+			Message: &Message{Id: wt.TaskID, Type: MsgTypeWorkflow, TaskId: wt.TaskID},
+			Ctx:     context.Background(),
+			Logger:  a.Logger,
+			app:     a,
+		}
+		go func(wt *WorkflowTask) {
+			wt.Run(ctx)
+			ctx.Notify(MsgTypeWorkflow+"/Result", wt.GetResult())
+		}(wt)
+	}
+	return nil
+}
+
+func (wt *WorkflowTask) GetTaskID() string {
+	return wt.TaskID
+}
+
+func (wt *WorkflowTask) GetType() string {
+	return TaskTypeWorkflow
+}
+
+func (wt *WorkflowTask) GetStatus() task.TaskStatus {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	return wt.Status
+}
+
+func (wt *WorkflowTask) GetContent() []byte {
+	return []byte(wt.Request.Name)
+}
+
+func (wt *WorkflowTask) SetStatus(status task.TaskStatus) {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	wt.Status = status
+	wt.Updated = time.Now()
+}
+
+// GetResult 返回工作流结果的副本
+func (wt *WorkflowTask) GetResult() interface{} {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	r := *wt.Result
+	r.Steps = make([]*WorkflowStepResult, len(wt.Result.Steps))
+	for i, sr := range wt.Result.Steps {
+		r.Steps[i] = sr.copy()
+	}
+	return &r
+}
+
+// Stop 取消工作流，正在执行的步骤（包括脚本）随之结束，之后执行补偿步骤
+func (wt *WorkflowTask) Stop() error {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	if wt.cancel == nil {
+		return task.ErrTaskNotRunning
+	}
+	wt.Status = task.TaskStatusCanceled
+	wt.cancel()
+	return nil
+}
+
+// Run 从当前步骤开始执行工作流，失败或取消时执行补偿步骤
+func (wt *WorkflowTask) Run(ctx *Context) (err error) {
+	r := wt.Result
+	wt.update(func() {
+		if r.StartTime.IsZero() {
+			r.StartTime = time.Now()
+		}
+	})
+
+	defer func() {
+		wt.mu.Lock()
+		r.EndTime = time.Now()
+		wt.Updated = time.Now()
+		wt.cancel = nil
+		if err != nil {
+			r.Message = err.Error()
+			r.Code = ErrorCode(err)
+			if wt.Status != task.TaskStatusCanceled {
+				wt.Status = task.TaskStatusFailed
+			}
+		} else {
+			r.Code = CODE_SUCCESS
+			r.Message = "success"
+			wt.Status = task.TaskStatusCompleted
+		}
+		wt.mu.Unlock()
+		wt.save(ctx)
+	}()
+
+	if err = wt.validate(); err != nil {
+		return err
+	}
+
+	c, cancel := context.WithCancel(ctx.Ctx)
+	defer cancel()
+
+	wt.mu.Lock()
+	wt.Status = task.TaskStatusRunning
+	wt.cancel = cancel
+	wt.mu.Unlock()
+
+	if r.RollingBack {
+		// 重启前已经在执行补偿步骤
+		err = errors.New(r.Message)
+	} else if err = wt.runSteps(c, ctx); err == nil {
+		return nil
+	} else {
+		wt.update(func() {
+			r.RollingBack = true
+			r.Message = err.Error()
+		})
+	}
+
+	ctx.Logger.Println("workflow failed, rolling back:", wt.TaskID, err)
+	rc := ctx.Ctx
+	if rc.Err() != nil {
+		rc = context.Background()
+	}
+	wt.rollback(rc, ctx)
+	return err
+}
+
+func (wt *WorkflowTask) validate() error {
+	if len(wt.Request.Steps) == 0 {
+		return errors.New("workflow has no steps")
+	}
+	names := make(map[string]bool)
+	for i := range wt.Request.Steps {
+		step := &wt.Request.Steps[i]
+		if names[step.Name] {
+			return fmt.Errorf("duplicate workflow step name: %s", step.Name)
+		}
+		names[step.Name] = true
+		if err := step.validate(); err != nil {
+			return err
+		}
+		if step.Rollback != nil {
+			if err := step.Rollback.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (step *WorkflowStep) validate() error {
+	var missing bool
+	switch step.Type {
+	case WorkflowStepDownload:
+		missing = step.Download == nil
+	case WorkflowStepScript:
+		missing = step.Script == nil
+	case WorkflowStepExtract:
+		missing = step.Extract == nil
+	case WorkflowStepMove:
+		missing = step.Move == nil
+	case WorkflowStepDelete:
+		missing = step.Delete == nil
+	case WorkflowStepWriteFile:
+		missing = step.WriteFile == nil
+	case WorkflowStepHealthCheck:
+		missing = step.HealthCheck == nil
+	default:
+		return fmt.Errorf("unknown workflow step type: %s: %s", step.Name, step.Type)
+	}
+	if missing {
+		return fmt.Errorf("workflow step %s: %s is empty", step.Name, step.Type)
+	}
+	return nil
+}
+
+// runSteps 依次执行步骤，遇到没有设置 continueOnError 的失败步骤或者被取消时停止
+func (wt *WorkflowTask) runSteps(c context.Context, ctx *Context) error {
+	r := wt.Result
+	steps := wt.Request.Steps
+	for i := r.Current; i < len(steps); i++ {
+		wt.update(func() { r.Current = i })
+
+		// 重启前已经结束的步骤不再执行
+		var err error
+		switch r.Steps[i].Status {
+		case StepStatusSucceeded, StepStatusIgnored:
+			continue
+		case StepStatusFailed:
+			err = errors.New(r.Steps[i].Error)
+		default:
+			if err = c.Err(); err == nil {
+				err = wt.runStep(c, ctx, &steps[i], r.Steps[i])
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if steps[i].ContinueOnError && c.Err() == nil {
+			wt.update(func() { r.Steps[i].Status = StepStatusIgnored })
+			ctx.Logger.Println("workflow step failed, continue:", steps[i].Name, err)
+			continue
+		}
+
+		wt.update(func() {
+			for _, sr := range r.Steps[i:] {
+				if sr.Status == StepStatusPending {
+					sr.Status = StepStatusSkipped
+				}
+			}
+		})
+		if c.Err() != nil {
+			return c.Err()
+		}
+		return fmt.Errorf("%w: %s: %v", ErrWorkflowStepFailed, steps[i].Name, err)
+	}
+	wt.update(func() { r.Current = len(steps) })
+	return nil
+}
+
+// rollback 按相反的顺序执行已经执行过的步骤的补偿步骤，补偿步骤失败时继续执行前面的补偿步骤
+func (wt *WorkflowTask) rollback(c context.Context, ctx *Context) {
+	r := wt.Result
+	steps := wt.Request.Steps
+	for i := r.Current; i >= 0; i-- {
+		if i >= len(steps) || steps[i].Rollback == nil {
+			continue
+		}
+		sr := r.Steps[i]
+		switch sr.Status {
+		case StepStatusPending, StepStatusSkipped:
+			continue
+		}
+		if sr.Rollback == nil {
+			wt.update(func() {
+				sr.Rollback = &WorkflowStepResult{Name: steps[i].Rollback.Name, Type: steps[i].Rollback.Type, Status: StepStatusPending}
+			})
+		}
+		if sr.Rollback.Status == StepStatusSucceeded || sr.Rollback.Status == StepStatusFailed {
+			continue
+		}
+		if err := wt.runStep(c, ctx, steps[i].Rollback, sr.Rollback); err != nil {
+			ctx.Logger.Println("workflow rollback step failed:", steps[i].Rollback.Name, err)
+		}
+	}
+}
+
+// runStep 执行一个步骤，失败后按间隔重试。执行前保存状态，重启后中断的步骤会重新执行并计入重试次数
+func (wt *WorkflowTask) runStep(c context.Context, ctx *Context, step *WorkflowStep, sr *WorkflowStepResult) error {
+	interval := time.Duration(step.RetryInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultWorkflowRetryInterval
+	}
+
+	err := ErrWorkflowInterrupted
+	for first := true; sr.Attempts <= step.Retries; first = false {
+		if !first {
+			select {
+			case <-c.Done():
+				return wt.finishStep(ctx, sr, err)
+			case <-time.After(interval):
+			}
+		}
+
+		wt.update(func() {
+			sr.Attempts++
+			sr.Status = StepStatusRunning
+			sr.Error = ""
+			if sr.StartTime.IsZero() {
+				sr.StartTime = time.Now()
+			}
+		})
+		wt.save(ctx)
+		ctx.Logger.Println("workflow step:", wt.TaskID, step.Name, "attempt:", sr.Attempts)
+
+		var output interface{}
+		output, err = wt.execute(c, ctx, step)
+		b, _ := json.Marshal(output)
+		wt.update(func() { sr.Output = b })
+		if err == nil || c.Err() != nil {
+			break
+		}
+		ctx.Logger.Println("workflow step failed:", step.Name, err)
+	}
+	return wt.finishStep(ctx, sr, err)
+}
+
+func (wt *WorkflowTask) finishStep(ctx *Context, sr *WorkflowStepResult, err error) error {
+	wt.update(func() {
+		sr.EndTime = time.Now()
+		if err != nil {
+			sr.Status = StepStatusFailed
+			sr.Error = err.Error()
+		} else {
+			sr.Status = StepStatusSucceeded
+		}
+	})
+	wt.save(ctx)
+	wt.notify(ctx, MsgTypeWorkflow+"/Step", sr)
+	return err
+}
+
+// execute 执行一次步骤，返回步骤的结果
+func (wt *WorkflowTask) execute(c context.Context, ctx *Context, step *WorkflowStep) (interface{}, error) {
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(c, time.Duration(step.Timeout)*time.Second)
+		defer cancel()
+	}
+	sctx := *ctx
+	sctx.Ctx = c
+	id := wt.TaskID + "-" + step.Name
+
+	switch step.Type {
+	case WorkflowStepDownload:
+		req := *step.Download
+		req.TaskID = id
+		if req.URL == "" {
+			if ctx.Client == nil {
+				return nil, errors.New("url is empty")
+			}
+			req.URL = ctx.Client.PackageURL(req.DownLoadPath)
+		}
+		dt := NewDownloadTask(&req)
+		err := dt.Run(&sctx)
+		return dt.GetResult(), err
+	case WorkflowStepScript:
+		return wt.runScript(c, &sctx, step, id)
+	case WorkflowStepExtract:
+		return wt.fm.Extract(c, step.Extract)
+	case WorkflowStepMove:
+		return step.Move, wt.fm.MoveFile(step.Move)
+	case WorkflowStepDelete:
+		req := step.Delete
+		if req.Recursive {
+			return req, wt.fm.DeleteAll(req.FilePath)
+		}
+		return req, wt.fm.DeleteFile(req.FilePath)
+	case WorkflowStepWriteFile:
+		result, err := wt.fm.WriteFile(step.WriteFile)
+		if err != nil {
+			return result, err
+		}
+		return result, wt.fm.VerifyWrite(&sctx, result, step.WriteFile.HealthCheck)
+	case WorkflowStepHealthCheck:
+		return RunHealthChecks(&sctx, step.HealthCheck)
+	}
+	return nil, fmt.Errorf("unknown workflow step type: %s", step.Type)
+}
+
+// runScript 通过 ScriptTask 执行脚本，超时时间不超过步骤的超时时间，脚本非零退出视为失败
+func (wt *WorkflowTask) runScript(c context.Context, ctx *Context, step *WorkflowStep, id string) (*ScriptResult, error) {
+	req := *step.Script
+	req.TaskID = id
+	if req.Timeout <= 0 {
+		req.Timeout = defaultWorkflowScriptTimeout
+	}
+	if d, ok := c.Deadline(); ok && time.Until(d) < time.Duration(req.Timeout)*time.Second {
+		req.Timeout = int(time.Until(d)/time.Second) + 1
+	}
+
+	st := NewScriptTask(&req)
+	st.Run(ctx)
+	r := st.ScriptResult
+	if r.Code != CodeSuccess || r.ExitCode != 0 {
+		return r, fmt.Errorf("%s exit code %d: %s", r.Code, r.ExitCode, strings.TrimSpace(r.Stderr+" "+r.Error))
+	}
+	return r, nil
+}
+
+func (wt *WorkflowTask) update(fn func()) {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	fn()
+	wt.Updated = time.Now()
+}
+
+// save 保存工作流状态，用于查询和重启后恢复
+func (wt *WorkflowTask) save(ctx *Context) {
+	if ctx.App() == nil || ctx.App().TaskStore == nil {
+		return
+	}
+	if err := ctx.App().TaskStore.AddTask(wt); err != nil {
+		ctx.Logger.Println("save workflow task failed:", wt.TaskID, err)
+	}
+}
+
+func (wt *WorkflowTask) notify(ctx *Context, msgType string, sr *WorkflowStepResult) {
+	if ctx.Client == nil {
+		return
+	}
+	wt.mu.Lock()
+	data := sr.copy()
+	wt.mu.Unlock()
+	ctx.Notify(msgType, data)
+}
+
+func (sr *WorkflowStepResult) copy() *WorkflowStepResult {
+	c := *sr
+	if sr.Rollback != nil {
+		c.Rollback = sr.Rollback.copy()
+	}
+	return &c
+}
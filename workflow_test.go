@@ -0,0 +1,189 @@
+package updater
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"updater/pkg/app"
+	"updater/pkg/task"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func scriptStep(name, content string) WorkflowStep {
+	return WorkflowStep{Name: name, Type: WorkflowStepScript, Script: &ScriptTaskRequest{Content: content}}
+}
+
+func TestWorkflowRetryAndContinueOnError(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	counter := filepath.Join(dir, "counter")
+
+	// 第一次执行失败，重试后成功
+	flaky := scriptStep("flaky", "test -f "+counter+" || { touch "+counter+"; exit 1; }; echo flaky >> "+log)
+	flaky.Retries, flaky.RetryInterval = 1, 1
+	broken := scriptStep("broken", "exit 2")
+	broken.ContinueOnError = true
+
+	wt := NewWorkflowTask(&WorkflowRequest{TaskID: "wf-retry", Steps: []WorkflowStep{
+		flaky,
+		broken,
+		{Type: WorkflowStepWriteFile, WriteFile: &WriteFileRequest{Path: filepath.Join(dir, "done"), Content: "ok"}},
+	}})
+	if err := wt.Run(newTestContext()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := wt.GetResult().(*WorkflowResult)
+	if wt.GetStatus() != task.TaskStatusCompleted || r.Code != CODE_SUCCESS {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if r.Steps[0].Attempts != 2 || r.Steps[0].Status != StepStatusSucceeded {
+		t.Fatalf("unexpected flaky step: %+v", r.Steps[0])
+	}
+	if r.Steps[1].Status != StepStatusIgnored || r.Steps[2].Name != "step-3" || r.Steps[2].Status != StepStatusSucceeded {
+		t.Fatalf("unexpected steps: %+v %+v", r.Steps[1], r.Steps[2])
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "done")); string(b) != "ok" {
+		t.Fatalf("done = %q", b)
+	}
+}
+
+func TestWorkflowRollback(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	step := func(name, content string) WorkflowStep {
+		s := scriptStep(name, content+"; echo "+name+" >> "+log)
+		undo := scriptStep("", "echo undo-"+name+" >> "+log)
+		s.Rollback = &undo
+		return s
+	}
+
+	wt := NewWorkflowTask(&WorkflowRequest{TaskID: "wf-rollback", Steps: []WorkflowStep{
+		step("stop", "true"),
+		step("swap", "true"),
+		step("start", "exit 1"),
+		step("check", "true"),
+	}})
+	err := wt.Run(newTestContext())
+	if !errors.Is(err, ErrWorkflowStepFailed) || wt.GetStatus() != task.TaskStatusFailed {
+		t.Fatalf("expected ErrWorkflowStepFailed, got %v", err)
+	}
+
+	// 失败的步骤和之前的步骤按相反的顺序补偿，后面的步骤不执行
+	b, _ := os.ReadFile(log)
+	if got := strings.Fields(string(b)); strings.Join(got, ",") != "stop,swap,undo-start,undo-swap,undo-stop" {
+		t.Fatalf("unexpected execution order: %v", got)
+	}
+	r := wt.GetResult().(*WorkflowResult)
+	if r.Steps[2].Status != StepStatusFailed || r.Steps[3].Status != StepStatusSkipped || r.Steps[3].Rollback != nil {
+		t.Fatalf("unexpected steps: %+v %+v", r.Steps[2], r.Steps[3])
+	}
+	if rb := r.Steps[0].Rollback; rb == nil || rb.Name != "stop-rollback" || rb.Status != StepStatusSucceeded {
+		t.Fatalf("unexpected rollback result: %+v", rb)
+	}
+}
+
+func TestWorkflowStopKillsScript(t *testing.T) {
+	wt := NewWorkflowTask(&WorkflowRequest{TaskID: "wf-stop", Steps: []WorkflowStep{scriptStep("sleep", "sleep 30")}})
+	errc := make(chan error, 1)
+	go func() { errc <- wt.Run(newTestContext()) }()
+	for i := 0; wt.GetResult().(*WorkflowResult).Steps[0].Status != StepStatusRunning; i++ {
+		if i == 100 {
+			t.Fatal("script step not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := wt.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil || wt.GetStatus() != task.TaskStatusCanceled {
+			t.Fatalf("unexpected result after stop: %v %v", err, wt.GetStatus())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("running script not stopped")
+	}
+}
+
+func TestWorkflowResume(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	store, err := task.NewTaskStore(filepath.Join(dir, "tasks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	second := scriptStep("second", "echo second >> "+log)
+	second.Retries = 1
+	req := &WorkflowRequest{TaskID: "wf-resume", Steps: []WorkflowStep{
+		scriptStep("first", "echo first >> "+log),
+		second,
+		scriptStep("third", "echo third >> "+log),
+	}}
+
+	// 模拟 agent 在执行第二个步骤时重启
+	wt := NewWorkflowTask(req)
+	wt.Status = task.TaskStatusRunning
+	wt.Result.Current = 1
+	wt.Result.Steps[0].Status = StepStatusSucceeded
+	wt.Result.Steps[0].Attempts = 1
+	wt.Result.Steps[1].Status = StepStatusRunning
+	wt.Result.Steps[1].Attempts = 1
+	if err := store.AddTask(wt); err != nil {
+		t.Fatal(err)
+	}
+	store.AddTask(NewScriptTask(&ScriptTaskRequest{TaskID: "script"}))
+	// 损坏的记录被跳过，不影响其他工作流恢复
+	broken := NewWorkflowTask(&WorkflowRequest{TaskID: "wf-broken", Steps: []WorkflowStep{scriptStep("first", "true")}})
+	broken.Status = task.TaskStatusRunning
+	broken.Result.Request = nil
+	if err := store.AddTask(broken); err != nil {
+		t.Fatal(err)
+	}
+
+	// 无法解析的记录同样跳过
+	store.Close()
+	db, err := leveldb.OpenFile(filepath.Join(dir, "tasks"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("corrupt"), []byte("{not json"), nil)
+	db.Close()
+	if store, err = task.NewTaskStore(filepath.Join(dir, "tasks")); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	tasks, err := LoadWorkflows(store, newTestContext().Logger)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("unexpected workflows: %v %v", tasks, err)
+	}
+	ctx := newTestContext()
+	ctx.app = &app.App{TaskStore: store}
+	if err := tasks[0].Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(log)
+	if string(b) != "second\nthird\n" {
+		t.Fatalf("unexpected log: %q", b)
+	}
+	if r := tasks[0].GetResult().(*WorkflowResult); r.Steps[1].Attempts != 2 {
+		t.Fatalf("unexpected attempts: %+v", r.Steps[1])
+	}
+
+	// 执行完的工作流不再恢复
+	info, err := store.GetTask("wf-resume")
+	if err != nil || info.GetStatus() != task.TaskStatusCompleted {
+		t.Fatalf("unexpected stored task: %+v %v", info, err)
+	}
+	if tasks, _ := LoadWorkflows(store, newTestContext().Logger); len(tasks) != 0 {
+		t.Fatalf("completed workflow loaded again: %v", tasks)
+	}
+}